@@ -27,8 +27,51 @@ typedef enum etBackupStatus {
 	ET_BACKUP_STATUS_ERROR,
 	ET_BACKUP_STATUS_INVALID,
 	ET_BACKUP_STATUS_CANCELLED,
+	ET_BACKUP_STATUS_PARTIAL, // The backup completed without some messages, see etBackupSetErrorBudget.
 } etBackupStatus;
 
+typedef enum etBackupFormat {
+	ET_BACKUP_FORMAT_EML,
+	ET_BACKUP_FORMAT_MBOX,
+	ET_BACKUP_FORMAT_MAILDIR,
+} etBackupFormat;
+
+// Stores the backup in a single archive file instead of a directory. Only supported with ET_BACKUP_FORMAT_EML.
+typedef enum etBackupArchiveFormat {
+	ET_BACKUP_ARCHIVE_FORMAT_NONE,
+	ET_BACKUP_ARCHIVE_FORMAT_TAR_ZSTD,
+	ET_BACKUP_ARCHIVE_FORMAT_ZIP,
+} etBackupArchiveFormat;
+
+// Selects where the messages of the backup are stored. ET_BACKUP_LAYOUT_LABELS stores them in the folders of their
+// labels, named after their date and subject. Only supported with ET_BACKUP_FORMAT_EML and without archive.
+typedef enum etBackupLayout {
+	ET_BACKUP_LAYOUT_FLAT,
+	ET_BACKUP_LAYOUT_LABELS,
+} etBackupLayout;
+
+// Restricts the messages included in a backup. Zero values disable the corresponding criteria.
+typedef struct etBackupFilter {
+	int64_t after;                    // Unix timestamp, only messages received at or after this time.
+	int64_t before;                   // Unix timestamp, only messages received before this time.
+	const char* const* includeLabels; // Label names or IDs, messages must have at least one of them.
+	size_t includeLabelsCount;
+	const char* const* excludeLabels; // Label names or IDs, messages must have none of them.
+	size_t excludeLabelsCount;
+	const char* const* addresses;     // Address emails or IDs, messages must belong to one of them.
+	size_t addressesCount;
+	int unreadOnly;
+	int withAttachments;
+} etBackupFilter;
+
+// Overrides the parallelism and memory of a backup. Zero values are adjusted automatically.
+typedef struct etBackupConcurrency {
+	int downloads;     // Messages downloaded in parallel.
+	int builders;      // Messages decrypted and built in parallel.
+	int writers;       // Messages written in parallel.
+	uint64_t memoryMB; // Memory for the messages being downloaded and built.
+} etBackupConcurrency;
+
 typedef enum etBackupMessageType {
 	ET_BACKUP_MESSAGE_TYPE_PROGRESS,
 } etBackupMessageType;
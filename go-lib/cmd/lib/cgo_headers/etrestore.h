@@ -30,6 +30,20 @@ typedef enum etRestoreStatus {
 	ET_RESTORE_STATUS_CANCELLED,
 } etRestoreStatus;
 
+// Selects the messages imported by a restore. Zero values disable the corresponding criteria.
+typedef struct etRestoreFilter {
+	int64_t after;                    // Unix timestamp, only messages received at or after this time.
+	int64_t before;                   // Unix timestamp, only messages received before this time.
+	const char* const* includeLabels; // Backup label names or IDs, messages must have at least one of them.
+	size_t includeLabelsCount;
+	const char* const* excludeLabels; // Backup label names or IDs, messages must have none of them.
+	size_t excludeLabelsCount;
+	const char* const* addresses;     // Address emails or IDs, messages must belong to one of them.
+	size_t addressesCount;
+	const char* const* participants;  // Email addresses, messages must have been sent by or to one of them.
+	size_t participantsCount;
+} etRestoreFilter;
+
 typedef enum etRestoreMessageType {
 	ET_RESTORE_MESSAGE_TYPE_PROGRESS,
 } etRestoreMessageType;
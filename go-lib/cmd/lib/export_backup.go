@@ -37,10 +37,41 @@ import (
 	"github.com/ProtonMail/export-tool/internal/session"
 	"github.com/ProtonMail/export-tool/internal/utils"
 	"github.com/ProtonMail/gluon/async"
+	"github.com/sirupsen/logrus"
 )
 
 //export etSessionNewBackup
 func etSessionNewBackup(sessionPtr *C.etSession, cExportPath *C.cchar_t, outBackup **C.etBackup) C.etSessionStatus {
+	exportPath := C.GoString(cExportPath)
+
+	return newBackup(sessionPtr, outBackup, func(cSession *csession) (*mail.ExportTask, error) {
+		return mail.NewExportTask(cSession.ctx, filepath.Join(exportPath, cSession.s.GetUser().Email), cSession.s), nil
+	})
+}
+
+//export etSessionResumeBackup
+func etSessionResumeBackup(sessionPtr *C.etSession, cExportPath *C.cchar_t, outBackup **C.etBackup) C.etSessionStatus {
+	exportPath := C.GoString(cExportPath)
+
+	return newBackup(sessionPtr, outBackup, func(cSession *csession) (*mail.ExportTask, error) {
+		return mail.NewResumeExportTask(cSession.ctx, exportPath, cSession.s)
+	})
+}
+
+//export etSessionNewIncrementalBackup
+func etSessionNewIncrementalBackup(sessionPtr *C.etSession, cExportPath *C.cchar_t, outBackup **C.etBackup) C.etSessionStatus {
+	exportPath := C.GoString(cExportPath)
+
+	return newBackup(sessionPtr, outBackup, func(cSession *csession) (*mail.ExportTask, error) {
+		return mail.NewIncrementalExportTask(cSession.ctx, filepath.Join(exportPath, cSession.s.GetUser().Email), cSession.s)
+	})
+}
+
+func newBackup(
+	sessionPtr *C.etSession,
+	outBackup **C.etBackup,
+	newExportTask func(cSession *csession) (*mail.ExportTask, error),
+) C.etSessionStatus {
 	cSession, ok := resolveSession(sessionPtr)
 	if !ok {
 		return C.ET_SESSION_STATUS_INVALID
@@ -53,10 +84,11 @@ func etSessionNewBackup(sessionPtr *C.etSession, cExportPath *C.cchar_t, outBack
 		return C.ET_SESSION_STATUS_ERROR
 	}
 
-	exportPath := C.GoString(cExportPath)
-	exportPath = filepath.Join(exportPath, cSession.s.GetUser().Email)
-
-	mailExport := mail.NewExportTask(cSession.ctx, exportPath, cSession.s)
+	mailExport, err := newExportTask(cSession)
+	if err != nil {
+		cSession.setLastError(err)
+		return C.ET_SESSION_STATUS_ERROR
+	}
 
 	h := internal.NewHandle(&cBackup{
 		csession: cSession,
@@ -83,6 +115,7 @@ func etBackupDelete(ptr *C.etBackup) C.etBackupStatus {
 
 	s.exporter.Close()
 	s.lastError.Close()
+	s.closeEvents()
 
 	h.Delete()
 
@@ -130,6 +163,10 @@ func etBackupStart(ptr *C.etBackup, callbacks *C.etBackupCallbacks) C.etBackupSt
 		return C.ET_BACKUP_STATUS_ERROR
 	}
 
+	if ce.exporter.GetFailedMessageCount() != 0 {
+		return C.ET_BACKUP_STATUS_PARTIAL
+	}
+
 	return C.ET_BACKUP_STATUS_OK
 }
 
@@ -147,6 +184,274 @@ func etBackupCancel(ptr *C.etBackup) C.etBackupStatus {
 	return C.ET_BACKUP_STATUS_OK
 }
 
+//export etBackupSetFormat
+func etBackupSetFormat(ptr *C.etBackup, format C.etBackupFormat) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	var exportFormat mail.ExportFormat
+
+	switch format {
+	case C.ET_BACKUP_FORMAT_EML:
+		exportFormat = mail.ExportFormatEML
+	case C.ET_BACKUP_FORMAT_MBOX:
+		exportFormat = mail.ExportFormatMbox
+	case C.ET_BACKUP_FORMAT_MAILDIR:
+		exportFormat = mail.ExportFormatMaildir
+	default:
+		ce.lastError.Set(errors.New("unknown backup format"))
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	if err := ce.exporter.SetFormat(exportFormat); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetArchiveFormat
+func etBackupSetArchiveFormat(ptr *C.etBackup, format C.etBackupArchiveFormat) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	var archiveFormat mail.ArchiveFormat
+
+	switch format {
+	case C.ET_BACKUP_ARCHIVE_FORMAT_NONE:
+		archiveFormat = mail.ArchiveFormatNone
+	case C.ET_BACKUP_ARCHIVE_FORMAT_TAR_ZSTD:
+		archiveFormat = mail.ArchiveFormatTarZstd
+	case C.ET_BACKUP_ARCHIVE_FORMAT_ZIP:
+		archiveFormat = mail.ArchiveFormatZip
+	default:
+		ce.lastError.Set(errors.New("unknown backup archive format"))
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	if err := ce.exporter.SetArchiveFormat(archiveFormat); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetLayout
+func etBackupSetLayout(ptr *C.etBackup, layout C.etBackupLayout) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	var exportLayout mail.ExportLayout
+
+	switch layout {
+	case C.ET_BACKUP_LAYOUT_FLAT:
+		exportLayout = mail.ExportLayoutFlat
+	case C.ET_BACKUP_LAYOUT_LABELS:
+		exportLayout = mail.ExportLayoutLabels
+	default:
+		ce.lastError.Set(errors.New("unknown backup layout"))
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	if err := ce.exporter.SetLayout(exportLayout); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetFilter
+func etBackupSetFilter(ptr *C.etBackup, filter *C.etBackupFilter) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	if filter == nil {
+		if err := ce.exporter.SetFilter(mail.ExportFilter{}); err != nil {
+			ce.lastError.Set(err)
+			return C.ET_BACKUP_STATUS_ERROR
+		}
+
+		return C.ET_BACKUP_STATUS_OK
+	}
+
+	exportFilter := mail.ExportFilter{
+		IncludeLabels:   goStringArray(filter.includeLabels, filter.includeLabelsCount),
+		ExcludeLabels:   goStringArray(filter.excludeLabels, filter.excludeLabelsCount),
+		Addresses:       goStringArray(filter.addresses, filter.addressesCount),
+		UnreadOnly:      filter.unreadOnly != 0,
+		WithAttachments: filter.withAttachments != 0,
+	}
+
+	if filter.after != 0 {
+		exportFilter.After = time.Unix(int64(filter.after), 0)
+	}
+
+	if filter.before != 0 {
+		exportFilter.Before = time.Unix(int64(filter.before), 0)
+	}
+
+	if err := ce.exporter.SetFilter(exportFilter); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetConcurrency
+func etBackupSetConcurrency(ptr *C.etBackup, concurrency *C.etBackupConcurrency) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	var options mail.ConcurrencyOptions
+
+	if concurrency != nil {
+		options = mail.ConcurrencyOptions{
+			Downloads: int(concurrency.downloads),
+			Builders:  int(concurrency.builders),
+			Writers:   int(concurrency.writers),
+			MemoryMB:  uint64(concurrency.memoryMB),
+		}
+	}
+
+	if err := ce.exporter.SetConcurrencyOptions(options); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetErrorBudget
+func etBackupSetErrorBudget(ptr *C.etBackup, budget C.int64_t) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	if err := ce.exporter.SetErrorBudget(int(budget)); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupGetFailedMessageCount
+func etBackupGetFailedMessageCount(ptr *C.etBackup, outCount *C.int64_t) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	*outCount = C.int64_t(ce.exporter.GetFailedMessageCount())
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetEncryptionKeyFile
+func etBackupSetEncryptionKeyFile(ptr *C.etBackup, cPath *C.cchar_t, cKeyPassphrase *C.cchar_t) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	cipher, err := utils.LoadPGPKeyFileCipher(C.GoString(cPath), []byte(C.GoString(cKeyPassphrase)))
+	if err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	if err := ce.exporter.SetEncrypter(cipher); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetEncryptionPassphrase
+func etBackupSetEncryptionPassphrase(ptr *C.etBackup, cPassphrase *C.cchar_t) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	if err := ce.exporter.SetEncrypter(utils.NewPGPPasswordFileCipher([]byte(C.GoString(cPassphrase)))); err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupSetEventFile
+func etBackupSetEventFile(ptr *C.etBackup, cPath *C.cchar_t) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	events, err := mail.NewJSONEventFile(C.GoString(cPath))
+	if err != nil {
+		ce.lastError.Set(err)
+		return C.ET_BACKUP_STATUS_ERROR
+	}
+
+	ce.closeEvents()
+	ce.events = events
+	ce.csession.callbacks.setEventReporter(events)
+	ce.exporter.SetEventReporter(events)
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+func goStringArray(array **C.cchar_t, count C.size_t) []string {
+	if array == nil || count == 0 {
+		return nil
+	}
+
+	result := make([]string, 0, int(count))
+	for _, str := range unsafe.Slice(array, int(count)) {
+		result = append(result, C.GoString(str))
+	}
+
+	return result
+}
+
 //export etBackupGetLastError
 func etBackupGetLastError(ptr *C.etBackup) *C.cchar_t {
 	ce, ok := resolveBackup(ptr)
@@ -191,10 +496,51 @@ func etBackupGetExportPath(ptr *C.etBackup, outPath **C.char) C.etBackupStatus {
 	return C.ET_BACKUP_STATUS_OK
 }
 
+//export etBackupSetFailureReportPath
+func etBackupSetFailureReportPath(ptr *C.etBackup, cPath *C.cchar_t) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	ce.exporter.SetFailureReportPath(C.GoString(cPath))
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
+//export etBackupGetFailureReportPath
+func etBackupGetFailureReportPath(ptr *C.etBackup, outPath **C.char) C.etBackupStatus {
+	ce, ok := resolveBackup(ptr)
+	if !ok {
+		return C.ET_BACKUP_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	*outPath = C.CString(ce.exporter.GetFailureReportPath())
+
+	return C.ET_BACKUP_STATUS_OK
+}
+
 type cBackup struct {
 	csession  *csession
 	exporter  *mail.ExportTask
 	lastError utils.CLastError
+	events    *mail.JSONEventWriter
+}
+
+func (c *cBackup) closeEvents() {
+	if c.events == nil {
+		return
+	}
+
+	c.csession.callbacks.clearEventReporter(c.events)
+
+	if err := c.events.Close(); err != nil {
+		logrus.WithError(err).Error("Failed to close event file")
+	}
 }
 
 type BackupHandle struct {
@@ -36,6 +36,7 @@ import (
 	"github.com/ProtonMail/export-tool/internal/session"
 	"github.com/ProtonMail/export-tool/internal/utils"
 	"github.com/ProtonMail/gluon/async"
+	"github.com/sirupsen/logrus"
 )
 
 //export etSessionNewRestore
@@ -84,6 +85,7 @@ func etRestoreDelete(ptr *C.etRestore) C.etRestoreStatus {
 
 	s.restorer.Close()
 	s.lastError.Close()
+	s.closeEvents()
 
 	h.Delete()
 
@@ -168,6 +170,34 @@ func etRestoreGetBackupPath(ptr *C.etRestore, outPath **C.char) C.etRestoreStatu
 	return C.ET_RESTORE_STATUS_OK
 }
 
+//export etRestoreSetFailureReportPath
+func etRestoreSetFailureReportPath(ptr *C.etRestore, cPath *C.cchar_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	ce.restorer.SetFailureReportPath(C.GoString(cPath))
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreGetFailureReportPath
+func etRestoreGetFailureReportPath(ptr *C.etRestore, outPath **C.char) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	*outPath = C.CString(ce.restorer.GetFailureReportPath())
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
 //export etRestoreGetImportableCount
 func etRestoreGetImportableCount(ptr *C.etRestore, count *C.int64_t) C.etRestoreStatus {
 	ce, ok := resolveRestore(ptr)
@@ -224,10 +254,218 @@ func etRestoreGetSkippedCount(ptr *C.etRestore, count *C.int64_t) C.etRestoreSta
 	return C.ET_RESTORE_STATUS_OK
 }
 
+//export etRestoreSetAddressMappingFile
+func etRestoreSetAddressMappingFile(ptr *C.etRestore, cPath *C.cchar_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	mapping, err := mail.LoadAddressMappingFile(C.GoString(cPath))
+	if err != nil {
+		ce.lastError.Set(err)
+		return C.ET_RESTORE_STATUS_ERROR
+	}
+
+	ce.restorer.SetAddressMapping(mapping)
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreSetDecryptionKeyFile
+func etRestoreSetDecryptionKeyFile(ptr *C.etRestore, cPath *C.cchar_t, cKeyPassphrase *C.cchar_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	cipher, err := utils.LoadPGPKeyFileCipher(C.GoString(cPath), []byte(C.GoString(cKeyPassphrase)))
+	if err != nil {
+		ce.lastError.Set(err)
+		return C.ET_RESTORE_STATUS_ERROR
+	}
+
+	ce.restorer.SetDecrypter(cipher)
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreSetDecryptionPassphrase
+func etRestoreSetDecryptionPassphrase(ptr *C.etRestore, cPassphrase *C.cchar_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	ce.restorer.SetDecrypter(utils.NewPGPPasswordFileCipher([]byte(C.GoString(cPassphrase))))
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreSetEventFile
+func etRestoreSetEventFile(ptr *C.etRestore, cPath *C.cchar_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	events, err := mail.NewJSONEventFile(C.GoString(cPath))
+	if err != nil {
+		ce.lastError.Set(err)
+		return C.ET_RESTORE_STATUS_ERROR
+	}
+
+	ce.closeEvents()
+	ce.events = events
+	ce.csession.callbacks.setEventReporter(events)
+	ce.restorer.SetEventReporter(events)
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreSetAddressFallback
+func etRestoreSetAddressFallback(ptr *C.etRestore, cAddress *C.cchar_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	ce.restorer.SetAddressFallback(C.GoString(cAddress))
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreSetRestoreSettings
+func etRestoreSetRestoreSettings(ptr *C.etRestore, enabled C.int) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	ce.restorer.SetRestoreSettings(enabled != 0)
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreSetSkipExisting
+func etRestoreSetSkipExisting(ptr *C.etRestore, enabled C.int) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	ce.restorer.SetSkipExisting(enabled != 0)
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreGetAlreadyPresentCount
+func etRestoreGetAlreadyPresentCount(ptr *C.etRestore, count *C.int64_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	*count = C.int64_t(ce.restorer.GetAlreadyPresentCount())
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreGetResumedCount
+func etRestoreGetResumedCount(ptr *C.etRestore, count *C.int64_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	*count = C.int64_t(ce.restorer.GetResumedCount())
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreGetFilteredCount
+func etRestoreGetFilteredCount(ptr *C.etRestore, count *C.int64_t) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	*count = C.int64_t(ce.restorer.GetFilteredCount())
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
+//export etRestoreSetFilter
+func etRestoreSetFilter(ptr *C.etRestore, filter *C.etRestoreFilter) C.etRestoreStatus {
+	ce, ok := resolveRestore(ptr)
+	if !ok {
+		return C.ET_RESTORE_STATUS_INVALID
+	}
+
+	defer async.HandlePanic(ce.csession.s.GetPanicHandler())
+
+	if filter == nil {
+		ce.restorer.SetFilter(mail.RestoreFilter{})
+		return C.ET_RESTORE_STATUS_OK
+	}
+
+	restoreFilter := mail.RestoreFilter{
+		IncludeLabels: goStringArray(filter.includeLabels, filter.includeLabelsCount),
+		ExcludeLabels: goStringArray(filter.excludeLabels, filter.excludeLabelsCount),
+		Addresses:     goStringArray(filter.addresses, filter.addressesCount),
+		Participants:  goStringArray(filter.participants, filter.participantsCount),
+	}
+
+	if filter.after != 0 {
+		restoreFilter.After = time.Unix(int64(filter.after), 0)
+	}
+
+	if filter.before != 0 {
+		restoreFilter.Before = time.Unix(int64(filter.before), 0)
+	}
+
+	ce.restorer.SetFilter(restoreFilter)
+
+	return C.ET_RESTORE_STATUS_OK
+}
+
 type cRestore struct {
 	csession  *csession
 	restorer  *mail.RestoreTask
 	lastError utils.CLastError
+	events    *mail.JSONEventWriter
+}
+
+func (c *cRestore) closeEvents() {
+	if c.events == nil {
+		return
+	}
+
+	c.csession.callbacks.clearEventReporter(c.events)
+
+	if err := c.events.Close(); err != nil {
+		logrus.WithError(err).Error("Failed to close event file")
+	}
 }
 
 type RestoreHandle struct {
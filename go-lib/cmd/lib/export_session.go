@@ -32,6 +32,7 @@ import (
 
 	"github.com/ProtonMail/export-tool/internal"
 	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/mail"
 	"github.com/ProtonMail/export-tool/internal/sentry"
 	"github.com/ProtonMail/export-tool/internal/session"
 	"github.com/ProtonMail/export-tool/internal/telemetry"
@@ -277,6 +278,7 @@ type csession struct {
 	cancelOnce sync.Once
 	ctxCancel  func()
 	lastError  utils.CLastError
+	callbacks  *csessionCallback
 }
 
 func newCSession(apiURL string, telemetryDisabled bool, cb C.etSessionCallbacks) (*csession, error) {
@@ -293,7 +295,7 @@ func newCSession(apiURL string, telemetryDisabled bool, cb C.etSessionCallbacks)
 
 	clientBuilder := apiclient.NewAutoRetryClientBuilder(
 		builder,
-		&apiclient.SleepRetryStrategyBuilder{},
+		apiclient.NewRateLimitRetryStrategyBuilder(),
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -302,6 +304,7 @@ func newCSession(apiURL string, telemetryDisabled bool, cb C.etSessionCallbacks)
 		s:         session.NewSession(clientBuilder, sessionCb, panicHandler, reporter, telemetryDisabled),
 		ctx:       ctx,
 		ctxCancel: cancel,
+		callbacks: sessionCb,
 	}, nil
 }
 
@@ -333,19 +336,48 @@ func resolveSession(ptr *C.etSession) (*csession, bool) {
 }
 
 type csessionCallback struct {
-	cb C.etSessionCallbacks
+	cb     C.etSessionCallbacks
+	lock   sync.Mutex
+	events mail.EventReporter
 }
 
-func newCSessionCallback(cb C.etSessionCallbacks) session.Callbacks {
-	return &csessionCallback{cb: cb}
+func newCSessionCallback(cb C.etSessionCallbacks) *csessionCallback {
+	return &csessionCallback{cb: cb, events: mail.NullEventReporter{}}
+}
+
+// setEventReporter makes the network events also reported to the event file of a backup or a restore.
+func (c *csessionCallback) setEventReporter(events mail.EventReporter) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.events = events
+}
+
+// clearEventReporter stops reporting the network events to events, if they still are.
+func (c *csessionCallback) clearEventReporter(events mail.EventReporter) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.events == events {
+		c.events = mail.NullEventReporter{}
+	}
+}
+
+func (c *csessionCallback) reportEvent(event mail.Event) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.events.ReportEvent(event)
 }
 
 func (c *csessionCallback) OnNetworkRestored() {
 	C.etSessionCallbackOnNetworkRestored(&c.cb) //nolint:gocritic
+	c.reportEvent(mail.Event{Type: mail.EventNetworkRestored})
 }
 
 func (c *csessionCallback) OnNetworkLost() {
 	C.etSessionCallbackOnNetworkLost(&c.cb) //nolint:gocritic
+	c.reportEvent(mail.Event{Type: mail.EventNetworkLost})
 }
 
 func main() {}
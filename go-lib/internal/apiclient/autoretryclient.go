@@ -21,8 +21,8 @@ import (
 	"context"
 	"errors"
 	"io"
-	"math/rand"
 	"net"
+	"net/url"
 	"time"
 
 	"github.com/ProtonMail/go-proton-api"
@@ -51,13 +51,18 @@ func (a *AutoRetryClientBuilder) NewClient(
 ) (Client, proton.Auth, error) {
 	retryStrategy := a.retryStrategyBuilder.NewRetryStrategy()
 	for {
-		client, auth, err := a.builder.NewClient(ctx, username, password, hvToken)
+		holder := &retryAfterHolder{}
+
+		client, auth, err := a.builder.NewClient(withRetryAfterHolder(ctx, holder), username, password, hvToken)
 		if err != nil {
 			if !isRetrieableError(err) {
 				return nil, proton.Auth{}, err
 			}
 
-			retryStrategy.HandleRetry(ctx)
+			if err := retryStrategy.HandleRetry(ctx, holder.wrapError(err)); err != nil {
+				return nil, proton.Auth{}, err
+			}
+
 			continue
 		}
 
@@ -122,6 +127,12 @@ func (arc *AutoRetryClient) CreateLabel(ctx context.Context, req proton.CreateLa
 	})
 }
 
+func (arc *AutoRetryClient) UpdateLabel(ctx context.Context, labelID string, req proton.UpdateLabelReq) (proton.Label, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (proton.Label, error) {
+		return client.UpdateLabel(ctx, labelID, req)
+	})
+}
+
 func (arc *AutoRetryClient) GetAddresses(ctx context.Context) ([]proton.Address, error) {
 	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]proton.Address, error) {
 		return client.GetAddresses(ctx)
@@ -185,16 +196,142 @@ func (arc *AutoRetryClient) ImportMessages(
 	})
 }
 
+func (arc *AutoRetryClient) GetContacts(ctx context.Context, page, pageSize int) ([]proton.Contact, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]proton.Contact, error) {
+		return client.GetContacts(ctx, page, pageSize)
+	})
+}
+
+func (arc *AutoRetryClient) GetContact(ctx context.Context, contactID string) (proton.Contact, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (proton.Contact, error) {
+		return client.GetContact(ctx, contactID)
+	})
+}
+
+func (arc *AutoRetryClient) CreateContacts(ctx context.Context, req proton.CreateContactsReq) ([]proton.CreateContactsRes, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]proton.CreateContactsRes, error) {
+		return client.CreateContacts(ctx, req)
+	})
+}
+
+func (arc *AutoRetryClient) GetCalendars(ctx context.Context) ([]proton.Calendar, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]proton.Calendar, error) {
+		return client.GetCalendars(ctx)
+	})
+}
+
+func (arc *AutoRetryClient) GetCalendarKeys(ctx context.Context, calendarID string) (proton.CalendarKeys, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (proton.CalendarKeys, error) {
+		return client.GetCalendarKeys(ctx, calendarID)
+	})
+}
+
+func (arc *AutoRetryClient) GetCalendarMembers(ctx context.Context, calendarID string) ([]proton.CalendarMember, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]proton.CalendarMember, error) {
+		return client.GetCalendarMembers(ctx, calendarID)
+	})
+}
+
+func (arc *AutoRetryClient) GetCalendarPassphrase(ctx context.Context, calendarID string) (proton.CalendarPassphrase, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (proton.CalendarPassphrase, error) {
+		return client.GetCalendarPassphrase(ctx, calendarID)
+	})
+}
+
+func (arc *AutoRetryClient) GetCalendarEvents(
+	ctx context.Context,
+	calendarID string,
+	page, pageSize int,
+	filter url.Values,
+) ([]proton.CalendarEvent, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]proton.CalendarEvent, error) {
+		return client.GetCalendarEvents(ctx, calendarID, page, pageSize, filter)
+	})
+}
+
+func (arc *AutoRetryClient) GetMailSettings(ctx context.Context) (proton.MailSettings, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (proton.MailSettings, error) {
+		return client.GetMailSettings(ctx)
+	})
+}
+
+func (arc *AutoRetryClient) SetDisplayName(ctx context.Context, req proton.SetDisplayNameReq) (proton.MailSettings, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (proton.MailSettings, error) {
+		return client.SetDisplayName(ctx, req)
+	})
+}
+
+func (arc *AutoRetryClient) SetSignature(ctx context.Context, req proton.SetSignatureReq) (proton.MailSettings, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (proton.MailSettings, error) {
+		return client.SetSignature(ctx, req)
+	})
+}
+
+func (arc *AutoRetryClient) GetMailFilters(ctx context.Context) ([]MailFilter, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]MailFilter, error) {
+		return client.GetMailFilters(ctx)
+	})
+}
+
+func (arc *AutoRetryClient) CreateMailFilter(ctx context.Context, req CreateMailFilterReq) (MailFilter, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) (MailFilter, error) {
+		return client.CreateMailFilter(ctx, req)
+	})
+}
+
+func (arc *AutoRetryClient) GetAddressSignatures(ctx context.Context) ([]AddressSignature, error) {
+	return repeatRequestTyped(ctx, arc, func(ctx context.Context, client Client) ([]AddressSignature, error) {
+		return client.GetAddressSignatures(ctx)
+	})
+}
+
+func (arc *AutoRetryClient) UpdateAddress(ctx context.Context, addressID string, req UpdateAddressReq) error {
+	return arc.repeatRequest(ctx, func(ctx context.Context, client Client) error {
+		return client.UpdateAddress(ctx, addressID, req)
+	})
+}
+
+// RequestObserver is notified of the outcome of every attempt of the requests made by an AutoRetryClient with a context
+// returned by WithRequestObserver.
+type RequestObserver interface {
+	OnRequest(duration time.Duration, err error)
+}
+
+type requestObserverKey struct{}
+
+func WithRequestObserver(ctx context.Context, observer RequestObserver) context.Context {
+	return context.WithValue(ctx, requestObserverKey{}, observer)
+}
+
 func (arc *AutoRetryClient) repeatRequest(ctx context.Context, req func(ctx context.Context, client Client) error) error {
+	observer, _ := ctx.Value(requestObserverKey{}).(RequestObserver)
+	gate, _ := arc.retryStrategyBuilder.(requestGate)
+
 	retryStrategy := arc.retryStrategyBuilder.NewRetryStrategy()
 	for {
-		err := req(ctx, arc.client)
+		if gate != nil {
+			if err := gate.WaitBeforeRequest(ctx); err != nil {
+				return err
+			}
+		}
+
+		holder := &retryAfterHolder{}
+		start := time.Now()
+
+		err := req(withRetryAfterHolder(ctx, holder), arc.client)
+		if observer != nil {
+			observer.OnRequest(time.Since(start), err)
+		}
+
 		if err != nil {
 			if !isRetrieableError(err) {
 				return err
 			}
 
-			retryStrategy.HandleRetry(ctx)
+			if err := retryStrategy.HandleRetry(ctx, holder.wrapError(err)); err != nil {
+				return err
+			}
+
 			continue
 		}
 
@@ -255,53 +392,7 @@ type RetryStrategyBuilder interface {
 
 // RetryStrategy is meant to be used in the scope of on goroutine for the lifetime of one specific request.
 type RetryStrategy interface {
-	HandleRetry(ctx context.Context)
-}
-
-type SleepRetryStrategyBuilder struct{}
-
-func (r SleepRetryStrategyBuilder) NewRetryStrategy() RetryStrategy {
-	return &SleepRetryStrategy{index: 0}
-}
-
-type SleepRetryStrategy struct {
-	index int
-}
-
-func (s *SleepRetryStrategy) HandleRetry(ctx context.Context) {
-	sleepCtx(ctx, s.nextWaitTime())
-}
-
-func (s *SleepRetryStrategy) nextWaitTime() time.Duration {
-	last := len(expWaitTimes) - 1
-	if s.index >= last {
-		s.index = last
-	}
-
-	nextWaitTime := expWaitTimes[s.index] + jitter(10)
-
-	s.index++
-
-	return nextWaitTime
-}
-
-//nolint:gochecknoglobals
-var expWaitTimes = []time.Duration{
-	20 * time.Second,
-	40 * time.Second,
-	80 * time.Second,
-	160 * time.Second,
-	300 * time.Second,
-	600 * time.Second,
-}
-
-func jitter(maxJitter int) time.Duration {
-	return time.Duration(rand.Intn(maxJitter)) * time.Second //nolint:gosec
-}
-
-func sleepCtx(ctx context.Context, duration time.Duration) {
-	select {
-	case <-ctx.Done():
-	case <-time.After(duration):
-	}
+	// HandleRetry waits before the request is retried after failing with err. The request is no longer retried if an
+	// error is returned.
+	HandleRetry(ctx context.Context, err error) error
 }
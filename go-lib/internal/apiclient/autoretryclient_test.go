@@ -23,6 +23,7 @@ import (
 	"io"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/stretchr/testify/require"
@@ -101,7 +102,7 @@ func TestAutoRetryClientRepetition(t *testing.T) {
 
 			call1 := mockClient.EXPECT().GetMessage(gomock.Any(), gomock.Any()).Times(1).Return(proton.Message{}, test.err)
 			if test.expectRetry {
-				strategy.EXPECT().HandleRetry(gomock.Any()).Times(1)
+				strategy.EXPECT().HandleRetry(gomock.Any(), gomock.Any()).Return(nil).Times(1)
 
 				mockClient.EXPECT().GetMessage(gomock.Any(), gomock.Any()).Times(1).After(call1).Return(proton.Message{}, nil)
 			}
@@ -116,6 +117,36 @@ func TestAutoRetryClientRepetition(t *testing.T) {
 	}
 }
 
+func TestAutoRetryClientRequestObserver(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	strategy := NewMockRetryStrategy(mockCtrl)
+	mockClient := NewMockClient(mockCtrl)
+
+	client := NewAutoRetryClient(mockClient, &mockRetryStrategyBuilder{s: strategy})
+
+	rateLimited := &proton.APIError{Status: 429}
+
+	call1 := mockClient.EXPECT().GetMessage(gomock.Any(), gomock.Any()).Times(1).Return(proton.Message{}, rateLimited)
+	strategy.EXPECT().HandleRetry(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockClient.EXPECT().GetMessage(gomock.Any(), gomock.Any()).Times(1).After(call1).Return(proton.Message{}, nil)
+
+	observer := &testRequestObserver{}
+
+	_, err := client.GetMessage(WithRequestObserver(context.Background(), observer), "msgid")
+	require.NoError(t, err)
+	require.Equal(t, []error{rateLimited, nil}, observer.errors)
+}
+
+type testRequestObserver struct {
+	errors []error
+}
+
+func (o *testRequestObserver) OnRequest(_ time.Duration, err error) {
+	o.errors = append(o.errors, err)
+}
+
 type mockRetryStrategyBuilder struct {
 	s *MockRetryStrategy
 }
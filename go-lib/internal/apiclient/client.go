@@ -20,6 +20,7 @@ package apiclient
 import (
 	"context"
 	"io"
+	"net/url"
 
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
@@ -40,6 +41,7 @@ type Client interface {
 
 	GetLabels(ctx context.Context, labelTypes ...proton.LabelType) ([]proton.Label, error)
 	CreateLabel(ctx context.Context, req proton.CreateLabelReq) (proton.Label, error)
+	UpdateLabel(ctx context.Context, labelID string, req proton.UpdateLabelReq) (proton.Label, error)
 	GetAddresses(ctx context.Context) ([]proton.Address, error)
 
 	GetGroupedMessageCount(ctx context.Context) ([]proton.MessageGroupCount, error)
@@ -48,6 +50,24 @@ type Client interface {
 	GetAttachmentInto(ctx context.Context, attachmentID string, reader io.ReaderFrom) error
 	ImportMessages(ctx context.Context, addrKR *crypto.KeyRing, workers, buffer int, req ...proton.ImportReq) (proton.ImportResStream, error)
 
+	GetContacts(ctx context.Context, page, pageSize int) ([]proton.Contact, error)
+	GetContact(ctx context.Context, contactID string) (proton.Contact, error)
+	CreateContacts(ctx context.Context, req proton.CreateContactsReq) ([]proton.CreateContactsRes, error)
+
+	GetCalendars(ctx context.Context) ([]proton.Calendar, error)
+	GetCalendarKeys(ctx context.Context, calendarID string) (proton.CalendarKeys, error)
+	GetCalendarMembers(ctx context.Context, calendarID string) ([]proton.CalendarMember, error)
+	GetCalendarPassphrase(ctx context.Context, calendarID string) (proton.CalendarPassphrase, error)
+	GetCalendarEvents(ctx context.Context, calendarID string, page, pageSize int, filter url.Values) ([]proton.CalendarEvent, error)
+
+	GetMailSettings(ctx context.Context) (proton.MailSettings, error)
+	SetDisplayName(ctx context.Context, req proton.SetDisplayNameReq) (proton.MailSettings, error)
+	SetSignature(ctx context.Context, req proton.SetSignatureReq) (proton.MailSettings, error)
+	GetMailFilters(ctx context.Context) ([]MailFilter, error)
+	CreateMailFilter(ctx context.Context, req CreateMailFilterReq) (MailFilter, error)
+	GetAddressSignatures(ctx context.Context) ([]AddressSignature, error)
+	UpdateAddress(ctx context.Context, addressID string, req UpdateAddressReq) error
+
 	// Required for telemetry
 	GetUserSettings(ctx context.Context) (proton.UserSettings, error)
 	SendDataEvent(ctx context.Context, req proton.SendStatsReq) error
@@ -70,6 +70,12 @@ func (u *UnlockedKeyRing) Close() {
 	u.keyRing.ClearPrivateParams()
 }
 
+// GetUserKeyRing returns the keyring of the user, which encrypts and signs the account data not tied to an address such
+// as the contacts.
+func (u *UnlockedKeyRing) GetUserKeyRing() *crypto.KeyRing {
+	return u.keyRing
+}
+
 func (u *UnlockedKeyRing) GetAddrKeyRing(addrID string) (*crypto.KeyRing, bool) {
 	kr, ok := u.addrMap[addrID]
 
@@ -12,6 +12,7 @@ package apiclient
 import (
 	context "context"
 	io "io"
+	url "net/url"
 	reflect "reflect"
 
 	proton "github.com/ProtonMail/go-proton-api"
@@ -149,6 +150,21 @@ func (mr *MockClientMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockClient)(nil).Close))
 }
 
+// CreateContacts mocks base method.
+func (m *MockClient) CreateContacts(ctx context.Context, req proton.CreateContactsReq) ([]proton.CreateContactsRes, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateContacts", ctx, req)
+	ret0, _ := ret[0].([]proton.CreateContactsRes)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateContacts indicates an expected call of CreateContacts.
+func (mr *MockClientMockRecorder) CreateContacts(ctx, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateContacts", reflect.TypeOf((*MockClient)(nil).CreateContacts), ctx, req)
+}
+
 // CreateLabel mocks base method.
 func (m *MockClient) CreateLabel(ctx context.Context, req proton.CreateLabelReq) (proton.Label, error) {
 	m.ctrl.T.Helper()
@@ -164,6 +180,36 @@ func (mr *MockClientMockRecorder) CreateLabel(ctx, req any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLabel", reflect.TypeOf((*MockClient)(nil).CreateLabel), ctx, req)
 }
 
+// CreateMailFilter mocks base method.
+func (m *MockClient) CreateMailFilter(ctx context.Context, req CreateMailFilterReq) (MailFilter, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMailFilter", ctx, req)
+	ret0, _ := ret[0].(MailFilter)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateMailFilter indicates an expected call of CreateMailFilter.
+func (mr *MockClientMockRecorder) CreateMailFilter(ctx, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMailFilter", reflect.TypeOf((*MockClient)(nil).CreateMailFilter), ctx, req)
+}
+
+// GetAddressSignatures mocks base method.
+func (m *MockClient) GetAddressSignatures(ctx context.Context) ([]AddressSignature, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAddressSignatures", ctx)
+	ret0, _ := ret[0].([]AddressSignature)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAddressSignatures indicates an expected call of GetAddressSignatures.
+func (mr *MockClientMockRecorder) GetAddressSignatures(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAddressSignatures", reflect.TypeOf((*MockClient)(nil).GetAddressSignatures), ctx)
+}
+
 // GetAddresses mocks base method.
 func (m *MockClient) GetAddresses(ctx context.Context) ([]proton.Address, error) {
 	m.ctrl.T.Helper()
@@ -193,6 +239,111 @@ func (mr *MockClientMockRecorder) GetAttachmentInto(ctx, attachmentID, reader an
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAttachmentInto", reflect.TypeOf((*MockClient)(nil).GetAttachmentInto), ctx, attachmentID, reader)
 }
 
+// GetCalendarEvents mocks base method.
+func (m *MockClient) GetCalendarEvents(ctx context.Context, calendarID string, page, pageSize int, filter url.Values) ([]proton.CalendarEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCalendarEvents", ctx, calendarID, page, pageSize, filter)
+	ret0, _ := ret[0].([]proton.CalendarEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCalendarEvents indicates an expected call of GetCalendarEvents.
+func (mr *MockClientMockRecorder) GetCalendarEvents(ctx, calendarID, page, pageSize, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCalendarEvents", reflect.TypeOf((*MockClient)(nil).GetCalendarEvents), ctx, calendarID, page, pageSize, filter)
+}
+
+// GetCalendarKeys mocks base method.
+func (m *MockClient) GetCalendarKeys(ctx context.Context, calendarID string) (proton.CalendarKeys, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCalendarKeys", ctx, calendarID)
+	ret0, _ := ret[0].(proton.CalendarKeys)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCalendarKeys indicates an expected call of GetCalendarKeys.
+func (mr *MockClientMockRecorder) GetCalendarKeys(ctx, calendarID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCalendarKeys", reflect.TypeOf((*MockClient)(nil).GetCalendarKeys), ctx, calendarID)
+}
+
+// GetCalendarMembers mocks base method.
+func (m *MockClient) GetCalendarMembers(ctx context.Context, calendarID string) ([]proton.CalendarMember, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCalendarMembers", ctx, calendarID)
+	ret0, _ := ret[0].([]proton.CalendarMember)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCalendarMembers indicates an expected call of GetCalendarMembers.
+func (mr *MockClientMockRecorder) GetCalendarMembers(ctx, calendarID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCalendarMembers", reflect.TypeOf((*MockClient)(nil).GetCalendarMembers), ctx, calendarID)
+}
+
+// GetCalendarPassphrase mocks base method.
+func (m *MockClient) GetCalendarPassphrase(ctx context.Context, calendarID string) (proton.CalendarPassphrase, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCalendarPassphrase", ctx, calendarID)
+	ret0, _ := ret[0].(proton.CalendarPassphrase)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCalendarPassphrase indicates an expected call of GetCalendarPassphrase.
+func (mr *MockClientMockRecorder) GetCalendarPassphrase(ctx, calendarID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCalendarPassphrase", reflect.TypeOf((*MockClient)(nil).GetCalendarPassphrase), ctx, calendarID)
+}
+
+// GetCalendars mocks base method.
+func (m *MockClient) GetCalendars(ctx context.Context) ([]proton.Calendar, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCalendars", ctx)
+	ret0, _ := ret[0].([]proton.Calendar)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCalendars indicates an expected call of GetCalendars.
+func (mr *MockClientMockRecorder) GetCalendars(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCalendars", reflect.TypeOf((*MockClient)(nil).GetCalendars), ctx)
+}
+
+// GetContact mocks base method.
+func (m *MockClient) GetContact(ctx context.Context, contactID string) (proton.Contact, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetContact", ctx, contactID)
+	ret0, _ := ret[0].(proton.Contact)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetContact indicates an expected call of GetContact.
+func (mr *MockClientMockRecorder) GetContact(ctx, contactID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetContact", reflect.TypeOf((*MockClient)(nil).GetContact), ctx, contactID)
+}
+
+// GetContacts mocks base method.
+func (m *MockClient) GetContacts(ctx context.Context, page, pageSize int) ([]proton.Contact, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetContacts", ctx, page, pageSize)
+	ret0, _ := ret[0].([]proton.Contact)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetContacts indicates an expected call of GetContacts.
+func (mr *MockClientMockRecorder) GetContacts(ctx, page, pageSize any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetContacts", reflect.TypeOf((*MockClient)(nil).GetContacts), ctx, page, pageSize)
+}
+
 // GetGroupedMessageCount mocks base method.
 func (m *MockClient) GetGroupedMessageCount(ctx context.Context) ([]proton.MessageGroupCount, error) {
 	m.ctrl.T.Helper()
@@ -228,6 +379,36 @@ func (mr *MockClientMockRecorder) GetLabels(ctx any, labelTypes ...any) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLabels", reflect.TypeOf((*MockClient)(nil).GetLabels), varargs...)
 }
 
+// GetMailFilters mocks base method.
+func (m *MockClient) GetMailFilters(ctx context.Context) ([]MailFilter, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMailFilters", ctx)
+	ret0, _ := ret[0].([]MailFilter)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMailFilters indicates an expected call of GetMailFilters.
+func (mr *MockClientMockRecorder) GetMailFilters(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMailFilters", reflect.TypeOf((*MockClient)(nil).GetMailFilters), ctx)
+}
+
+// GetMailSettings mocks base method.
+func (m *MockClient) GetMailSettings(ctx context.Context) (proton.MailSettings, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMailSettings", ctx)
+	ret0, _ := ret[0].(proton.MailSettings)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMailSettings indicates an expected call of GetMailSettings.
+func (mr *MockClientMockRecorder) GetMailSettings(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMailSettings", reflect.TypeOf((*MockClient)(nil).GetMailSettings), ctx)
+}
+
 // GetMessage mocks base method.
 func (m *MockClient) GetMessage(ctx context.Context, messageID string) (proton.Message, error) {
 	m.ctrl.T.Helper()
@@ -352,6 +533,65 @@ func (mr *MockClientMockRecorder) SendDataEvent(ctx, req any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendDataEvent", reflect.TypeOf((*MockClient)(nil).SendDataEvent), ctx, req)
 }
 
+// SetDisplayName mocks base method.
+func (m *MockClient) SetDisplayName(ctx context.Context, req proton.SetDisplayNameReq) (proton.MailSettings, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetDisplayName", ctx, req)
+	ret0, _ := ret[0].(proton.MailSettings)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetDisplayName indicates an expected call of SetDisplayName.
+func (mr *MockClientMockRecorder) SetDisplayName(ctx, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDisplayName", reflect.TypeOf((*MockClient)(nil).SetDisplayName), ctx, req)
+}
+
+// SetSignature mocks base method.
+func (m *MockClient) SetSignature(ctx context.Context, req proton.SetSignatureReq) (proton.MailSettings, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetSignature", ctx, req)
+	ret0, _ := ret[0].(proton.MailSettings)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetSignature indicates an expected call of SetSignature.
+func (mr *MockClientMockRecorder) SetSignature(ctx, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSignature", reflect.TypeOf((*MockClient)(nil).SetSignature), ctx, req)
+}
+
+// UpdateAddress mocks base method.
+func (m *MockClient) UpdateAddress(ctx context.Context, addressID string, req UpdateAddressReq) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateAddress", ctx, addressID, req)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateAddress indicates an expected call of UpdateAddress.
+func (mr *MockClientMockRecorder) UpdateAddress(ctx, addressID, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAddress", reflect.TypeOf((*MockClient)(nil).UpdateAddress), ctx, addressID, req)
+}
+
+// UpdateLabel mocks base method.
+func (m *MockClient) UpdateLabel(ctx context.Context, labelID string, req proton.UpdateLabelReq) (proton.Label, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateLabel", ctx, labelID, req)
+	ret0, _ := ret[0].(proton.Label)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateLabel indicates an expected call of UpdateLabel.
+func (mr *MockClientMockRecorder) UpdateLabel(ctx, labelID, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLabel", reflect.TypeOf((*MockClient)(nil).UpdateLabel), ctx, labelID, req)
+}
+
 // MockRetryStrategy is a mock of RetryStrategy interface.
 type MockRetryStrategy struct {
 	ctrl     *gomock.Controller
@@ -377,13 +617,15 @@ func (m *MockRetryStrategy) EXPECT() *MockRetryStrategyMockRecorder {
 }
 
 // HandleRetry mocks base method.
-func (m *MockRetryStrategy) HandleRetry(ctx context.Context) {
+func (m *MockRetryStrategy) HandleRetry(ctx context.Context, err error) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "HandleRetry", ctx)
+	ret := m.ctrl.Call(m, "HandleRetry", ctx, err)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // HandleRetry indicates an expected call of HandleRetry.
-func (mr *MockRetryStrategyMockRecorder) HandleRetry(ctx any) *gomock.Call {
+func (mr *MockRetryStrategyMockRecorder) HandleRetry(ctx, err any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleRetry", reflect.TypeOf((*MockRetryStrategy)(nil).HandleRetry), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleRetry", reflect.TypeOf((*MockRetryStrategy)(nil).HandleRetry), ctx, err)
 }
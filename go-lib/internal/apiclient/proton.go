@@ -43,6 +43,7 @@ type ProtonCallbacks interface {
 
 type ProtonAPIClientBuilder struct {
 	manager  *proton.Manager
+	rc       *resty.Client
 	callback ProtonCallbacks
 }
 
@@ -59,7 +60,13 @@ func NewProtonAPIClientBuilder(apiURL string, panicHandler async.PanicHandler, c
 			proton.WithLogger(logrus.StandardLogger()),
 			proton.WithPanicHandler(panicHandler),
 			proton.WithCookieJar(cookieJar),
+			proton.WithTransport(newRetryAfterTransport(http.DefaultTransport)),
 		),
+		rc: resty.New().
+			SetBaseURL(apiURL).
+			SetCookieJar(cookieJar).
+			SetTransport(newRetryAfterTransport(http.DefaultTransport)).
+			SetHeader("x-pm-appversion", internal.ETAppIdentifier),
 		callback: callbacks,
 	}
 
@@ -96,7 +103,12 @@ func NewProtonAPIClientBuilder(apiURL string, panicHandler async.PanicHandler, c
 }
 
 func (p *ProtonAPIClientBuilder) NewClient(ctx context.Context, username string, password []byte, hvToken *proton.APIHVDetails) (Client, proton.Auth, error) {
-	return p.manager.NewClientWithLoginWithHVToken(ctx, username, password, hvToken)
+	client, auth, err := p.manager.NewClientWithLoginWithHVToken(ctx, username, password, hvToken)
+	if err != nil {
+		return nil, auth, err
+	}
+
+	return newProtonClient(client, p.rc, auth), auth, nil
 }
 
 func (p *ProtonAPIClientBuilder) Close() {
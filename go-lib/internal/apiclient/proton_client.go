@@ -0,0 +1,179 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/go-resty/resty/v2"
+)
+
+// MailFilter is a Sieve filter of the account.
+type MailFilter struct {
+	ID       string
+	Name     string
+	Status   int
+	Priority int
+	Version  int
+	Sieve    string
+}
+
+type CreateMailFilterReq struct {
+	Name    string
+	Status  int
+	Version int
+	Sieve   string
+}
+
+// AddressSignature is the signature of an address, which go-proton-api leaves out of proton.Address.
+type AddressSignature struct {
+	ID        string
+	Signature string
+}
+
+type UpdateAddressReq struct {
+	DisplayName string
+	Signature   string
+}
+
+// protonClient adds the endpoints go-proton-api does not expose to its client. Those requests are sent with the auth of
+// the client, which is refreshed through the client when they are rejected with a 401.
+type protonClient struct {
+	*proton.Client
+
+	rc *resty.Client
+
+	uid      string
+	acc      string
+	authLock sync.RWMutex
+}
+
+func newProtonClient(client *proton.Client, rc *resty.Client, auth proton.Auth) *protonClient {
+	c := &protonClient{
+		Client: client,
+		rc:     rc,
+		uid:    auth.UID,
+		acc:    auth.AccessToken,
+	}
+
+	client.AddAuthHandler(func(auth proton.Auth) {
+		c.authLock.Lock()
+		defer c.authLock.Unlock()
+
+		c.acc = auth.AccessToken
+	})
+
+	return c
+}
+
+func (c *protonClient) GetMailFilters(ctx context.Context) ([]MailFilter, error) {
+	var res struct {
+		Filters []MailFilter
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/mail/v4/filters")
+	}); err != nil {
+		return nil, err
+	}
+
+	return res.Filters, nil
+}
+
+func (c *protonClient) CreateMailFilter(ctx context.Context, req CreateMailFilterReq) (MailFilter, error) {
+	var res struct {
+		Filter MailFilter
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(req).SetResult(&res).Post("/mail/v4/filters")
+	}); err != nil {
+		return MailFilter{}, err
+	}
+
+	return res.Filter, nil
+}
+
+func (c *protonClient) GetAddressSignatures(ctx context.Context) ([]AddressSignature, error) {
+	var res struct {
+		Addresses []AddressSignature
+	}
+
+	if err := c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetResult(&res).Get("/core/v4/addresses")
+	}); err != nil {
+		return nil, err
+	}
+
+	return res.Addresses, nil
+}
+
+func (c *protonClient) UpdateAddress(ctx context.Context, addressID string, req UpdateAddressReq) error {
+	return c.do(ctx, func(r *resty.Request) (*resty.Response, error) {
+		return r.SetBody(req).Put("/core/v4/addresses/" + addressID)
+	})
+}
+
+func (c *protonClient) do(ctx context.Context, fn func(*resty.Request) (*resty.Response, error)) error {
+	res, err := c.exec(ctx, fn)
+
+	if res != nil && res.StatusCode() == http.StatusUnauthorized {
+		// Any request of the proton client refreshes the auth, the new access token is set by the auth handler.
+		if _, err := c.Client.GetUserSettings(ctx); err != nil {
+			return fmt.Errorf("failed to refresh auth: %w", err)
+		}
+
+		res, err = c.exec(ctx, fn)
+	}
+
+	if res == nil || res.RawResponse == nil {
+		return &proton.NetError{Cause: err, Message: "received no response from API"}
+	}
+
+	if apiErr, ok := res.Error().(*proton.APIError); ok && res.IsError() {
+		apiErr.Status = res.StatusCode()
+		return apiErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return errors.New(res.Status())
+	}
+
+	return nil
+}
+
+func (c *protonClient) exec(ctx context.Context, fn func(*resty.Request) (*resty.Response, error)) (*resty.Response, error) {
+	c.authLock.RLock()
+	defer c.authLock.RUnlock()
+
+	return fn(c.rc.R().
+		SetContext(ctx).
+		SetError(&proton.APIError{}).
+		SetHeader("x-pm-uid", c.uid).
+		SetAuthToken(c.acc),
+	)
+}
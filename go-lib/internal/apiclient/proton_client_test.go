@@ -0,0 +1,78 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProtonClient_RefreshesAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/auth/v4/refresh" {
+			_, _ = w.Write([]byte(`{"Code":1000,"UID":"uid","AccessToken":"new","RefreshToken":"ref"}`))
+			return
+		}
+
+		if r.Header.Get("x-pm-uid") != "uid" || r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"Code":401,"Error":"Invalid access token"}`))
+
+			return
+		}
+
+		switch r.URL.Path {
+		case "/core/v4/settings":
+			_, _ = w.Write([]byte(`{"Code":1000,"UserSettings":{}}`))
+		case "/mail/v4/filters":
+			_, _ = w.Write([]byte(`{"Code":1000,"Filters":[{"ID":"filter","Name":"Newsletters","Version":2,"Sieve":"keep;"}]}`))
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"Code":2001,"Error":"Invalid address"}`))
+		}
+	}))
+	defer srv.Close()
+
+	manager := proton.New(proton.WithHostURL(srv.URL))
+	defer manager.Close()
+
+	client := newProtonClient(
+		manager.NewClient("uid", "old", "ref"),
+		resty.New().SetBaseURL(srv.URL),
+		proton.Auth{UID: "uid", AccessToken: "old", RefreshToken: "ref"},
+	)
+
+	filters, err := client.GetMailFilters(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []MailFilter{{ID: "filter", Name: "Newsletters", Version: 2, Sieve: "keep;"}}, filters)
+
+	err = client.UpdateAddress(context.Background(), "address", UpdateAddressReq{Signature: "Regards"})
+
+	apiErr := new(proton.APIError)
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusUnprocessableEntity, apiErr.Status)
+	require.Equal(t, proton.InvalidValue, apiErr.Code)
+}
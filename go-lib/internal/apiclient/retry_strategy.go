@@ -0,0 +1,310 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMaxRetryTime is how long a request is retried for before giving up.
+const DefaultMaxRetryTime = time.Hour
+
+// The retries of all the requests of a client are paced by a token bucket, so that they don't all hit the API at once
+// after an outage or when it rate limits the client.
+const retryTokensPerSecond = 2
+const retryTokenBurst = 4
+
+var ErrRetryTimeExceeded = errors.New("request kept failing")
+
+// RetryAfterError is passed to the RetryStrategy when the API told how long to wait before retrying the request.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %v)", e.Err, e.RetryAfter)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitRetryStrategyBuilder creates strategies which wait for as long as the API asks with the Retry-After header, or
+// back off exponentially with a base delay depending on the error otherwise. The strategies of one builder share a token
+// bucket: the retries of all the requests are paced together, and all of them wait when the client is rate limited.
+// A new builder must be used for each AutoRetryClient.
+type RateLimitRetryStrategyBuilder struct {
+	bucket       *tokenBucket
+	maxRetryTime time.Duration
+}
+
+func NewRateLimitRetryStrategyBuilder() *RateLimitRetryStrategyBuilder {
+	return &RateLimitRetryStrategyBuilder{
+		bucket:       newTokenBucket(retryTokensPerSecond, retryTokenBurst),
+		maxRetryTime: DefaultMaxRetryTime,
+	}
+}
+
+// SetMaxRetryTime changes how long each request is retried for, DefaultMaxRetryTime by default.
+func (r *RateLimitRetryStrategyBuilder) SetMaxRetryTime(maxRetryTime time.Duration) {
+	r.maxRetryTime = maxRetryTime
+}
+
+func (r *RateLimitRetryStrategyBuilder) NewRetryStrategy() RetryStrategy {
+	return &RateLimitRetryStrategy{
+		bucket:       r.bucket,
+		maxRetryTime: r.maxRetryTime,
+		start:        r.bucket.now(),
+	}
+}
+
+// WaitBeforeRequest delays new requests while the client is rate limited, see requestGate.
+func (r *RateLimitRetryStrategyBuilder) WaitBeforeRequest(ctx context.Context) error {
+	if wait := r.bucket.getPauseRemaining(); wait > 0 {
+		sleepCtx(ctx, wait)
+	}
+
+	return ctx.Err()
+}
+
+type RateLimitRetryStrategy struct {
+	bucket       *tokenBucket
+	maxRetryTime time.Duration
+	start        time.Time
+	attempt      int
+}
+
+func (s *RateLimitRetryStrategy) HandleRetry(ctx context.Context, err error) error {
+	delay := s.getDelay(err)
+
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		s.bucket.pause(delay)
+	}
+
+	delay = max(delay, s.bucket.reserve())
+
+	if elapsed := s.bucket.now().Sub(s.start); elapsed+delay > s.maxRetryTime {
+		return fmt.Errorf("%w, gave up after retrying for %v: %w", ErrRetryTimeExceeded, elapsed.Round(time.Second), err)
+	}
+
+	logrus.WithError(err).WithField("delay", delay).Debug("Retrying request")
+
+	if observer, ok := ctx.Value(retryObserverKey{}).(RetryObserver); ok {
+		observer.OnRetry(delay, err)
+	}
+
+	sleepCtx(ctx, delay)
+
+	return ctx.Err()
+}
+
+// RetryObserver is notified before the requests made with a context returned by WithRetryObserver are retried by a
+// RateLimitRetryStrategy.
+type RetryObserver interface {
+	OnRetry(delay time.Duration, err error)
+}
+
+type retryObserverKey struct{}
+
+func WithRetryObserver(ctx context.Context, observer RetryObserver) context.Context {
+	return context.WithValue(ctx, retryObserverKey{}, observer)
+}
+
+// getDelay returns the time to wait before the next attempt. Rate limiting and server errors start with a longer delay
+// than network errors, which are usually transient.
+func (s *RateLimitRetryStrategy) getDelay(err error) time.Duration {
+	defer func() { s.attempt++ }()
+
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		return retryAfterErr.RetryAfter + jitter(retryAfterErr.RetryAfter/10)
+	}
+
+	baseDelay, maxDelay := time.Second, time.Minute
+
+	if apiErr := new(proton.APIError); errors.As(err, &apiErr) {
+		if apiErr.Status == http.StatusTooManyRequests {
+			baseDelay, maxDelay = 10*time.Second, 10*time.Minute
+		} else {
+			baseDelay, maxDelay = 5*time.Second, 5*time.Minute
+		}
+	}
+
+	delay := maxDelay
+	if s.attempt < 16 {
+		delay = min(baseDelay<<s.attempt, maxDelay)
+	}
+
+	return delay + jitter(delay/10)
+}
+
+// tokenBucket hands out tokens at a fixed rate, up to burst tokens at once. The bucket can be paused, no token is
+// available before the pause ends.
+type tokenBucket struct {
+	lock        sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// reserve takes a token and returns how long to wait before it can be used.
+func (b *tokenBucket) reserve() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+
+	return max(wait, b.pausedUntil.Sub(now))
+}
+
+func (b *tokenBucket) pause(duration time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if until := b.now().Add(duration); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+}
+
+func (b *tokenBucket) getPauseRemaining() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.pausedUntil.Sub(b.now())
+}
+
+// requestGate is implemented by the RetryStrategyBuilders which can hold back the first attempt of requests.
+type requestGate interface {
+	WaitBeforeRequest(ctx context.Context) error
+}
+
+// retryAfterTransport records the Retry-After header of the responses to rate limited requests, for the AutoRetryClient
+// which made them, see withRetryAfterHolder.
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func newRetryAfterTransport(next http.RoundTripper) http.RoundTripper {
+	return &retryAfterTransport{next: next}
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return res, nil
+	}
+
+	if holder, ok := req.Context().Value(retryAfterHolderKey{}).(*retryAfterHolder); ok {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			holder.value.Store(int64(retryAfter))
+		}
+	}
+
+	return res, nil
+}
+
+type retryAfterHolderKey struct{}
+
+type retryAfterHolder struct {
+	value atomic.Int64
+}
+
+func withRetryAfterHolder(ctx context.Context, holder *retryAfterHolder) context.Context {
+	return context.WithValue(ctx, retryAfterHolderKey{}, holder)
+}
+
+// wrapError returns a RetryAfterError if the API told how long to wait before retrying the failed request.
+func (h *retryAfterHolder) wrapError(err error) error {
+	if retryAfter := time.Duration(h.value.Load()); retryAfter > 0 {
+		return &RetryAfterError{Err: err, RetryAfter: retryAfter}
+	}
+
+	return err
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, seconds > 0
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return date.Sub(now), date.After(now)
+}
+
+func jitter(maxJitter time.Duration) time.Duration {
+	if maxJitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(maxJitter))) //nolint:gosec
+}
+
+func sleepCtx(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	bucket := newTokenBucket(2, 2)
+	bucket.now = func() time.Time { return now }
+	bucket.last = now
+
+	// The burst is available immediately, then tokens come at the rate of the bucket.
+	require.Equal(t, time.Duration(0), bucket.reserve())
+	require.Equal(t, time.Duration(0), bucket.reserve())
+	require.Equal(t, 500*time.Millisecond, bucket.reserve())
+
+	now = now.Add(5 * time.Second)
+	require.Equal(t, time.Duration(0), bucket.reserve())
+
+	// No token is available while the bucket is paused.
+	bucket.pause(30 * time.Second)
+	bucket.pause(10 * time.Second)
+	require.Equal(t, 30*time.Second, bucket.getPauseRemaining())
+	require.Equal(t, 30*time.Second, bucket.reserve())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+
+	retryAfter, ok := parseRetryAfter("120", now)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Minute, retryAfter)
+
+	retryAfter, ok = parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, retryAfter)
+
+	for _, value := range []string{"", "0", "-5", "soon", now.Add(-time.Minute).Format(http.TimeFormat)} {
+		_, ok := parseRetryAfter(value, now)
+		require.False(t, ok, value)
+	}
+}
+
+func TestRetryAfterTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "42")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	holder := &retryAfterHolder{}
+
+	req, err := http.NewRequestWithContext(withRetryAfterHolder(context.Background(), holder), http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	res, err := newRetryAfterTransport(http.DefaultTransport).RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+
+	apiErr := &proton.APIError{Status: http.StatusTooManyRequests}
+
+	var retryAfterErr *RetryAfterError
+	require.ErrorAs(t, holder.wrapError(apiErr), &retryAfterErr)
+	require.Equal(t, 42*time.Second, retryAfterErr.RetryAfter)
+	require.ErrorIs(t, retryAfterErr, apiErr)
+
+	require.Equal(t, apiErr, (&retryAfterHolder{}).wrapError(apiErr))
+}
+
+func TestRateLimitRetryStrategy_Delay(t *testing.T) {
+	strategy := NewRateLimitRetryStrategyBuilder().NewRetryStrategy().(*RateLimitRetryStrategy) //nolint:forcetypeassert
+
+	requireDelay := func(err error, expected time.Duration) {
+		delay := strategy.getDelay(err)
+		require.GreaterOrEqual(t, delay, expected)
+		require.Less(t, delay, expected+expected/10+1)
+	}
+
+	requireDelay(&RetryAfterError{Err: &proton.APIError{Status: 429}, RetryAfter: time.Minute}, time.Minute)
+	requireDelay(&proton.APIError{Status: 429}, 20*time.Second)
+	requireDelay(&proton.APIError{Status: 503}, 20*time.Second)
+	requireDelay(&proton.NetError{}, 8*time.Second)
+
+	strategy.attempt = 100
+	requireDelay(&proton.APIError{Status: 500}, 5*time.Minute)
+	requireDelay(&proton.NetError{}, time.Minute)
+}
+
+func TestRateLimitRetryStrategy_MaxRetryTime(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	builder := NewRateLimitRetryStrategyBuilder()
+	builder.SetMaxRetryTime(time.Minute)
+	builder.bucket.now = func() time.Time { return now }
+
+	strategy := builder.NewRetryStrategy()
+
+	now = now.Add(time.Minute)
+
+	apiErr := &proton.APIError{Status: 503}
+
+	err := strategy.HandleRetry(context.Background(), apiErr)
+	require.ErrorIs(t, err, ErrRetryTimeExceeded)
+	require.ErrorIs(t, err, apiErr)
+}
+
+func TestAutoRetryClient_StopRetrying(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	strategy := NewMockRetryStrategy(mockCtrl)
+	mockClient := NewMockClient(mockCtrl)
+
+	client := NewAutoRetryClient(mockClient, &mockRetryStrategyBuilder{s: strategy})
+
+	stopErr := errors.New("stop")
+
+	mockClient.EXPECT().GetMessage(gomock.Any(), gomock.Any()).Times(1).Return(proton.Message{}, &proton.APIError{Status: 500})
+	strategy.EXPECT().HandleRetry(gomock.Any(), gomock.Any()).Return(stopErr).Times(1)
+
+	_, err := client.GetMessage(context.Background(), "msgid")
+	require.Equal(t, stopErr, err)
+}
+
+func TestRateLimitRetryStrategy_RetryObserver(t *testing.T) {
+	observer := &testRetryObserver{}
+
+	err := &RetryAfterError{Err: &proton.APIError{Status: 429}, RetryAfter: time.Millisecond}
+
+	strategy := NewRateLimitRetryStrategyBuilder().NewRetryStrategy()
+	require.NoError(t, strategy.HandleRetry(WithRetryObserver(context.Background(), observer), err))
+	require.Equal(t, []error{err}, observer.errors)
+}
+
+type testRetryObserver struct {
+	errors []error
+}
+
+func (t *testRetryObserver) OnRetry(_ time.Duration, err error) {
+	t.errors = append(t.errors, err)
+}
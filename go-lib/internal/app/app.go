@@ -2,18 +2,24 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
 
 	"github.com/ProtonMail/export-tool/internal"
 	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/calendar"
+	"github.com/ProtonMail/export-tool/internal/contacts"
 	"github.com/ProtonMail/export-tool/internal/mail"
 	"github.com/ProtonMail/export-tool/internal/reporter"
 	"github.com/ProtonMail/export-tool/internal/sentry"
 	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/task"
+	"github.com/ProtonMail/export-tool/internal/utils"
 	"github.com/ProtonMail/gluon/async"
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/sirupsen/logrus"
@@ -22,6 +28,9 @@ import (
 
 const retryCount = 5
 
+// exitCodePartialBackup is the exit code of the backups which completed without some of the data.
+const exitCodePartialBackup = 2
+
 var (
 	flagUsername = &cli.StringFlag{ //nolint:gochecknoglobals
 		Name:    "username",
@@ -53,6 +62,157 @@ var (
 		Aliases: []string{"f"},
 		EnvVars: []string{"ET_DIR"},
 	}
+	flagResume = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "resume",
+		Usage:   "resume the most recent backup found in the backup directory instead of starting a new one",
+		EnvVars: []string{"ET_RESUME"},
+	}
+	flagIncremental = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "incremental",
+		Usage:   "only backup messages that are new or have changed since the last incremental backup",
+		EnvVars: []string{"ET_INCREMENTAL"},
+	}
+	flagFormat = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "format",
+		Usage:   "format of the backup: eml (default), mbox or maildir",
+		EnvVars: []string{"ET_FORMAT"},
+	}
+	flagArchive = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "archive",
+		Usage:   "store the backup in a single archive file: tar.zst or zip (eml format only)",
+		EnvVars: []string{"ET_ARCHIVE"},
+	}
+	flagLayout = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "layout",
+		Usage:   "where messages are stored: flat (default) or labels, in the folders of their labels (unencrypted eml format only)",
+		EnvVars: []string{"ET_LAYOUT"},
+	}
+	flagAfter = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "after",
+		Usage:   "only backup or restore messages received on or after this date (YYYY-MM-DD or RFC 3339)",
+		EnvVars: []string{"ET_AFTER"},
+	}
+	flagBefore = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "before",
+		Usage:   "only backup or restore messages received before this date (YYYY-MM-DD or RFC 3339)",
+		EnvVars: []string{"ET_BEFORE"},
+	}
+	flagLabel = &cli.StringSliceFlag{ //nolint:gochecknoglobals
+		Name:    "label",
+		Usage:   "only backup or restore messages with one of these labels or folders (name or ID)",
+		EnvVars: []string{"ET_LABEL"},
+	}
+	flagExcludeLabel = &cli.StringSliceFlag{ //nolint:gochecknoglobals
+		Name:    "exclude-label",
+		Usage:   "do not backup or restore messages with one of these labels or folders (name or ID)",
+		EnvVars: []string{"ET_EXCLUDE_LABEL"},
+	}
+	flagAddress = &cli.StringSliceFlag{ //nolint:gochecknoglobals
+		Name:    "address",
+		Usage:   "only backup or restore messages of these addresses (email or ID)",
+		EnvVars: []string{"ET_ADDRESS"},
+	}
+	flagParticipant = &cli.StringSliceFlag{ //nolint:gochecknoglobals
+		Name:    "participant",
+		Usage:   "only restore messages sent by or to one of these email addresses",
+		EnvVars: []string{"ET_PARTICIPANT"},
+	}
+	flagAddressMap = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "address-map",
+		Usage:   "JSON file mapping backup addresses to the addresses messages are restored into (emails or IDs)",
+		EnvVars: []string{"ET_ADDRESS_MAP"},
+	}
+	flagAddressFallback = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "address-fallback",
+		Usage:   "address restoring messages which do not match any address of the account (email, ID or 'primary')",
+		EnvVars: []string{"ET_ADDRESS_FALLBACK"},
+	}
+	flagEncryptionKey = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "encryption-key",
+		Usage:   "armored OpenPGP key file: the backup is encrypted to the key, restore decrypts it with the private key",
+		EnvVars: []string{"ET_ENCRYPTION_KEY"},
+	}
+	flagEncryptionKeyPassphrase = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "encryption-key-passphrase",
+		Usage:   "passphrase unlocking the private encryption key",
+		EnvVars: []string{"ET_ENCRYPTION_KEY_PASSPHRASE"},
+	}
+	flagEncryptionPassphrase = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "encryption-passphrase",
+		Usage:   "passphrase the backup is encrypted with, instead of a key",
+		EnvVars: []string{"ET_ENCRYPTION_PASSPHRASE"},
+	}
+	flagEvents = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "events",
+		Usage:   "write the backup or restore events as JSON lines to this file, or to the standard output with '-'",
+		EnvVars: []string{"ET_EVENTS"},
+	}
+	flagFailureReport = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "failure-report",
+		Usage:   "write the messages which failed to back up or restore to this file, as CSV if it ends with .csv and JSON otherwise",
+		EnvVars: []string{"ET_FAILURE_REPORT"},
+	}
+	flagReport = &cli.StringFlag{ //nolint:gochecknoglobals
+		Name:    "report",
+		Usage:   "write the full verify or diff report to this JSON file",
+		EnvVars: []string{"ET_REPORT"},
+	}
+	flagParallelDownloads = &cli.IntFlag{ //nolint:gochecknoglobals
+		Name:    "parallel-downloads",
+		Usage:   "number of messages downloaded in parallel during a backup, adjusted automatically by default",
+		EnvVars: []string{"ET_PARALLEL_DOWNLOADS"},
+	}
+	flagParallelBuilders = &cli.IntFlag{ //nolint:gochecknoglobals
+		Name:    "parallel-builders",
+		Usage:   "number of messages decrypted and built in parallel during a backup, adjusted automatically by default",
+		EnvVars: []string{"ET_PARALLEL_BUILDERS"},
+	}
+	flagParallelWriters = &cli.IntFlag{ //nolint:gochecknoglobals
+		Name:    "parallel-writers",
+		Usage:   "number of messages written in parallel during a backup, adjusted automatically by default",
+		EnvVars: []string{"ET_PARALLEL_WRITERS"},
+	}
+	flagMemoryLimit = &cli.Uint64Flag{ //nolint:gochecknoglobals
+		Name:    "memory-limit",
+		Usage:   "memory in MB used for the messages being downloaded and built during a backup",
+		EnvVars: []string{"ET_MEMORY_LIMIT"},
+	}
+	flagErrorBudget = &cli.IntFlag{ //nolint:gochecknoglobals
+		Name:    "error-budget",
+		Usage:   "number of messages a backup may leave out when they keep failing, instead of stopping on the first failure. The backup then exits with code 2",
+		EnvVars: []string{"ET_ERROR_BUDGET"},
+	}
+	flagUnreadOnly = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "unread-only",
+		Usage:   "only backup unread messages",
+		EnvVars: []string{"ET_UNREAD_ONLY"},
+	}
+	flagWithAttachments = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "with-attachments",
+		Usage:   "only backup messages with attachments",
+		EnvVars: []string{"ET_WITH_ATTACHMENTS"},
+	}
+	flagContacts = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "contacts",
+		Usage:   "also backup or restore the contacts, as vCard files next to the mail backup. The backup exits with code 2 if some contacts could not be exported",
+		EnvVars: []string{"ET_CONTACTS"},
+	}
+	flagCalendars = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "calendars",
+		Usage:   "also backup the calendars, as iCalendar files next to the mail backup. Exits with code 2 if some calendars or events could not be exported",
+		EnvVars: []string{"ET_CALENDARS"},
+	}
+	flagRestoreSettings = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "restore-settings",
+		Usage:   "re-apply the display name, address signatures, filters and label colors of the backup during a restore",
+		EnvVars: []string{"ET_RESTORE_SETTINGS"},
+	}
+	flagSkipExisting = &cli.BoolFlag{ //nolint:gochecknoglobals
+		Name:    "skip-existing",
+		Usage:   "skip the messages already in the account, with the same Message-ID, time and labels, during a restore",
+		Value:   true,
+		EnvVars: []string{"ET_SKIP_EXISTING"},
+	}
 )
 
 func Run() {
@@ -77,6 +237,36 @@ func Run() {
 			flagTOTP,
 			flagOperation,
 			flagFolder,
+			flagResume,
+			flagIncremental,
+			flagFormat,
+			flagArchive,
+			flagLayout,
+			flagAfter,
+			flagBefore,
+			flagLabel,
+			flagExcludeLabel,
+			flagAddress,
+			flagParticipant,
+			flagAddressMap,
+			flagAddressFallback,
+			flagEncryptionKey,
+			flagEncryptionKeyPassphrase,
+			flagEncryptionPassphrase,
+			flagFailureReport,
+			flagReport,
+			flagEvents,
+			flagParallelDownloads,
+			flagParallelBuilders,
+			flagParallelWriters,
+			flagMemoryLimit,
+			flagErrorBudget,
+			flagUnreadOnly,
+			flagWithAttachments,
+			flagContacts,
+			flagCalendars,
+			flagRestoreSettings,
+			flagSkipExisting,
 		},
 	}
 
@@ -86,7 +276,7 @@ func Run() {
 }
 
 func fatal(err error) {
-	fmt.Printf("\nFatal error: %v\n", err)
+	fmt.Fprintf(console, "\nFatal error: %v\n", err)
 	logrus.WithError(err).Fatal("Fatal error")
 }
 
@@ -94,12 +284,25 @@ func run(ctx *cli.Context) error {
 	panicHandler := sentry.NewPanicHandler(func() {})
 	defer async.HandlePanic(panicHandler)
 
+	events, err := newEventWriterFromCLI(ctx)
+	if err != nil {
+		return err
+	}
+
+	if closer, ok := events.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				logrus.WithError(err).Error("Failed to close event file")
+			}
+		}()
+	}
+
 	printHeader()
 	checkForNewVersion()
 
-	fmt.Printf("\nSession log: %v\n\n", filepath.FromSlash(state.logPath))
+	fmt.Fprintf(console, "\nSession log: %v\n\n", filepath.FromSlash(state.logPath))
 
-	session, err := newSession(panicHandler)
+	session, err := newSession(panicHandler, events)
 	if err != nil {
 		return err
 	}
@@ -109,6 +312,21 @@ func run(ctx *cli.Context) error {
 		return err
 	}
 
+	if operation == operationVerify {
+		// A backup is verified offline, no login is needed.
+		dir, err := getTargetFolder(ctx, operation, ctx.String(flagUsername.Name))
+		if err != nil {
+			return err
+		}
+
+		decrypter, err := newFileCipherFromCLI(ctx)
+		if err != nil {
+			return err
+		}
+
+		return runVerify(ctx.Context, dir, decrypter, ctx.String(flagReport.Name))
+	}
+
 	if err = login(ctx, session); err != nil {
 		return err
 	}
@@ -119,28 +337,52 @@ func run(ctx *cli.Context) error {
 	}
 
 	if operation == operationBackup {
-		return runBackup(ctx.Context, dir, session)
+		opts, err := newBackupOptionsFromCLI(ctx)
+		if err != nil {
+			return err
+		}
+
+		return runBackup(ctx.Context, dir, session, opts, events)
 	}
 
 	if operation == operationRestore {
-		return runRestore(ctx.Context, dir, session)
+		opts, err := newRestoreOptionsFromCLI(ctx)
+		if err != nil {
+			return err
+		}
+
+		return runRestore(ctx.Context, dir, session, opts, events)
+	}
+
+	if operation == operationDiff {
+		filter, err := newExportFilterFromCLI(ctx)
+		if err != nil {
+			return err
+		}
+
+		decrypter, err := newFileCipherFromCLI(ctx)
+		if err != nil {
+			return err
+		}
+
+		return runDiff(ctx.Context, dir, session, filter, decrypter, ctx.String(flagReport.Name))
 	}
 
 	return nil
 }
 
 func printHeader() {
-	fmt.Printf("Proton Mail Export Tool (%v) (c) Proton AG, Switzerland\n", internal.ETVersionString)
-	fmt.Printf("This program is licensed under the GNU General Public License v3\n")
-	fmt.Printf("Get support at https://proton.me/support/proton-mail-export-tool\n\n")
+	fmt.Fprintf(console, "Proton Mail Export Tool (%v) (c) Proton AG, Switzerland\n", internal.ETVersionString)
+	fmt.Fprintf(console, "This program is licensed under the GNU General Public License v3\n")
+	fmt.Fprintf(console, "Get support at https://proton.me/support/proton-mail-export-tool\n\n")
 }
 
 func checkForNewVersion() {
-	fmt.Print("Checking for new version... ")
+	fmt.Fprint(console, "Checking for new version... ")
 	if internal.HasNewVersion() {
-		fmt.Println("A new version is available at: https://proton.me/support/proton-mail-export-tool")
+		fmt.Fprintln(console, "A new version is available at: https://proton.me/support/proton-mail-export-tool")
 	} else {
-		fmt.Println("Your version is up to date")
+		fmt.Fprintln(console, "Your version is up to date")
 	}
 }
 
@@ -153,8 +395,22 @@ func getAPIURL() string {
 	return url
 }
 
-func newSession(panicHandler async.PanicHandler) (*session.Session, error) {
-	sessionCb := CliCallback{}
+// newEventWriterFromCLI returns the writer of the events requested on the command line, which discards them by default.
+// When the events are written to the standard output, the console output is moved to the standard error.
+func newEventWriterFromCLI(ctx *cli.Context) (mail.EventReporter, error) {
+	switch path := ctx.String(flagEvents.Name); path {
+	case "":
+		return mail.NullEventReporter{}, nil
+	case "-":
+		console = os.Stderr
+		return mail.NewJSONEventWriter(os.Stdout), nil
+	default:
+		return mail.NewJSONEventFile(path)
+	}
+}
+
+func newSession(panicHandler async.PanicHandler, events mail.EventReporter) (*session.Session, error) {
+	sessionCb := CliCallback{events: events}
 	builder, err := apiclient.NewProtonAPIClientBuilder(getAPIURL(), panicHandler, sessionCb)
 	if err != nil {
 		return nil, err
@@ -162,20 +418,24 @@ func newSession(panicHandler async.PanicHandler) (*session.Session, error) {
 
 	clientBuilder := apiclient.NewAutoRetryClientBuilder(
 		builder,
-		&apiclient.SleepRetryStrategyBuilder{},
+		apiclient.NewRateLimitRetryStrategyBuilder(),
 	)
 
 	return session.NewSession(clientBuilder, sessionCb, panicHandler, reporter.NullReporter{}, false), nil
 }
 
-type CliCallback struct{}
+type CliCallback struct {
+	events mail.EventReporter
+}
 
 func (n CliCallback) OnNetworkRestored() {
-	fmt.Println("Network restored")
+	fmt.Fprintln(console, "Network restored")
+	n.events.ReportEvent(mail.Event{Type: mail.EventNetworkRestored})
 }
 
 func (n CliCallback) OnNetworkLost() {
-	fmt.Println("Network lost")
+	fmt.Fprintln(console, "Network lost")
+	n.events.ReportEvent(mail.Event{Type: mail.EventNetworkLost})
 }
 
 func login(ctx *cli.Context, s *session.Session) error {
@@ -234,7 +494,7 @@ func login(ctx *cli.Context, s *session.Session) error {
 				return err
 			}
 
-			fmt.Printf("Human Verification requested. Please open the URL below in a  browser and "+
+			fmt.Fprintf(console, "Human Verification requested. Please open the URL below in a  browser and "+
 				" press ENTER when the challenge has been completed.\n\n%s\n\n", url)
 			waitForReturn()
 
@@ -249,37 +509,396 @@ func login(ctx *cli.Context, s *session.Session) error {
 	}
 }
 
-func runBackup(ctx context.Context, exportPath string, session *session.Session) error {
-	exportTask := mail.NewExportTask(ctx, exportPath, session)
-	fmt.Printf("Starting backup - Path=\"%v\"\n", filepath.FromSlash(exportTask.GetExportPath()))
-	err := exportTask.Run(ctx, newCliReporter())
+func runBackup(
+	ctx context.Context,
+	exportPath string,
+	session *session.Session,
+	opts backupOptions,
+	events mail.EventReporter,
+) error {
+	exportTask, err := newExportTask(ctx, exportPath, session, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := exportTask.SetFormat(opts.format); err != nil {
+		return err
+	}
+
+	if err := exportTask.SetArchiveFormat(opts.archiveFormat); err != nil {
+		return err
+	}
+
+	if err := exportTask.SetLayout(opts.layout); err != nil {
+		return err
+	}
+
+	if err := exportTask.SetFilter(opts.filter); err != nil {
+		return err
+	}
+
+	exportTask.SetEventReporter(events)
+
+	if len(opts.failureReport) != 0 {
+		exportTask.SetFailureReportPath(opts.failureReport)
+	}
+
+	if err := exportTask.SetErrorBudget(opts.errorBudget); err != nil {
+		return err
+	}
+
+	if err := exportTask.SetConcurrencyOptions(opts.concurrency); err != nil {
+		return err
+	}
+
+	if opts.encrypter != nil {
+		if err := exportTask.SetEncrypter(opts.encrypter); err != nil {
+			return err
+		}
+	}
+
+	if exportTask.IsResuming() {
+		fmt.Fprintf(console, "Resuming backup - Path=\"%v\"\n", filepath.FromSlash(exportTask.GetExportPath()))
+	} else {
+		fmt.Fprintf(console, "Starting backup - Path=\"%v\"\n", filepath.FromSlash(exportTask.GetExportPath()))
+	}
+
+	err = exportTask.Run(ctx, newCliReporter())
 	if err == nil {
-		fmt.Println("Backup finished")
+		if failed := exportTask.GetFailedMessageCount(); failed != 0 {
+			fmt.Fprintf(console, "Backup finished, %v messages could not be exported\n", failed)
+		} else {
+			fmt.Fprintln(console, "Backup finished")
+		}
+	}
+
+	printFailureReportPath(exportTask.GetFailureReportPath())
+
+	if err != nil {
+		return err
+	}
+
+	// A backup missing some data doesn't stop the others, the outcome is reported once they are done.
+	partial := exportTask.GetFailedMessageCount() != 0
+
+	if opts.contacts {
+		if err := runContactsBackup(ctx, exportPath, session, opts); errors.Is(err, task.ErrPartialExport) {
+			partial = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if opts.calendars {
+		if err := runCalendarBackup(ctx, exportPath, session, opts); errors.Is(err, task.ErrPartialExport) {
+			partial = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if partial {
+		return cli.Exit("Backup is incomplete", exitCodePartialBackup)
+	}
+
+	return nil
+}
+
+func runContactsBackup(ctx context.Context, exportPath string, session *session.Session, opts backupOptions) error {
+	exportTask := contacts.NewExportTask(ctx, exportPath, session)
+
+	if opts.encrypter != nil {
+		exportTask.SetEncrypter(opts.encrypter)
+	}
+
+	fmt.Fprintf(console, "Starting contacts backup - Path=\"%v\"\n", filepath.FromSlash(exportTask.GetExportPath()))
+
+	// The contacts which could be decrypted are written even if others could not.
+	err := exportTask.Run(newCliReporter())
+	if err != nil && !errors.Is(err, task.ErrPartialExport) {
+		return err
+	}
+
+	if failed := exportTask.GetFailedCount(); failed != 0 {
+		fmt.Fprintf(console, "Contacts backup finished, %v contacts could not be exported\n", failed)
+	} else {
+		fmt.Fprintln(console, "Contacts backup finished")
 	}
 
 	return err
 }
 
-func runRestore(ctx context.Context, backupPath string, session *session.Session) error {
+func runCalendarBackup(ctx context.Context, exportPath string, session *session.Session, opts backupOptions) error {
+	exportTask := calendar.NewExportTask(ctx, exportPath, session)
+
+	if opts.encrypter != nil {
+		exportTask.SetEncrypter(opts.encrypter)
+	}
+
+	fmt.Fprintf(console, "Starting calendar backup - Path=\"%v\"\n", filepath.FromSlash(exportTask.GetExportPath()))
+
+	// The calendars and events which could be decrypted are written even if others could not.
+	err := exportTask.Run(newCliReporter())
+	if err != nil && !errors.Is(err, task.ErrPartialExport) {
+		return err
+	}
+
+	if failed := exportTask.GetFailedCalendarCount(); failed != 0 {
+		fmt.Fprintf(console, "%v calendars could not be unlocked and were not exported\n", failed)
+	}
+
+	if failed := exportTask.GetFailedCount(); failed != 0 {
+		fmt.Fprintf(console, "Calendar backup finished, %v events could not be exported\n", failed)
+	} else {
+		fmt.Fprintln(console, "Calendar backup finished")
+	}
+
+	return err
+}
+
+func runRestore(
+	ctx context.Context,
+	backupPath string,
+	session *session.Session,
+	opts restoreOptions,
+	events mail.EventReporter,
+) error {
 	restoreTask, err := mail.NewRestoreTask(ctx, backupPath, session)
 	if err != nil {
 		return err
 	}
+	defer restoreTask.Close()
+
+	restoreTask.SetFilter(opts.filter)
+	restoreTask.SetAddressMapping(opts.addressMapping)
+	restoreTask.SetAddressFallback(opts.addressFallback)
+	restoreTask.SetRestoreSettings(opts.restoreSettings)
+	restoreTask.SetSkipExisting(opts.skipExisting)
+	restoreTask.SetEventReporter(events)
+
+	if len(opts.failureReport) != 0 {
+		restoreTask.SetFailureReportPath(opts.failureReport)
+	}
 
-	fmt.Println("Starting restore")
+	if opts.decrypter != nil {
+		restoreTask.SetDecrypter(opts.decrypter)
+	}
+
+	fmt.Fprintln(console, "Starting restore")
 	err = restoreTask.Run(newCliReporter())
 	if err == nil {
-		fmt.Println("Restore finished")
+		fmt.Fprintln(console, "Restore finished")
 	}
 	printRestoreTaskSummary(restoreTask)
+
+	if err != nil || !opts.contacts {
+		return err
+	}
+
+	return runContactsRestore(ctx, backupPath, session, opts)
+}
+
+func runContactsRestore(ctx context.Context, backupPath string, session *session.Session, opts restoreOptions) error {
+	restoreTask, err := contacts.NewRestoreTask(ctx, backupPath, session)
+	if err != nil {
+		return err
+	}
+
+	if opts.decrypter != nil {
+		restoreTask.SetDecrypter(opts.decrypter)
+	}
+
+	fmt.Fprintf(console, "Starting contacts restore - Path=\"%v\"\n", filepath.FromSlash(restoreTask.GetBackupDir()))
+	err = restoreTask.Run(newCliReporter())
+	if err == nil {
+		fmt.Fprintln(console, "Contacts restore finished")
+	}
+
+	fmt.Fprintf(console, "Importable contacts: %v\n", restoreTask.GetImportableCount())
+	fmt.Fprintf(console, "Successful contact imports: %v\n", restoreTask.GetImportedCount())
+	fmt.Fprintf(console, "Failed contact imports: %v\n", restoreTask.GetFailedCount())
+	fmt.Fprintf(console, "Contacts already present: %v\n", restoreTask.GetAlreadyPresentCount())
+
 	return err
 }
 
 func printRestoreTaskSummary(task *mail.RestoreTask) {
-	fmt.Printf("Importable emails: %v\n", task.GetImportableCount())
-	fmt.Printf("Successful imports: %v\n", task.GetImportedCount())
-	fmt.Printf("Failed imports: %v\n", task.GetFailedCount())
-	fmt.Printf("Skipped imports: %v\n", task.GetSkippedCount())
+	fmt.Fprintf(console, "Importable emails: %v\n", task.GetImportableCount())
+	fmt.Fprintf(console, "Successful imports: %v\n", task.GetImportedCount())
+	fmt.Fprintf(console, "Failed imports: %v\n", task.GetFailedCount())
+	fmt.Fprintf(console, "Skipped imports: %v\n", task.GetSkippedCount())
+	fmt.Fprintf(console, "Skipped as already present: %v\n", task.GetAlreadyPresentCount())
+
+	if resumed := task.GetResumedCount(); resumed != 0 {
+		fmt.Fprintf(console, "Imported by a previous restore: %v\n", resumed)
+	}
+
+	if filtered := task.GetFilteredCount(); filtered != 0 {
+		fmt.Fprintf(console, "Filtered out emails: %v\n", filtered)
+	}
+
+	printFailureReportPath(task.GetFailureReportPath())
+}
+
+func printFailureReportPath(path string) {
+	if len(path) != 0 {
+		fmt.Fprintf(console, "Failed messages are listed in %v\n", filepath.FromSlash(path))
+	}
+}
+
+func runVerify(ctx context.Context, backupPath string, decrypter *utils.PGPFileCipher, reportPath string) error {
+	verifyTask, err := mail.NewVerifyTask(ctx, backupPath)
+	if err != nil {
+		return err
+	}
+
+	if decrypter != nil {
+		verifyTask.SetDecrypter(decrypter)
+	}
+
+	fmt.Fprintf(console, "Starting verification - Path=\"%v\"\n", filepath.FromSlash(verifyTask.GetBackupPath()))
+
+	if err := verifyTask.Run(newCliReporter()); err != nil {
+		return err
+	}
+
+	report := verifyTask.GetReport()
+	printVerifyReport(report)
+
+	if err := writeJSONReport(reportPath, report); err != nil {
+		return err
+	}
+
+	if !report.IsValid() {
+		return fmt.Errorf("the backup has %v issue(s)", len(report.Issues))
+	}
+
+	fmt.Fprintln(console, "Verification finished, no issue found")
+
+	return nil
+}
+
+func printVerifyReport(report mail.VerifyReport) {
+	fmt.Fprintf(console, "Export folders: %v\n", report.ExportCount)
+	fmt.Fprintf(console, "Messages: %v\n", report.MessageCount)
+	fmt.Fprintf(console, "Valid messages: %v\n", report.BuiltMessageCount)
+	fmt.Fprintf(console, "Valid message folders: %v\n", report.PartsMessageCount)
+	fmt.Fprintf(console, "Invalid messages: %v\n", report.InvalidMessageCount)
+
+	if report.ChecksumsVerified {
+		fmt.Fprintln(console, "Checksums: verified")
+	} else {
+		fmt.Fprintln(console, "Checksums: not available")
+	}
+
+	if len(report.Issues) != 0 {
+		fmt.Fprintln(console, "Issues:")
+	}
+
+	for _, issue := range report.Issues {
+		fmt.Fprintf(console, "  %v: %v\n", filepath.FromSlash(issue.Path), issue.Problem)
+	}
+}
+
+func runDiff(
+	ctx context.Context,
+	backupPath string,
+	session *session.Session,
+	filter mail.ExportFilter,
+	decrypter *utils.PGPFileCipher,
+	reportPath string,
+) error {
+	diffTask, err := mail.NewDiffTask(ctx, backupPath, session)
+	if err != nil {
+		return err
+	}
+
+	diffTask.SetFilter(filter)
+
+	if decrypter != nil {
+		diffTask.SetDecrypter(decrypter)
+	}
+
+	fmt.Fprintln(console, "Starting comparison")
+
+	if err := diffTask.Run(newCliReporter()); err != nil {
+		return err
+	}
+
+	report := diffTask.GetReport()
+	printDiffReport(report)
+
+	if err := writeJSONReport(reportPath, report); err != nil {
+		return err
+	}
+
+	if !report.IsEmpty() {
+		return errors.New("the backup does not match the account")
+	}
+
+	fmt.Fprintln(console, "Comparison finished, the backup matches the account")
+
+	return nil
+}
+
+// diffReportPrintLimit is the number of entries of each section of the diff report printed on the console.
+const diffReportPrintLimit = 20
+
+func printDiffReport(report mail.DiffReport) {
+	fmt.Fprintf(console, "Messages in backup: %v\n", report.BackupMessageCount)
+	fmt.Fprintf(console, "Messages on account: %v\n", report.RemoteMessageCount)
+
+	printDiffSection("Missing in backup", report.MissingInBackup, func(m mail.DiffMessage) string {
+		return fmt.Sprintf("%v %q", m.ID, m.Subject)
+	})
+	printDiffSection("Missing on account", report.MissingOnAccount, func(m mail.DiffMessage) string {
+		return fmt.Sprintf("%v %q", m.ID, m.Subject)
+	})
+	printDiffSection("Label changes", report.LabelDrift, func(d mail.DiffLabelDrift) string {
+		return fmt.Sprintf("%v %q added: %v, removed: %v", d.ID, d.Subject, d.Added, d.Removed)
+	})
+	printDiffSection("New folders and labels", report.NewLabels, func(l mail.DiffLabel) string {
+		return l.RemotePath
+	})
+	printDiffSection("Deleted folders and labels", report.DeletedLabels, func(l mail.DiffLabel) string {
+		return l.BackupPath
+	})
+	printDiffSection("Renamed folders and labels", report.ChangedLabels, func(l mail.DiffLabel) string {
+		return fmt.Sprintf("%v -> %v", l.BackupPath, l.RemotePath)
+	})
+}
+
+func printDiffSection[T any](title string, entries []T, format func(T) string) {
+	fmt.Fprintf(console, "%v: %v\n", title, len(entries))
+
+	for i, entry := range entries {
+		if i == diffReportPrintLimit {
+			fmt.Fprintf(console, "  ... and %v more\n", len(entries)-i)
+			break
+		}
+
+		fmt.Fprintf(console, "  %v\n", format(entry))
+	}
+}
+
+// writeJSONReport writes the report to the given path, if any.
+func writeJSONReport(path string, report any) error {
+	if len(path) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	fmt.Fprintf(console, "Report written to %v\n", path)
+
+	return nil
 }
 
 func initApp(defaultOperationPath string, onRecover func()) error {
@@ -331,7 +950,7 @@ func closeApp() {
 	defer state.mutex.Unlock()
 
 	if state.file != nil {
-		logrus.SetOutput(os.Stdout)
+		logrus.SetOutput(console)
 		if err := state.file.Close(); err != nil {
 			logrus.WithError(err).Error("Failed to close log file")
 		} else {
@@ -343,11 +962,11 @@ func closeApp() {
 func printError(err error) {
 	var apiError *proton.APIError
 	if errors.As(err, &apiError) {
-		fmt.Println(apiError.Message)
+		fmt.Fprintln(console, apiError.Message)
 		return
 	}
 
-	fmt.Println(err)
+	fmt.Fprintln(console, err)
 }
 
 type globalState struct {
@@ -360,3 +979,9 @@ type globalState struct {
 
 //nolint:gochecknoglobals
 var state globalState
+
+// console receives the messages, prompts and progress meant for the user. It is the standard error when the events are
+// written to the standard output, so that they can be parsed.
+//
+//nolint:gochecknoglobals
+var console io.Writer = os.Stdout
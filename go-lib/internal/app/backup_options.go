@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/mail"
+	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/urfave/cli/v2"
+)
+
+type backupOptions struct {
+	resume        bool
+	incremental   bool
+	format        mail.ExportFormat
+	archiveFormat mail.ArchiveFormat
+	layout        mail.ExportLayout
+	filter        mail.ExportFilter
+	encrypter     *utils.PGPFileCipher
+	concurrency   mail.ConcurrencyOptions
+	failureReport string
+	errorBudget   int
+	contacts      bool
+	calendars     bool
+}
+
+func newBackupOptionsFromCLI(ctx *cli.Context) (backupOptions, error) {
+	format, err := stringToExportFormat(ctx.String(flagFormat.Name))
+	if err != nil {
+		return backupOptions{}, err
+	}
+
+	archiveFormat, err := stringToArchiveFormat(ctx.String(flagArchive.Name))
+	if err != nil {
+		return backupOptions{}, err
+	}
+
+	layout, err := stringToExportLayout(ctx.String(flagLayout.Name))
+	if err != nil {
+		return backupOptions{}, err
+	}
+
+	filter, err := newExportFilterFromCLI(ctx)
+	if err != nil {
+		return backupOptions{}, err
+	}
+
+	encrypter, err := newFileCipherFromCLI(ctx)
+	if err != nil {
+		return backupOptions{}, err
+	}
+
+	return backupOptions{
+		resume:        ctx.Bool(flagResume.Name),
+		incremental:   ctx.Bool(flagIncremental.Name),
+		format:        format,
+		archiveFormat: archiveFormat,
+		layout:        layout,
+		filter:        filter,
+		encrypter:     encrypter,
+		failureReport: ctx.String(flagFailureReport.Name),
+		errorBudget:   ctx.Int(flagErrorBudget.Name),
+		contacts:      ctx.Bool(flagContacts.Name),
+		calendars:     ctx.Bool(flagCalendars.Name),
+		concurrency: mail.ConcurrencyOptions{
+			Downloads: ctx.Int(flagParallelDownloads.Name),
+			Builders:  ctx.Int(flagParallelBuilders.Name),
+			Writers:   ctx.Int(flagParallelWriters.Name),
+			MemoryMB:  ctx.Uint64(flagMemoryLimit.Name),
+		},
+	}, nil
+}
+
+func newExportFilterFromCLI(ctx *cli.Context) (mail.ExportFilter, error) {
+	after, err := parseFilterDate(ctx.String(flagAfter.Name))
+	if err != nil {
+		return mail.ExportFilter{}, fmt.Errorf("invalid --%v date: %w", flagAfter.Name, err)
+	}
+
+	before, err := parseFilterDate(ctx.String(flagBefore.Name))
+	if err != nil {
+		return mail.ExportFilter{}, fmt.Errorf("invalid --%v date: %w", flagBefore.Name, err)
+	}
+
+	return mail.ExportFilter{
+		After:           after,
+		Before:          before,
+		IncludeLabels:   ctx.StringSlice(flagLabel.Name),
+		ExcludeLabels:   ctx.StringSlice(flagExcludeLabel.Name),
+		Addresses:       ctx.StringSlice(flagAddress.Name),
+		UnreadOnly:      ctx.Bool(flagUnreadOnly.Name),
+		WithAttachments: ctx.Bool(flagWithAttachments.Name),
+	}, nil
+}
+
+// parseFilterDate accepts either a date, interpreted as midnight local time, or an RFC 3339 timestamp.
+func parseFilterDate(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+func newExportTask(ctx context.Context, exportPath string, session *session.Session, opts backupOptions) (*mail.ExportTask, error) {
+	switch {
+	case opts.resume && opts.incremental:
+		return nil, errors.New("resume and incremental backups cannot be combined")
+	case opts.resume:
+		return mail.NewResumeExportTask(ctx, exportPath, session)
+	case opts.incremental:
+		return mail.NewIncrementalExportTask(ctx, exportPath, session)
+	default:
+		return mail.NewExportTask(ctx, exportPath, session), nil
+	}
+}
+
+func stringToExportFormat(format string) (mail.ExportFormat, error) {
+	if len(format) == 0 || strings.EqualFold(format, "eml") {
+		return mail.ExportFormatEML, nil
+	}
+
+	if strings.EqualFold(format, "mbox") {
+		return mail.ExportFormatMbox, nil
+	}
+
+	if strings.EqualFold(format, "maildir") {
+		return mail.ExportFormatMaildir, nil
+	}
+
+	return mail.ExportFormatEML, fmt.Errorf("unknown backup format %s", format)
+}
+
+func stringToArchiveFormat(format string) (mail.ArchiveFormat, error) {
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "":
+		return mail.ArchiveFormatNone, nil
+	case "tar.zst", "tar.zstd":
+		return mail.ArchiveFormatTarZstd, nil
+	case "zip":
+		return mail.ArchiveFormatZip, nil
+	default:
+		return mail.ArchiveFormatNone, fmt.Errorf("unknown backup archive format %s", format)
+	}
+}
+
+func stringToExportLayout(layout string) (mail.ExportLayout, error) {
+	switch strings.ToLower(layout) {
+	case "", "flat":
+		return mail.ExportLayoutFlat, nil
+	case "labels":
+		return mail.ExportLayoutLabels, nil
+	default:
+		return mail.ExportLayoutFlat, fmt.Errorf("unknown backup layout %s", layout)
+	}
+}
@@ -19,6 +19,7 @@ func newCliReporter() *cliReporter {
 			progressbar.OptionClearOnFinish(),
 			progressbar.OptionSetPredictTime(false),
 			progressbar.OptionSetWidth(100),
+			progressbar.OptionSetWriter(console),
 		),
 	}
 }
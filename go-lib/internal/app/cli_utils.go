@@ -12,7 +12,7 @@ import (
 
 func readLine(prompt string) (string, error) {
 	if len(prompt) > 0 {
-		fmt.Print(prompt)
+		fmt.Fprint(console, prompt)
 	}
 
 	result, err := bufio.NewReader(os.Stdin).ReadString('\n')
@@ -25,7 +25,7 @@ func readLine(prompt string) (string, error) {
 
 func readPassword(prompt string) ([]byte, error) {
 	if len(prompt) > 0 {
-		fmt.Print(prompt)
+		fmt.Fprint(console, prompt)
 	}
 
 	result, err := term.ReadPassword(int(os.Stdin.Fd()))
@@ -33,7 +33,7 @@ func readPassword(prompt string) ([]byte, error) {
 		return nil, err
 	}
 
-	fmt.Println()
+	fmt.Fprintln(console)
 
 	return result, nil
 }
@@ -45,7 +45,7 @@ func waitForReturn() {
 func readYesNo(prompt string, retryCount int) (bool, error) {
 	reader := bufio.NewReader(os.Stdin)
 	for i := 0; i < retryCount; i++ {
-		fmt.Print(prompt)
+		fmt.Fprint(console, prompt)
 		text, err := reader.ReadString('\n')
 		if err != nil {
 			return false, err
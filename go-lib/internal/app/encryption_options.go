@@ -0,0 +1,25 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// newFileCipherFromCLI returns the cipher used to encrypt a backup or to decrypt it on restore, nil if none is configured.
+func newFileCipherFromCLI(ctx *cli.Context) (*utils.PGPFileCipher, error) {
+	keyPath := ctx.String(flagEncryptionKey.Name)
+	passphrase := ctx.String(flagEncryptionPassphrase.Name)
+
+	switch {
+	case len(keyPath) != 0 && len(passphrase) != 0:
+		return nil, errors.New("an encryption key and an encryption passphrase cannot be combined")
+	case len(keyPath) != 0:
+		return utils.LoadPGPKeyFileCipher(keyPath, []byte(ctx.String(flagEncryptionKeyPassphrase.Name)))
+	case len(passphrase) != 0:
+		return utils.NewPGPPasswordFileCipher([]byte(passphrase)), nil
+	default:
+		return nil, nil
+	}
+}
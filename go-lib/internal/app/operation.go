@@ -13,6 +13,8 @@ import (
 const (
 	strBackup  = "backup"
 	strRestore = "restore"
+	strVerify  = "verify"
+	strDiff    = "diff"
 	strUnknown = "unknown"
 )
 
@@ -22,6 +24,8 @@ const (
 	operationUnknown Operation = iota
 	operationBackup
 	operationRestore
+	operationVerify
+	operationDiff
 )
 
 func getOperation(ctx *cli.Context) (Operation, error) {
@@ -36,7 +40,7 @@ func getOperation(ctx *cli.Context) (Operation, error) {
 func readOperationFromCLI() (Operation, error) {
 	reader := bufio.NewReader(os.Stdin)
 	for i := 0; i < retryCount; i++ {
-		fmt.Printf("Enter the operation ((B)ackup / (R)restore): ")
+		fmt.Fprintf(console, "Enter the operation ((B)ackup / (R)restore / (V)erify / (D)iff): ")
 		input, err := reader.ReadString('\n')
 		if err != nil {
 			return operationUnknown, err
@@ -44,7 +48,7 @@ func readOperationFromCLI() (Operation, error) {
 		input = strings.TrimSpace(input)
 		operation, err := stringToOperation(input)
 		if err != nil {
-			fmt.Printf("Error: %s\n", err)
+			fmt.Fprintf(console, "Error: %s\n", err)
 		} else {
 			return operation, err
 		}
@@ -62,6 +66,14 @@ func stringToOperation(operation string) (Operation, error) {
 		return operationRestore, nil
 	}
 
+	if strings.EqualFold(operation, "verify") || strings.EqualFold(operation, "v") {
+		return operationVerify, nil
+	}
+
+	if strings.EqualFold(operation, "diff") || strings.EqualFold(operation, "d") {
+		return operationDiff, nil
+	}
+
 	return operationUnknown, fmt.Errorf("unknown operation %s", operation)
 }
 
@@ -71,6 +83,10 @@ func operationToString(operation Operation) string {
 		return strBackup
 	case operationRestore:
 		return strRestore
+	case operationVerify:
+		return strVerify
+	case operationDiff:
+		return strDiff
 	case operationUnknown:
 		return strUnknown
 	default:
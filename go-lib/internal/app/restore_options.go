@@ -0,0 +1,72 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/ProtonMail/export-tool/internal/mail"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/urfave/cli/v2"
+)
+
+type restoreOptions struct {
+	filter          mail.RestoreFilter
+	addressMapping  map[string]string
+	addressFallback string
+	decrypter       *utils.PGPFileCipher
+	failureReport   string
+	contacts        bool
+	restoreSettings bool
+	skipExisting    bool
+}
+
+func newRestoreOptionsFromCLI(ctx *cli.Context) (restoreOptions, error) {
+	filter, err := newRestoreFilterFromCLI(ctx)
+	if err != nil {
+		return restoreOptions{}, err
+	}
+
+	var addressMapping map[string]string
+
+	if path := ctx.String(flagAddressMap.Name); len(path) != 0 {
+		if addressMapping, err = mail.LoadAddressMappingFile(path); err != nil {
+			return restoreOptions{}, err
+		}
+	}
+
+	decrypter, err := newFileCipherFromCLI(ctx)
+	if err != nil {
+		return restoreOptions{}, err
+	}
+
+	return restoreOptions{
+		filter:          filter,
+		addressMapping:  addressMapping,
+		addressFallback: ctx.String(flagAddressFallback.Name),
+		decrypter:       decrypter,
+		failureReport:   ctx.String(flagFailureReport.Name),
+		contacts:        ctx.Bool(flagContacts.Name),
+		restoreSettings: ctx.Bool(flagRestoreSettings.Name),
+		skipExisting:    ctx.Bool(flagSkipExisting.Name),
+	}, nil
+}
+
+func newRestoreFilterFromCLI(ctx *cli.Context) (mail.RestoreFilter, error) {
+	after, err := parseFilterDate(ctx.String(flagAfter.Name))
+	if err != nil {
+		return mail.RestoreFilter{}, fmt.Errorf("invalid --%v date: %w", flagAfter.Name, err)
+	}
+
+	before, err := parseFilterDate(ctx.String(flagBefore.Name))
+	if err != nil {
+		return mail.RestoreFilter{}, fmt.Errorf("invalid --%v date: %w", flagBefore.Name, err)
+	}
+
+	return mail.RestoreFilter{
+		After:         after,
+		Before:        before,
+		IncludeLabels: ctx.StringSlice(flagLabel.Name),
+		ExcludeLabels: ctx.StringSlice(flagExcludeLabel.Name),
+		Addresses:     ctx.StringSlice(flagAddress.Name),
+		Participants:  ctx.StringSlice(flagParticipant.Name),
+	}, nil
+}
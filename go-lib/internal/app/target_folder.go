@@ -38,14 +38,14 @@ func readTargetFolderFromCLI(operation Operation, username string) (string, erro
 
 	reader := bufio.NewReader(os.Stdin)
 	for i := 0; i < retryCount; i++ {
-		fmt.Printf("Enter the path of the target folder: ")
+		fmt.Fprintf(console, "Enter the path of the target folder: ")
 		input, err := reader.ReadString('\n')
 		if err != nil {
 			return "", err
 		}
 		input = strings.TrimSpace(input)
 		if len(input) == 0 {
-			fmt.Printf("Error: please provide a path\n")
+			fmt.Fprintf(console, "Error: please provide a path\n")
 		}
 
 		path, err := validateTargetFolder(operation, input)
@@ -53,7 +53,7 @@ func readTargetFolderFromCLI(operation Operation, username string) (string, erro
 			return path, nil
 		}
 
-		fmt.Printf("Error: %v\n", err)
+		fmt.Fprintf(console, "Error: %v\n", err)
 	}
 
 	return "", nil
@@ -75,13 +75,14 @@ func validateTargetFolder(operation Operation, path string) (string, error) {
 		}
 	}
 
-	if operation == operationRestore {
+	if operation == operationRestore || operation == operationVerify || operation == operationDiff {
 		stat, err := os.Stat(fullPath)
 		if err != nil {
 			return "", err
 		}
-		if !stat.IsDir() {
-			return "", errors.New("target folder is not a directory")
+		// A regular file is accepted as it may be an mbox file.
+		if !stat.IsDir() && !stat.Mode().IsRegular() {
+			return "", errors.New("target folder is not a directory or an mbox file")
 		}
 	}
 
@@ -0,0 +1,218 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package calendar
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+// errEventSignature is returned along with the event when the signature of one of its parts is invalid.
+var errEventSignature = errors.New("event signature is invalid")
+
+// calendarKeys unlocks the keys of a calendar. Their passphrase is encrypted to the address of each member of the
+// calendar, the first member whose address keyring is given is used.
+func calendarKeys(
+	ctx context.Context,
+	client apiclient.Client,
+	calendarID string,
+	addrKRs map[string]*crypto.KeyRing,
+) (*crypto.KeyRing, proton.CalendarMember, error) {
+	members, err := client.GetCalendarMembers(ctx, calendarID)
+	if err != nil {
+		return nil, proton.CalendarMember{}, fmt.Errorf("failed to get calendar members: %w", err)
+	}
+
+	passphrase, err := client.GetCalendarPassphrase(ctx, calendarID)
+	if err != nil {
+		return nil, proton.CalendarMember{}, fmt.Errorf("failed to get calendar passphrase: %w", err)
+	}
+
+	keys, err := client.GetCalendarKeys(ctx, calendarID)
+	if err != nil {
+		return nil, proton.CalendarMember{}, fmt.Errorf("failed to get calendar keys: %w", err)
+	}
+
+	for _, member := range members {
+		addrKR, ok := addrKRs[strings.ToLower(member.Email)]
+		if !ok {
+			continue
+		}
+
+		decrypted, err := passphrase.Decrypt(member.ID, addrKR)
+		if err != nil {
+			continue
+		}
+
+		calKR, err := keys.Unlock(decrypted)
+		if err != nil {
+			return nil, proton.CalendarMember{}, fmt.Errorf("failed to unlock calendar keys: %w", err)
+		}
+
+		if calKR.CountDecryptionEntities() == 0 {
+			return nil, proton.CalendarMember{}, errors.New("failed to unlock calendar keys")
+		}
+
+		return calKR, member, nil
+	}
+
+	return nil, proton.CalendarMember{}, errors.New("no address of the user can decrypt the calendar passphrase")
+}
+
+// decryptEvent merges the parts of an event into a single event. The encrypted parts are decrypted with the calendar
+// keyring. The signatures made by the addresses of the user are verified, the event is still returned along with
+// errEventSignature if one of them is invalid. The personal parts of the other members, which hold their own
+// notifications, are left out.
+func decryptEvent(
+	calKR *crypto.KeyRing,
+	member proton.CalendarMember,
+	addrKRs map[string]*crypto.KeyRing,
+	calendarEvent proton.CalendarEvent,
+) (event, error) {
+	sharedKeyPacket, err := base64.StdEncoding.DecodeString(calendarEvent.SharedKeyPacket)
+	if err != nil {
+		return event{}, fmt.Errorf("failed to decode shared key packet: %w", err)
+	}
+
+	calendarKeyPacket := sharedKeyPacket
+
+	if len(calendarEvent.CalendarKeyPacket) != 0 {
+		if calendarKeyPacket, err = base64.StdEncoding.DecodeString(calendarEvent.CalendarKeyPacket); err != nil {
+			return event{}, fmt.Errorf("failed to decode calendar key packet: %w", err)
+		}
+	}
+
+	type keyedPart struct {
+		part      proton.CalendarEventPart
+		keyPacket []byte
+	}
+
+	var keyedParts []keyedPart
+
+	for _, part := range calendarEvent.SharedEvents {
+		keyedParts = append(keyedParts, keyedPart{part: part, keyPacket: sharedKeyPacket})
+	}
+
+	for _, part := range calendarEvent.CalendarEvents {
+		keyedParts = append(keyedParts, keyedPart{part: part, keyPacket: calendarKeyPacket})
+	}
+
+	for _, part := range calendarEvent.AttendeesEvents {
+		keyedParts = append(keyedParts, keyedPart{part: part, keyPacket: sharedKeyPacket})
+	}
+
+	for _, part := range calendarEvent.PersonalEvents {
+		if part.MemberID == member.ID {
+			keyedParts = append(keyedParts, keyedPart{part: part})
+		}
+	}
+
+	var (
+		parts        []event
+		signatureErr error
+	)
+
+	for _, keyed := range keyedParts {
+		data, err := decryptEventPart(calKR, keyed.part, keyed.keyPacket)
+		if err != nil {
+			return event{}, err
+		}
+
+		if keyed.part.Type&proton.CalendarEventTypeSigned != 0 {
+			if authorKR, ok := addrKRs[strings.ToLower(keyed.part.Author)]; ok {
+				if err := verifyEventPart(authorKR, data, keyed.part.Signature); err != nil {
+					signatureErr = fmt.Errorf("%w: %v", errEventSignature, err)
+				}
+			}
+		}
+
+		part, err := parseEvent(data)
+		if err != nil {
+			return event{}, fmt.Errorf("failed to read event part: %w", err)
+		}
+
+		parts = append(parts, part)
+	}
+
+	if len(parts) == 0 {
+		return event{}, errNoEvent
+	}
+
+	return mergeEvents(parts), signatureErr
+}
+
+// decryptEventPart returns the iCalendar data of an event part. The data of the encrypted parts is the data packet of
+// an OpenPGP message, whose key packet is shared by the parts of the event, unless no key packet is given.
+func decryptEventPart(calKR *crypto.KeyRing, part proton.CalendarEventPart, keyPacket []byte) (string, error) {
+	if part.Type&proton.CalendarEventTypeEncrypted == 0 {
+		return part.Data, nil
+	}
+
+	var message *crypto.PGPMessage
+
+	if len(keyPacket) != 0 {
+		dataPacket, err := base64.StdEncoding.DecodeString(part.Data)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode event part: %w", err)
+		}
+
+		message = crypto.NewPGPSplitMessage(keyPacket, dataPacket).GetPGPMessage()
+	} else {
+		var err error
+
+		if message, err = crypto.NewPGPMessageFromArmored(part.Data); err != nil {
+			return "", fmt.Errorf("failed to read event part: %w", err)
+		}
+	}
+
+	decrypted, err := calKR.Decrypt(message, nil, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt event part: %w", err)
+	}
+
+	return decrypted.GetString(), nil
+}
+
+func verifyEventPart(kr *crypto.KeyRing, data, signature string) error {
+	sig, err := crypto.NewPGPSignatureFromArmored(signature)
+	if err != nil {
+		return err
+	}
+
+	return kr.VerifyDetached(crypto.NewPlainMessageFromString(data), sig, crypto.GetUnixTime())
+}
+
+// getAddrKeyRings returns the unlocked address keyrings by lower case email address.
+func getAddrKeyRings(addresses []proton.Address, keyRing *apiclient.UnlockedKeyRing) map[string]*crypto.KeyRing {
+	result := make(map[string]*crypto.KeyRing)
+
+	for _, address := range addresses {
+		if addrKR, ok := keyRing.GetAddrKeyRing(address.ID); ok {
+			result[strings.ToLower(address.Email)] = addrKR
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package calendar
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func newTestKeyRing(t *testing.T, email string) *crypto.KeyRing {
+	key, err := crypto.GenerateKey("test", email, "x25519", 0)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	return kr
+}
+
+func newTestSignedPart(t *testing.T, addrKR *crypto.KeyRing, author, data string) proton.CalendarEventPart {
+	signature, err := addrKR.SignDetached(crypto.NewPlainMessageFromString(data))
+	require.NoError(t, err)
+
+	armored, err := signature.GetArmored()
+	require.NoError(t, err)
+
+	return proton.CalendarEventPart{Type: proton.CalendarEventTypeSigned, Data: data, Signature: armored, Author: author}
+}
+
+func newTestEncryptedPart(t *testing.T, addrKR *crypto.KeyRing, sessionKey *crypto.SessionKey, author, data string) proton.CalendarEventPart {
+	part := newTestSignedPart(t, addrKR, author, data)
+
+	dataPacket, err := sessionKey.Encrypt(crypto.NewPlainMessageFromString(data))
+	require.NoError(t, err)
+
+	part.Type |= proton.CalendarEventTypeEncrypted
+	part.Data = base64.StdEncoding.EncodeToString(dataPacket)
+
+	return part
+}
+
+func newTestEvent(t *testing.T, calKR, addrKR *crypto.KeyRing) proton.CalendarEvent {
+	sessionKey, err := crypto.GenerateSessionKey()
+	require.NoError(t, err)
+
+	keyPacket, err := calKR.EncryptSessionKey(sessionKey)
+	require.NoError(t, err)
+
+	const author = "alice@proton.me"
+
+	return proton.CalendarEvent{
+		ID:              "event-id",
+		SharedKeyPacket: base64.StdEncoding.EncodeToString(keyPacket),
+		SharedEvents: []proton.CalendarEventPart{
+			newTestSignedPart(t, addrKR, author, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:event-uid\r\nDTSTAMP:20240101T120000Z\r\n"+
+				"DTSTART:20240102T090000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"),
+			newTestEncryptedPart(t, addrKR, sessionKey, author, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:event-uid\r\n"+
+				"DTSTAMP:20240101T120000Z\r\nSUMMARY:Meeting\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"),
+		},
+		PersonalEvents: []proton.CalendarEventPart{
+			func() proton.CalendarEventPart {
+				part := newTestSignedPart(t, addrKR, author, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:event-uid\r\n"+
+					"BEGIN:VALARM\r\nTRIGGER:-PT15M\r\nEND:VALARM\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+				part.MemberID = "member-id"
+				return part
+			}(),
+			func() proton.CalendarEventPart {
+				part := newTestSignedPart(t, addrKR, author, "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:event-uid\r\n"+
+					"BEGIN:VALARM\r\nTRIGGER:-PT1H\r\nEND:VALARM\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")
+				part.MemberID = "other-member-id"
+				return part
+			}(),
+		},
+	}
+}
+
+func TestDecryptEvent(t *testing.T) {
+	calKR := newTestKeyRing(t, "calendar@proton.me")
+	addrKR := newTestKeyRing(t, "alice@proton.me")
+
+	calendarEvent := newTestEvent(t, calKR, addrKR)
+	member := proton.CalendarMember{ID: "member-id", Email: "alice@proton.me"}
+
+	event, err := decryptEvent(calKR, member, map[string]*crypto.KeyRing{"alice@proton.me": addrKR}, calendarEvent)
+	require.NoError(t, err)
+	require.Equal(t, []string{"UID:event-uid", "DTSTAMP:20240101T120000Z", "DTSTART:20240102T090000Z", "SUMMARY:Meeting"}, event.properties)
+	require.Equal(t, [][]string{{"BEGIN:VALARM", "TRIGGER:-PT15M", "END:VALARM"}}, event.components)
+
+	// The signatures of unknown authors can't be verified.
+	_, err = decryptEvent(calKR, member, nil, calendarEvent)
+	require.NoError(t, err)
+
+	event, err = decryptEvent(calKR, member, map[string]*crypto.KeyRing{"alice@proton.me": newTestKeyRing(t, "alice@proton.me")}, calendarEvent)
+	require.ErrorIs(t, err, errEventSignature)
+	require.Len(t, event.properties, 4)
+
+	_, err = decryptEvent(newTestKeyRing(t, "calendar@proton.me"), member, nil, calendarEvent)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errEventSignature)
+}
+
+func TestCalendarKeys(t *testing.T) {
+	addrKR := newTestKeyRing(t, "alice@proton.me")
+	passphrase := []byte("calendar passphrase")
+
+	encrypted, err := addrKR.Encrypt(crypto.NewPlainMessage(passphrase), nil)
+	require.NoError(t, err)
+
+	armoredPassphrase, err := encrypted.GetArmored()
+	require.NoError(t, err)
+
+	signature, err := addrKR.SignDetached(crypto.NewPlainMessage(passphrase))
+	require.NoError(t, err)
+
+	armoredSignature, err := signature.GetArmored()
+	require.NoError(t, err)
+
+	calendarKey, err := crypto.GenerateKey("calendar", "calendar@proton.me", "x25519", 0)
+	require.NoError(t, err)
+
+	lockedKey, err := calendarKey.Lock(passphrase)
+	require.NoError(t, err)
+
+	armoredKey, err := lockedKey.Armor()
+	require.NoError(t, err)
+
+	client := apiclient.NewMockClient(gomock.NewController(t))
+
+	client.EXPECT().GetCalendarMembers(gomock.Any(), "calendar-id").Return([]proton.CalendarMember{
+		{ID: "shared-member-id", Email: "bob@proton.me"},
+		{ID: "member-id", Email: "Alice@proton.me"},
+	}, nil).Times(2)
+	client.EXPECT().GetCalendarPassphrase(gomock.Any(), "calendar-id").Return(proton.CalendarPassphrase{
+		MemberPassphrases: []proton.MemberPassphrase{{MemberID: "member-id", Passphrase: armoredPassphrase, Signature: armoredSignature}},
+	}, nil).Times(2)
+	client.EXPECT().GetCalendarKeys(gomock.Any(), "calendar-id").Return(proton.CalendarKeys{{PrivateKey: armoredKey}}, nil).Times(2)
+
+	calKR, member, err := calendarKeys(context.Background(), client, "calendar-id", map[string]*crypto.KeyRing{"alice@proton.me": addrKR})
+	require.NoError(t, err)
+	require.Equal(t, "member-id", member.ID)
+	require.Equal(t, calendarKey.GetFingerprint(), calKR.GetKeys()[0].GetFingerprint())
+
+	_, _, err = calendarKeys(context.Background(), client, "calendar-id", map[string]*crypto.KeyRing{"bob@proton.me": addrKR})
+	require.Error(t, err)
+}
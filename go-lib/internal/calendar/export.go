@@ -0,0 +1,242 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/mail"
+	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/task"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+const EventPageSize = 100
+
+// Calendar exports will be created in the given directory, next to the mail exports, and will be structured:
+// <email>
+//  |- calendar_yyyy_mm_dd_hh:mm:ss
+//      |- calendar-id.ics
+//
+// Each file holds the events of a calendar, whose name is stored in the X-WR-CALNAME property.
+// When the export is encrypted, every file is a binary OpenPGP message.
+
+type ExportTask struct {
+	task.ExportBase
+	exportedCount       int64
+	failedCount         int64
+	failedCalendarCount int64
+}
+
+// calendarExport is a calendar whose keys could be unlocked, along with its events.
+type calendarExport struct {
+	calendar proton.Calendar
+	keyRing  *crypto.KeyRing
+	member   proton.CalendarMember
+	events   []proton.CalendarEvent
+}
+
+func NewExportTask(ctx context.Context, exportPath string, session *session.Session) *ExportTask {
+	return &ExportTask{ExportBase: task.NewExportBase(ctx, exportPath, "calendar", session)}
+}
+
+// GetExportedCount returns the number of events written by Run.
+func (e *ExportTask) GetExportedCount() int64 {
+	return e.exportedCount
+}
+
+// GetFailedCount returns the number of events which could not be decrypted and were left out of the export. Run returns
+// task.ErrPartialExport when it isn't 0.
+func (e *ExportTask) GetFailedCount() int64 {
+	return e.failedCount
+}
+
+// GetFailedCalendarCount returns the number of calendars whose keys could not be unlocked, which were left out of the
+// export along with their events. Run returns task.ErrPartialExport when it isn't 0.
+func (e *ExportTask) GetFailedCalendarCount() int64 {
+	return e.failedCalendarCount
+}
+
+func (e *ExportTask) Run(reporter mail.Reporter) error {
+	defer e.Log.Info("Finished")
+	e.Log.WithField("export-dir", e.ExportDir).Info("Starting")
+
+	removeTmpDir, err := e.CreateTmpDir()
+	if err != nil {
+		return err
+	}
+	defer removeTmpDir()
+
+	client := e.Session.GetClient()
+
+	addresses, err := client.GetAddresses(e.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get user addresses: %w", err)
+	}
+
+	// The calendar passphrases are encrypted to the address keys.
+	keyRing, err := task.UnlockKeyRing(e.Session, addresses)
+	if err != nil {
+		return err
+	}
+	defer keyRing.Close()
+
+	addrKRs := getAddrKeyRings(addresses, keyRing)
+
+	e.Log.Debug("Listing calendars")
+
+	calendars, err := client.GetCalendars(e.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list calendars: %w", err)
+	}
+
+	exports, err := e.prepareCalendars(client, calendars, addrKRs)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, export := range exports {
+			export.keyRing.ClearPrivateParams()
+		}
+	}()
+
+	var total int
+	for _, export := range exports {
+		total += len(export.events)
+	}
+
+	reporter.SetMessageTotal(uint64(total))
+	reporter.SetMessageProcessed(0)
+
+	fileWriter := e.NewFileWriter()
+
+	for _, export := range exports {
+		data, err := e.exportCalendar(export, addrKRs, reporter)
+		if err != nil {
+			return err
+		}
+
+		if err := fileWriter.WriteFile(e.TmpDir, filepath.Join(e.ExportDir, getCalendarFileName(export.calendar.ID)), data, &utils.Sha256IntegrityChecker{}); err != nil {
+			return err
+		}
+	}
+
+	e.Log.WithField("exported", e.exportedCount).WithField("failed", e.failedCount).Info("Calendars written")
+
+	if e.failedCalendarCount != 0 || e.failedCount != 0 {
+		return fmt.Errorf(
+			"%w: %v calendars could not be unlocked, %v events could not be decrypted",
+			task.ErrPartialExport,
+			e.failedCalendarCount,
+			e.failedCount,
+		)
+	}
+
+	return nil
+}
+
+// prepareCalendars unlocks the keys of the calendars and lists their events. The calendars whose keys can't be unlocked
+// are left out.
+func (e *ExportTask) prepareCalendars(
+	client apiclient.Client,
+	calendars []proton.Calendar,
+	addrKRs map[string]*crypto.KeyRing,
+) ([]calendarExport, error) {
+	var exports []calendarExport
+
+	for _, calendar := range calendars {
+		log := e.Log.WithField("calendarID", calendar.ID)
+
+		calKR, member, err := calendarKeys(e.Ctx, client, calendar.ID, addrKRs)
+		if err != nil {
+			if ctxErr := e.Ctx.Err(); ctxErr != nil {
+				return exports, ctxErr
+			}
+
+			log.WithError(err).Error("Failed to unlock calendar, skipping it")
+			e.failedCalendarCount++
+
+			continue
+		}
+
+		log.Debug("Listing events")
+
+		events, err := listEvents(e.Ctx, client, calendar.ID)
+		if err != nil {
+			calKR.ClearPrivateParams()
+			return exports, err
+		}
+
+		exports = append(exports, calendarExport{calendar: calendar, keyRing: calKR, member: member, events: events})
+	}
+
+	return exports, nil
+}
+
+// exportCalendar returns the iCalendar object of a calendar, without the events which could not be decrypted.
+func (e *ExportTask) exportCalendar(export calendarExport, addrKRs map[string]*crypto.KeyRing, reporter mail.Reporter) ([]byte, error) {
+	events := make([]event, 0, len(export.events))
+
+	for _, calendarEvent := range export.events {
+		if err := e.Ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		log := e.Log.WithField("calendarID", export.calendar.ID).WithField("eventID", calendarEvent.ID)
+
+		decrypted, err := decryptEvent(export.keyRing, export.member, addrKRs, calendarEvent)
+		if errors.Is(err, errEventSignature) {
+			log.WithError(err).Warn("Event signature could not be verified, exporting it anyway")
+		} else if err != nil {
+			log.WithError(err).Error("Failed to decrypt event")
+			e.failedCount++
+			reporter.OnProgress(1)
+
+			continue
+		}
+
+		events = append(events, decrypted)
+		e.exportedCount++
+		reporter.OnProgress(1)
+	}
+
+	return encodeCalendar(export.calendar, events), nil
+}
+
+// listEvents returns the events of a calendar.
+func listEvents(ctx context.Context, client apiclient.Client, calendarID string) ([]proton.CalendarEvent, error) {
+	events, err := task.ListPages(EventPageSize, func(page, pageSize int) ([]proton.CalendarEvent, error) {
+		return client.GetCalendarEvents(ctx, calendarID, page, pageSize, nil)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calendar events: %w", err)
+	}
+
+	return events, nil
+}
+
+func getCalendarFileName(calendarID string) string {
+	return calendarID + ".ics"
+}
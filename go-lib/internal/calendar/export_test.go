@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package calendar
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/mail"
+	"github.com/ProtonMail/export-tool/internal/task"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportTask_ExportCalendar(t *testing.T) {
+	calKR := newTestKeyRing(t, "calendar@proton.me")
+	addrKR := newTestKeyRing(t, "alice@proton.me")
+
+	task := &ExportTask{ExportBase: task.ExportBase{Ctx: context.Background(), Log: logrus.WithField("test", "calendar")}}
+
+	export := calendarExport{
+		calendar: proton.Calendar{ID: "calendar-id", Name: "Personal"},
+		keyRing:  calKR,
+		member:   proton.CalendarMember{ID: "member-id"},
+		events: []proton.CalendarEvent{
+			newTestEvent(t, calKR, addrKR),
+			// Events which can't be decrypted are left out without failing the export.
+			newTestEvent(t, newTestKeyRing(t, "calendar@proton.me"), addrKR),
+		},
+	}
+
+	data, err := task.exportCalendar(export, nil, mail.NullProgressReporter{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), task.GetExportedCount())
+	require.Equal(t, int64(1), task.GetFailedCount())
+
+	event, err := parseEvent(string(data))
+	require.NoError(t, err)
+	require.Contains(t, event.properties, "SUMMARY:Meeting")
+	require.Contains(t, string(data), "X-WR-CALNAME:Personal\r\n")
+}
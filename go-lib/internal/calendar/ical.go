@@ -0,0 +1,233 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package calendar
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ProtonMail/go-proton-api"
+)
+
+// The maximum length of an iCalendar content line, in octets and without the line break.
+const maxLineLength = 75
+
+var errNoEvent = errors.New("no event in calendar data")
+
+// event is the content of a VEVENT component: its properties as unfolded content lines, and the components it
+// contains, such as VALARM, as unfolded content lines including their BEGIN and END lines.
+type event struct {
+	properties []string
+	components [][]string
+}
+
+// parseEvent returns the first VEVENT component of an iCalendar object.
+func parseEvent(data string) (event, error) {
+	var (
+		result    event
+		inEvent   bool
+		component []string
+		depth     int
+	)
+
+	for _, line := range unfoldLines(data) {
+		name := getPropertyName(line)
+
+		if !inEvent {
+			inEvent = name == "BEGIN" && strings.EqualFold(getPropertyValue(line), "VEVENT")
+			continue
+		}
+
+		switch {
+		case name == "BEGIN":
+			depth++
+		case name == "END" && depth == 0:
+			return result, nil
+		case name == "END":
+			depth--
+
+			if depth == 0 {
+				result.components = append(result.components, append(component, line))
+				component = nil
+
+				continue
+			}
+		}
+
+		if depth == 0 {
+			result.properties = append(result.properties, line)
+		} else {
+			component = append(component, line)
+		}
+	}
+
+	return event{}, errNoEvent
+}
+
+// mergeEvents merges the parts of a Proton event into a single event. The properties repeated across the parts, such as
+// UID and DTSTAMP, are only kept once.
+func mergeEvents(parts []event) event {
+	var result event
+
+	properties := make(map[string]struct{})
+	components := make(map[string]struct{})
+
+	for _, part := range parts {
+		for _, property := range part.properties {
+			key := property
+			if name := getPropertyName(property); name == "UID" || name == "DTSTAMP" {
+				key = name
+			}
+
+			if _, ok := properties[key]; ok {
+				continue
+			}
+
+			properties[key] = struct{}{}
+			result.properties = append(result.properties, property)
+		}
+
+		for _, component := range part.components {
+			key := strings.Join(component, "\n")
+
+			if _, ok := components[key]; ok {
+				continue
+			}
+
+			components[key] = struct{}{}
+			result.components = append(result.components, component)
+		}
+	}
+
+	return result
+}
+
+// encodeCalendar returns the iCalendar object holding the events of a calendar.
+func encodeCalendar(calendar proton.Calendar, events []event) []byte {
+	var builder strings.Builder
+
+	writeLine := func(line string) {
+		builder.WriteString(foldLine(line))
+		builder.WriteString("\r\n")
+	}
+
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//Proton AG//Proton Export Tool//EN")
+	writeLine("CALSCALE:GREGORIAN")
+	writeLine("X-WR-CALNAME:" + escapeText(calendar.Name))
+
+	if len(calendar.Description) != 0 {
+		writeLine("X-WR-CALDESC:" + escapeText(calendar.Description))
+	}
+
+	for _, event := range events {
+		writeLine("BEGIN:VEVENT")
+
+		for _, property := range event.properties {
+			writeLine(property)
+		}
+
+		for _, component := range event.components {
+			for _, line := range component {
+				writeLine(line)
+			}
+		}
+
+		writeLine("END:VEVENT")
+	}
+
+	writeLine("END:VCALENDAR")
+
+	return []byte(builder.String())
+}
+
+// unfoldLines splits an iCalendar object into its content lines, joining the lines folded over several lines.
+func unfoldLines(data string) []string {
+	var lines []string
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if len(lines) != 0 && len(line) != 0 && (line[0] == ' ' || line[0] == '\t') {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// foldLine splits a content line longer than allowed over several lines, without splitting UTF-8 characters.
+func foldLine(line string) string {
+	if len(line) <= maxLineLength {
+		return line
+	}
+
+	var builder strings.Builder
+
+	limit := maxLineLength
+
+	for len(line) > limit {
+		end := limit
+		for end > 0 && !utf8.RuneStart(line[end]) {
+			end--
+		}
+
+		builder.WriteString(line[:end])
+		builder.WriteString("\r\n ")
+
+		line = line[end:]
+
+		// The continuation lines start with a space.
+		limit = maxLineLength - 1
+	}
+
+	builder.WriteString(line)
+
+	return builder.String()
+}
+
+func getPropertyName(line string) string {
+	if index := strings.IndexAny(line, ";:"); index >= 0 {
+		return strings.ToUpper(line[:index])
+	}
+
+	return strings.ToUpper(line)
+}
+
+func getPropertyValue(line string) string {
+	if index := strings.IndexByte(line, ':'); index >= 0 {
+		return line[index+1:]
+	}
+
+	return ""
+}
+
+func escapeText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package calendar
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEvent(t *testing.T) {
+	data := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:event-uid\r\nDESCRIPTION:A long\r\n  description\r\n" +
+		"BEGIN:VALARM\r\nACTION:DISPLAY\r\nTRIGGER:-PT15M\r\nEND:VALARM\r\nSUMMARY:Meeting\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+	event, err := parseEvent(data)
+	require.NoError(t, err)
+	require.Equal(t, []string{"UID:event-uid", "DESCRIPTION:A long description", "SUMMARY:Meeting"}, event.properties)
+	require.Equal(t, [][]string{{"BEGIN:VALARM", "ACTION:DISPLAY", "TRIGGER:-PT15M", "END:VALARM"}}, event.components)
+
+	_, err = parseEvent("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n")
+	require.ErrorIs(t, err, errNoEvent)
+}
+
+func TestMergeEvents(t *testing.T) {
+	alarm := []string{"BEGIN:VALARM", "TRIGGER:-PT15M", "END:VALARM"}
+
+	merged := mergeEvents([]event{
+		{properties: []string{"UID:event-uid", "DTSTAMP:20240101T120000Z", "DTSTART:20240102T090000Z"}},
+		{properties: []string{"UID:event-uid", "DTSTAMP:20240101T120001Z", "SUMMARY:Meeting"}, components: [][]string{alarm}},
+		{properties: []string{"UID:event-uid", "DTSTART:20240102T090000Z"}, components: [][]string{alarm}},
+	})
+
+	require.Equal(t, []string{"UID:event-uid", "DTSTAMP:20240101T120000Z", "DTSTART:20240102T090000Z", "SUMMARY:Meeting"}, merged.properties)
+	require.Equal(t, [][]string{alarm}, merged.components)
+}
+
+func TestEncodeCalendar(t *testing.T) {
+	summary := "SUMMARY:" + strings.Repeat("é", 50)
+
+	data := string(encodeCalendar(proton.Calendar{Name: "Work, mostly"}, []event{{properties: []string{"UID:event-uid", summary}}}))
+
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n") {
+		require.LessOrEqual(t, len(line), maxLineLength)
+	}
+
+	require.Contains(t, data, "X-WR-CALNAME:Work\\, mostly\r\n")
+	require.NotContains(t, data, "X-WR-CALDESC")
+
+	event, err := parseEvent(data)
+	require.NoError(t, err)
+	require.Equal(t, []string{"UID:event-uid", summary}, event.properties)
+}
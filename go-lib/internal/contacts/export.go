@@ -0,0 +1,186 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package contacts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/mail"
+	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/task"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+const ContactPageSize = 100
+
+// Contact exports will be created in the given directory, next to the mail exports, and will be structured:
+// <email>
+//  |- contacts_yyyy_mm_dd_hh:mm:ss
+//      |- contacts.vcf
+//      |- contact-id.vcf
+//
+// contacts.vcf holds every contact and can be imported at once in most address books, it is the file which is restored.
+// When the export is encrypted, every file is a binary OpenPGP message.
+
+var contactsFolderRegExp = regexp.MustCompile(`^contacts_\d{8}_\d{6}$`)
+
+type ExportTask struct {
+	task.ExportBase
+	exportedCount int64
+	failedCount   int64
+}
+
+func NewExportTask(ctx context.Context, exportPath string, session *session.Session) *ExportTask {
+	return &ExportTask{ExportBase: task.NewExportBase(ctx, exportPath, "contacts", session)}
+}
+
+// GetExportedCount returns the number of contacts written by Run.
+func (e *ExportTask) GetExportedCount() int64 {
+	return e.exportedCount
+}
+
+// GetFailedCount returns the number of contacts which could not be decrypted and were left out of the export. Run returns
+// task.ErrPartialExport when it isn't 0.
+func (e *ExportTask) GetFailedCount() int64 {
+	return e.failedCount
+}
+
+func (e *ExportTask) Run(reporter mail.Reporter) error {
+	defer e.Log.Info("Finished")
+	e.Log.WithField("export-dir", e.ExportDir).Info("Starting")
+
+	removeTmpDir, err := e.CreateTmpDir()
+	if err != nil {
+		return err
+	}
+	defer removeTmpDir()
+
+	// Contacts are encrypted with the user keys, the address keys are not needed.
+	keyRing, err := task.UnlockKeyRing(e.Session, nil)
+	if err != nil {
+		return err
+	}
+	defer keyRing.Close()
+
+	client := e.Session.GetClient()
+
+	e.Log.Debug("Listing contacts")
+
+	contacts, err := listContacts(e.Ctx, client)
+	if err != nil {
+		return err
+	}
+
+	reporter.SetMessageTotal(uint64(len(contacts)))
+	reporter.SetMessageProcessed(0)
+
+	fileWriter := e.NewFileWriter()
+
+	var all bytes.Buffer
+
+	for _, metadata := range contacts {
+		if err := e.Ctx.Err(); err != nil {
+			return err
+		}
+
+		data, err := e.exportContact(client, keyRing.GetUserKeyRing(), metadata)
+		if err != nil {
+			return err
+		}
+
+		reporter.OnProgress(1)
+
+		if data == nil {
+			e.failedCount++
+			continue
+		}
+
+		if err := fileWriter.WriteFile(e.TmpDir, filepath.Join(e.ExportDir, getContactFileName(metadata.ID)), data, &utils.Sha256IntegrityChecker{}); err != nil {
+			return err
+		}
+
+		all.Write(data)
+		e.exportedCount++
+	}
+
+	e.Log.WithField("exported", e.exportedCount).WithField("failed", e.failedCount).Info("Writing contacts file")
+
+	if err := fileWriter.WriteFile(e.TmpDir, filepath.Join(e.ExportDir, getContactsFileName()), all.Bytes(), &utils.Sha256IntegrityChecker{}); err != nil {
+		return err
+	}
+
+	if e.failedCount != 0 {
+		return fmt.Errorf("%w: %v contacts could not be decrypted", task.ErrPartialExport, e.failedCount)
+	}
+
+	return nil
+}
+
+// exportContact downloads a contact and returns it as a vCard, or nil if it could not be decrypted.
+func (e *ExportTask) exportContact(client apiclient.Client, kr *crypto.KeyRing, metadata proton.Contact) ([]byte, error) {
+	contact, err := client.GetContact(e.Ctx, metadata.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contact '%v': %w", metadata.ID, err)
+	}
+
+	log := e.Log.WithField("contactID", metadata.ID)
+
+	card, err := decryptContact(kr, contact)
+	if errors.Is(err, errContactSignature) {
+		log.WithError(err).Warn("Contact signature could not be verified, exporting it anyway")
+	} else if err != nil {
+		log.WithError(err).Error("Failed to decrypt contact")
+		return nil, nil
+	}
+
+	data, err := encodeCard(card)
+	if err != nil {
+		log.WithError(err).Error("Failed to encode contact")
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// listContacts returns the contacts of the account, without their cards.
+func listContacts(ctx context.Context, client apiclient.Client) ([]proton.Contact, error) {
+	contacts, err := task.ListPages(ContactPageSize, func(page, pageSize int) ([]proton.Contact, error) {
+		return client.GetContacts(ctx, page, pageSize)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contacts: %w", err)
+	}
+
+	return contacts, nil
+}
+
+func getContactsFileName() string {
+	return "contacts.vcf"
+}
+
+func getContactFileName(contactID string) string {
+	return contactID + ".vcf"
+}
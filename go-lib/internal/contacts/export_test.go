@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package contacts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/task"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/emersion/go-vcard"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestExportTask_ExportContact(t *testing.T) {
+	kr := newTestKeyRing(t)
+	client := apiclient.NewMockClient(gomock.NewController(t))
+
+	cards, err := encryptContact(kr, newTestCard())
+	require.NoError(t, err)
+
+	client.EXPECT().GetContact(gomock.Any(), "valid").Return(proton.Contact{
+		ContactMetadata: proton.ContactMetadata{ID: "valid"},
+		ContactCards:    cards,
+	}, nil)
+
+	client.EXPECT().GetContact(gomock.Any(), "other-key").Return(proton.Contact{
+		ContactMetadata: proton.ContactMetadata{ID: "other-key"},
+		ContactCards:    cards,
+	}, nil)
+
+	task := &ExportTask{ExportBase: task.ExportBase{Ctx: context.Background(), Log: logrus.WithField("test", "contacts")}}
+
+	data, err := task.exportContact(client, kr, proton.Contact{ContactMetadata: proton.ContactMetadata{ID: "valid"}})
+	require.NoError(t, err)
+
+	decoded, err := decodeCards(data)
+	require.NoError(t, err)
+	require.Len(t, decoded, 1)
+	require.Equal(t, "Alice", decoded[0].Value(vcard.FieldFormattedName))
+	require.Equal(t, "Met at the conference", decoded[0].Value(vcard.FieldNote))
+
+	// Contacts which can't be decrypted are left out without failing the export.
+	data, err = task.exportContact(client, newTestKeyRing(t), proton.Contact{ContactMetadata: proton.ContactMetadata{ID: "other-key"}})
+	require.NoError(t, err)
+	require.Nil(t, data)
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package contacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/mail"
+	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/task"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/emersion/go-vcard"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// The number of contacts created by each request.
+const ContactImportBatchSize = 10
+
+var ErrNoContactsBackup = errors.New("no contacts backup found")
+
+type RestoreTask struct {
+	ctx             context.Context
+	ctxCancel       func()
+	backupDir       string
+	session         *session.Session
+	log             *logrus.Entry
+	decrypter       utils.FileDecrypter
+	cancelledByUser bool
+	importableCount int64
+	importedCount   int64
+	failedCount     int64
+	presentCount    int64
+}
+
+// NewRestoreTask restores the contacts of a contacts export. The given path can be the export folder, the folder which
+// contains it, or a mail export folder next to it. The most recent export is picked if there are several.
+func NewRestoreTask(ctx context.Context, backupPath string, session *session.Session) (*RestoreTask, error) {
+	backupDir, err := findContactsBackupDir(backupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &RestoreTask{
+		ctx:       ctx,
+		ctxCancel: cancel,
+		backupDir: backupDir,
+		session:   session,
+		log:       logrus.WithField("backup", "contacts").WithField("userID", session.GetUser().ID),
+	}, nil
+}
+
+func (r *RestoreTask) Cancel() {
+	r.cancelledByUser = true
+	r.ctxCancel()
+}
+
+func (r *RestoreTask) GetOperationCancelledByUser() bool {
+	return r.cancelledByUser
+}
+
+func (r *RestoreTask) GetBackupDir() string {
+	return r.backupDir
+}
+
+// SetDecrypter sets the key or passphrase used to read a backup created with export encryption. Must be called before Run.
+func (r *RestoreTask) SetDecrypter(decrypter utils.FileDecrypter) {
+	r.decrypter = decrypter
+}
+
+func (r *RestoreTask) GetImportableCount() int64 {
+	return r.importableCount
+}
+
+func (r *RestoreTask) GetImportedCount() int64 {
+	return r.importedCount
+}
+
+func (r *RestoreTask) GetFailedCount() int64 {
+	return r.failedCount
+}
+
+// GetAlreadyPresentCount returns the number of contacts which were not restored as a contact with the same UID is
+// already in the account.
+func (r *RestoreTask) GetAlreadyPresentCount() int64 {
+	return r.presentCount
+}
+
+func (r *RestoreTask) Run(reporter mail.Reporter) error {
+	startTime := time.Now()
+	defer func() { r.log.WithField("duration", time.Since(startTime)).Info("Finished") }()
+	r.log.WithField("backupDir", r.backupDir).Info("Starting")
+
+	data, err := utils.ReadFileDecrypted(filepath.Join(r.backupDir, getContactsFileName()), r.decrypter)
+	if err != nil {
+		return fmt.Errorf("failed to read contacts file: %w", err)
+	}
+
+	cards, err := decodeCards(data)
+	if err != nil {
+		return err
+	}
+
+	r.importableCount = int64(len(cards))
+
+	reporter.SetMessageTotal(uint64(len(cards)))
+	reporter.SetMessageProcessed(0)
+
+	// Contacts are encrypted with the user keys, the address keys are not needed.
+	keyRing, err := task.UnlockKeyRing(r.session, nil)
+	if err != nil {
+		return err
+	}
+	defer keyRing.Close()
+
+	client := r.session.GetClient()
+
+	remoteContacts, err := listContacts(r.ctx, client)
+	if err != nil {
+		return err
+	}
+
+	remoteUIDs := make(map[string]struct{}, len(remoteContacts))
+	for _, contact := range remoteContacts {
+		remoteUIDs[contact.UID] = struct{}{}
+	}
+
+	batch := make([]proton.ContactCards, 0, ContactImportBatchSize)
+
+	for _, card := range cards {
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, ok := remoteUIDs[card.Value(vcard.FieldUID)]; ok && len(card.Value(vcard.FieldUID)) != 0 {
+			r.presentCount++
+			reporter.OnProgress(1)
+
+			continue
+		}
+
+		contact, err := encryptContact(keyRing.GetUserKeyRing(), card)
+		if err != nil {
+			r.log.WithError(err).Error("Failed to encrypt contact")
+			r.failedCount++
+			reporter.OnProgress(1)
+
+			continue
+		}
+
+		if batch = append(batch, contact); len(batch) == ContactImportBatchSize {
+			if err := r.importContacts(client, batch, reporter); err != nil {
+				return err
+			}
+
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) != 0 {
+		return r.importContacts(client, batch, reporter)
+	}
+
+	return nil
+}
+
+func (r *RestoreTask) importContacts(client apiclient.Client, contacts []proton.ContactCards, reporter mail.Reporter) error {
+	responses, err := client.CreateContacts(r.ctx, proton.CreateContactsReq{Contacts: contacts})
+	if err != nil {
+		return fmt.Errorf("failed to create contacts: %w", err)
+	}
+
+	for _, res := range responses {
+		if res.Response.Code != proton.SuccessCode {
+			r.log.WithError(&res.Response.APIError).WithField("index", res.Index).Error("Failed to create contact")
+			r.failedCount++
+
+			continue
+		}
+
+		r.importedCount++
+	}
+
+	reporter.OnProgress(len(contacts))
+
+	return nil
+}
+
+// findContactsBackupDir returns the contacts export folder to restore from the given path, see NewRestoreTask.
+func findContactsBackupDir(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	if contactsFolderRegExp.MatchString(filepath.Base(absPath)) {
+		return absPath, nil
+	}
+
+	for _, dir := range []string{absPath, filepath.Dir(absPath)} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		var exports []string
+
+		for _, entry := range entries {
+			if entry.IsDir() && contactsFolderRegExp.MatchString(entry.Name()) {
+				exports = append(exports, entry.Name())
+			}
+		}
+
+		if len(exports) != 0 {
+			// The names sort in chronological order.
+			slices.Sort(exports)
+			return filepath.Join(dir, exports[len(exports)-1]), nil
+		}
+	}
+
+	return "", ErrNoContactsBackup
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package contacts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/mail"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestFindContactsBackupDir(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := findContactsBackupDir(dir)
+	require.ErrorIs(t, err, ErrNoContactsBackup)
+
+	older := filepath.Join(dir, "contacts_20240101_120000")
+	newer := filepath.Join(dir, "contacts_20240202_120000")
+	mailDir := filepath.Join(dir, "mail_20240202_120000")
+
+	for _, d := range []string{older, newer, mailDir, filepath.Join(dir, "contacts_backup")} {
+		require.NoError(t, os.Mkdir(d, 0o700))
+	}
+
+	for path, expected := range map[string]string{
+		dir:     newer,
+		older:   older,
+		mailDir: newer,
+	} {
+		result, err := findContactsBackupDir(path)
+		require.NoError(t, err)
+		require.Equal(t, expected, result)
+	}
+}
+
+func TestRestoreTask_ImportContacts(t *testing.T) {
+	client := apiclient.NewMockClient(gomock.NewController(t))
+
+	contacts := []proton.ContactCards{{}, {}, {}}
+
+	client.EXPECT().CreateContacts(gomock.Any(), proton.CreateContactsReq{Contacts: contacts}).Return([]proton.CreateContactsRes{
+		{Index: 0, Response: proton.CreateContactResp{APIError: proton.APIError{Code: proton.SuccessCode}}},
+		{Index: 1, Response: proton.CreateContactResp{APIError: proton.APIError{Code: 2001, Message: "invalid"}}},
+		{Index: 2, Response: proton.CreateContactResp{APIError: proton.APIError{Code: proton.SuccessCode}}},
+	}, nil)
+
+	task := &RestoreTask{ctx: context.Background(), log: logrus.WithField("test", "contacts")}
+
+	require.NoError(t, task.importContacts(client, contacts, mail.NullProgressReporter{}))
+	require.Equal(t, int64(2), task.GetImportedCount())
+	require.Equal(t, int64(1), task.GetFailedCount())
+}
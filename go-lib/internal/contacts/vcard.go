@@ -0,0 +1,219 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package contacts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-vcard"
+	"github.com/google/uuid"
+)
+
+// errContactSignature is returned along with the contact when the signature of one of its cards is invalid.
+var errContactSignature = errors.New("contact signature is invalid")
+
+// decryptContact merges the cards of a contact into a single vCard. The encrypted cards are decrypted with the user
+// keyring, which also verifies the signatures. The contact is still returned along with errContactSignature if one of
+// them is invalid, as it is the only copy of the data.
+func decryptContact(kr *crypto.KeyRing, contact proton.Contact) (vcard.Card, error) {
+	merged := make(vcard.Card)
+
+	var signatureErr error
+
+	for _, card := range contact.Cards {
+		data := card.Data
+
+		if card.Type&proton.CardTypeEncrypted != 0 {
+			message, err := crypto.NewPGPMessageFromArmored(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read contact card: %w", err)
+			}
+
+			decrypted, err := kr.Decrypt(message, nil, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt contact card: %w", err)
+			}
+
+			data = decrypted.GetString()
+		}
+
+		if card.Type&proton.CardTypeSigned != 0 {
+			if err := verifyCard(kr, data, card.Signature); err != nil {
+				signatureErr = fmt.Errorf("%w: %v", errContactSignature, err)
+			}
+		}
+
+		decoded, err := vcard.NewDecoder(strings.NewReader(data)).Decode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode contact card: %w", err)
+		}
+
+		for key, fields := range decoded {
+			// The fields which describe the card itself are only kept once.
+			if isSingleCardField(key) && len(merged[key]) != 0 {
+				continue
+			}
+
+			merged[key] = append(merged[key], fields...)
+		}
+	}
+
+	if merged.Get(vcard.FieldVersion) == nil {
+		merged.SetValue(vcard.FieldVersion, "4.0")
+	}
+
+	return merged, signatureErr
+}
+
+func verifyCard(kr *crypto.KeyRing, data, signature string) error {
+	sig, err := crypto.NewPGPSignatureFromArmored(signature)
+	if err != nil {
+		return err
+	}
+
+	return kr.VerifyDetached(crypto.NewPlainMessageFromString(data), sig, crypto.GetUnixTime())
+}
+
+// encryptContact splits a vCard into the cards of a Proton contact: the fields used to find the contact and send it
+// mail are only signed, the other ones are encrypted and signed. A UID is added if the vCard has none.
+func encryptContact(kr *crypto.KeyRing, card vcard.Card) (proton.ContactCards, error) {
+	if len(card.Value(vcard.FieldUID)) == 0 {
+		card.SetValue(vcard.FieldUID, "proton-export-"+uuid.NewString())
+	}
+
+	signed := vcard.Card{vcard.FieldVersion: card[vcard.FieldVersion]}
+	encrypted := vcard.Card{vcard.FieldVersion: card[vcard.FieldVersion]}
+
+	if len(signed[vcard.FieldVersion]) == 0 {
+		signed.SetValue(vcard.FieldVersion, "4.0")
+		encrypted.SetValue(vcard.FieldVersion, "4.0")
+	}
+
+	emailGroups := make(map[string]struct{})
+	for _, field := range card[vcard.FieldEmail] {
+		if len(field.Group) != 0 {
+			emailGroups[field.Group] = struct{}{}
+		}
+	}
+
+	for key, fields := range card {
+		if key == vcard.FieldVersion {
+			continue
+		}
+
+		for _, field := range fields {
+			_, isEmailGroup := emailGroups[field.Group]
+
+			switch {
+			case key == vcard.FieldFormattedName, key == vcard.FieldUID, key == vcard.FieldEmail, key == vcard.FieldProductID, isEmailGroup:
+				signed.Add(key, field)
+			default:
+				encrypted.Add(key, field)
+			}
+		}
+	}
+
+	cards := proton.Cards{}
+
+	signedCard, err := newContactCard(kr, proton.CardTypeSigned, signed)
+	if err != nil {
+		return proton.ContactCards{}, err
+	}
+
+	cards = append(cards, signedCard)
+
+	if len(encrypted) > 1 {
+		encryptedCard, err := newContactCard(kr, proton.CardTypeEncrypted|proton.CardTypeSigned, encrypted)
+		if err != nil {
+			return proton.ContactCards{}, err
+		}
+
+		cards = append(cards, encryptedCard)
+	}
+
+	return proton.ContactCards{Cards: cards}, nil
+}
+
+func newContactCard(kr *crypto.KeyRing, cardType proton.CardType, card vcard.Card) (*proton.Card, error) {
+	data, err := encodeCard(card)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &proton.Card{Type: cardType, Data: string(data)}
+
+	signature, err := kr.SignDetached(crypto.NewPlainMessageFromString(result.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign contact card: %w", err)
+	}
+
+	if result.Signature, err = signature.GetArmored(); err != nil {
+		return nil, fmt.Errorf("failed to sign contact card: %w", err)
+	}
+
+	if cardType&proton.CardTypeEncrypted != 0 {
+		message, err := kr.Encrypt(crypto.NewPlainMessageFromString(result.Data), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt contact card: %w", err)
+		}
+
+		if result.Data, err = message.GetArmored(); err != nil {
+			return nil, fmt.Errorf("failed to encrypt contact card: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+func encodeCard(card vcard.Card) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	if err := vcard.NewEncoder(&buffer).Encode(card); err != nil {
+		return nil, fmt.Errorf("failed to encode contact: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decodeCards returns the vCards of a .vcf file.
+func decodeCards(data []byte) ([]vcard.Card, error) {
+	decoder := vcard.NewDecoder(bytes.NewReader(data))
+
+	var cards []vcard.Card
+
+	for {
+		card, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			return cards, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode contacts: %w", err)
+		}
+
+		cards = append(cards, card)
+	}
+}
+
+func isSingleCardField(key string) bool {
+	return key == vcard.FieldVersion || key == vcard.FieldUID || key == vcard.FieldProductID
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package contacts
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/emersion/go-vcard"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeyRing(t *testing.T) *crypto.KeyRing {
+	key, err := crypto.GenerateKey("test", "test@proton.me", "x25519", 0)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	return kr
+}
+
+func newTestCard() vcard.Card {
+	card := make(vcard.Card)
+	card.SetValue(vcard.FieldVersion, "4.0")
+	card.SetValue(vcard.FieldFormattedName, "Alice")
+	card.SetValue(vcard.FieldUID, "alice-uid")
+	card.Add(vcard.FieldEmail, &vcard.Field{Value: "alice@proton.me", Group: "item1"})
+	card.Add("X-PM-ENCRYPT", &vcard.Field{Value: "true", Group: "item1"})
+	card.SetValue(vcard.FieldTelephone, "+41 22 000 00 00")
+	card.SetValue(vcard.FieldNote, "Met at the conference")
+
+	return card
+}
+
+func TestContact_EncryptDecrypt(t *testing.T) {
+	kr := newTestKeyRing(t)
+
+	contact, err := encryptContact(kr, newTestCard())
+	require.NoError(t, err)
+	require.Len(t, contact.Cards, 2)
+
+	signed := contact.Cards[0]
+	require.Equal(t, proton.CardTypeSigned, signed.Type)
+	require.Contains(t, signed.Data, "alice@proton.me")
+	require.Contains(t, signed.Data, "X-PM-ENCRYPT")
+	require.NotContains(t, signed.Data, "conference")
+
+	encrypted := contact.Cards[1]
+	require.Equal(t, proton.CardTypeEncrypted|proton.CardTypeSigned, encrypted.Type)
+	require.NotContains(t, encrypted.Data, "conference")
+
+	card, err := decryptContact(kr, proton.Contact{ContactCards: contact})
+	require.NoError(t, err)
+
+	require.Equal(t, "4.0", card.Value(vcard.FieldVersion))
+	require.Equal(t, "Alice", card.Value(vcard.FieldFormattedName))
+	require.Equal(t, "alice-uid", card.Value(vcard.FieldUID))
+	require.Equal(t, "alice@proton.me", card.Value(vcard.FieldEmail))
+	require.Equal(t, "+41 22 000 00 00", card.Value(vcard.FieldTelephone))
+	require.Equal(t, "Met at the conference", card.Value(vcard.FieldNote))
+}
+
+func TestContact_EncryptAddsUID(t *testing.T) {
+	kr := newTestKeyRing(t)
+
+	card := make(vcard.Card)
+	card.SetValue(vcard.FieldVersion, "3.0")
+	card.SetValue(vcard.FieldFormattedName, "Bob")
+
+	contact, err := encryptContact(kr, card)
+	require.NoError(t, err)
+
+	// Without other fields, there is nothing to encrypt.
+	require.Len(t, contact.Cards, 1)
+
+	decrypted, err := decryptContact(kr, proton.Contact{ContactCards: contact})
+	require.NoError(t, err)
+	require.Equal(t, "3.0", decrypted.Value(vcard.FieldVersion))
+	require.Regexp(t, "^proton-export-", decrypted.Value(vcard.FieldUID))
+}
+
+func TestContact_DecryptInvalidSignature(t *testing.T) {
+	kr := newTestKeyRing(t)
+
+	contact, err := encryptContact(kr, newTestCard())
+	require.NoError(t, err)
+
+	contact.Cards[1].Signature = contact.Cards[0].Signature
+
+	card, err := decryptContact(kr, proton.Contact{ContactCards: contact})
+	require.ErrorIs(t, err, errContactSignature)
+	require.Equal(t, "Met at the conference", card.Value(vcard.FieldNote))
+
+	_, err = decryptContact(newTestKeyRing(t), proton.Contact{ContactCards: contact})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errContactSignature)
+}
+
+func TestContact_DecodeCards(t *testing.T) {
+	var data []byte
+
+	for _, name := range []string{"Alice", "Bob"} {
+		card := newTestCard()
+		card.SetValue(vcard.FieldFormattedName, name)
+
+		encoded, err := encodeCard(card)
+		require.NoError(t, err)
+
+		data = append(data, encoded...)
+	}
+
+	cards, err := decodeCards(data)
+	require.NoError(t, err)
+	require.Len(t, cards, 2)
+	require.Equal(t, "Alice", cards[0].Value(vcard.FieldFormattedName))
+	require.Equal(t, "Bob", cards[1].Value(vcard.FieldFormattedName))
+
+	cards, err = decodeCards(nil)
+	require.NoError(t, err)
+	require.Empty(t, cards)
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+const AccountMetadataVersion = 1
+
+// AccountMetadata is the snapshot of the account settings stored in the account file, next to the labels file.
+// Backups created before the filters and the address signatures were added to it have none.
+type AccountMetadata struct {
+	UserSettings proton.UserSettings
+	MailSettings proton.MailSettings
+	Addresses    []AccountAddress
+	Filters      []apiclient.MailFilter
+}
+
+// AccountAddress is an address of the account without its keys.
+type AccountAddress struct {
+	ID          string
+	Email       string
+	DisplayName string
+	Signature   string
+	Order       int
+	Status      proton.AddressStatus
+	Type        proton.AddressType
+	Send        proton.Bool
+	Receive     proton.Bool
+}
+
+func newAccountAddresses(addresses []proton.Address, signatures []apiclient.AddressSignature) []AccountAddress {
+	result := make([]AccountAddress, 0, len(addresses))
+
+	for _, address := range addresses {
+		var signature string
+
+		if index := slices.IndexFunc(signatures, func(s apiclient.AddressSignature) bool { return s.ID == address.ID }); index >= 0 {
+			signature = signatures[index].Signature
+		}
+
+		result = append(result, AccountAddress{
+			ID:          address.ID,
+			Email:       address.Email,
+			DisplayName: address.DisplayName,
+			Signature:   signature,
+			Order:       address.Order,
+			Status:      address.Status,
+			Type:        address.Type,
+			Send:        address.Send,
+			Receive:     address.Receive,
+		})
+	}
+
+	slices.SortFunc(result, func(lhs, rhs AccountAddress) bool { return lhs.Order < rhs.Order })
+
+	return result
+}
+
+// WriteAccountMetadata writes the user settings, mail settings, addresses and filters to the account file.
+func (e *ExportTask) WriteAccountMetadata(ctx context.Context, tmpDir, exportPath string, addresses []proton.Address) error {
+	e.log.Debug("Writing account metadata")
+
+	client := e.session.GetClient()
+
+	userSettings, err := client.GetUserSettings(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve user settings: %w", err)
+	}
+
+	mailSettings, err := client.GetMailSettings(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve mail settings: %w", err)
+	}
+
+	signatures, err := client.GetAddressSignatures(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve address signatures: %w", err)
+	}
+
+	filters, err := client.GetMailFilters(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve filters: %w", err)
+	}
+
+	accountData, err := utils.GenerateVersionedJSON(AccountMetadataVersion, AccountMetadata{
+		UserSettings: userSettings,
+		MailSettings: mailSettings,
+		Addresses:    newAccountAddresses(addresses, signatures),
+		Filters:      filters,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to json encode account: %w", err)
+	}
+
+	return e.fileWriter.WriteFile(tmpDir, filepath.Join(exportPath, getAccountFileName()), accountData, &utils.Sha256IntegrityChecker{})
+}
+
+// SetRestoreSettings makes the restore re-apply the display name, signatures and filters of the account file and the
+// colors of the labels which already exist in the account. Must be called before Run.
+func (r *RestoreTask) SetRestoreSettings(restoreSettings bool) {
+	r.restoreSettings = restoreSettings
+}
+
+// restoreMailSettings applies the display name and signatures of the backup when they differ from those of the account,
+// and creates the filters of the backup which are missing. Backups created before the account file was introduced are
+// restored without settings.
+func (r *RestoreTask) restoreMailSettings() error {
+	account, err := r.source.getAccount()
+	if err != nil {
+		return fmt.Errorf("failed to read account file: %w", err)
+	}
+
+	if account == nil {
+		r.log.Warn("The backup has no account file, the settings are not restored")
+		return nil
+	}
+
+	client := r.session.GetClient()
+
+	remoteSettings, err := client.GetMailSettings(r.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve mail settings: %w", err)
+	}
+
+	backupSettings := account.Payload.MailSettings
+
+	if len(backupSettings.DisplayName) != 0 && backupSettings.DisplayName != remoteSettings.DisplayName {
+		if _, err := client.SetDisplayName(r.ctx, proton.SetDisplayNameReq{DisplayName: backupSettings.DisplayName}); err != nil {
+			return fmt.Errorf("failed to restore display name: %w", err)
+		}
+
+		r.log.Info("Restored display name")
+	}
+
+	if len(backupSettings.Signature) != 0 && backupSettings.Signature != remoteSettings.Signature {
+		if _, err := client.SetSignature(r.ctx, proton.SetSignatureReq{Signature: backupSettings.Signature}); err != nil {
+			return fmt.Errorf("failed to restore signature: %w", err)
+		}
+
+		r.log.Info("Restored signature")
+	}
+
+	if err := restoreAddressSignatures(r.ctx, client, r.log, account.Payload.Addresses); err != nil {
+		return err
+	}
+
+	return restoreMailFilters(r.ctx, client, r.log, account.Payload.Filters)
+}
+
+// restoreAddressSignatures applies the display name and signature of each backup address to the address of the account
+// with the same email.
+func restoreAddressSignatures(ctx context.Context, client apiclient.Client, log *logrus.Entry, addresses []AccountAddress) error {
+	remoteAddresses, err := client.GetAddresses(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve addresses: %w", err)
+	}
+
+	remoteSignatures, err := client.GetAddressSignatures(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve address signatures: %w", err)
+	}
+
+	for _, remoteAddress := range newAccountAddresses(remoteAddresses, remoteSignatures) {
+		index := slices.IndexFunc(addresses, func(address AccountAddress) bool {
+			return strings.EqualFold(address.Email, remoteAddress.Email)
+		})
+		if index < 0 {
+			continue
+		}
+
+		req := apiclient.UpdateAddressReq{DisplayName: remoteAddress.DisplayName, Signature: remoteAddress.Signature}
+
+		if len(addresses[index].DisplayName) != 0 {
+			req.DisplayName = addresses[index].DisplayName
+		}
+
+		if len(addresses[index].Signature) != 0 {
+			req.Signature = addresses[index].Signature
+		}
+
+		if req.DisplayName == remoteAddress.DisplayName && req.Signature == remoteAddress.Signature {
+			continue
+		}
+
+		if err := client.UpdateAddress(ctx, remoteAddress.ID, req); err != nil {
+			return fmt.Errorf("failed to restore signature of address %v: %w", remoteAddress.Email, err)
+		}
+
+		log.WithField("addressID", remoteAddress.ID).Info("Restored address signature")
+	}
+
+	return nil
+}
+
+// restoreMailFilters creates the filters of the backup whose name is not used by a filter of the account. A filter the
+// API rejects, e.g. because it refers to a folder which no longer exists, is skipped.
+func restoreMailFilters(ctx context.Context, client apiclient.Client, log *logrus.Entry, filters []apiclient.MailFilter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	remoteFilters, err := client.GetMailFilters(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve filters: %w", err)
+	}
+
+	slices.SortFunc(filters, func(lhs, rhs apiclient.MailFilter) bool { return lhs.Priority < rhs.Priority })
+
+	for _, filter := range filters {
+		if slices.ContainsFunc(remoteFilters, func(remoteFilter apiclient.MailFilter) bool { return remoteFilter.Name == filter.Name }) {
+			continue
+		}
+
+		if _, err := client.CreateMailFilter(ctx, apiclient.CreateMailFilterReq{
+			Name:    filter.Name,
+			Status:  filter.Status,
+			Version: filter.Version,
+			Sieve:   filter.Sieve,
+		}); err != nil {
+			log.WithError(err).WithField("filterID", filter.ID).Warn("Failed to restore filter")
+			continue
+		}
+
+		log.WithField("filterID", filter.ID).Info("Restored filter")
+	}
+
+	return nil
+}
+
+// restoreLabelSettings applies the color of a backup label to the existing label it was mapped to.
+func (r *RestoreTask) restoreLabelSettings(label proton.Label, remoteLabels []proton.Label) {
+	index := slices.IndexFunc(remoteLabels, func(remoteLabel proton.Label) bool {
+		return remoteLabel.ID == r.labelMapping[label.ID]
+	})
+
+	if index < 0 || isSystemLabel(label.ID) || len(label.Color) == 0 || remoteLabels[index].Color == label.Color {
+		return
+	}
+
+	remoteLabel := remoteLabels[index]
+
+	if _, err := r.session.GetClient().UpdateLabel(r.ctx, remoteLabel.ID, proton.UpdateLabelReq{
+		Name:     remoteLabel.Name,
+		Color:    label.Color,
+		ParentID: remoteLabel.ParentID,
+	}); err != nil {
+		r.log.WithError(err).WithField("remoteLabelID", remoteLabel.ID).Warn("Failed to restore label color")
+		return
+	}
+
+	r.log.WithFields(logrus.Fields{"backupLabelID": label.ID, "remoteLabelID": remoteLabel.ID}).Info("Restored label color")
+}
+
+// readAccountFile returns the account file of a backup folder, or nil if it has none.
+func readAccountFile(dir string, decrypter utils.FileDecrypter) (*utils.VersionedJSON[AccountMetadata], error) {
+	data, err := utils.ReadFileDecrypted(filepath.Join(dir, getAccountFileName()), decrypter)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return utils.NewVersionedJSON[AccountMetadata](AccountMetadataVersion, data)
+}
+
+func getAccountFileName() string {
+	return "account.json"
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewAccountAddresses(t *testing.T) {
+	addresses := newAccountAddresses([]proton.Address{
+		{ID: "second", Email: "second@proton.me", DisplayName: "Second", Order: 2, Keys: proton.Keys{{ID: "key"}}},
+		{ID: "first", Email: "first@proton.me", DisplayName: "First", Order: 1, Status: proton.AddressStatusEnabled},
+	}, []apiclient.AddressSignature{{ID: "first", Signature: "Regards"}})
+
+	require.Equal(t, []AccountAddress{
+		{ID: "first", Email: "first@proton.me", DisplayName: "First", Signature: "Regards", Order: 1, Status: proton.AddressStatusEnabled},
+		{ID: "second", Email: "second@proton.me", DisplayName: "Second", Order: 2},
+	}, addresses)
+}
+
+func TestBackupDirSource_Account(t *testing.T) {
+	dir := t.TempDir()
+	cipher := utils.NewPGPPasswordFileCipher([]byte("secret"))
+
+	account, err := newBackupDirSource(dir, cipher).getAccount()
+	require.NoError(t, err)
+	require.Nil(t, account)
+
+	data, err := utils.GenerateVersionedJSON(AccountMetadataVersion, AccountMetadata{
+		MailSettings: proton.MailSettings{DisplayName: "Alice", Signature: "Regards"},
+		Addresses:    []AccountAddress{{ID: "address", Email: "alice@proton.me", Signature: "Alice"}},
+		Filters:      []apiclient.MailFilter{{ID: "filter", Name: "Newsletters", Version: 2, Sieve: "require \"fileinto\";"}},
+	})
+	require.NoError(t, err)
+
+	writer := &utils.DiskFileWriter{Encrypter: cipher}
+	require.NoError(t, writer.WriteFile(dir, filepath.Join(dir, getAccountFileName()), data, nil))
+
+	account, err = newBackupDirSource(dir, cipher).getAccount()
+	require.NoError(t, err)
+	require.Equal(t, "Alice", account.Payload.MailSettings.DisplayName)
+	require.Equal(t, "Regards", account.Payload.MailSettings.Signature)
+	require.Len(t, account.Payload.Addresses, 1)
+	require.Equal(t, "Alice", account.Payload.Addresses[0].Signature)
+	require.Len(t, account.Payload.Filters, 1)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, getAccountFileName()), []byte("{}"), 0o600))
+
+	_, err = newBackupDirSource(dir, cipher).getAccount()
+	require.Error(t, err)
+}
+
+func TestRestoreAddressSignatures(t *testing.T) {
+	client := apiclient.NewMockClient(gomock.NewController(t))
+
+	client.EXPECT().GetAddresses(gomock.Any()).Return([]proton.Address{
+		{ID: "remote-alice", Email: "Alice@proton.me", DisplayName: "Alice"},
+		{ID: "remote-bob", Email: "bob@proton.me", DisplayName: "Bob"},
+		{ID: "remote-carol", Email: "carol@proton.me", DisplayName: "Carol"},
+	}, nil)
+	client.EXPECT().GetAddressSignatures(gomock.Any()).Return([]apiclient.AddressSignature{
+		{ID: "remote-bob", Signature: "Bob"},
+	}, nil)
+	client.EXPECT().UpdateAddress(gomock.Any(), "remote-alice", apiclient.UpdateAddressReq{
+		DisplayName: "Alice",
+		Signature:   "Regards, Alice",
+	}).Return(nil)
+
+	require.NoError(t, restoreAddressSignatures(context.Background(), client, logrus.WithField("test", "test"), []AccountAddress{
+		{ID: "alice", Email: "alice@proton.me", Signature: "Regards, Alice"},
+		{ID: "bob", Email: "bob@proton.me", DisplayName: "Bob", Signature: "Bob"},
+		{ID: "dave", Email: "dave@proton.me", Signature: "Dave"},
+	}))
+}
+
+func TestRestoreMailFilters(t *testing.T) {
+	client := apiclient.NewMockClient(gomock.NewController(t))
+
+	client.EXPECT().GetMailFilters(gomock.Any()).Return([]apiclient.MailFilter{{ID: "remote", Name: "Existing"}}, nil)
+
+	gomock.InOrder(
+		client.EXPECT().CreateMailFilter(gomock.Any(), apiclient.CreateMailFilterReq{
+			Name: "Rejected", Status: 1, Version: 2, Sieve: "invalid",
+		}).Return(apiclient.MailFilter{}, errors.New("invalid sieve")),
+		client.EXPECT().CreateMailFilter(gomock.Any(), apiclient.CreateMailFilterReq{
+			Name: "Newsletters", Status: 1, Version: 2, Sieve: "require \"fileinto\";",
+		}).Return(apiclient.MailFilter{ID: "created"}, nil),
+	)
+
+	require.NoError(t, restoreMailFilters(context.Background(), client, logrus.WithField("test", "test"), []apiclient.MailFilter{
+		{ID: "newsletters", Name: "Newsletters", Status: 1, Priority: 2, Version: 2, Sieve: "require \"fileinto\";"},
+		{ID: "existing", Name: "Existing", Priority: 3},
+		{ID: "rejected", Name: "Rejected", Status: 1, Priority: 1, Version: 2, Sieve: "invalid"},
+	}))
+}
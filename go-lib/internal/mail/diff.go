@@ -0,0 +1,405 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// DiffTask compares a backup created by the export with the current state of the account: the messages which are
+// missing on either side, the folders and labels which were created, deleted or changed, and the messages whose folders
+// and labels changed. The backup is read the same way as the restore does, so messages which could not be built are
+// reported as missing from the backup: they can't be restored.
+type DiffTask struct {
+	ctx             context.Context
+	ctxCancel       func()
+	backupPath      string
+	session         *session.Session
+	decrypter       utils.FileDecrypter
+	filter          ExportFilter
+	log             *logrus.Entry
+	report          DiffReport
+	cancelledByUser bool
+}
+
+// DiffMessage is a message found on one side only.
+type DiffMessage struct {
+	ID      string
+	Subject string
+	Time    int64
+}
+
+// DiffLabel is a folder or label found on one side only, or whose name or parent changed.
+type DiffLabel struct {
+	ID         string
+	BackupPath string `json:",omitempty"`
+	RemotePath string `json:",omitempty"`
+}
+
+// DiffLabelDrift is a message present on both sides whose folders and labels differ. The labels are listed by name.
+type DiffLabelDrift struct {
+	ID      string
+	Subject string
+	Added   []string `json:",omitempty"` // labels the message has on the account but not in the backup.
+	Removed []string `json:",omitempty"` // labels the message has in the backup but not on the account.
+}
+
+// DiffReport is the result of the comparison of a backup with the account.
+type DiffReport struct {
+	BackupPath         string
+	BackupMessageCount int
+	RemoteMessageCount int
+	MissingInBackup    []DiffMessage
+	MissingOnAccount   []DiffMessage
+	LabelDrift         []DiffLabelDrift
+	NewLabels          []DiffLabel // on the account but not in the backup.
+	DeletedLabels      []DiffLabel // in the backup but not on the account.
+	ChangedLabels      []DiffLabel // renamed or moved.
+}
+
+// IsEmpty returns true if the backup matches the account.
+func (r *DiffReport) IsEmpty() bool {
+	return len(r.MissingInBackup) == 0 &&
+		len(r.MissingOnAccount) == 0 &&
+		len(r.LabelDrift) == 0 &&
+		len(r.NewLabels) == 0 &&
+		len(r.DeletedLabels) == 0 &&
+		len(r.ChangedLabels) == 0
+}
+
+func NewDiffTask(ctx context.Context, backupPath string, session *session.Session) (*DiffTask, error) {
+	absPath, err := filepath.Abs(backupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &DiffTask{
+		ctx:        ctx,
+		ctxCancel:  cancel,
+		backupPath: absPath,
+		session:    session,
+		log:        logrus.WithField("diff", "mail").WithField("userID", session.GetUser().ID),
+		report:     DiffReport{BackupPath: absPath},
+	}, nil
+}
+
+// SetDecrypter sets the key or passphrase used to read a backup created with export encryption. Must be called before Run.
+func (d *DiffTask) SetDecrypter(decrypter utils.FileDecrypter) {
+	d.decrypter = decrypter
+}
+
+// SetFilter restricts the comparison to the messages matching the filter, on both sides. It should be the filter the
+// backup was created with. Must be called before Run.
+func (d *DiffTask) SetFilter(filter ExportFilter) {
+	d.filter = filter
+}
+
+func (d *DiffTask) Cancel() {
+	d.cancelledByUser = true
+	d.ctxCancel()
+}
+
+func (d *DiffTask) GetOperationCancelledByUser() bool {
+	return d.cancelledByUser
+}
+
+// GetReport returns the result of the comparison. Only complete once Run has returned without error.
+func (d *DiffTask) GetReport() DiffReport {
+	return d.report
+}
+
+func (d *DiffTask) Run(reporter Reporter) error {
+	startTime := time.Now()
+	defer func() { d.log.WithField("duration", time.Since(startTime)).Info("Finished") }()
+	d.log.WithField("backupPath", d.backupPath).Info("Starting")
+
+	client := d.session.GetClient()
+
+	remoteLabels, err := client.GetLabels(d.ctx, proton.LabelTypeSystem, proton.LabelTypeFolder, proton.LabelTypeLabel)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve labels: %w", err)
+	}
+
+	var filter *messageFilter
+
+	if !d.filter.IsEmpty() {
+		addresses, err := client.GetAddresses(d.ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get user addresses: %w", err)
+		}
+
+		if filter, err = d.filter.resolve(remoteLabels, addresses); err != nil {
+			return err
+		}
+	}
+
+	backupLabels, backupMessages, err := d.readBackup(filter, reporter)
+	if err != nil {
+		return err
+	}
+
+	remoteMessages, err := d.readRemoteMessages(filter)
+	if err != nil {
+		return err
+	}
+
+	d.report.BackupMessageCount = len(backupMessages)
+	d.report.RemoteMessageCount = len(remoteMessages)
+
+	d.compareLabels(backupLabels, remoteLabels)
+	d.compareMessages(backupMessages, remoteMessages, getLabelNames(backupLabels, remoteLabels))
+
+	d.log.WithFields(logrus.Fields{
+		"backup":           d.report.BackupMessageCount,
+		"remote":           d.report.RemoteMessageCount,
+		"missingInBackup":  len(d.report.MissingInBackup),
+		"missingOnAccount": len(d.report.MissingOnAccount),
+		"labelDrift":       len(d.report.LabelDrift),
+		"newLabels":        len(d.report.NewLabels),
+		"deletedLabels":    len(d.report.DeletedLabels),
+		"changedLabels":    len(d.report.ChangedLabels),
+	}).Info("Report")
+
+	return nil
+}
+
+// readBackup lists the labels and the metadata of the messages of the backup.
+func (d *DiffTask) readBackup(filter *messageFilter, reporter Reporter) ([]proton.Label, map[string]proton.MessageMetadata, error) {
+	restoreTask := &RestoreTask{
+		ctx:       d.ctx,
+		backupDir: d.backupPath,
+		log:       d.log,
+		decrypter: d.decrypter,
+	}
+	defer restoreTask.Close()
+
+	messageList, err := restoreTask.validateBackupDir(reporter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := restoreTask.source.(*mailboxSource); ok {
+		return nil, nil, errors.New("only backups created by the export can be compared with the account")
+	}
+
+	labels, err := restoreTask.source.getLabels()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup labels: %w", err)
+	}
+
+	messages := make(map[string]proton.MessageMetadata, len(messageList))
+
+	for _, info := range messageList {
+		if d.ctx.Err() != nil {
+			return nil, nil, d.ctx.Err()
+		}
+
+		metadata, err := restoreTask.source.readMetadata(info)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if filter == nil || filter.matches(metadata) {
+			messages[metadata.ID] = metadata
+		}
+
+		reporter.OnProgress(1)
+	}
+
+	return labels, messages, nil
+}
+
+// readRemoteMessages lists the metadata of the messages of the account, the same way the export does.
+func (d *DiffTask) readRemoteMessages(filter *messageFilter) (map[string]proton.MessageMetadata, error) {
+	client := d.session.GetClient()
+
+	var apiFilter proton.MessageFilter
+	if filter != nil {
+		apiFilter = filter.apiFilter()
+	}
+
+	apiFilter.Desc = true
+
+	messages := make(map[string]proton.MessageMetadata)
+
+	for {
+		if d.ctx.Err() != nil {
+			return nil, d.ctx.Err()
+		}
+
+		page, err := client.GetMessageMetadataPage(d.ctx, 0, MetadataPageSize, apiFilter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list messages: %w", err)
+		}
+
+		// The page starts with the last message of the previous one.
+		if len(page) != 0 && page[0].ID == apiFilter.EndID {
+			page = page[1:]
+		}
+
+		if len(page) == 0 {
+			return messages, nil
+		}
+
+		for _, metadata := range page {
+			if filter == nil || filter.matches(metadata) {
+				messages[metadata.ID] = metadata
+			}
+		}
+
+		apiFilter.EndID = page[len(page)-1].ID
+	}
+}
+
+func (d *DiffTask) compareLabels(backupLabels, remoteLabels []proton.Label) {
+	backupPaths := getLabelPaths(backupLabels)
+	remotePaths := getLabelPaths(xslices.Filter(remoteLabels, nonSystemLabel))
+
+	for _, id := range sortedKeys(remotePaths) {
+		backupPath, ok := backupPaths[id]
+
+		switch {
+		case !ok:
+			d.report.NewLabels = append(d.report.NewLabels, DiffLabel{ID: id, RemotePath: remotePaths[id]})
+		case backupPath != remotePaths[id]:
+			d.report.ChangedLabels = append(d.report.ChangedLabels, DiffLabel{ID: id, BackupPath: backupPath, RemotePath: remotePaths[id]})
+		}
+	}
+
+	for _, id := range sortedKeys(backupPaths) {
+		if _, ok := remotePaths[id]; !ok {
+			d.report.DeletedLabels = append(d.report.DeletedLabels, DiffLabel{ID: id, BackupPath: backupPaths[id]})
+		}
+	}
+}
+
+func (d *DiffTask) compareMessages(backupMessages, remoteMessages map[string]proton.MessageMetadata, labelNames map[string]string) {
+	for _, id := range sortedKeys(remoteMessages) {
+		remote := remoteMessages[id]
+
+		backup, ok := backupMessages[id]
+		if !ok {
+			d.report.MissingInBackup = append(d.report.MissingInBackup, newDiffMessage(remote))
+			continue
+		}
+
+		added, removed := diffLabelIDs(backup.LabelIDs, remote.LabelIDs)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+
+		d.report.LabelDrift = append(d.report.LabelDrift, DiffLabelDrift{
+			ID:      id,
+			Subject: remote.Subject,
+			Added:   getLabelNameList(added, labelNames),
+			Removed: getLabelNameList(removed, labelNames),
+		})
+	}
+
+	for _, id := range sortedKeys(backupMessages) {
+		if _, ok := remoteMessages[id]; !ok {
+			d.report.MissingOnAccount = append(d.report.MissingOnAccount, newDiffMessage(backupMessages[id]))
+		}
+	}
+}
+
+func newDiffMessage(metadata proton.MessageMetadata) DiffMessage {
+	return DiffMessage{ID: metadata.ID, Subject: metadata.Subject, Time: metadata.Time}
+}
+
+// diffLabelIDs returns the labels which are only in the remote list and those which are only in the backup list.
+func diffLabelIDs(backupIDs, remoteIDs []string) ([]string, []string) {
+	var added, removed []string
+
+	for _, id := range remoteIDs {
+		if !slices.Contains(backupIDs, id) {
+			added = append(added, id)
+		}
+	}
+
+	for _, id := range backupIDs {
+		if !slices.Contains(remoteIDs, id) {
+			removed = append(removed, id)
+		}
+	}
+
+	return added, removed
+}
+
+// getLabelPaths returns the slash separated path of each label in the folder hierarchy.
+func getLabelPaths(labels []proton.Label) map[string]string {
+	paths := make(map[string]string, len(labels))
+	for _, label := range labels {
+		paths[label.ID] = strings.Join(label.Path, "/")
+	}
+
+	return paths
+}
+
+// getLabelNames returns the names of the labels, those of the account taking precedence over those of the backup.
+func getLabelNames(backupLabels, remoteLabels []proton.Label) map[string]string {
+	names := make(map[string]string, len(backupLabels)+len(remoteLabels))
+
+	for _, labels := range [][]proton.Label{backupLabels, remoteLabels} {
+		for _, label := range labels {
+			if len(label.Path) != 0 {
+				names[label.ID] = strings.Join(label.Path, "/")
+			} else {
+				names[label.ID] = label.Name
+			}
+		}
+	}
+
+	return names
+}
+
+func getLabelNameList(ids []string, names map[string]string) []string {
+	result := make([]string, 0, len(ids))
+
+	for _, id := range ids {
+		if name, ok := names[id]; ok {
+			result = append(result, name)
+		} else {
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+
+	return keys
+}
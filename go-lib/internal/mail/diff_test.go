@@ -0,0 +1,110 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffTask_CompareLabels(t *testing.T) {
+	task := &DiffTask{}
+
+	task.compareLabels(
+		[]proton.Label{
+			{ID: "kept", Path: []string{"Work"}, Type: proton.LabelTypeFolder},
+			{ID: "renamed", Path: []string{"Old"}, Type: proton.LabelTypeLabel},
+			{ID: "deleted", Path: []string{"Gone"}, Type: proton.LabelTypeLabel},
+		},
+		[]proton.Label{
+			{ID: proton.InboxLabel, Name: "Inbox", Path: []string{"Inbox"}, Type: proton.LabelTypeSystem},
+			{ID: "kept", Path: []string{"Work"}, Type: proton.LabelTypeFolder},
+			{ID: "renamed", Path: []string{"Work", "New"}, Type: proton.LabelTypeLabel},
+			{ID: "new", Path: []string{"New"}, Type: proton.LabelTypeLabel},
+		},
+	)
+
+	require.Equal(t, []DiffLabel{{ID: "new", RemotePath: "New"}}, task.report.NewLabels)
+	require.Equal(t, []DiffLabel{{ID: "deleted", BackupPath: "Gone"}}, task.report.DeletedLabels)
+	require.Equal(t, []DiffLabel{{ID: "renamed", BackupPath: "Old", RemotePath: "Work/New"}}, task.report.ChangedLabels)
+}
+
+func TestDiffTask_CompareMessages(t *testing.T) {
+	task := &DiffTask{}
+
+	metadata := func(id string, labelIDs ...string) proton.MessageMetadata {
+		return proton.MessageMetadata{ID: id, Subject: "subject " + id, LabelIDs: labelIDs}
+	}
+
+	task.compareMessages(
+		map[string]proton.MessageMetadata{
+			"same":    metadata("same", proton.InboxLabel),
+			"moved":   metadata("moved", proton.InboxLabel, "label"),
+			"deleted": metadata("deleted", proton.InboxLabel),
+		},
+		map[string]proton.MessageMetadata{
+			"same":  metadata("same", proton.InboxLabel),
+			"moved": metadata("moved", proton.ArchiveLabel, "label"),
+			"new":   metadata("new", proton.InboxLabel),
+		},
+		map[string]string{proton.InboxLabel: "Inbox"},
+	)
+
+	require.Equal(t, []DiffMessage{{ID: "new", Subject: "subject new"}}, task.report.MissingInBackup)
+	require.Equal(t, []DiffMessage{{ID: "deleted", Subject: "subject deleted"}}, task.report.MissingOnAccount)
+	require.Equal(t, []DiffLabelDrift{{
+		ID:      "moved",
+		Subject: "subject moved",
+		Added:   []string{proton.ArchiveLabel},
+		Removed: []string{"Inbox"},
+	}}, task.report.LabelDrift)
+	require.False(t, task.report.IsEmpty())
+}
+
+func TestDiffTask_ReadBackup(t *testing.T) {
+	dir := t.TempDir()
+
+	labels, err := utils.GenerateVersionedJSON(LabelMetadataVersion, []proton.Label{{ID: "label", Name: "Label", Path: []string{"Label"}}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, getLabelFileName()), labels, 0o600))
+
+	for i, id := range []string{"msg1", "msg2"} {
+		metadata := MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: id, Time: int64(1700000000 + i), LabelIDs: []string{"label"}}}
+		metadataBytes, err := metadata.toBytes()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, getMetadataFileName(id)), metadataBytes, 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, getEMLFileName(id)), []byte("Subject: "+id+"\r\n\r\n"), 0o600))
+	}
+
+	task := &DiffTask{ctx: context.Background(), backupPath: dir, log: logrus.WithField("test", "diff")}
+
+	filter := &messageFilter{after: 1700000001}
+
+	backupLabels, messages, err := task.readBackup(filter, &NullProgressReporter{})
+	require.NoError(t, err)
+	require.Len(t, backupLabels, 1)
+	require.Len(t, messages, 1)
+	require.Equal(t, []string{"label"}, messages["msg2"].LabelIDs)
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+)
+
+// EventType identifies what an Event reports.
+type EventType string
+
+const (
+	EventStageStarted    EventType = "StageStarted"
+	EventStageFinished   EventType = "StageFinished"
+	EventMessageExported EventType = "MessageExported"
+	EventMessageImported EventType = "MessageImported"
+	EventMessageFailed   EventType = "MessageFailed"
+	EventMessageSkipped  EventType = "MessageSkipped"
+	EventRetry           EventType = "Retry"
+	EventNetworkLost     EventType = "NetworkLost"
+	EventNetworkRestored EventType = "NetworkRestored"
+	EventProgressUpdated EventType = "ProgressUpdated"
+)
+
+// MessageFailure tells why a message did not make it into the backup or the account, see FailureReport.
+type MessageFailure string
+
+const (
+	FailureDownload          MessageFailure = "DownloadFailed"
+	FailureDownloadRejected  MessageFailure = "DownloadRejected" // The API refused to serve the message (422).
+	FailureBuild             MessageFailure = "BuildFailed"
+	FailureWrite             MessageFailure = "WriteFailed"
+	FailureAssemble          MessageFailure = "AssembleFailed"    // Exported as a folder with its body and attachments.
+	FailureAddressKeyMissing MessageFailure = "AddressKeyMissing" // Exported encrypted as a folder.
+	FailureRead              MessageFailure = "ReadFailed"
+	FailureImport            MessageFailure = "ImportFailed"
+	FailureImportRejected    MessageFailure = "ImportRejected" // The API refused to import the message, see Code.
+)
+
+// The progress events are emitted at most once in this interval, and always when the last message is processed.
+const progressEventInterval = time.Second
+
+// Event is a machine-readable record of what a backup or a restore is doing, see EventReporter.
+type Event struct {
+	Time      time.Time
+	Type      EventType
+	Stage     string         `json:",omitempty"`
+	MessageID string         `json:",omitempty"`
+	Subject   string         `json:",omitempty"`
+	Failure   MessageFailure `json:",omitempty"` // Set when the message did not make it, or only partially.
+	Code      int            `json:",omitempty"` // API error code of the failure.
+	Reason    string         `json:",omitempty"` // Error of failed messages and retries, or why a message was skipped.
+	Delay     float64        `json:",omitempty"` // Seconds before a request is retried.
+	Progress  *EventProgress `json:",omitempty"`
+}
+
+type EventProgress struct {
+	Processed uint64
+	Total     uint64
+	ETA       int64 // Estimated seconds until all the messages are processed, -1 when unknown.
+}
+
+// EventReporter receives the events of a task. It is called from all the goroutines of the task.
+type EventReporter interface {
+	ReportEvent(event Event)
+}
+
+type NullEventReporter struct{}
+
+func (n NullEventReporter) ReportEvent(Event) {}
+
+// JSONEventWriter writes the events as JSON lines.
+type JSONEventWriter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+func NewJSONEventWriter(w io.Writer) *JSONEventWriter {
+	return &JSONEventWriter{encoder: json.NewEncoder(w)}
+}
+
+// NewJSONEventFile creates a JSONEventWriter writing to the file at path, which is truncated. The writer must be closed.
+func NewJSONEventFile(path string) (*JSONEventWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create event file: %w", err)
+	}
+
+	writer := NewJSONEventWriter(file)
+	writer.closer = file
+
+	return writer, nil
+}
+
+func (j *JSONEventWriter) ReportEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err := j.encoder.Encode(event); err != nil {
+		logrus.WithError(err).Warn("Failed to write event")
+	}
+}
+
+func (j *JSONEventWriter) Close() error {
+	if j.closer == nil {
+		return nil
+	}
+
+	return j.closer.Close()
+}
+
+func reportStageStarted(events EventReporter, stage string) {
+	events.ReportEvent(Event{Type: EventStageStarted, Stage: stage})
+}
+
+func reportStageFinished(events EventReporter, stage string) {
+	events.ReportEvent(Event{Type: EventStageFinished, Stage: stage})
+}
+
+func reportMessageFailed(events EventReporter, stage string, failure MessageFailure, metadata proton.MessageMetadata, err error) {
+	events.ReportEvent(newFailureEvent(EventMessageFailed, stage, failure, metadata, err))
+}
+
+func newFailureEvent(eventType EventType, stage string, failure MessageFailure, metadata proton.MessageMetadata, err error) Event {
+	event := Event{
+		Type:      eventType,
+		Stage:     stage,
+		MessageID: metadata.ID,
+		Subject:   metadata.Subject,
+		Failure:   failure,
+		Reason:    err.Error(),
+	}
+
+	if apiErr := new(proton.APIError); errors.As(err, &apiErr) {
+		event.Code = int(apiErr.Code)
+	}
+
+	return event
+}
+
+// withoutSubjects removes the subjects of the messages from the events forwarded to events, so that they are not
+// stored in plain text by encrypted tasks.
+func withoutSubjects(events EventReporter) EventReporter {
+	return subjectRemover{events: events}
+}
+
+type subjectRemover struct {
+	events EventReporter
+}
+
+func (s subjectRemover) ReportEvent(event Event) {
+	event.Subject = ""
+	s.events.ReportEvent(event)
+}
+
+// retryEventObserver reports the retries of the API requests.
+type retryEventObserver struct {
+	events EventReporter
+}
+
+func (r retryEventObserver) OnRetry(delay time.Duration, err error) {
+	r.events.ReportEvent(Event{Type: EventRetry, Reason: err.Error(), Delay: delay.Seconds()})
+}
+
+// eventProgressReporter forwards the progress to a Reporter and reports it as events, along with the estimated time
+// until the task completes.
+type eventProgressReporter struct {
+	reporter  Reporter
+	events    EventReporter
+	lock      sync.Mutex
+	total     atomic.Uint64
+	processed atomic.Uint64
+	start     time.Time
+	startDone uint64 // Messages processed before the progress started being measured, excluded from the rate.
+	lastEvent time.Time
+	now       func() time.Time
+}
+
+func newEventProgressReporter(reporter Reporter, events EventReporter) *eventProgressReporter {
+	return &eventProgressReporter{
+		reporter: reporter,
+		events:   events,
+		start:    time.Now(),
+		now:      time.Now,
+	}
+}
+
+func (e *eventProgressReporter) SetMessageTotal(total uint64) {
+	e.reporter.SetMessageTotal(total)
+	e.total.Store(total)
+	e.report(true)
+}
+
+func (e *eventProgressReporter) SetMessageProcessed(total uint64) {
+	e.reporter.SetMessageProcessed(total)
+	e.processed.Store(total)
+
+	e.lock.Lock()
+	e.start = e.now()
+	e.startDone = total
+	e.lock.Unlock()
+
+	e.report(true)
+}
+
+func (e *eventProgressReporter) OnProgress(delta int) {
+	e.reporter.OnProgress(delta)
+
+	processed := e.processed.Add(uint64(delta)) //nolint:gosec // the progress only increases.
+	e.report(processed >= e.total.Load())
+}
+
+func (e *eventProgressReporter) report(force bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	now := e.now()
+	if !force && now.Sub(e.lastEvent) < progressEventInterval {
+		return
+	}
+
+	e.lastEvent = now
+
+	processed, total := e.processed.Load(), e.total.Load()
+
+	e.events.ReportEvent(Event{
+		Type: EventProgressUpdated,
+		Progress: &EventProgress{
+			Processed: processed,
+			Total:     total,
+			ETA:       estimateRemainingTime(processed-min(processed, e.startDone), total-min(total, processed), now.Sub(e.start)),
+		},
+	})
+}
+
+// estimateRemainingTime returns the seconds needed to process the remaining messages at the rate at which the done
+// messages were processed during elapsed, or -1 if the rate isn't known yet.
+func estimateRemainingTime(done, remaining uint64, elapsed time.Duration) int64 {
+	if remaining == 0 {
+		return 0
+	}
+
+	if done == 0 || elapsed <= 0 {
+		return -1
+	}
+
+	return int64(elapsed.Seconds() / float64(done) * float64(remaining))
+}
+
+// withRetryEvents reports the retries of the API requests made with the returned context.
+func withRetryEvents(ctx context.Context, events EventReporter) context.Context {
+	if _, ok := events.(NullEventReporter); ok {
+		return ctx
+	}
+
+	return apiclient.WithRetryObserver(ctx, retryEventObserver{events: events})
+}
+
+// withProgressEvents reports the progress of the returned reporter.
+func withProgressEvents(reporter Reporter, events EventReporter) Reporter {
+	if _, ok := events.(NullEventReporter); ok {
+		return reporter
+	}
+
+	return newEventProgressReporter(reporter, events)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gluon/async"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONEventWriter(t *testing.T) {
+	var buffer bytes.Buffer
+
+	writer := NewJSONEventWriter(&buffer)
+	writer.ReportEvent(Event{Type: EventMessageFailed, Stage: "write", MessageID: "msg", Reason: "disk full"})
+	writer.ReportEvent(Event{Type: EventProgressUpdated, Progress: &EventProgress{Processed: 1, Total: 2, ETA: -1}})
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var event Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	require.False(t, event.Time.IsZero())
+	require.Equal(t, Event{Time: event.Time, Type: EventMessageFailed, Stage: "write", MessageID: "msg", Reason: "disk full"}, event)
+	require.NotContains(t, lines[0], "Progress")
+
+	require.Contains(t, lines[1], `"Progress":{"Processed":1,"Total":2,"ETA":-1}`)
+}
+
+func TestEventProgressReporter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	events := &testEventReporter{}
+
+	reporter := newEventProgressReporter(NullProgressReporter{}, events)
+	reporter.now = func() time.Time { return now }
+	reporter.start = now
+
+	reporter.SetMessageTotal(100)
+	require.Equal(t, &EventProgress{Processed: 0, Total: 100, ETA: -1}, events.last().Progress)
+
+	// Progress events are throttled.
+	now = now.Add(10 * time.Second)
+	reporter.OnProgress(10)
+	reporter.OnProgress(10)
+	require.Len(t, events.get(), 2)
+	require.Equal(t, &EventProgress{Processed: 10, Total: 100, ETA: 90}, events.last().Progress)
+
+	now = now.Add(10 * time.Second)
+	reporter.OnProgress(30)
+	require.Equal(t, &EventProgress{Processed: 50, Total: 100, ETA: 20}, events.last().Progress)
+
+	// The last one is always reported.
+	reporter.OnProgress(50)
+	require.Len(t, events.get(), 4)
+	require.Equal(t, &EventProgress{Processed: 100, Total: 100, ETA: 0}, events.last().Progress)
+}
+
+func TestWriteStage_Events(t *testing.T) {
+	events := &testEventReporter{}
+
+	stage := NewWriteStage(t.TempDir(), t.TempDir(), 1, logrus.WithField("test", "test"), NullProgressReporter{}, &async.NoopPanicHandler{})
+	stage.SetEventReporter(events)
+
+	inputs := make(chan BuildStageOutput, 1)
+	inputs <- BuildStageOutput{messages: []MessageWriter{
+		&DecryptedAndBuiltMessageWriter{msg: proton.FullMessage{Message: proton.Message{MessageMetadata: proton.MessageMetadata{ID: "built"}}}},
+		&AddrKeyRingMissingMessageWriter{msg: proton.FullMessage{Message: proton.Message{MessageMetadata: proton.MessageMetadata{ID: "nokey"}}}},
+	}}
+	close(inputs)
+
+	stage.Run(context.Background(), inputs, NullErrorReporter{})
+
+	require.Equal(t, []Event{
+		{Type: EventMessageExported, Stage: "write", MessageID: "built"},
+		{Type: EventMessageExported, Stage: "write", MessageID: "nokey", Reason: "the key of the address is not available, the message was written encrypted", Failure: FailureAddressKeyMissing},
+	}, events.get())
+
+	failing := &testEventReporter{}
+	stage = NewWriteStage(t.TempDir(), "/nonexistent/dir", 1, logrus.WithField("test", "test"), NullProgressReporter{}, &async.NoopPanicHandler{})
+	stage.SetEventReporter(failing)
+
+	inputs = make(chan BuildStageOutput, 1)
+	inputs <- BuildStageOutput{messages: []MessageWriter{
+		&DecryptedAndBuiltMessageWriter{msg: proton.FullMessage{Message: proton.Message{MessageMetadata: proton.MessageMetadata{ID: "failed"}}}},
+	}}
+	close(inputs)
+
+	stage.Run(context.Background(), inputs, NullErrorReporter{})
+
+	require.Len(t, failing.get(), 1)
+	require.Equal(t, EventMessageFailed, failing.last().Type)
+	require.Equal(t, "failed", failing.last().MessageID)
+	require.NotEmpty(t, failing.last().Reason)
+}
+
+func TestRetryEventObserver(t *testing.T) {
+	events := &testEventReporter{}
+
+	retryEventObserver{events: events}.OnRetry(30*time.Second, errors.New("rate limited"))
+	require.Equal(t, []Event{{Type: EventRetry, Reason: "rate limited", Delay: 30}}, events.get())
+}
+
+type testEventReporter struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (t *testEventReporter) ReportEvent(event Event) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.events = append(t.events, event)
+}
+
+func (t *testEventReporter) get() []Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.events
+}
+
+func (t *testEventReporter) last() Event {
+	events := t.get()
+
+	return events[len(events)-1]
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/ProtonMail/export-tool/internal/apiclient"
 	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/task"
 	"github.com/ProtonMail/export-tool/internal/utils"
 	"github.com/ProtonMail/gluon/async"
 	"github.com/ProtonMail/go-proton-api"
@@ -37,6 +38,7 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Initial number of parallel workers of the export stages, see ConcurrencyController.
 const NumParallelDownloads = 10
 const NumParallelBuilders = 4
 const NumParallelWriters = 4
@@ -51,8 +53,52 @@ const MaxBuildMemMB = 512 * MB
 // <email>
 //  |- mail_yyyy_mm_dd_hh:mm:ss
 //      |- labels.json
+//      |- account.json
 //      |- msg-id.eml
 //      |- msg-id.meta.json
+//      |- manifest.json
+//
+// With ExportLayoutLabels the messages are instead stored in the folder they belong to and named after their date
+// and subject, e.g. Inbox/2024-01-31 143005 Meeting notes (1a2b3c4d).eml, and linked in the folders of their labels:
+// <email>
+//  |- mail_yyyy_mm_dd_hh:mm:ss
+//      |- labels.json
+//      |- account.json
+//      |- Inbox
+//      |   |- <date> <subject> (<hash>).eml
+//      |   |- <date> <subject> (<hash>).metadata.json
+//      |- Folders/<folder>/...
+//      |- Labels/<label>/<date> <subject> (<hash>).eml
+//      |- manifest.json
+//
+// When the export is encrypted, every file except the checkpoint and the manifest is a binary OpenPGP message.
+// When the export is archived, the same tree is stored in a mail_yyyy_mm_dd_hh:mm:ss.tar.zst or .zip file.
+
+var ErrNoResumableExport = errors.New("no resumable export found")
+var ErrUnsupportedExportFormat = errors.New("export format is not supported in this mode")
+
+// ExportFormat selects how successfully built messages are stored.
+type ExportFormat int
+
+const (
+	// ExportFormatEML stores one .eml and one .metadata.json file per message.
+	ExportFormatEML ExportFormat = iota
+	// ExportFormatMbox appends messages to one mbox file per folder and label.
+	ExportFormatMbox
+	// ExportFormatMaildir stores messages in a Maildir++ tree with one folder per folder and label.
+	ExportFormatMaildir
+)
+
+// ExportLayout selects where the files of ExportFormatEML exports are stored.
+type ExportLayout int
+
+const (
+	// ExportLayoutFlat stores the files of every message at the root of the export, named after the message ID.
+	ExportLayoutFlat ExportLayout = iota
+	// ExportLayoutLabels stores the files of every message in the folder of its folder label, named after its date and
+	// subject. The message is added to the folders of its labels as a hard link, its ID is kept in its metadata file.
+	ExportLayoutLabels
+)
 
 type ExportTask struct {
 	ctx             context.Context
@@ -63,6 +109,21 @@ type ExportTask struct {
 	session         *session.Session
 	log             *logrus.Entry
 	cancelledByUser bool
+	resume          bool
+	incremental     *IncrementalMetadataFileChecker
+	format          ExportFormat
+	layout          ExportLayout
+	filter          ExportFilter
+	encrypter       utils.FileEncrypter
+	archiveFormat   ArchiveFormat
+	fileWriter      utils.FileWriter
+	manifest        *manifestFileWriter
+	concurrency     ConcurrencyOptions
+	events          EventReporter
+	reportPath      string
+	writtenReport   string
+	errorBudget     int
+	failedCount     int
 }
 
 func NewExportTask(
@@ -70,21 +131,75 @@ func NewExportTask(
 	exportPath string,
 	session *session.Session,
 ) *ExportTask {
-	exportPath = filepath.Join(exportPath, generateUniqueExportDir())
+	return newExportTask(ctx, filepath.Join(exportPath, generateUniqueExportDir()), session)
+}
+
+// NewResumeExportTask re-opens a previous export so that it can be completed. The given path can either be
+// the export directory itself or its parent directory, in which case the most recent export is picked.
+// Messages that were fully written by the previous run are not downloaded again.
+func NewResumeExportTask(
+	ctx context.Context,
+	exportPath string,
+	session *session.Session,
+) (*ExportTask, error) {
+	exportDir, err := findResumableExportDir(exportPath)
+	if err != nil {
+		return nil, err
+	}
 
+	task := newExportTask(ctx, exportDir, session)
+	task.resume = true
+
+	return task, nil
+}
+
+// NewIncrementalExportTask creates a new export generation in the given directory which only contains the messages that are
+// new or have changed since the most recent generation found in the same directory. If no previous generation is found a full
+// export is performed, which serves as the base of the chain.
+func NewIncrementalExportTask(
+	ctx context.Context,
+	exportPath string,
+	session *session.Session,
+) (*ExportTask, error) {
+	previousGeneration, previous, err := findLatestCheckpoint(exportPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous checkpoint: %w", err)
+	}
+
+	generation := generateUniqueExportDir()
+	if generation <= previousGeneration {
+		return nil, fmt.Errorf("export generation '%v' already exists", generation)
+	}
+
+	task := newExportTask(ctx, filepath.Join(exportPath, generation), session)
+	task.incremental = NewIncrementalMetadataFileChecker(previousGeneration, previous, generation)
+
+	if previous != nil {
+		task.log.WithFields(logrus.Fields{
+			"previousGeneration": previousGeneration,
+			"previousMessages":   len(previous.Messages),
+		}).Info("Found previous export generation")
+	}
+
+	return task, nil
+}
+
+func newExportTask(ctx context.Context, exportDir string, session *session.Session) *ExportTask {
 	// Tmp dir needs to be next to export path to as os.rename doesn't work if export path is on a different volume.
-	tmpDir := filepath.Join(exportPath, "temp")
+	tmpDir := filepath.Join(exportDir, "temp")
 
 	ctx, cancel := context.WithCancel(ctx)
 
 	return &ExportTask{
-		ctx:       ctx,
-		ctxCancel: cancel,
-		group:     async.NewGroup(ctx, session.GetPanicHandler()),
-		tmpDir:    tmpDir,
-		exportDir: exportPath,
-		session:   session,
-		log:       logrus.WithField("export", "mail").WithField("userID", session.GetUser().ID),
+		ctx:        ctx,
+		ctxCancel:  cancel,
+		group:      async.NewGroup(ctx, session.GetPanicHandler()),
+		tmpDir:     tmpDir,
+		exportDir:  exportDir,
+		session:    session,
+		log:        logrus.WithField("export", "mail").WithField("userID", session.GetUser().ID),
+		fileWriter: &utils.DiskFileWriter{},
+		events:     NullEventReporter{},
 	}
 }
 
@@ -113,18 +228,55 @@ func (e *ExportTask) GetRequiredDiskSpaceEstimate(_ context.Context) (uint64, er
 
 func (e *ExportTask) Run(ctx context.Context, reporter Reporter) error {
 	defer e.log.Info("Finished")
-	e.log.WithFields(logrus.Fields{"tmp-dir": e.tmpDir, "export-dir": e.exportDir}).Info("Starting")
+	e.log.WithFields(logrus.Fields{"tmp-dir": e.tmpDir, "export-dir": e.exportDir, "resume": e.resume}).Info("Starting")
 
-	e.log.Debug("Preparing export dir")
+	report := &FailureReport{}
+	e.collectFailures(report)
 
-	if err := os.MkdirAll(e.exportDir, 0o700); err != nil {
-		return fmt.Errorf("failed to create export directory: %w", err)
-	}
+	defer func() {
+		e.writtenReport = writeFailureReport(report, e.getFailureReportPath())
+	}()
 
-	if err := os.MkdirAll(e.tmpDir, 0o700); err != nil {
-		return fmt.Errorf("failed to create export tmp directory: %w", err)
+	ctx = withRetryEvents(ctx, e.events)
+	reporter = withProgressEvents(reporter, e.events)
+
+	var archive *archiveFileWriter
+
+	// Large attachments are downloaded in the tmp dir, which doesn't exist when exporting to an archive.
+	streamingDir := e.tmpDir
+
+	if e.archiveFormat != ArchiveFormatNone {
+		e.log.WithField("archive", e.GetExportPath()).Debug("Creating export archive")
+
+		var err error
+		if archive, err = newArchiveFileWriter(e.GetExportPath(), filepath.Dir(e.exportDir), e.archiveFormat, e.encrypter); err != nil {
+			return err
+		}
+
+		// The archive is only kept if the export succeeds.
+		defer archive.abort()
+
+		if streamingDir, err = os.MkdirTemp(filepath.Dir(e.exportDir), "export-tool-temp-*"); err != nil {
+			return fmt.Errorf("failed to create export tmp directory: %w", err)
+		}
+
+		defer func() {
+			if err := os.RemoveAll(streamingDir); err != nil {
+				e.log.WithError(err).Error("Failed to remove temp directory")
+			}
+		}()
+
+		e.manifest = newManifestFileWriter(archive, e.exportDir)
+	} else {
+		if err := e.prepareExportDir(); err != nil {
+			return err
+		}
+
+		e.manifest = newManifestFileWriter(&utils.DiskFileWriter{Encrypter: e.encrypter}, e.exportDir)
 	}
 
+	e.fileWriter = e.manifest
+
 	reporter.OnProgress(0)
 
 	client := e.session.GetClient()
@@ -137,20 +289,6 @@ func (e *ExportTask) Run(ctx context.Context, reporter Reporter) error {
 		toMB(approximateDiskUsage(user.ProductUsedSpace.Mail)),
 	)
 
-	salts := e.session.GetUserSalts()
-
-	saltedKeyPass, err := salts.SaltForKey(e.session.GetMailboxPassword(), user.Keys.Primary().ID)
-	if err != nil {
-		return fmt.Errorf("failed to salt key password: %w", err)
-	}
-
-	e.log.Debug("Unlocking decryption key")
-	if userKR, err := user.Keys.Unlock(saltedKeyPass, nil); err != nil {
-		return fmt.Errorf("failed to unlock user keys: %w", err)
-	} else if userKR.CountDecryptionEntities() == 0 {
-		return fmt.Errorf("failed to unlock user keys")
-	}
-
 	e.log.Debug("Getting addresses")
 	// Get User addresses
 	addresses, err := client.GetAddresses(ctx)
@@ -158,35 +296,62 @@ func (e *ExportTask) Run(ctx context.Context, reporter Reporter) error {
 		return fmt.Errorf("failed to get user addresses: %w", err)
 	}
 
-	e.log.Debug("Unlocking address keys")
-	keyRing, err := apiclient.NewUnlockedKeyRing(user, addresses, saltedKeyPass)
+	e.log.Debug("Unlocking keys")
+	keyRing, err := task.UnlockKeyRing(e.session, addresses)
 	if err != nil {
-		return fmt.Errorf("failed to unlock user keyring:%w", err)
+		return err
 	}
 	defer keyRing.Close()
 
 	// Create required folders
-	if err := e.WriteLabelMetadata(ctx, e.tmpDir, e.exportDir); err != nil {
+	labels, err := e.WriteLabelMetadata(ctx, e.tmpDir, e.exportDir)
+	if err != nil {
 		return err
 	}
 
+	// The messages are exported even if the settings can't be.
+	if err := e.WriteAccountMetadata(ctx, e.tmpDir, e.exportDir, addresses); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+
+		e.log.WithError(err).Warn("Failed to write account metadata, continuing without it")
+	}
+
+	var filter *messageFilter
+
+	countLabelID := proton.AllMailLabel
+
+	if !e.filter.IsEmpty() {
+		if filter, err = e.filter.resolve(labels, addresses); err != nil {
+			return err
+		}
+
+		if labelID := filter.apiFilter().LabelID; len(labelID) != 0 {
+			countLabelID = labelID
+		}
+
+		e.log.WithField("filter", fmt.Sprintf("%+v", *filter)).Info("Filtering exported messages")
+	}
+
 	msgCountPerLabel, err := client.GetGroupedMessageCount(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get message count: %w", err)
 	}
 
 	var totalMessageCount uint64
-	var foundAllMailLabel bool
+	var foundLabelCount bool
 
 	for _, c := range msgCountPerLabel {
-		if c.LabelID == proton.AllMailLabel {
+		if c.LabelID == countLabelID {
 			totalMessageCount = uint64(c.Total) //nolint:gosec // we won't overflow.
-			foundAllMailLabel = true
+			foundLabelCount = true
 			break
 		}
 	}
 
-	if !foundAllMailLabel {
+	// Empty labels may be missing from the counts.
+	if !foundLabelCount && countLabelID == proton.AllMailLabel {
 		return fmt.Errorf("failed to determine total message count")
 	}
 
@@ -194,59 +359,92 @@ func (e *ExportTask) Run(ctx context.Context, reporter Reporter) error {
 
 	reporter.SetMessageTotal(totalMessageCount)
 
-	totalMemory := memory.TotalMemory()
+	concurrency := NewConcurrencyController(e.concurrency, memory.TotalMemory(), e.log)
 
-	var (
-		buildMemMB    uint64
-		downloadMemMb uint64
-	)
-
-	if totalMemory >= 4096*MB {
-		buildMemMB = MaxBuildMemMB
-		downloadMemMb = MaxDownloadMemMB
-	} else {
-		buildMemMB = MinBuildMemMB
-		downloadMemMb = MinDownloadMemMB
+	pipeline := exportPipeline{
+		client:       client,
+		keyRing:      keyRing,
+		labels:       labels,
+		streamingDir: streamingDir,
+		concurrency:  concurrency,
+		userID:       user.ID,
+		reporter:     reporter,
 	}
 
-	// Build stages
-	metaStage := NewMetadataStage(client, e.log, MetadataPageSize, NumParallelDownloads)
-	downloadStage := NewDownloadStage(client, NumParallelDownloads, e.log, downloadMemMb, e.session.GetPanicHandler())
-	buildStage := NewBuildStage(NumParallelBuilders, e.log, buildMemMB, e.session.GetPanicHandler(), e.session.GetReporter(), user.ID)
-	writeStage := NewWriteStage(e.tmpDir, e.exportDir, NumParallelWriters, e.log, reporter, e.session.GetPanicHandler())
+	metaStage := newExportMetadataStage(client, e.log)
+	metaStage.SetFilter(filter)
 
 	e.log.Debug("Starting message download")
-	errReporter := &exportErrReporter{
-		export: e,
-		lock:   sync.Mutex{},
-		errors: nil,
+	errReporter := newExportErrReporter(e, e.errorBudget)
+
+	var fileChecker MetadataFileChecker
+	if e.resume {
+		fileChecker = NewFileMetadataFileChecker(e.exportDir)
+	} else if e.incremental != nil {
+		fileChecker = e.incremental
+	} else {
+		fileChecker = &alwaysMissingMetadataFileChecker{}
 	}
 
 	// start pipeline.
-	e.group.Once(func(ctx context.Context) {
-		// To enable resume features use re-enable this line and delete the one below.
-		// metaStage.Run(ctx, errReporter, NewFileMetadataFileChecker(e.exportDir), reporter)
-		metaStage.Run(ctx, errReporter, &alwaysMissingMetadataFileChecker{}, reporter)
-	})
-	e.group.Once(func(ctx context.Context) {
-		downloadStage.Run(ctx, metaStage.outputCh, errReporter)
-	})
-	e.group.Once(func(ctx context.Context) {
-		buildStage.Run(ctx, downloadStage.outputCh, keyRing, errReporter)
-	})
-	e.group.Once(func(ctx context.Context) {
-		writeStage.Run(ctx, buildStage.outputCh, errReporter)
+	e.runStage("metadata", func(ctx context.Context) {
+		metaStage.Run(ctx, errReporter, fileChecker, reporter)
 	})
 
-	// wait for downloads to finish.
-	e.group.WaitToFinish()
+	writtenCount := e.runPipeline(metaStage.outputCh, pipeline, errReporter)
 
 	e.log.Debug("Message download finished")
 
 	// collect errors.
 	exportError := errReporter.getErrors()
+
+	// The messages which failed are given another chance once the rest of the mailbox is exported, those which still
+	// fail are left out of the export.
+	if failed := errReporter.getFailedMessages(); len(exportError) == 0 && len(failed) != 0 && e.ctx.Err() == nil {
+		e.log.WithField("count", len(failed)).Info("Retrying the messages which failed")
+
+		retryErrReporter := newExportErrReporter(e, len(failed))
+
+		input := make(chan []proton.MessageMetadata, 1)
+		input <- failed
+		close(input)
+
+		writtenCount += e.runPipeline(input, pipeline, retryErrReporter)
+
+		exportError = retryErrReporter.getErrors()
+		e.failedCount = len(retryErrReporter.getFailedMessages())
+
+		// The messages given up on are done with as far as the progress is concerned.
+		reporter.OnProgress(e.failedCount)
+
+		if e.failedCount != 0 {
+			e.log.WithField("count", e.failedCount).Warn("Messages were left out of the export")
+		}
+	}
+
 	if len(exportError) == 0 {
-		return e.ctx.Err()
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+
+		if e.incremental != nil {
+			e.log.WithField("newMessages", e.incremental.GetNewMessageCount()).Info("Writing export checkpoint")
+			if err := e.incremental.WriteCheckpoint(e.tmpDir, e.exportDir); err != nil {
+				return fmt.Errorf("failed to write export checkpoint: %w", err)
+			}
+		}
+
+		if err := e.writeManifest(archive, writtenCount); err != nil {
+			return fmt.Errorf("failed to write export manifest: %w", err)
+		}
+
+		if archive != nil {
+			if err := archive.finish(); err != nil {
+				return err
+			}
+		}
+
+		return nil
 	}
 
 	e.log.Error("Export task ran into the following errors")
@@ -257,43 +455,317 @@ func (e *ExportTask) Run(ctx context.Context, reporter Reporter) error {
 	return exportError[0]
 }
 
+// exportPipeline holds what the download, build and write stages need, so that they can be run more than once.
+type exportPipeline struct {
+	client       apiclient.Client
+	keyRing      *apiclient.UnlockedKeyRing
+	labels       []proton.Label
+	streamingDir string
+	concurrency  *ConcurrencyController
+	userID       string
+	reporter     Reporter
+}
+
+// runPipeline downloads, builds and writes the messages of input, waits for the task group to finish and returns how
+// many messages were written.
+func (e *ExportTask) runPipeline(input <-chan []proton.MessageMetadata, pipeline exportPipeline, errReporter *exportErrReporter) int64 {
+	concurrency := pipeline.concurrency
+
+	downloadStage := NewDownloadStage(pipeline.client, NumParallelDownloads, e.log, concurrency.downloadMem, e.session.GetPanicHandler())
+	downloadStage.SetWorkerLimit(concurrency.downloads)
+	buildStage := NewBuildStage(NumParallelBuilders, e.log, concurrency.buildMem, e.session.GetPanicHandler(), e.session.GetReporter(), pipeline.userID)
+	buildStage.SetWorkerLimit(concurrency.builders)
+	writeStage := NewWriteStage(e.tmpDir, e.exportDir, NumParallelWriters, e.log, pipeline.reporter, e.session.GetPanicHandler())
+	writeStage.SetWorkerLimit(concurrency.writers)
+	writeStage.SetFileWriter(e.fileWriter)
+	downloadStage.SetEventReporter(e.events)
+	buildStage.SetEventReporter(e.events)
+	writeStage.SetEventReporter(e.events)
+	downloadStage.SetMessageErrorReporter(errReporter)
+	buildStage.SetMessageErrorReporter(errReporter)
+	writeStage.SetMessageErrorReporter(errReporter)
+
+	if e.layout == ExportLayoutLabels {
+		writeStage.SetLabelLayout(pipeline.labels)
+	}
+
+	// Mbox and Maildir messages are stored from memory, their attachments are never streamed.
+	switch e.format {
+	case ExportFormatMbox:
+		buildStage.SetBuiltMessageWriterFactory(NewMboxStore(e.exportDir, pipeline.labels).NewMessageWriter)
+	case ExportFormatMaildir:
+		buildStage.SetBuiltMessageWriterFactory(NewMaildirStore(e.exportDir, pipeline.labels).NewMessageWriter)
+	case ExportFormatEML:
+		downloadStage.SetStreamingDir(pipeline.streamingDir)
+		buildStage.SetStreamingDir(pipeline.streamingDir)
+	}
+
+	e.runStage("download", func(ctx context.Context) {
+		// The downloads are adjusted from the outcome of their requests.
+		downloadStage.Run(apiclient.WithRequestObserver(ctx, concurrency.downloads), input, errReporter)
+	})
+	e.runStage("build", func(ctx context.Context) {
+		buildStage.Run(ctx, downloadStage.outputCh, pipeline.keyRing, errReporter)
+	})
+	e.runStage("write", func(ctx context.Context) {
+		writeStage.Run(ctx, buildStage.outputCh, errReporter)
+	})
+
+	// wait for downloads to finish.
+	e.group.WaitToFinish()
+
+	return writeStage.getWrittenCount()
+}
+
+// runStage runs a stage of the pipeline in the task group and reports when it starts and finishes.
+func (e *ExportTask) runStage(name string, run func(ctx context.Context)) {
+	e.group.Once(func(ctx context.Context) {
+		reportStageStarted(e.events, name)
+		defer reportStageFinished(e.events, name)
+
+		run(withRetryEvents(ctx, e.events))
+	})
+}
+
+func (e *ExportTask) prepareExportDir() error {
+	e.log.Debug("Preparing export dir")
+
+	if err := os.MkdirAll(e.exportDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	if e.resume {
+		// Files left over in the temp dir belong to writes that were interrupted, they are never complete.
+		if err := os.RemoveAll(e.tmpDir); err != nil {
+			return fmt.Errorf("failed to clean export tmp directory: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(e.tmpDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create export tmp directory: %w", err)
+	}
+
+	return nil
+}
+
+// newExportMetadataStage returns the metadata stage of the export pipeline. Each chunk of metadata is downloaded in
+// parallel, chunks as large as the most parallel downloads let the download limit grow past its initial value.
+func newExportMetadataStage(client apiclient.Client, log *logrus.Entry) *MetadataStage {
+	return NewMetadataStage(client, log, MetadataPageSize, MaxParallelDownloads)
+}
+
 const LabelMetadataVersion = 1
 
-func (e *ExportTask) WriteLabelMetadata(ctx context.Context, tmpDir, exportPath string) error {
+// WriteLabelMetadata writes the user's folders and labels to the label file and returns all the labels, including the system ones.
+func (e *ExportTask) WriteLabelMetadata(ctx context.Context, tmpDir, exportPath string) ([]proton.Label, error) {
 	e.log.Debug("Writing root label metadata")
 	apiLabels, err := e.session.GetClient().GetLabels(ctx, proton.LabelTypeSystem, proton.LabelTypeFolder, proton.LabelTypeLabel)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve labels: %w", err)
+		return nil, fmt.Errorf("failed to retrieve labels: %w", err)
 	}
 
-	apiLabels = xslices.Filter(apiLabels, nonSystemLabel)
-
-	labelData, err := utils.GenerateVersionedJSON(LabelMetadataVersion, apiLabels)
+	labelData, err := utils.GenerateVersionedJSON(LabelMetadataVersion, xslices.Filter(apiLabels, nonSystemLabel))
 	if err != nil {
-		return fmt.Errorf("failed to json encode labels: %w", err)
+		return nil, fmt.Errorf("failed to json encode labels: %w", err)
 	}
 
 	labelFile := filepath.Join(exportPath, getLabelFileName())
 
-	return utils.WriteFileSafe(tmpDir, labelFile, labelData, &utils.Sha256IntegrityChecker{})
+	if err := e.fileWriter.WriteFile(tmpDir, labelFile, labelData, &utils.Sha256IntegrityChecker{}); err != nil {
+		return nil, err
+	}
+
+	return apiLabels, nil
 }
 
+// GetExportPath returns the export directory, or the archive file when the export is stored in an archive.
 func (e *ExportTask) GetExportPath() string {
-	return e.exportDir
+	return e.exportDir + e.archiveFormat.extension()
 }
 
 func (e *ExportTask) GetOperationCancelledByUser() bool {
 	return e.cancelledByUser
 }
 
+// SetFormat selects the output format of the export. Must be called before Run. Resumed and incremental exports
+// rely on per message files and only support ExportFormatEML.
+func (e *ExportTask) SetFormat(format ExportFormat) error {
+	if format != ExportFormatEML && (e.resume || e.incremental != nil) {
+		return ErrUnsupportedExportFormat
+	}
+
+	if format == ExportFormatMbox && e.encrypter != nil {
+		return ErrUnsupportedExportFormat
+	}
+
+	if format != ExportFormatEML && (e.archiveFormat != ArchiveFormatNone || e.layout != ExportLayoutFlat) {
+		return ErrUnsupportedExportFormat
+	}
+
+	e.format = format
+
+	return nil
+}
+
+// SetEncrypter makes the export encrypt every file it writes, so that no decrypted mail is stored on disk. Must be called
+// before Run. The checkpoint of incremental exports is not encrypted as it is needed to create the next generation, it only
+// holds message IDs and fingerprints. Mbox files are appended to and can't be encrypted, and the label layout can't be
+// encrypted as its folders are named after the labels. The subjects are left out of the events and the failure report.
+func (e *ExportTask) SetEncrypter(encrypter utils.FileEncrypter) error {
+	if encrypter != nil && (e.format == ExportFormatMbox || e.layout != ExportLayoutFlat) {
+		return ErrUnsupportedExportFormat
+	}
+
+	e.encrypter = encrypter
+
+	return nil
+}
+
+// SetArchiveFormat stores the export in a single archive file instead of a directory, see GetExportPath. Must be called
+// before Run. Archives can't be resumed or extended and only support ExportFormatEML.
+func (e *ExportTask) SetArchiveFormat(format ArchiveFormat) error {
+	if format != ArchiveFormatNone && (e.resume || e.incremental != nil || e.format != ExportFormatEML || e.layout != ExportLayoutFlat) {
+		return ErrUnsupportedExportFormat
+	}
+
+	e.archiveFormat = format
+
+	return nil
+}
+
+// SetLayout selects where the files of the messages are stored, see ExportLayout. Must be called before Run. The label
+// layout relies on hard links and only supports ExportFormatEML exports which are neither resumed, incremental,
+// archived nor encrypted, as the names of its folders would reveal the labels.
+func (e *ExportTask) SetLayout(layout ExportLayout) error {
+	if layout != ExportLayoutFlat && (e.resume || e.incremental != nil || e.format != ExportFormatEML || e.archiveFormat != ArchiveFormatNone || e.encrypter != nil) {
+		return ErrUnsupportedExportFormat
+	}
+
+	e.layout = layout
+
+	return nil
+}
+
+// SetConcurrencyOptions overrides the parallelism and memory of the export, which are otherwise adjusted automatically,
+// see ConcurrencyController. Must be called before Run.
+func (e *ExportTask) SetConcurrencyOptions(options ConcurrencyOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+
+	e.concurrency = options
+
+	return nil
+}
+
+// SetEventReporter makes the export report its stages, the outcome of each message, the retried requests and its
+// progress as events. Must be called before Run.
+func (e *ExportTask) SetEventReporter(events EventReporter) {
+	e.events = events
+}
+
+// SetFailureReportPath sets where the report of the messages which failed to export, or were exported in parts, is
+// written. The report is CSV if the path ends with .csv and JSON otherwise. By default, it is written as JSON next to
+// the export. Must be called before Run.
+func (e *ExportTask) SetFailureReportPath(path string) {
+	e.reportPath = path
+}
+
+// GetFailureReportPath returns the path of the failure report written by Run, or an empty string if no message failed.
+func (e *ExportTask) GetFailureReportPath() string {
+	return e.writtenReport
+}
+
+func (e *ExportTask) getFailureReportPath() string {
+	if len(e.reportPath) != 0 {
+		return e.reportPath
+	}
+
+	return e.exportDir + "_failures.json"
+}
+
+// collectFailures makes the events of the export collected in report. The subjects of the messages are left out of
+// both the events and the report of encrypted exports.
+func (e *ExportTask) collectFailures(report *FailureReport) {
+	e.events = withFailureReport(e.events, report)
+
+	if e.encrypter != nil {
+		e.events = withoutSubjects(e.events)
+	}
+}
+
+// SetErrorBudget lets the export carry on when up to budget messages fail to download, build or write, rather than
+// stopping on the first failure. The messages which failed are retried once the rest of the mailbox is exported, and the
+// export completes without those which fail again, see GetFailedMessageCount. The export stops if more messages fail.
+// A budget of 0, the default, stops the export on the first failure. Must be called before Run.
+func (e *ExportTask) SetErrorBudget(budget int) error {
+	if budget < 0 {
+		return fmt.Errorf("invalid error budget %v", budget)
+	}
+
+	e.errorBudget = budget
+
+	return nil
+}
+
+// GetFailedMessageCount returns the number of messages left out of an export which completed with an error budget.
+func (e *ExportTask) GetFailedMessageCount() int {
+	return e.failedCount
+}
+
+// SetFilter restricts the messages included in the export. Must be called before Run. Incremental exports can't be
+// filtered, the messages left out would be missing from the checkpoint and from the generations restored after it.
+func (e *ExportTask) SetFilter(filter ExportFilter) error {
+	if !filter.IsEmpty() && e.incremental != nil {
+		return ErrUnsupportedExportFormat
+	}
+
+	e.filter = filter
+
+	return nil
+}
+
+func (e *ExportTask) IsResuming() bool {
+	return e.resume
+}
+
+func (e *ExportTask) IsIncremental() bool {
+	return e.incremental != nil
+}
+
 func getLabelFileName() string {
 	return "labels.json"
 }
 
+// exportErrReporter stops the export on the first error, unless it is the failure of a message which fits in the error
+// budget. Those messages are recorded so that they can be retried.
 type exportErrReporter struct {
-	export *ExportTask
-	lock   sync.Mutex
-	errors []error
+	export      *ExportTask
+	lock        sync.Mutex
+	errors      []error
+	errorBudget int
+	failed      []proton.MessageMetadata
+}
+
+func newExportErrReporter(export *ExportTask, errorBudget int) *exportErrReporter {
+	return &exportErrReporter{
+		export:      export,
+		errorBudget: errorBudget,
+	}
+}
+
+func (e *exportErrReporter) ReportMessageError(metadata proton.MessageMetadata, err error) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if len(e.failed) >= e.errorBudget {
+		return false
+	}
+
+	e.export.log.WithError(err).WithField("msgID", metadata.ID).Warn("Skipping message which failed")
+	e.failed = append(e.failed, metadata)
+
+	return true
 }
 
 func (e *exportErrReporter) ReportStageError(err error) {
@@ -314,6 +786,13 @@ func (e *exportErrReporter) getErrors() []error {
 	return e.errors
 }
 
+func (e *exportErrReporter) getFailedMessages() []proton.MessageMetadata {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.failed
+}
+
 func approximateDiskUsage(v uint64) uint64 {
 	// add another 30% of to current usage estimate due to variance in the decrypted message sizes and metadata.
 	return uint64(math.Ceil(float64(v) * 1.3))
@@ -327,3 +806,47 @@ func generateUniqueExportDir() string {
 	const format = "20060102_150405"
 	return "mail_" + time.Now().Format(format)
 }
+
+// findResumableExportDir returns path if it is an export directory, otherwise the most recent export
+// directory it contains.
+func findResumableExportDir(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	if exists, err := dirExists(absPath); err != nil {
+		return "", err
+	} else if !exists {
+		return "", fmt.Errorf("%w: '%v' does not exist", ErrNoResumableExport, absPath)
+	}
+
+	if mailFolderRegExp.MatchString(filepath.Base(absPath)) {
+		return absPath, nil
+	}
+
+	if exists, err := fileExists(filepath.Join(absPath, getLabelFileName())); err != nil {
+		return "", err
+	} else if exists {
+		return absPath, nil
+	}
+
+	entries, err := os.ReadDir(absPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Export directory names embed their creation time, the last one in lexical order is the most recent.
+	var latest string
+	for _, entry := range entries {
+		if entry.IsDir() && mailFolderRegExp.MatchString(entry.Name()) && entry.Name() > latest {
+			latest = entry.Name()
+		}
+	}
+
+	if len(latest) == 0 {
+		return "", fmt.Errorf("%w in '%v'", ErrNoResumableExport, absPath)
+	}
+
+	return filepath.Join(absPath, latest), nil
+}
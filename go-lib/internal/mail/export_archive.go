@@ -0,0 +1,326 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveFormat selects whether an export is stored in a single archive file instead of a directory.
+type ArchiveFormat int
+
+const (
+	// ArchiveFormatNone stores the export in a directory.
+	ArchiveFormatNone ArchiveFormat = iota
+	// ArchiveFormatTarZstd stores the export in a zstd compressed tar archive.
+	ArchiveFormatTarZstd
+	// ArchiveFormatZip stores the export in a zip archive.
+	ArchiveFormatZip
+)
+
+const (
+	tarZstdExtension = ".tar.zst"
+	zipExtension     = ".zip"
+)
+
+func (f ArchiveFormat) extension() string {
+	switch f {
+	case ArchiveFormatTarZstd:
+		return tarZstdExtension
+	case ArchiveFormatZip:
+		return zipExtension
+	case ArchiveFormatNone:
+	}
+
+	return ""
+}
+
+// archiveFormatFromPath returns the format of the archive at path, based on its extension.
+func archiveFormatFromPath(path string) (ArchiveFormat, bool) {
+	lowerPath := strings.ToLower(path)
+
+	switch {
+	case strings.HasSuffix(lowerPath, tarZstdExtension):
+		return ArchiveFormatTarZstd, true
+	case strings.HasSuffix(lowerPath, zipExtension):
+		return ArchiveFormatZip, true
+	default:
+		return ArchiveFormatNone, false
+	}
+}
+
+// archiveFileWriter stores the files of an export in a single archive. The entries are named after their path relative
+// to the parent of the export directory, so that extracting the archive gives the same tree as a regular export. The
+// archive is written to a '.part' file which is renamed once it is complete.
+//
+// The integrity checkers are initialized with the stored data so that its hash can be recorded, but there is no file to
+// check: the entries are covered by the checksums of the archive, CRC-32 for zip and the frame checksum for zstd. The
+// moved files are checked against their integrity checker before they are stored, see MoveFile.
+type archiveFileWriter struct {
+	lock      sync.Mutex
+	path      string
+	rootDir   string
+	format    ArchiveFormat
+	encrypter utils.FileEncrypter
+	file      *os.File
+	encoder   *zstd.Encoder
+	tar       *tar.Writer
+	zip       *zip.Writer
+}
+
+func newArchiveFileWriter(path, rootDir string, format ArchiveFormat, encrypter utils.FileEncrypter) (*archiveFileWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	file, err := os.OpenFile(getArchivePartPath(path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	writer := &archiveFileWriter{
+		path:      path,
+		rootDir:   rootDir,
+		format:    format,
+		encrypter: encrypter,
+		file:      file,
+	}
+
+	switch format {
+	case ArchiveFormatTarZstd:
+		encoder, err := zstd.NewWriter(file)
+		if err != nil {
+			writer.abort()
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+
+		writer.encoder = encoder
+		writer.tar = tar.NewWriter(encoder)
+	case ArchiveFormatZip:
+		writer.zip = zip.NewWriter(file)
+	case ArchiveFormatNone:
+		writer.abort()
+		return nil, errors.New("no archive format selected")
+	}
+
+	return writer, nil
+}
+
+func getArchivePartPath(path string) string {
+	return path + ".part"
+}
+
+// MkdirAll does nothing, directories are implied by the entry names.
+func (a *archiveFileWriter) MkdirAll(_ string) error {
+	return nil
+}
+
+func (a *archiveFileWriter) WriteFile(_, dstPath string, data []byte, integrityChecker utils.IntegrityChecker) error {
+	if a.encrypter != nil {
+		encrypted, err := a.encrypter.EncryptFile(data)
+		if err != nil {
+			return err
+		}
+
+		data = encrypted
+	}
+
+	if integrityChecker != nil {
+		integrityChecker.Initialize(data)
+	}
+
+	return a.writeEntry(dstPath, data, a.encrypter != nil)
+}
+
+// MoveFile stores the file at srcPath as an entry without reading it into memory and removes it, see utils.MoveFile. The
+// file of an encrypted archive is first encrypted next to it, as the size of the tar entries must be known before their
+// data is written.
+func (a *archiveFileWriter) MoveFile(srcPath, dstPath string, integrityChecker utils.IntegrityChecker) error {
+	if a.encrypter != nil {
+		encryptedPath := srcPath + ".pgp"
+
+		if err := (&utils.DiskFileWriter{Encrypter: a.encrypter}).MoveFile(srcPath, encryptedPath, integrityChecker); err != nil {
+			return err
+		}
+
+		srcPath = encryptedPath
+	} else if integrityChecker != nil {
+		if err := integrityChecker.Check(srcPath); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(srcPath) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to open '%v': %w", srcPath, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to stat '%v': %w", srcPath, err)
+	}
+
+	if err := a.writeEntryFrom(dstPath, info.Size(), file, a.encrypter != nil); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close '%v': %w", srcPath, err)
+	}
+
+	return os.Remove(srcPath)
+}
+
+// writePlainFile stores a file without encrypting it, even if the archive is encrypted.
+func (a *archiveFileWriter) writePlainFile(dstPath string, data []byte) error {
+	return a.writeEntry(dstPath, data, false)
+}
+
+func (a *archiveFileWriter) writeEntry(dstPath string, data []byte, encrypted bool) error {
+	return a.writeEntryFrom(dstPath, int64(len(data)), bytes.NewReader(data), encrypted)
+}
+
+// writeEntryFrom stores the size bytes read from r as the entry of dstPath.
+func (a *archiveFileWriter) writeEntryFrom(dstPath string, size int64, r io.Reader, encrypted bool) error {
+	name, err := filepath.Rel(a.rootDir, dstPath)
+	if err != nil {
+		return fmt.Errorf("failed to get archive entry name: %w", err)
+	}
+
+	name = filepath.ToSlash(name)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.file == nil {
+		return errors.New("archive is closed")
+	}
+
+	if a.tar != nil {
+		if err := a.tar.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0o600,
+			ModTime:  time.Now(),
+		}); err != nil {
+			return fmt.Errorf("failed to write archive entry '%v': %w", name, err)
+		}
+
+		if _, err := io.CopyN(a.tar, r, size); err != nil {
+			return fmt.Errorf("failed to write archive entry '%v': %w", name, err)
+		}
+
+		return nil
+	}
+
+	method := zip.Deflate
+	if encrypted {
+		// Encrypted data does not compress.
+		method = zip.Store
+	}
+
+	entry, err := a.zip.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to write archive entry '%v': %w", name, err)
+	}
+
+	if _, err := io.Copy(entry, r); err != nil {
+		return fmt.Errorf("failed to write archive entry '%v': %w", name, err)
+	}
+
+	return nil
+}
+
+// finish completes the archive and moves it to its final location.
+func (a *archiveFileWriter) finish() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err := a.closeWriters(); err != nil {
+		return fmt.Errorf("failed to complete archive: %w", err)
+	}
+
+	if err := a.file.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %w", err)
+	}
+
+	a.file = nil
+
+	if err := os.Rename(getArchivePartPath(a.path), a.path); err != nil {
+		return fmt.Errorf("failed to move archive to location: %w", err)
+	}
+
+	return nil
+}
+
+// abort deletes the incomplete archive. It does nothing once the archive is finished.
+func (a *archiveFileWriter) abort() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.file == nil {
+		return
+	}
+
+	if err := a.closeWriters(); err != nil {
+		logrus.WithError(err).Warn("Failed to close incomplete archive")
+	}
+
+	if err := a.file.Close(); err != nil {
+		logrus.WithError(err).Warn("Failed to close incomplete archive")
+	}
+
+	a.file = nil
+
+	if err := os.Remove(getArchivePartPath(a.path)); err != nil {
+		logrus.WithError(err).Warn("Failed to remove incomplete archive")
+	}
+}
+
+func (a *archiveFileWriter) closeWriters() error {
+	if a.tar != nil {
+		if err := a.tar.Close(); err != nil {
+			return err
+		}
+
+		return a.encoder.Close()
+	}
+
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveFormatFromPath(t *testing.T) {
+	format, ok := archiveFormatFromPath("/backup/mail_20240101_000000.tar.zst")
+	require.True(t, ok)
+	require.Equal(t, ArchiveFormatTarZstd, format)
+
+	format, ok = archiveFormatFromPath("/backup/mail_20240101_000000.ZIP")
+	require.True(t, ok)
+	require.Equal(t, ArchiveFormatZip, format)
+
+	_, ok = archiveFormatFromPath("/backup/mail_20240101_000000")
+	require.False(t, ok)
+}
+
+func TestArchive_WriteAndRestore(t *testing.T) {
+	for _, format := range []ArchiveFormat{ArchiveFormatTarZstd, ArchiveFormatZip} {
+		format := format
+		t.Run(format.extension(), func(t *testing.T) {
+			testArchiveWriteAndRestore(t, format, nil)
+		})
+		t.Run(format.extension()+" encrypted", func(t *testing.T) {
+			testArchiveWriteAndRestore(t, format, utils.NewPGPPasswordFileCipher([]byte("secret")))
+		})
+	}
+}
+
+func testArchiveWriteAndRestore(t *testing.T, format ArchiveFormat, cipher *utils.PGPFileCipher) {
+	rootDir := t.TempDir()
+	exportDir := filepath.Join(rootDir, "mail_20240101_000000")
+	archivePath := exportDir + format.extension()
+
+	var encrypter utils.FileEncrypter
+	if cipher != nil {
+		encrypter = cipher
+	}
+
+	writer, err := newArchiveFileWriter(archivePath, rootDir, format, encrypter)
+	require.NoError(t, err)
+
+	labels, err := utils.GenerateVersionedJSON(LabelMetadataVersion, []proton.Label{{ID: "label", Name: "Label", Type: proton.LabelTypeLabel}})
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteFile("", filepath.Join(exportDir, getLabelFileName()), labels, nil))
+
+	account, err := utils.GenerateVersionedJSON(AccountMetadataVersion, AccountMetadata{MailSettings: proton.MailSettings{Signature: "Regards"}})
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteFile("", filepath.Join(exportDir, getAccountFileName()), account, nil))
+
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("msg%v", i)
+		metadata := MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: id, Time: int64(1700000000 - i)}}
+		metadataBytes, err := metadata.toBytes()
+		require.NoError(t, err)
+
+		require.NoError(t, writer.WriteFile("", filepath.Join(exportDir, getMetadataFileName(id)), metadataBytes, nil))
+
+		eml := []byte("Subject: " + id + "\r\n\r\n")
+
+		if i != 0 {
+			require.NoError(t, writer.WriteFile("", filepath.Join(exportDir, getEMLFileName(id)), eml, nil))
+			continue
+		}
+
+		// Streamed messages are moved to the archive from their temp file.
+		tmpPath := filepath.Join(t.TempDir(), "streamed.eml")
+		require.NoError(t, os.WriteFile(tmpPath, eml, 0o600))
+
+		checker := &utils.Sha256IntegrityChecker{}
+		checker.Initialize(eml)
+
+		require.NoError(t, utils.MoveFile(writer, tmpPath, filepath.Join(exportDir, getEMLFileName(id)), checker))
+		require.NoFileExists(t, tmpPath)
+		require.NoFileExists(t, tmpPath+".pgp")
+	}
+
+	// Parts of a message which could not be built are ignored.
+	require.NoError(t, writer.WriteFile("", filepath.Join(exportDir, "msg3", bodyFileName()), []byte("body"), nil))
+
+	require.NoFileExists(t, archivePath)
+	require.NoError(t, writer.finish())
+	require.FileExists(t, archivePath)
+	require.NoFileExists(t, getArchivePartPath(archivePath))
+
+	if cipher != nil {
+		_, err := newArchiveSource(context.Background(), archivePath, format, nil)
+		require.ErrorIs(t, err, utils.ErrFileEncrypted)
+	}
+
+	source, err := newArchiveSource(context.Background(), archivePath, format, cipher)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, source.Close()) }()
+
+	require.True(t, source.hasLabels())
+
+	backupLabels, err := source.getLabels()
+	require.NoError(t, err)
+	require.Len(t, backupLabels, 1)
+
+	backupAccount, err := source.getAccount()
+	require.NoError(t, err)
+	require.Equal(t, "Regards", backupAccount.Payload.MailSettings.Signature)
+
+	messageList := source.getMessageInfoList()
+	require.Len(t, messageList, 3)
+
+	source.sortMessageInfoList(messageList)
+
+	// Read in reverse order to exercise re-opening tar archives.
+	for i := len(messageList) - 1; i >= 0; i-- {
+		message, err := source.readMessage(messageList[i])
+		require.NoError(t, err)
+		require.Equal(t, "Subject: "+messageList[i].messageID+"\r\n\r\n", string(message.literal))
+		require.Equal(t, messageList[i].messageID, message.metadata.ID)
+	}
+}
+
+func TestArchive_MoveFileChecksSource(t *testing.T) {
+	rootDir := t.TempDir()
+
+	writer, err := newArchiveFileWriter(filepath.Join(rootDir, "mail_20240101_000000.zip"), rootDir, ArchiveFormatZip, nil)
+	require.NoError(t, err)
+	defer writer.abort()
+
+	var _ utils.FileMover = writer
+
+	tmpPath := filepath.Join(t.TempDir(), "streamed.eml")
+	require.NoError(t, os.WriteFile(tmpPath, []byte("Subject: corrupted"), 0o600))
+
+	checker := &utils.Sha256IntegrityChecker{}
+	checker.Initialize([]byte("Subject: hello"))
+
+	require.ErrorIs(t, utils.MoveFile(writer, tmpPath, filepath.Join(rootDir, "mail_20240101_000000", "msg.eml"), checker), utils.ErrIntegrityCheckFailed)
+}
+
+func TestArchive_Abort(t *testing.T) {
+	rootDir := t.TempDir()
+	archivePath := filepath.Join(rootDir, "mail_20240101_000000.zip")
+
+	writer, err := newArchiveFileWriter(archivePath, rootDir, ArchiveFormatZip, nil)
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteFile("", filepath.Join(rootDir, "mail_20240101_000000", "labels.json"), []byte("{}"), nil))
+
+	writer.abort()
+
+	entries, err := os.ReadDir(rootDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.Error(t, writer.WriteFile("", filepath.Join(rootDir, "mail_20240101_000000", "labels.json"), []byte("{}"), nil))
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+)
+
+// Incremental exports are stored as a chain of generations next to each other:
+// <email>
+//  |- mail_yyyymmdd_hhmmss (base generation, full export)
+//  |   |- checkpoint.json
+//  |- mail_yyyymmdd_hhmmss (incremental generation, new or changed messages only)
+//      |- checkpoint.json
+//
+// The checkpoint of a generation lists every message present in the mailbox at the time of the export
+// along with the generation in which its files can be found, and records the newest of them. The newest message only
+// tells how recent the generation is: all the metadata is listed again by the next generation, as older messages may
+// have been relabeled or deleted since.
+
+const CheckpointVersion = 1
+
+type ExportCheckpoint struct {
+	ParentGeneration  string
+	NewestMessageID   string
+	NewestMessageTime int64
+	Messages          map[string]CheckpointMessage
+}
+
+type CheckpointMessage struct {
+	Generation  string
+	Time        int64
+	Fingerprint string
+}
+
+func getCheckpointFileName() string {
+	return "checkpoint.json"
+}
+
+func loadCheckpointFile(exportDir string) (*ExportCheckpoint, error) {
+	b, err := os.ReadFile(filepath.Join(exportDir, getCheckpointFileName())) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint file: %w", err)
+	}
+
+	c, err := utils.NewVersionedJSON[ExportCheckpoint](CheckpointVersion, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint file: %w", err)
+	}
+
+	return &c.Payload, nil
+}
+
+func hasCheckpointFile(exportDir string) bool {
+	exists, err := fileExists(filepath.Join(exportDir, getCheckpointFileName()))
+
+	return err == nil && exists
+}
+
+// findLatestCheckpoint returns the most recent generation in path which has a checkpoint file.
+// An empty generation is returned if there is none.
+func findLatestCheckpoint(path string) (string, *ExportCheckpoint, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, nil
+		}
+
+		return "", nil, err
+	}
+
+	generations := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && mailFolderRegExp.MatchString(entry.Name()) {
+			generations = append(generations, entry.Name())
+		}
+	}
+
+	// Export directory names embed their creation time, the last one in lexical order is the most recent.
+	sort.Sort(sort.Reverse(sort.StringSlice(generations)))
+
+	for _, generation := range generations {
+		if !hasCheckpointFile(filepath.Join(path, generation)) {
+			continue
+		}
+
+		checkpoint, err := loadCheckpointFile(filepath.Join(path, generation))
+		if err != nil {
+			return "", nil, err
+		}
+
+		return generation, checkpoint, nil
+	}
+
+	return "", nil, nil
+}
+
+// messageFingerprint summarizes the mutable state of a message. A message whose fingerprint changed
+// since the last generation needs to be exported again.
+func messageFingerprint(metadata proton.MessageMetadata) string {
+	labelIDs := make([]string, len(metadata.LabelIDs))
+	copy(labelIDs, metadata.LabelIDs)
+	sort.Strings(labelIDs)
+
+	state := strings.Join([]string{
+		strings.Join(labelIDs, ","),
+		strconv.FormatBool(bool(metadata.Unread)),
+		strconv.FormatBool(bool(metadata.IsReplied)),
+		strconv.FormatBool(bool(metadata.IsRepliedAll)),
+		strconv.FormatBool(bool(metadata.IsForwarded)),
+		strconv.FormatInt(int64(metadata.Flags), 10),
+	}, "|")
+
+	hash := sha256.Sum256([]byte(state))
+
+	return hex.EncodeToString(hash[:16])
+}
+
+// IncrementalMetadataFileChecker skips messages which have not changed since the previous generation and records
+// the state of every message it sees, so that the checkpoint of the new generation can be written.
+type IncrementalMetadataFileChecker struct {
+	previous   *ExportCheckpoint
+	generation string
+	current    ExportCheckpoint
+}
+
+func NewIncrementalMetadataFileChecker(previousGeneration string, previous *ExportCheckpoint, generation string) *IncrementalMetadataFileChecker {
+	if previous == nil {
+		previous = &ExportCheckpoint{}
+	}
+
+	return &IncrementalMetadataFileChecker{
+		previous:   previous,
+		generation: generation,
+		current: ExportCheckpoint{
+			ParentGeneration: previousGeneration,
+			Messages:         make(map[string]CheckpointMessage),
+		},
+	}
+}
+
+func (c *IncrementalMetadataFileChecker) HasMessage(msgID string) (bool, error) {
+	_, ok := c.previous.Messages[msgID]
+
+	return ok, nil
+}
+
+func (c *IncrementalMetadataFileChecker) HasMessageState(metadata proton.MessageMetadata) (bool, error) {
+	fingerprint := messageFingerprint(metadata)
+
+	if prev, ok := c.previous.Messages[metadata.ID]; ok && prev.Fingerprint == fingerprint {
+		c.current.Messages[metadata.ID] = prev
+
+		return true, nil
+	}
+
+	c.current.Messages[metadata.ID] = CheckpointMessage{
+		Generation:  c.generation,
+		Time:        metadata.Time,
+		Fingerprint: fingerprint,
+	}
+
+	return false, nil
+}
+
+// WriteCheckpoint stores the checkpoint of the new generation. Messages which were expected in the new generation but
+// never made it to disk keep the entry of the previous generation, or are left out if they are new, so that the next
+// incremental export picks them up again.
+func (c *IncrementalMetadataFileChecker) WriteCheckpoint(tmpDir, exportDir string) error {
+	fileChecker := NewFileMetadataFileChecker(exportDir)
+
+	for id, msg := range c.current.Messages {
+		if msg.Generation != c.generation {
+			continue
+		}
+
+		if exists, err := fileChecker.HasMessage(id); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+
+		if prev, ok := c.previous.Messages[id]; ok {
+			c.current.Messages[id] = prev
+		} else {
+			delete(c.current.Messages, id)
+		}
+	}
+
+	c.current.NewestMessageID, c.current.NewestMessageTime = newestCheckpointMessage(c.current.Messages)
+
+	data, err := utils.GenerateVersionedJSON(CheckpointVersion, c.current)
+	if err != nil {
+		return fmt.Errorf("failed to json encode checkpoint: %w", err)
+	}
+
+	return utils.WriteFileSafe(tmpDir, filepath.Join(exportDir, getCheckpointFileName()), data, &utils.Sha256IntegrityChecker{})
+}
+
+// newestCheckpointMessage returns the ID and the time of the most recent message, the greatest ID if several have the
+// same time.
+func newestCheckpointMessage(messages map[string]CheckpointMessage) (string, int64) {
+	var (
+		newestID   string
+		newestTime int64
+	)
+
+	for id, msg := range messages {
+		if msg.Time > newestTime || (msg.Time == newestTime && id > newestID) {
+			newestID, newestTime = id, msg.Time
+		}
+	}
+
+	return newestID, newestTime
+}
+
+// GetNewMessageCount returns the number of messages which were not in the previous generation.
+func (c *IncrementalMetadataFileChecker) GetNewMessageCount() int {
+	var count int
+
+	for id := range c.current.Messages {
+		if _, ok := c.previous.Messages[id]; !ok {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrementalMetadataFileChecker(t *testing.T) {
+	unchanged := proton.MessageMetadata{ID: "unchanged", LabelIDs: []string{proton.InboxLabel}, Time: 10}
+	changed := proton.MessageMetadata{ID: "changed", LabelIDs: []string{proton.InboxLabel}, Time: 20, Unread: true}
+	added := proton.MessageMetadata{ID: "added", LabelIDs: []string{proton.InboxLabel}, Time: 30}
+
+	previous := &ExportCheckpoint{
+		Messages: map[string]CheckpointMessage{
+			unchanged.ID: {Generation: "mail_20240101_000000", Time: unchanged.Time, Fingerprint: messageFingerprint(unchanged)},
+			changed.ID:   {Generation: "mail_20240101_000000", Time: changed.Time, Fingerprint: messageFingerprint(changed)},
+			"deleted":    {Generation: "mail_20240101_000000", Time: 5, Fingerprint: "deleted"},
+		},
+	}
+
+	checker := NewIncrementalMetadataFileChecker("mail_20240101_000000", previous, "mail_20240102_000000")
+
+	changed.Unread = false
+
+	for _, v := range []struct {
+		metadata proton.MessageMetadata
+		present  bool
+	}{
+		{metadata: added, present: false},
+		{metadata: changed, present: false},
+		{metadata: unchanged, present: true},
+	} {
+		present, err := checker.HasMessageState(v.metadata)
+		require.NoError(t, err)
+		require.Equal(t, v.present, present, v.metadata.ID)
+	}
+
+	require.Equal(t, "mail_20240101_000000", checker.current.ParentGeneration)
+	require.Equal(t, 1, checker.GetNewMessageCount())
+	require.Len(t, checker.current.Messages, 3)
+	require.Equal(t, "mail_20240101_000000", checker.current.Messages[unchanged.ID].Generation)
+	require.Equal(t, "mail_20240102_000000", checker.current.Messages[changed.ID].Generation)
+	require.Equal(t, "mail_20240102_000000", checker.current.Messages[added.ID].Generation)
+}
+
+func TestMessageFingerprint(t *testing.T) {
+	m := proton.MessageMetadata{ID: "id", LabelIDs: []string{"a", "b"}}
+	reordered := proton.MessageMetadata{ID: "id", LabelIDs: []string{"b", "a"}}
+	relabeled := proton.MessageMetadata{ID: "id", LabelIDs: []string{"a"}}
+
+	require.Equal(t, messageFingerprint(m), messageFingerprint(reordered))
+	require.NotEqual(t, messageFingerprint(m), messageFingerprint(relabeled))
+}
+
+func TestIncrementalMetadataFileChecker_WriteCheckpoint(t *testing.T) {
+	userDir := t.TempDir()
+	generation := "mail_20240102_000000"
+	exportDir := filepath.Join(userDir, generation)
+	require.NoError(t, os.MkdirAll(exportDir, 0o700))
+
+	written := proton.MessageMetadata{ID: "written", Time: 100}
+	lost := proton.MessageMetadata{ID: "lost", Time: 300}
+	changed := proton.MessageMetadata{ID: "changed", Unread: true}
+
+	parent := CheckpointMessage{Generation: "mail_20240101_000000", Fingerprint: messageFingerprint(proton.MessageMetadata{ID: "changed"})}
+	previous := &ExportCheckpoint{Messages: map[string]CheckpointMessage{changed.ID: parent}}
+
+	checker := NewIncrementalMetadataFileChecker("mail_20240101_000000", previous, generation)
+
+	for _, m := range []proton.MessageMetadata{written, lost, changed} {
+		present, err := checker.HasMessageState(m)
+		require.NoError(t, err)
+		require.False(t, present)
+	}
+
+	metadata := MessageMetadata{MessageMetadata: written}
+	metadataBytes, err := metadata.toBytes()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, getMetadataFileName(written.ID)), metadataBytes, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, getEMLFileName(written.ID)), []byte("eml"), 0o600))
+
+	require.NoError(t, checker.WriteCheckpoint(t.TempDir(), exportDir))
+
+	foundGeneration, checkpoint, err := findLatestCheckpoint(userDir)
+	require.NoError(t, err)
+	require.Equal(t, generation, foundGeneration)
+	require.Contains(t, checkpoint.Messages, written.ID)
+	require.NotContains(t, checkpoint.Messages, lost.ID)
+
+	// The newest message is among those of the checkpoint.
+	require.Equal(t, written.ID, checkpoint.NewestMessageID)
+	require.Equal(t, written.Time, checkpoint.NewestMessageTime)
+
+	// A changed message which could not be written again is still found in the previous generation.
+	require.Equal(t, parent, checkpoint.Messages[changed.ID])
+}
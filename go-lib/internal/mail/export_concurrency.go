@@ -0,0 +1,307 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+)
+
+const MaxParallelDownloads = 32
+const MaxParallelBuilders = 16
+const MaxParallelWriters = 16
+
+// The parallel downloads are only increased after this long without rate limiting, and decreased at most once in this
+// interval since the requests which are in flight when the API starts rate limiting usually all fail.
+const downloadLimitCooldown = 10 * time.Second
+
+// The parallel downloads are increased while the average latency is below minLatency * downloadLatencyIncreaseFactor
+// and decreased when it is above minLatency * downloadLatencyDecreaseFactor.
+const downloadLatencyIncreaseFactor = 2
+const downloadLatencyDecreaseFactor = 4
+
+// The direction in which the builders and writers are adjusted is reversed when the throughput drops by more than this
+// ratio.
+const throughputDropRatio = 0.9
+
+// ConcurrencyOptions overrides the parallelism and memory of the export pipeline. Zero values are adjusted automatically.
+type ConcurrencyOptions struct {
+	Downloads int
+	Builders  int
+	Writers   int
+	MemoryMB  uint64 // Memory for the messages which are downloaded and built, in MB.
+}
+
+func (o ConcurrencyOptions) validate() error {
+	if o.Downloads < 0 || o.Builders < 0 || o.Writers < 0 {
+		return errors.New("the number of parallel workers can't be negative")
+	}
+
+	if o.Downloads > MaxParallelDownloads || o.Builders > MaxParallelBuilders || o.Writers > MaxParallelWriters {
+		return fmt.Errorf(
+			"at most %v downloads, %v builders and %v writers can run in parallel",
+			MaxParallelDownloads, MaxParallelBuilders, MaxParallelWriters,
+		)
+	}
+
+	return nil
+}
+
+// WorkerLimit provides the number of workers of a stage. It is read before each batch, so that it can change while the
+// stage runs.
+type WorkerLimit interface {
+	GetWorkerCount() int
+}
+
+type fixedWorkerLimit int
+
+func (f fixedWorkerLimit) GetWorkerCount() int {
+	return int(f)
+}
+
+// ConcurrencyController sizes the export pipeline: the downloads are adjusted from the outcome of the API requests, the
+// builders from the build throughput, starting from the number of CPUs, and the writers from the disk throughput.
+type ConcurrencyController struct {
+	downloads   *downloadLimit
+	builders    *throughputLimit
+	writers     *throughputLimit
+	downloadMem uint64
+	buildMem    uint64
+}
+
+func NewConcurrencyController(options ConcurrencyOptions, totalMemory uint64, log *logrus.Entry) *ConcurrencyController {
+	log = log.WithField("export", "concurrency")
+
+	downloadMem, buildMem := getExportMemoryLimits(totalMemory, options.MemoryMB*MB)
+
+	controller := &ConcurrencyController{
+		downloads:   newDownloadLimit(options.Downloads, log),
+		builders:    newBuilderLimit(options.Builders, log),
+		writers:     newWriterLimit(options.Writers, log),
+		downloadMem: downloadMem,
+		buildMem:    buildMem,
+	}
+
+	log.WithFields(logrus.Fields{
+		"downloads":     controller.downloads.GetWorkerCount(),
+		"builders":      controller.builders.GetWorkerCount(),
+		"writers":       controller.writers.GetWorkerCount(),
+		"downloadMemMB": toMB(downloadMem),
+		"buildMemMB":    toMB(buildMem),
+	}).Info("Export concurrency")
+
+	return controller
+}
+
+// getExportMemoryLimits splits the memory between the download and the build stages. Unless overridden, a quarter of the
+// memory of the machine is used, within the bounds of the Min and Max constants.
+func getExportMemoryLimits(totalMemory, override uint64) (uint64, uint64) {
+	memory := override
+	if memory == 0 {
+		memory = clampUint64(totalMemory/4, MinDownloadMemMB+MinBuildMemMB, MaxDownloadMemMB+MaxBuildMemMB)
+	}
+
+	downloadMem := memory / 3 * 2
+
+	return downloadMem, memory - downloadMem
+}
+
+// downloadLimit adjusts the number of parallel downloads with an additive increase, multiplicative decrease scheme: the
+// number is halved when the API rate limits the client and increased by one after each round of successful requests
+// whose latency stays close to the best one observed.
+type downloadLimit struct {
+	lock         sync.Mutex
+	log          *logrus.Entry
+	limit        int
+	fixed        bool
+	successCount int
+	latency      time.Duration // Moving average.
+	minLatency   time.Duration
+	lastDecrease time.Time
+	now          func() time.Time
+}
+
+func newDownloadLimit(override int, log *logrus.Entry) *downloadLimit {
+	limit := &downloadLimit{
+		log:   log,
+		limit: NumParallelDownloads,
+		now:   time.Now,
+	}
+
+	if override != 0 {
+		limit.limit = override
+		limit.fixed = true
+	}
+
+	return limit
+}
+
+func (d *downloadLimit) GetWorkerCount() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.limit
+}
+
+func (d *downloadLimit) OnRequest(duration time.Duration, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.fixed {
+		return
+	}
+
+	if isThrottlingError(err) {
+		d.decrease(d.limit/2, "Rate limited by the API")
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	if d.latency == 0 {
+		d.latency = duration
+	} else {
+		d.latency = (d.latency*7 + duration) / 8
+	}
+
+	if d.minLatency == 0 || d.latency < d.minLatency {
+		d.minLatency = d.latency
+	}
+
+	d.successCount++
+	if d.successCount < d.limit {
+		return
+	}
+
+	d.successCount = 0
+
+	switch {
+	case d.latency > d.minLatency*downloadLatencyDecreaseFactor:
+		d.decrease(d.limit-1, "Request latency increased")
+
+	case d.latency <= d.minLatency*downloadLatencyIncreaseFactor &&
+		d.limit < MaxParallelDownloads &&
+		d.now().Sub(d.lastDecrease) >= downloadLimitCooldown:
+		d.limit++
+		d.log.WithField("downloads", d.limit).Debug("Increasing parallel downloads")
+	}
+}
+
+func (d *downloadLimit) decrease(limit int, reason string) {
+	if d.now().Sub(d.lastDecrease) < downloadLimitCooldown {
+		return
+	}
+
+	d.limit = clampInt(limit, 1, MaxParallelDownloads)
+	d.lastDecrease = d.now()
+	d.successCount = 0
+
+	d.log.WithField("downloads", d.limit).Info(reason + ", decreasing parallel downloads")
+}
+
+func isThrottlingError(err error) bool {
+	var apiErr *proton.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.Status == 429 || apiErr.Status == 503
+}
+
+// throughputLimit adjusts the number of parallel builders or writers by hill climbing on the throughput of their stage:
+// the number keeps changing in the same direction while the throughput improves and changes direction when it drops.
+type throughputLimit struct {
+	lock           sync.Mutex
+	log            *logrus.Entry
+	name           string
+	limit          int
+	maxLimit       int
+	fixed          bool
+	direction      int
+	lastThroughput float64
+}
+
+func newBuilderLimit(override int, log *logrus.Entry) *throughputLimit {
+	return newThroughputLimit("builders", override, clampInt(runtime.NumCPU(), 1, MaxParallelBuilders), MaxParallelBuilders, log)
+}
+
+func newWriterLimit(override int, log *logrus.Entry) *throughputLimit {
+	return newThroughputLimit("writers", override, NumParallelWriters, MaxParallelWriters, log)
+}
+
+func newThroughputLimit(name string, override, initial, maxLimit int, log *logrus.Entry) *throughputLimit {
+	if override != 0 {
+		return &throughputLimit{log: log, name: name, limit: override, maxLimit: maxLimit, fixed: true}
+	}
+
+	return &throughputLimit{log: log, name: name, limit: initial, maxLimit: maxLimit, direction: 1}
+}
+
+func (t *throughputLimit) GetWorkerCount() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.limit
+}
+
+// OnBatchProcessed is called by the build and write stages with the size of the messages of each batch and the time it
+// took to process them.
+func (t *throughputLimit) OnBatchProcessed(size uint64, duration time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.fixed || duration <= 0 || size == 0 {
+		return
+	}
+
+	throughput := float64(size) / duration.Seconds()
+
+	if throughput < t.lastThroughput*throughputDropRatio {
+		t.direction = -t.direction
+	}
+
+	t.lastThroughput = throughput
+
+	if limit := clampInt(t.limit+t.direction, 1, t.maxLimit); limit != t.limit {
+		t.limit = limit
+		t.log.WithField(t.name, t.limit).Debug("Adjusting parallel " + t.name)
+	} else {
+		t.direction = -t.direction
+	}
+}
+
+// throughputObserver is implemented by the WorkerLimit of the build and write stages to be notified of each batch.
+type throughputObserver interface {
+	OnBatchProcessed(size uint64, duration time.Duration)
+}
+
+func clampInt(value, minValue, maxValue int) int {
+	return max(minValue, min(value, maxValue))
+}
+
+func clampUint64(value, minValue, maxValue uint64) uint64 {
+	return max(minValue, min(value, maxValue))
+}
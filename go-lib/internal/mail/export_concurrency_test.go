@@ -0,0 +1,185 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/gluon/async"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestDownloadLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	limit := newDownloadLimit(0, logrus.WithField("test", "test"))
+	limit.now = func() time.Time { return now }
+
+	succeed := func(count int, latency time.Duration) {
+		for i := 0; i < count; i++ {
+			limit.OnRequest(latency, nil)
+		}
+	}
+
+	// A full round of fast requests increases the limit by one.
+	succeed(NumParallelDownloads, time.Second)
+	require.Equal(t, NumParallelDownloads+1, limit.GetWorkerCount())
+
+	// Rate limiting halves it, once for all the requests in flight.
+	limit.OnRequest(time.Second, &proton.APIError{Status: 429})
+	limit.OnRequest(time.Second, &proton.APIError{Status: 429})
+	require.Equal(t, (NumParallelDownloads+1)/2, limit.GetWorkerCount())
+
+	// It isn't increased again before the cooldown.
+	succeed(limit.GetWorkerCount(), time.Second)
+	require.Equal(t, (NumParallelDownloads+1)/2, limit.GetWorkerCount())
+
+	now = now.Add(downloadLimitCooldown)
+
+	succeed(limit.GetWorkerCount(), time.Second)
+	require.Equal(t, (NumParallelDownloads+1)/2+1, limit.GetWorkerCount())
+
+	// Other errors are ignored.
+	limit.OnRequest(time.Second, &proton.APIError{Status: 422})
+	require.Equal(t, (NumParallelDownloads+1)/2+1, limit.GetWorkerCount())
+
+	// A growing latency decreases it.
+	succeed(limit.GetWorkerCount()*4, time.Minute)
+	require.Equal(t, (NumParallelDownloads+1)/2, limit.GetWorkerCount())
+}
+
+func TestDownloadLimit_Fixed(t *testing.T) {
+	limit := newDownloadLimit(3, logrus.WithField("test", "test"))
+
+	limit.OnRequest(time.Second, &proton.APIError{Status: 429})
+	require.Equal(t, 3, limit.GetWorkerCount())
+}
+
+func TestWriterLimit(t *testing.T) {
+	limit := newWriterLimit(0, logrus.WithField("test", "test"))
+
+	// More writers keep being added while the throughput improves.
+	limit.OnBatchProcessed(100*MB, time.Second)
+	limit.OnBatchProcessed(200*MB, time.Second)
+	require.Equal(t, NumParallelWriters+2, limit.GetWorkerCount())
+
+	// They are removed once it drops.
+	limit.OnBatchProcessed(100*MB, time.Second)
+	require.Equal(t, NumParallelWriters+1, limit.GetWorkerCount())
+
+	fixed := newWriterLimit(2, logrus.WithField("test", "test"))
+	fixed.OnBatchProcessed(100*MB, time.Second)
+	require.Equal(t, 2, fixed.GetWorkerCount())
+}
+
+func TestBuilderLimit(t *testing.T) {
+	limit := newBuilderLimit(0, logrus.WithField("test", "test"))
+	initial := limit.GetWorkerCount()
+
+	limit.OnBatchProcessed(100*MB, time.Second)
+	limit.OnBatchProcessed(50*MB, time.Second)
+	require.Equal(t, max(initial-1, 1), limit.GetWorkerCount())
+
+	fixed := newBuilderLimit(2, logrus.WithField("test", "test"))
+	fixed.OnBatchProcessed(100*MB, time.Second)
+	require.Equal(t, 2, fixed.GetWorkerCount())
+}
+
+func TestExportPipeline_DownloadsMoreThanInitialLimit(t *testing.T) {
+	const downloads = 16
+	const messageCount = 40
+
+	mockCtrl := gomock.NewController(t)
+	client := apiclient.NewMockClient(mockCtrl)
+	errReporter := NewMockStageErrorReporter(mockCtrl)
+
+	metadata := testMetadata(messageCount)
+	encodeMetadataExpectations(client, metadata, MetadataPageSize)
+
+	var (
+		lock      sync.Mutex
+		active    int
+		maxActive int
+		closeOnce sync.Once
+	)
+
+	allActive := make(chan struct{})
+
+	// Each download waits for the others to start, the downloads of a chunk of 10 messages would all time out.
+	client.EXPECT().GetMessage(gomock.Any(), gomock.Any()).Times(messageCount).DoAndReturn(func(_ context.Context, id string) (proton.Message, error) {
+		lock.Lock()
+		active++
+		if maxActive = max(maxActive, active); maxActive == downloads {
+			closeOnce.Do(func() { close(allActive) })
+		}
+		lock.Unlock()
+
+		select {
+		case <-allActive:
+		case <-time.After(5 * time.Second):
+		}
+
+		lock.Lock()
+		active--
+		lock.Unlock()
+
+		return proton.Message{MessageMetadata: proton.MessageMetadata{ID: id}}, nil
+	})
+
+	metaStage := newExportMetadataStage(client, logrus.WithField("test", "test"))
+	downloadStage := NewDownloadStage(client, NumParallelDownloads, logrus.WithField("test", "test"), MaxDownloadMemMB, &async.NoopPanicHandler{})
+	downloadStage.SetWorkerLimit(newDownloadLimit(downloads, logrus.WithField("test", "test")))
+
+	go metaStage.Run(context.Background(), errReporter, &alwaysMissingMetadataFileChecker{}, NullProgressReporter{})
+	go downloadStage.Run(context.Background(), metaStage.outputCh, errReporter)
+
+	var downloaded int
+	for output := range downloadStage.outputCh {
+		downloaded += len(output.messages)
+	}
+
+	require.Equal(t, messageCount, downloaded)
+	require.Equal(t, downloads, maxActive)
+}
+
+func TestGetExportMemoryLimits(t *testing.T) {
+	downloadMem, buildMem := getExportMemoryLimits(16*1024*MB, 0)
+	require.Equal(t, uint64(MaxDownloadMemMB), downloadMem)
+	require.Equal(t, uint64(MaxBuildMemMB), buildMem)
+
+	downloadMem, buildMem = getExportMemoryLimits(512*MB, 0)
+	require.Equal(t, uint64(MinDownloadMemMB+MinBuildMemMB), downloadMem+buildMem)
+
+	downloadMem, buildMem = getExportMemoryLimits(16*1024*MB, 300*MB)
+	require.Equal(t, uint64(200*MB), downloadMem)
+	require.Equal(t, uint64(100*MB), buildMem)
+}
+
+func TestConcurrencyOptions_Validate(t *testing.T) {
+	require.NoError(t, ConcurrencyOptions{}.validate())
+	require.NoError(t, ConcurrencyOptions{Downloads: 1, Builders: 1, Writers: 1}.validate())
+	require.Error(t, ConcurrencyOptions{Downloads: -1}.validate())
+	require.Error(t, ConcurrencyOptions{Writers: MaxParallelWriters + 1}.validate())
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"golang.org/x/exp/slices"
+)
+
+// ExportFilter restricts the messages included in an export. The zero value of each field disables the
+// corresponding criteria.
+type ExportFilter struct {
+	After           time.Time // Only messages received at or after this time.
+	Before          time.Time // Only messages received before this time.
+	IncludeLabels   []string  // Label IDs or names, messages must have at least one of them.
+	ExcludeLabels   []string  // Label IDs or names, messages must have none of them.
+	Addresses       []string  // Address IDs or emails, messages must belong to one of them.
+	UnreadOnly      bool
+	WithAttachments bool
+}
+
+func (f ExportFilter) IsEmpty() bool {
+	return f.After.IsZero() &&
+		f.Before.IsZero() &&
+		len(f.IncludeLabels) == 0 &&
+		len(f.ExcludeLabels) == 0 &&
+		len(f.Addresses) == 0 &&
+		!f.UnreadOnly &&
+		!f.WithAttachments
+}
+
+// messageFilter is an ExportFilter whose label names and addresses have been resolved to IDs.
+type messageFilter struct {
+	after           int64
+	before          int64
+	includeLabelIDs []string
+	excludeLabelIDs []string
+	addressIDs      []string
+	participants    []string
+	unreadOnly      bool
+	withAttachments bool
+}
+
+func (f ExportFilter) resolve(labels []proton.Label, addresses []proton.Address) (*messageFilter, error) {
+	result := &messageFilter{
+		unreadOnly:      f.UnreadOnly,
+		withAttachments: f.WithAttachments,
+	}
+
+	if !f.After.IsZero() {
+		result.after = f.After.Unix()
+	}
+
+	if !f.Before.IsZero() {
+		result.before = f.Before.Unix()
+	}
+
+	if result.after != 0 && result.before != 0 && result.after >= result.before {
+		return nil, fmt.Errorf("the filter start date must be before its end date")
+	}
+
+	var err error
+
+	if result.includeLabelIDs, err = resolveFilterLabels(f.IncludeLabels, labels); err != nil {
+		return nil, err
+	}
+
+	if result.excludeLabelIDs, err = resolveFilterLabels(f.ExcludeLabels, labels); err != nil {
+		return nil, err
+	}
+
+	for _, address := range f.Addresses {
+		index := slices.IndexFunc(addresses, func(a proton.Address) bool {
+			return a.ID == address || strings.EqualFold(a.Email, address)
+		})
+		if index < 0 {
+			return nil, fmt.Errorf("unknown address '%v' in filter", address)
+		}
+
+		result.addressIDs = append(result.addressIDs, addresses[index].ID)
+	}
+
+	return result, nil
+}
+
+// resolveFilterLabels returns the IDs of the labels matching the given IDs or names. Names are matched case-insensitively
+// and can match several labels. The usual names of system folders are accepted even if the system labels are not listed.
+func resolveFilterLabels(names []string, labels []proton.Label) ([]string, error) {
+	var result []string
+
+	for _, name := range names {
+		var found bool
+
+		for _, label := range labels {
+			if label.ID == name || strings.EqualFold(label.Name, name) {
+				result = append(result, label.ID)
+				found = true
+			}
+		}
+
+		if labelID, ok := systemLabelFromFolderName(name); !found && ok && len(labelID) != 0 {
+			result = append(result, labelID)
+			found = true
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown label '%v' in filter", name)
+		}
+	}
+
+	return result, nil
+}
+
+// apiFilter returns the part of the filter which is applied by the API. Only a single label can be requested, which
+// lets the export use the message count of the label as its total. Addresses are filtered client side as there is no
+// per address message count to report the progress against.
+func (f *messageFilter) apiFilter() proton.MessageFilter {
+	if len(f.includeLabelIDs) == 1 {
+		return proton.MessageFilter{LabelID: f.includeLabelIDs[0]}
+	}
+
+	return proton.MessageFilter{}
+}
+
+func (f *messageFilter) matches(metadata proton.MessageMetadata) bool {
+	if f.after != 0 && metadata.Time < f.after {
+		return false
+	}
+
+	if f.before != 0 && metadata.Time >= f.before {
+		return false
+	}
+
+	if len(f.includeLabelIDs) != 0 && !slices.ContainsFunc(f.includeLabelIDs, func(id string) bool { return slices.Contains(metadata.LabelIDs, id) }) {
+		return false
+	}
+
+	if slices.ContainsFunc(f.excludeLabelIDs, func(id string) bool { return slices.Contains(metadata.LabelIDs, id) }) {
+		return false
+	}
+
+	if len(f.addressIDs) != 0 && !slices.Contains(f.addressIDs, metadata.AddressID) {
+		return false
+	}
+
+	if len(f.participants) != 0 && !slices.ContainsFunc(f.participants, func(email string) bool { return hasParticipant(metadata, email) }) {
+		return false
+	}
+
+	if f.unreadOnly && !bool(metadata.Unread) {
+		return false
+	}
+
+	if f.withAttachments && metadata.NumAttachments == 0 {
+		return false
+	}
+
+	return true
+}
+
+// hasParticipant returns true if the message was sent by or to the given email address.
+func hasParticipant(metadata proton.MessageMetadata, email string) bool {
+	if metadata.Sender != nil && strings.EqualFold(metadata.Sender.Address, email) {
+		return true
+	}
+
+	for _, list := range [][]*mail.Address{metadata.ToList, metadata.CCList, metadata.BCCList} {
+		if slices.ContainsFunc(list, func(address *mail.Address) bool { return address != nil && strings.EqualFold(address.Address, email) }) {
+			return true
+		}
+	}
+
+	return false
+}
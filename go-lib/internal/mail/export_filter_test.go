@@ -0,0 +1,91 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportFilter_Resolve(t *testing.T) {
+	labels := []proton.Label{
+		{ID: proton.InboxLabel, Name: "Inbox", Type: proton.LabelTypeSystem},
+		{ID: "folder", Name: "Legal", Type: proton.LabelTypeFolder},
+		{ID: "label", Name: "legal", Type: proton.LabelTypeLabel},
+		{ID: "other", Name: "Other", Type: proton.LabelTypeLabel},
+	}
+
+	addresses := []proton.Address{
+		{ID: "addr-1", Email: "one@proton.me"},
+		{ID: "addr-2", Email: "two@proton.me"},
+	}
+
+	filter, err := ExportFilter{
+		IncludeLabels: []string{"LEGAL"},
+		ExcludeLabels: []string{"other"},
+		Addresses:     []string{"Two@proton.me", "addr-1"},
+	}.resolve(labels, addresses)
+	require.NoError(t, err)
+	require.Equal(t, []string{"folder", "label"}, filter.includeLabelIDs)
+	require.Equal(t, []string{"other"}, filter.excludeLabelIDs)
+	require.Equal(t, []string{"addr-2", "addr-1"}, filter.addressIDs)
+	require.Equal(t, proton.MessageFilter{}, filter.apiFilter())
+
+	filter, err = ExportFilter{IncludeLabels: []string{proton.InboxLabel}}.resolve(labels, addresses)
+	require.NoError(t, err)
+	require.Equal(t, proton.MessageFilter{LabelID: proton.InboxLabel}, filter.apiFilter())
+
+	_, err = ExportFilter{IncludeLabels: []string{"missing"}}.resolve(labels, addresses)
+	require.Error(t, err)
+
+	_, err = ExportFilter{Addresses: []string{"missing@proton.me"}}.resolve(labels, addresses)
+	require.Error(t, err)
+
+	_, err = ExportFilter{After: time.Unix(200, 0), Before: time.Unix(100, 0)}.resolve(labels, addresses)
+	require.Error(t, err)
+}
+
+func TestMessageFilter_Matches(t *testing.T) {
+	metadata := proton.MessageMetadata{
+		Time:           1000,
+		AddressID:      "addr",
+		LabelIDs:       []string{proton.InboxLabel, "label"},
+		Unread:         true,
+		NumAttachments: 1,
+	}
+
+	require.True(t, (&messageFilter{}).matches(metadata))
+	require.True(t, (&messageFilter{after: 1000, before: 1001}).matches(metadata))
+	require.False(t, (&messageFilter{after: 1001}).matches(metadata))
+	require.False(t, (&messageFilter{before: 1000}).matches(metadata))
+	require.True(t, (&messageFilter{includeLabelIDs: []string{"other", "label"}}).matches(metadata))
+	require.False(t, (&messageFilter{includeLabelIDs: []string{"other"}}).matches(metadata))
+	require.False(t, (&messageFilter{excludeLabelIDs: []string{"label"}}).matches(metadata))
+	require.True(t, (&messageFilter{addressIDs: []string{"addr"}}).matches(metadata))
+	require.False(t, (&messageFilter{addressIDs: []string{"other"}}).matches(metadata))
+	require.True(t, (&messageFilter{unreadOnly: true, withAttachments: true}).matches(metadata))
+
+	metadata.Unread = false
+	metadata.NumAttachments = 0
+
+	require.False(t, (&messageFilter{unreadOnly: true}).matches(metadata))
+	require.False(t, (&messageFilter{withAttachments: true}).matches(metadata))
+}
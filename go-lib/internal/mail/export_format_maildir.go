@@ -0,0 +1,254 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+)
+
+const maildirRootDir = "Maildir"
+
+// MaildirStore writes messages into a Maildir++ tree. The Inbox is the root maildir, every other folder and label is a
+// sub-folder named after its path in the label hierarchy, e.g. '.Folders.Work.Projects'. A message with several labels is
+// stored in each of their folders, using hard links when possible.
+type MaildirStore struct {
+	dir         string
+	layout      *labelFolderLayout
+	createdDirs sync.Map
+}
+
+func NewMaildirStore(dir string, labels []proton.Label) *MaildirStore {
+	return &MaildirStore{
+		dir:    filepath.Join(dir, maildirRootDir),
+		layout: newLabelFolderLayout(labels),
+	}
+}
+
+func (m *MaildirStore) NewMessageWriter(msg proton.FullMessage, eml bytes.Buffer) MessageWriter {
+	return &MaildirMessageWriter{msg: msg, eml: eml, store: m}
+}
+
+// StoreMessage writes the message in the maildir folder of each of its labels.
+func (m *MaildirStore) StoreMessage(
+	metadata proton.MessageMetadata,
+	eml []byte,
+	fileWriter utils.FileWriter,
+	integrityChecker utils.IntegrityChecker,
+) error {
+	fileName := maildirFileName(metadata)
+
+	var firstPath string
+
+	for _, path := range m.layout.getPaths(metadata.LabelIDs) {
+		folderDir := filepath.Join(m.dir, maildirFolderName(path))
+		if err := m.createFolder(folderDir); err != nil {
+			return err
+		}
+
+		dstPath := filepath.Join(folderDir, "cur", fileName)
+
+		if len(firstPath) != 0 {
+			if err := os.Link(firstPath, dstPath); err == nil || os.IsExist(err) {
+				continue
+			}
+		}
+
+		if err := fileWriter.WriteFile(filepath.Join(folderDir, "tmp"), dstPath, eml, integrityChecker); err != nil {
+			return err
+		}
+
+		if len(firstPath) == 0 {
+			firstPath = dstPath
+		}
+	}
+
+	return nil
+}
+
+func (m *MaildirStore) createFolder(folderDir string) error {
+	if _, ok := m.createdDirs.Load(folderDir); ok {
+		return nil
+	}
+
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(folderDir, sub), 0o700); err != nil {
+			return fmt.Errorf("failed to create maildir folder '%v': %w", folderDir, err)
+		}
+	}
+
+	if folderDir != m.dir {
+		// Marks the directory as a Maildir++ sub-folder.
+		if err := os.WriteFile(filepath.Join(folderDir, "maildirfolder"), nil, 0o600); err != nil {
+			return fmt.Errorf("failed to create maildir folder '%v': %w", folderDir, err)
+		}
+	}
+
+	m.createdDirs.Store(folderDir, struct{}{})
+
+	return nil
+}
+
+// maildirFolderName returns the Maildir++ directory name of a folder. The Inbox is the root directory, every other folder
+// is a dot separated list of its modified UTF-7 encoded path components.
+func maildirFolderName(path []string) string {
+	if len(path) == 1 && strings.EqualFold(path[0], "Inbox") {
+		return ""
+	}
+
+	var builder strings.Builder
+
+	for _, component := range path {
+		builder.WriteByte('.')
+		// The dot is the hierarchy separator and can't be part of a name.
+		builder.WriteString(encodeModifiedUTF7(strings.ReplaceAll(component, ".", "_")))
+	}
+
+	return builder.String()
+}
+
+// maildirFileName returns a unique file name for the message which embeds its flags in the info section.
+func maildirFileName(metadata proton.MessageMetadata) string {
+	return fmt.Sprintf("%v.%v.proton-mail-export%v2,%v", metadata.Time, metadata.ID, maildirInfoSeparator(), maildirFlags(metadata))
+}
+
+// maildirFlags returns the maildir info flags of the message, in ASCII order as required by the specification.
+func maildirFlags(metadata proton.MessageMetadata) string {
+	var flags string
+
+	if metadata.IsDraft() {
+		flags += "D"
+	}
+
+	if metadata.Starred() {
+		flags += "F"
+	}
+
+	if metadata.IsForwarded {
+		flags += "P"
+	}
+
+	if metadata.IsReplied || metadata.IsRepliedAll {
+		flags += "R"
+	}
+
+	if !metadata.Unread {
+		flags += "S"
+	}
+
+	return flags
+}
+
+func maildirInfoSeparator() string {
+	// Colons are not allowed in file names on Windows.
+	if runtime.GOOS == "windows" {
+		return "!"
+	}
+
+	return ":"
+}
+
+// encodeModifiedUTF7 encodes a folder name using the modified UTF-7 encoding of RFC 3501, which is how IMAP servers
+// expect non ASCII folder names to be stored in a Maildir++ tree.
+func encodeModifiedUTF7(name string) string {
+	encoding := base64.StdEncoding.WithPadding(base64.NoPadding)
+
+	var builder strings.Builder
+	var pending []rune
+
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+
+		units := utf16.Encode(pending)
+		buf := make([]byte, 0, len(units)*2)
+
+		for _, u := range units {
+			buf = append(buf, byte(u>>8), byte(u))
+		}
+
+		builder.WriteByte('&')
+		builder.WriteString(strings.ReplaceAll(encoding.EncodeToString(buf), "/", ","))
+		builder.WriteByte('-')
+
+		pending = pending[:0]
+	}
+
+	for _, r := range name {
+		if r >= 0x20 && r <= 0x7e {
+			flush()
+
+			if r == '&' {
+				builder.WriteString("&-")
+			} else {
+				builder.WriteRune(r)
+			}
+
+			continue
+		}
+
+		pending = append(pending, r)
+	}
+
+	flush()
+
+	return builder.String()
+}
+
+// MaildirMessageWriter is used instead of DecryptedAndBuiltMessageWriter when exporting to Maildir. Messages which could not be built
+// are still written with their own writers.
+type MaildirMessageWriter struct {
+	msg   proton.FullMessage
+	eml   bytes.Buffer
+	store *MaildirStore
+}
+
+func (m *MaildirMessageWriter) WriteMessage(
+	_ string,
+	_ string,
+	log *logrus.Entry,
+	fileWriter utils.FileWriter,
+	integrityChecker utils.IntegrityChecker,
+) error {
+	if err := m.store.StoreMessage(m.msg.MessageMetadata, m.eml.Bytes(), fileWriter, integrityChecker); err != nil {
+		log.WithField("msg-id", m.msg.ID).WithError(err).Error("Failed to write message to maildir")
+		return fmt.Errorf("failed to write message '%v' to maildir: %w", m.msg.ID, err)
+	}
+
+	return nil
+}
+
+func (m *MaildirMessageWriter) GetMetadata() MessageMetadata {
+	return NewMessageMetadata(MessageWriterTypeDecryptedAndBuilt, &m.msg.Message)
+}
+
+func (m *MaildirMessageWriter) HasEmbeddedMetadata() bool {
+	return true
+}
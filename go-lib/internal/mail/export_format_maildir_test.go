@@ -0,0 +1,115 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaildirFlags(t *testing.T) {
+	require.Equal(t, "", maildirFlags(proton.MessageMetadata{Unread: true, Flags: proton.MessageFlagReceived}))
+	require.Equal(t, "S", maildirFlags(proton.MessageMetadata{Flags: proton.MessageFlagReceived}))
+	require.Equal(t, "DF", maildirFlags(proton.MessageMetadata{
+		Unread:   true,
+		LabelIDs: []string{proton.StarredLabel},
+	}))
+	require.Equal(t, "FPRS", maildirFlags(proton.MessageMetadata{
+		LabelIDs:    []string{proton.InboxLabel, proton.StarredLabel},
+		IsReplied:   true,
+		IsForwarded: true,
+		Flags:       proton.MessageFlagReceived,
+	}))
+}
+
+func TestMaildirFolderName(t *testing.T) {
+	require.Equal(t, "", maildirFolderName([]string{"Inbox"}))
+	require.Equal(t, ".Sent", maildirFolderName([]string{"Sent"}))
+	require.Equal(t, ".Folders.Work.Projects", maildirFolderName([]string{"Folders", "Work", "Projects"}))
+	require.Equal(t, ".Labels.v1_2", maildirFolderName([]string{"Labels", "v1.2"}))
+}
+
+func TestEncodeModifiedUTF7(t *testing.T) {
+	require.Equal(t, "Work", encodeModifiedUTF7("Work"))
+	require.Equal(t, "R&-D", encodeModifiedUTF7("R&D"))
+	require.Equal(t, "&ZeVnLIqe-", encodeModifiedUTF7("日本語"))
+	require.Equal(t, "Entw&APw-rfe", encodeModifiedUTF7("Entwürfe"))
+}
+
+func TestMaildirStore_StoreMessage(t *testing.T) {
+	dir := t.TempDir()
+
+	labels := []proton.Label{
+		{ID: proton.InboxLabel, Name: "Inbox", Type: proton.LabelTypeSystem},
+		{ID: proton.StarredLabel, Name: "Starred", Type: proton.LabelTypeSystem},
+		{ID: "folder-parent", Name: "Work", Type: proton.LabelTypeFolder},
+		{ID: "folder-child", Name: "Projects", ParentID: "folder-parent", Type: proton.LabelTypeFolder},
+		{ID: "label", Name: "Important", Type: proton.LabelTypeLabel},
+	}
+
+	store := NewMaildirStore(dir, labels)
+
+	msg := proton.FullMessage{Message: proton.Message{MessageMetadata: proton.MessageMetadata{
+		ID:       "msg",
+		Time:     1700000000,
+		LabelIDs: []string{proton.InboxLabel, proton.StarredLabel, "folder-child", "label"},
+		Flags:    proton.MessageFlagReceived,
+	}}}
+
+	eml := "Subject: hello\r\n\r\nbody\r\n"
+
+	writer := store.NewMessageWriter(msg, *bytes.NewBufferString(eml))
+	require.NoError(t, writer.WriteMessage(dir, dir, logrus.WithField("test", "test"), &utils.DiskFileWriter{}, nil))
+	require.NoError(t, writer.WriteMessage(dir, dir, logrus.WithField("test", "test"), &utils.DiskFileWriter{}, nil))
+
+	fileName := "1700000000.msg.proton-mail-export" + maildirInfoSeparator() + "2,FS"
+
+	for _, folder := range []string{
+		filepath.Join(dir, maildirRootDir),
+		filepath.Join(dir, maildirRootDir, ".Folders.Work.Projects"),
+		filepath.Join(dir, maildirRootDir, ".Labels.Important"),
+	} {
+		for _, sub := range []string{"new", "tmp"} {
+			info, err := os.Stat(filepath.Join(folder, sub))
+			require.NoError(t, err)
+			require.True(t, info.IsDir())
+		}
+
+		entries, err := os.ReadDir(filepath.Join(folder, "cur"))
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, fileName, entries[0].Name())
+
+		data, err := os.ReadFile(filepath.Join(folder, "cur", fileName))
+		require.NoError(t, err)
+		require.Equal(t, eml, string(data))
+	}
+
+	_, err := os.Stat(filepath.Join(dir, maildirRootDir, ".Folders.Work.Projects", "maildirfolder"))
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, maildirRootDir, ".Starred"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+)
+
+const mboxExtension = ".mbox"
+
+// MboxStore appends messages to one mbox file (mboxrd flavour) per folder and label. The files are laid out following
+// the label hierarchy, see labelFolderLayout.
+type MboxStore struct {
+	dir    string
+	layout *labelFolderLayout
+	lock   sync.Mutex
+	locks  map[string]*sync.Mutex
+}
+
+func NewMboxStore(dir string, labels []proton.Label) *MboxStore {
+	return &MboxStore{
+		dir:    dir,
+		layout: newLabelFolderLayout(labels),
+		locks:  make(map[string]*sync.Mutex),
+	}
+}
+
+func (m *MboxStore) NewMessageWriter(msg proton.FullMessage, eml bytes.Buffer) MessageWriter {
+	return &MboxMessageWriter{msg: msg, eml: eml, store: m}
+}
+
+// AppendMessage writes the message to the mbox file of each of its labels.
+func (m *MboxStore) AppendMessage(metadata proton.MessageMetadata, eml []byte) error {
+	entry := newMboxEntry(metadata, eml)
+
+	for _, path := range m.layout.getPaths(metadata.LabelIDs) {
+		mboxPath := filepath.Join(append([]string{m.dir}, path...)...) + mboxExtension
+
+		if err := m.appendToFile(mboxPath, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MboxStore) appendToFile(path string, entry []byte) error {
+	lock := m.getFileLock(path)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create '%v': %w", filepath.Dir(path), err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to open mbox file: %w", err)
+	}
+
+	if _, err := file.Write(entry); err != nil {
+		if err := file.Close(); err != nil {
+			logrus.WithField("path", path).WithError(err).Error("Failed to close mbox file after io error")
+		}
+
+		return fmt.Errorf("failed to append to mbox file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close mbox file: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MboxStore) getFileLock(path string) *sync.Mutex {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	lock, ok := m.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[path] = lock
+	}
+
+	return lock
+}
+
+// newMboxEntry builds the mboxrd representation of a message: a 'From ' separator line, the message with its Status and
+// X-Status headers and every line starting with '>*From ' quoted with an additional '>'.
+func newMboxEntry(metadata proton.MessageMetadata, eml []byte) []byte {
+	const asctime = "Mon Jan _2 15:04:05 2006"
+
+	sender := "MAILER-DAEMON"
+	if metadata.Sender != nil && len(metadata.Sender.Address) != 0 {
+		sender = metadata.Sender.Address
+	}
+
+	newline := []byte("\n")
+	if bytes.Contains(eml, []byte("\r\n")) {
+		newline = []byte("\r\n")
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(len(eml) + 256)
+
+	buffer.WriteString(fmt.Sprintf("From %v %v", sender, time.Unix(metadata.Time, 0).UTC().Format(asctime)))
+	buffer.Write(newline)
+
+	for _, header := range mboxStatusHeaders(metadata) {
+		buffer.WriteString(header)
+		buffer.Write(newline)
+	}
+
+	for remaining := eml; len(remaining) != 0; {
+		line := remaining
+		if i := bytes.IndexByte(remaining, '\n'); i >= 0 {
+			line = remaining[:i+1]
+		}
+
+		remaining = remaining[len(line):]
+
+		if isMboxFromLine(line) {
+			buffer.WriteByte('>')
+		}
+
+		buffer.Write(line)
+	}
+
+	if !bytes.HasSuffix(eml, newline) {
+		buffer.Write(newline)
+	}
+
+	// Messages are separated by an empty line.
+	buffer.Write(newline)
+
+	return buffer.Bytes()
+}
+
+func mboxStatusHeaders(metadata proton.MessageMetadata) []string {
+	status := "Status: O"
+	if !metadata.Unread {
+		status = "Status: RO"
+	}
+
+	headers := []string{status}
+
+	var xStatus string
+	if metadata.IsReplied || metadata.IsRepliedAll {
+		xStatus += "A"
+	}
+
+	if metadata.Starred() {
+		xStatus += "F"
+	}
+
+	if metadata.IsDraft() {
+		xStatus += "T"
+	}
+
+	if len(xStatus) != 0 {
+		headers = append(headers, "X-Status: "+xStatus)
+	}
+
+	return headers
+}
+
+func isMboxFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// MboxMessageWriter is used instead of DecryptedAndBuiltMessageWriter when exporting to mbox. Messages which could not be built
+// are still written with their own writers, as they can't be represented in an mbox file.
+type MboxMessageWriter struct {
+	msg   proton.FullMessage
+	eml   bytes.Buffer
+	store *MboxStore
+}
+
+func (m *MboxMessageWriter) WriteMessage(_ string, _ string, log *logrus.Entry, _ utils.FileWriter, _ utils.IntegrityChecker) error {
+	if err := m.store.AppendMessage(m.msg.MessageMetadata, m.eml.Bytes()); err != nil {
+		log.WithField("msg-id", m.msg.ID).WithError(err).Error("Failed to append message to mbox")
+		return fmt.Errorf("failed to write message '%v' to mbox: %w", m.msg.ID, err)
+	}
+
+	return nil
+}
+
+func (m *MboxMessageWriter) GetMetadata() MessageMetadata {
+	return NewMessageMetadata(MessageWriterTypeDecryptedAndBuilt, &m.msg.Message)
+}
+
+func (m *MboxMessageWriter) HasEmbeddedMetadata() bool {
+	return true
+}
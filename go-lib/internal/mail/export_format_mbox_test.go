@@ -0,0 +1,131 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bytes"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMboxEntry(t *testing.T) {
+	metadata := proton.MessageMetadata{
+		ID:       "msg",
+		Sender:   &mail.Address{Address: "sender@proton.me"},
+		Time:     1700000000,
+		Unread:   false,
+		LabelIDs: []string{proton.InboxLabel, proton.StarredLabel},
+		Flags:    proton.MessageFlagReceived,
+	}
+
+	eml := []byte("Subject: hello\r\n\r\nFrom the start\r\n>From quoted\r\nbody")
+
+	expected := "From sender@proton.me Tue Nov 14 22:13:20 2023\r\n" +
+		"Status: RO\r\n" +
+		"X-Status: F\r\n" +
+		"Subject: hello\r\n" +
+		"\r\n" +
+		">From the start\r\n" +
+		">>From quoted\r\n" +
+		"body\r\n" +
+		"\r\n"
+
+	require.Equal(t, expected, string(newMboxEntry(metadata, eml)))
+}
+
+func TestMboxStatusHeaders(t *testing.T) {
+	require.Equal(t, []string{"Status: O", "X-Status: T"}, mboxStatusHeaders(proton.MessageMetadata{Unread: true}))
+	require.Equal(t, []string{"Status: RO", "X-Status: A"}, mboxStatusHeaders(proton.MessageMetadata{
+		IsReplied: true,
+		Flags:     proton.MessageFlagSent,
+	}))
+}
+
+func TestMboxStore_AppendMessage(t *testing.T) {
+	dir := t.TempDir()
+
+	labels := []proton.Label{
+		{ID: proton.InboxLabel, Name: "Inbox", Type: proton.LabelTypeSystem},
+		{ID: proton.StarredLabel, Name: "Starred", Type: proton.LabelTypeSystem},
+		{ID: "folder-parent", Name: "Work", Type: proton.LabelTypeFolder},
+		{ID: "folder-child", Name: "Pro/jects", ParentID: "folder-parent", Type: proton.LabelTypeFolder},
+		{ID: "label", Name: "Important", Type: proton.LabelTypeLabel},
+	}
+
+	store := NewMboxStore(dir, labels)
+
+	msg := proton.FullMessage{Message: proton.Message{MessageMetadata: proton.MessageMetadata{
+		ID:       "msg",
+		LabelIDs: []string{proton.InboxLabel, proton.StarredLabel, "folder-child", "label"},
+		Flags:    proton.MessageFlagReceived,
+	}}}
+
+	writer := store.NewMessageWriter(msg, *bytes.NewBufferString("Subject: hello\n\nbody\n"))
+	require.NoError(t, writer.WriteMessage(dir, dir, logrus.WithField("test", "test"), &utils.DiskFileWriter{}, nil))
+	require.NoError(t, writer.WriteMessage(dir, dir, logrus.WithField("test", "test"), &utils.DiskFileWriter{}, nil))
+
+	for _, path := range []string{
+		filepath.Join(dir, "Inbox.mbox"),
+		filepath.Join(dir, "Folders", "Work", "Pro_jects.mbox"),
+		filepath.Join(dir, "Labels", "Important.mbox"),
+	} {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, 2, bytes.Count(data, []byte("From ")), path)
+	}
+
+	_, err := os.Stat(filepath.Join(dir, "Starred.mbox"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	require.Equal(t, "a_b_c", sanitizeFileName("a/b\\c"))
+	require.Equal(t, "_", sanitizeFileName(".."))
+	require.Equal(t, "_", sanitizeFileName("  "))
+	require.Equal(t, "name", sanitizeFileName("name."))
+}
+
+func TestLabelLayoutFileName(t *testing.T) {
+	metadata := proton.MessageMetadata{ID: "msg", Subject: " Re: a/b ", Time: 1706711405}
+	require.Regexp(t, `^2024-01-31 143005 Re_ a_b \([0-9a-f]{8}\)$`, labelLayoutFileName(metadata))
+
+	// The hash of the ID keeps the names of messages with the same date and subject unique.
+	require.NotEqual(t, labelLayoutFileName(metadata), labelLayoutFileName(proton.MessageMetadata{ID: "other", Subject: metadata.Subject, Time: metadata.Time}))
+
+	require.Regexp(t, `^1970-01-01 000000 No subject \(`, labelLayoutFileName(proton.MessageMetadata{ID: "msg"}))
+
+	long := proton.MessageMetadata{ID: "msg", Subject: strings.Repeat("é", 100)}
+	require.Contains(t, labelLayoutFileName(long), strings.Repeat("é", labelLayoutMaxSubjectLength)+" (")
+
+	// The names of multibyte subjects fit in the file systems with every extension, without splitting a character.
+	for _, subject := range []string{strings.Repeat("会議の議事録", 20), strings.Repeat("📧", 80), "a" + strings.Repeat("😀", 79)} {
+		name := labelLayoutFileName(proton.MessageMetadata{ID: "msg", Subject: subject, Time: 1706711405})
+		require.LessOrEqual(t, len(getMetadataFileName(name)), labelLayoutMaxNameSize)
+		require.True(t, utf8.ValidString(name))
+		require.Regexp(t, `^2024-01-31 143005 .+ \([0-9a-f]{8}\)$`, name)
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ProtonMail/go-proton-api"
+	"golang.org/x/exp/slices"
+)
+
+const labelLayoutFoldersDir = "Folders"
+const labelLayoutLabelsDir = "Labels"
+
+// The subjects are truncated in the file names of the label layout, to stay clear of the path length limits.
+const labelLayoutMaxSubjectLength = 80
+
+// labelLayoutMaxNameSize is the limit of the file names of most file systems in bytes, e.g. NAME_MAX on ext4 and APFS.
+const labelLayoutMaxNameSize = 255
+
+// labelFolderLayout maps labels to folder paths for the export formats which store messages per label.
+// System folders are placed at the root, user folders and labels under their own directory following
+// their parent hierarchy:
+//
+//	Inbox
+//	Sent
+//	Folders/Work/Projects
+//	Labels/Important
+type labelFolderLayout struct {
+	paths    map[string][]string
+	fallback []string
+}
+
+func newLabelFolderLayout(labels []proton.Label) *labelFolderLayout {
+	byID := make(map[string]proton.Label, len(labels))
+	for _, label := range labels {
+		byID[label.ID] = label
+	}
+
+	layout := &labelFolderLayout{
+		paths:    make(map[string][]string, len(labels)),
+		fallback: []string{"All Mail"},
+	}
+
+	for _, label := range labels {
+		if label.ID == proton.AllMailLabel {
+			layout.fallback = []string{sanitizeFileName(label.Name)}
+		}
+
+		if isSystemLabel(label.ID) {
+			if isFolderSystemLabel(label.ID) {
+				layout.paths[label.ID] = []string{sanitizeFileName(label.Name)}
+			}
+
+			continue
+		}
+
+		path := labelHierarchy(label, byID)
+
+		switch label.Type {
+		case proton.LabelTypeFolder:
+			layout.paths[label.ID] = append([]string{labelLayoutFoldersDir}, path...)
+		case proton.LabelTypeLabel:
+			layout.paths[label.ID] = append([]string{labelLayoutLabelsDir}, path...)
+		case proton.LabelTypeSystem, proton.LabelTypeContactGroup:
+		}
+	}
+
+	return layout
+}
+
+// getPaths returns the folder paths under which a message with the given labels should be stored.
+// Each message is stored at least once.
+func (l *labelFolderLayout) getPaths(labelIDs []string) [][]string {
+	result := make([][]string, 0, len(labelIDs))
+
+	for _, labelID := range labelIDs {
+		if path, ok := l.paths[labelID]; ok {
+			result = append(result, path)
+		}
+	}
+
+	if len(result) == 0 {
+		result = append(result, l.fallback)
+	}
+
+	return result
+}
+
+// getFolderPath returns the path of the folder (not label) a message with the given labels belongs to.
+func (l *labelFolderLayout) getFolderPath(labelIDs []string) []string {
+	for _, labelID := range labelIDs {
+		if path, ok := l.paths[labelID]; ok && (isSystemLabel(labelID) || path[0] == labelLayoutFoldersDir) {
+			return path
+		}
+	}
+
+	return l.fallback
+}
+
+// getLabelPaths returns the paths of the labels (not folders) of a message with the given labels.
+func (l *labelFolderLayout) getLabelPaths(labelIDs []string) [][]string {
+	var result [][]string
+
+	for _, labelID := range labelIDs {
+		if path, ok := l.paths[labelID]; ok && path[0] == labelLayoutLabelsDir {
+			result = append(result, path)
+		}
+	}
+
+	return result
+}
+
+// getFolderPaths returns the paths of all the folders messages can belong to, see getFolderPath.
+func (l *labelFolderLayout) getFolderPaths() [][]string {
+	result := [][]string{l.fallback}
+
+	for _, path := range l.paths {
+		if path[0] == labelLayoutLabelsDir || slices.ContainsFunc(result, func(other []string) bool { return slices.Equal(path, other) }) {
+			continue
+		}
+
+		result = append(result, path)
+	}
+
+	return result
+}
+
+// getFolderDir returns the directory of the folder a message with the given labels is stored in by the label layout.
+func (l *labelFolderLayout) getFolderDir(exportDir string, labelIDs []string) string {
+	return filepath.Join(append([]string{exportDir}, l.getFolderPath(labelIDs)...)...)
+}
+
+// labelLayoutFileName returns the name of the files of a message in the label layout, without extension. It is made of
+// the date and the subject of the message, and the beginning of a hash of its ID which keeps the names unique, e.g.
+// '2024-01-31 143005 Meeting notes (1a2b3c4d)'.
+func labelLayoutFileName(metadata proton.MessageMetadata) string {
+	hash := sha256.Sum256([]byte(metadata.ID))
+	date := time.Unix(metadata.Time, 0).UTC().Format("2006-01-02 150405")
+	suffix := " (" + hex.EncodeToString(hash[:4]) + ")"
+
+	subject := []rune(strings.TrimSpace(metadata.Subject))
+	if len(subject) > labelLayoutMaxSubjectLength {
+		subject = subject[:labelLayoutMaxSubjectLength]
+	}
+
+	// Multibyte subjects are also limited in bytes, so that the names with the longest extension fit in the file
+	// systems. The characters replaced by sanitizeFileName are single bytes, they don't change the size.
+	maxSubjectSize := labelLayoutMaxNameSize - len(date) - len(" ") - len(suffix) - len(jsonMetadataExtension)
+
+	name := "No subject"
+	if len(subject) != 0 {
+		name = sanitizeFileName(truncateUTF8(string(subject), maxSubjectSize))
+	}
+
+	return date + " " + name + suffix
+}
+
+// truncateUTF8 returns the longest prefix of s which is at most size bytes long and doesn't split a character.
+func truncateUTF8(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+
+	return s[:size]
+}
+
+// isFolderSystemLabel returns true for the system labels which behave as folders. Starred is represented as a message
+// flag and the aggregated views (All Mail, All Sent, ...) would only duplicate the content of the other folders.
+func isFolderSystemLabel(labelID string) bool {
+	return slices.Contains([]string{
+		proton.InboxLabel,
+		proton.DraftsLabel,
+		proton.SentLabel,
+		proton.ArchiveLabel,
+		proton.SpamLabel,
+		proton.TrashLabel,
+		proton.OutboxLabel,
+	}, labelID)
+}
+
+// labelHierarchy returns the sanitized names of the label and its parents, starting from the root.
+func labelHierarchy(label proton.Label, byID map[string]proton.Label) []string {
+	var path []string
+
+	visited := make(map[string]struct{})
+
+	for {
+		if _, ok := visited[label.ID]; ok {
+			break
+		}
+
+		visited[label.ID] = struct{}{}
+		path = append([]string{sanitizeFileName(label.Name)}, path...)
+
+		parent, ok := byID[label.ParentID]
+		if len(label.ParentID) == 0 || !ok {
+			break
+		}
+
+		label = parent
+	}
+
+	return path
+}
+
+// sanitizeFileName replaces the characters which are not allowed in file names on any of the supported platforms.
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	name = strings.TrimRight(strings.TrimSpace(name), ".")
+	if len(name) == 0 || name == "." || name == ".." {
+		return "_"
+	}
+
+	return name
+}
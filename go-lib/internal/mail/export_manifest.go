@@ -0,0 +1,324 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const ExportManifestVersion = 1
+
+// ExportManifest is written at the end of a successful export and lists every file of the export with its SHA-256 hash,
+// so that the integrity of the export can be proven later on. The hashes are those of the stored data, after encryption,
+// and the manifest itself is never encrypted: an encrypted export can be checked without its key.
+type ExportManifest struct {
+	UserID       string
+	ToolVersion  string
+	CreatedAt    time.Time
+	MessageCount int64
+	Parameters   ExportManifestParameters
+	Files        []ExportManifestFile
+}
+
+// ExportManifestParameters are the options the export was created with.
+type ExportManifestParameters struct {
+	Format      string
+	Archive     string `json:",omitempty"`
+	Layout      string `json:",omitempty"`
+	Encrypted   bool
+	Resumed     bool
+	Incremental bool
+	Filter      ExportFilter
+}
+
+type ExportManifestFile struct {
+	Path   string // slash separated path relative to the export directory.
+	SHA256 string // hex encoded.
+}
+
+func getManifestFileName() string {
+	return "manifest.json"
+}
+
+// exportLayoutName returns the name of the layout in the manifest, empty for the default flat layout.
+func exportLayoutName(layout ExportLayout) string {
+	if layout == ExportLayoutLabels {
+		return "labels"
+	}
+
+	return ""
+}
+
+func exportFormatName(format ExportFormat) string {
+	switch format {
+	case ExportFormatEML:
+		return "eml"
+	case ExportFormatMbox:
+		return "mbox"
+	case ExportFormatMaildir:
+		return "maildir"
+	default:
+		return "unknown"
+	}
+}
+
+// manifestFileWriter records the hash of every file written through it. The hash is taken from the integrity checker,
+// which is initialized with the data as it is stored.
+type manifestFileWriter struct {
+	utils.FileWriter
+	exportDir string
+	lock      sync.Mutex
+	hashes    map[string]string
+}
+
+func newManifestFileWriter(fileWriter utils.FileWriter, exportDir string) *manifestFileWriter {
+	return &manifestFileWriter{
+		FileWriter: fileWriter,
+		exportDir:  exportDir,
+		hashes:     make(map[string]string),
+	}
+}
+
+// WriteFile writes the file with the underlying writer. A Sha256IntegrityChecker is used if none is given.
+func (m *manifestFileWriter) WriteFile(tempPath, dstPath string, data []byte, integrityChecker utils.IntegrityChecker) error {
+	checker, ok := integrityChecker.(*utils.Sha256IntegrityChecker)
+	if !ok {
+		checker = &utils.Sha256IntegrityChecker{}
+	}
+
+	if err := m.FileWriter.WriteFile(tempPath, dstPath, data, checker); err != nil {
+		return err
+	}
+
+	return m.record(dstPath, checker.GetStoredHash())
+}
+
+// MoveFile moves the file with the underlying writer, see utils.MoveFile. A Sha256IntegrityChecker is used if none is
+// given.
+func (m *manifestFileWriter) MoveFile(srcPath, dstPath string, integrityChecker utils.IntegrityChecker) error {
+	checker, ok := integrityChecker.(*utils.Sha256IntegrityChecker)
+	if !ok {
+		hash, err := hashFile(srcPath)
+		if err != nil {
+			return err
+		}
+
+		checker = &utils.Sha256IntegrityChecker{}
+		checker.InitializeHash(hash)
+	}
+
+	if err := utils.MoveFile(m.FileWriter, srcPath, dstPath, checker); err != nil {
+		return err
+	}
+
+	return m.record(dstPath, checker.GetStoredHash())
+}
+
+// RemoveFile removes the file with the underlying writer, see utils.RemoveFile, and forgets its hash.
+func (m *manifestFileWriter) RemoveFile(path string) error {
+	if err := utils.RemoveFile(m.FileWriter, path); err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(m.exportDir, path)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest path of '%v': %w", path, err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.hashes, filepath.ToSlash(rel))
+
+	return nil
+}
+
+func (m *manifestFileWriter) record(path string, hash []byte) error {
+	rel, err := filepath.Rel(m.exportDir, path)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest path of '%v': %w", path, err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.hashes[filepath.ToSlash(rel)] = hex.EncodeToString(hash)
+
+	return nil
+}
+
+// addMissingFiles hashes the files of the export directory which were not written through the writer: those of a
+// previous run of a resumed export, the hard links of Maildir exports and the mbox files which are appended to.
+func (m *manifestFileWriter) addMissingFiles(ctx context.Context, tmpDir string) error {
+	return filepath.WalkDir(m.exportDir, func(path string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if path == tmpDir {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		rel, err := filepath.Rel(m.exportDir, path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if m.hasFile(rel) || rel == getManifestFileName() {
+			return nil
+		}
+
+		hash, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		m.hashes[rel] = hex.EncodeToString(hash)
+
+		return nil
+	})
+}
+
+func (m *manifestFileWriter) hasFile(rel string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, ok := m.hashes[rel]
+
+	return ok
+}
+
+// getMetadataFileCount returns the number of message metadata files at the root of the export.
+func (m *manifestFileWriter) getMetadataFileCount() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var count int64
+
+	for path := range m.hashes {
+		if !strings.Contains(path, "/") && strings.HasSuffix(path, jsonMetadataExtension) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (m *manifestFileWriter) getFiles() []ExportManifestFile {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	paths := maps.Keys(m.hashes)
+	slices.Sort(paths)
+
+	files := make([]ExportManifestFile, 0, len(paths))
+	for _, path := range paths {
+		files = append(files, ExportManifestFile{Path: path, SHA256: m.hashes[path]})
+	}
+
+	return files
+}
+
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to open '%v': %w", path, err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, fmt.Errorf("failed to hash '%v': %w", path, err)
+	}
+
+	return hasher.Sum(nil), nil
+}
+
+// writeManifest writes the manifest of the export. writtenCount is the number of messages written by this run, only used
+// for the formats which don't have one metadata file per message.
+func (e *ExportTask) writeManifest(archive *archiveFileWriter, writtenCount int64) error {
+	if archive == nil {
+		if err := e.manifest.addMissingFiles(e.ctx, e.tmpDir); err != nil {
+			return err
+		}
+	}
+
+	messageCount := writtenCount
+	if e.format == ExportFormatEML && e.layout == ExportLayoutFlat {
+		messageCount = e.manifest.getMetadataFileCount()
+	}
+
+	manifest := ExportManifest{
+		UserID:       e.session.GetUser().ID,
+		ToolVersion:  internal.ETVersionString,
+		CreatedAt:    time.Now().UTC(),
+		MessageCount: messageCount,
+		Parameters: ExportManifestParameters{
+			Format:      exportFormatName(e.format),
+			Archive:     strings.TrimPrefix(e.archiveFormat.extension(), "."),
+			Layout:      exportLayoutName(e.layout),
+			Encrypted:   e.encrypter != nil,
+			Resumed:     e.resume,
+			Incremental: e.incremental != nil,
+			Filter:      e.filter,
+		},
+		Files: e.manifest.getFiles(),
+	}
+
+	data, err := utils.GenerateVersionedJSON(ExportManifestVersion, manifest)
+	if err != nil {
+		return fmt.Errorf("failed to json encode manifest: %w", err)
+	}
+
+	manifestPath := filepath.Join(e.exportDir, getManifestFileName())
+
+	e.log.WithField("fileCount", len(manifest.Files)).Info("Writing export manifest")
+
+	if archive != nil {
+		return archive.writePlainFile(manifestPath, data)
+	}
+
+	return utils.WriteFileSafe(e.tmpDir, manifestPath, data, &utils.Sha256IntegrityChecker{})
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestFileWriter(t *testing.T) {
+	exportDir := filepath.Join(t.TempDir(), "mail_20240101_000000")
+	tmpDir := filepath.Join(exportDir, "temp")
+	require.NoError(t, os.MkdirAll(tmpDir, 0o700))
+
+	writer := newManifestFileWriter(&utils.DiskFileWriter{Encrypter: utils.NewPGPPasswordFileCipher([]byte("secret"))}, exportDir)
+
+	metadata := MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: "msg"}}
+	metadataBytes, err := metadata.toBytes()
+	require.NoError(t, err)
+
+	require.NoError(t, writer.WriteFile(tmpDir, filepath.Join(exportDir, getMetadataFileName("msg")), metadataBytes, nil))
+	require.NoError(t, writer.WriteFile(tmpDir, filepath.Join(exportDir, getEMLFileName("msg")), []byte("Subject: msg\r\n\r\n"), &utils.Sha256IntegrityChecker{}))
+	require.NoError(t, writer.MkdirAll(filepath.Join(exportDir, "parts")))
+	require.NoError(t, writer.WriteFile(tmpDir, filepath.Join(exportDir, "parts", bodyFileName()), []byte("body"), nil))
+
+	// Files which are not written through the writer, and the temp files which are not part of the export.
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, "Inbox.mbox"), []byte("From "), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "export-tool-1"), []byte("partial"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, getManifestFileName()), []byte("{}"), 0o600))
+
+	require.NoError(t, writer.addMissingFiles(context.Background(), tmpDir))
+	require.Equal(t, int64(1), writer.getMetadataFileCount())
+
+	files := writer.getFiles()
+	require.Equal(t, []string{"Inbox.mbox", "msg.eml", "msg.metadata.json", "parts/body.txt"}, manifestFilePaths(files))
+
+	// The hashes are those of the encrypted files.
+	for _, file := range files {
+		hash, err := hashFile(filepath.Join(exportDir, filepath.FromSlash(file.Path)))
+		require.NoError(t, err)
+		require.Equal(t, hex.EncodeToString(hash), file.SHA256, file.Path)
+	}
+}
+
+func TestManifestFileWriter_Archive(t *testing.T) {
+	rootDir := t.TempDir()
+	exportDir := filepath.Join(rootDir, "mail_20240101_000000")
+
+	archive, err := newArchiveFileWriter(exportDir+tarZstdExtension, rootDir, ArchiveFormatTarZstd, nil)
+	require.NoError(t, err)
+	defer archive.abort()
+
+	writer := newManifestFileWriter(archive, exportDir)
+	require.NoError(t, writer.WriteFile("", filepath.Join(exportDir, getEMLFileName("msg")), []byte("Subject: msg\r\n\r\n"), nil))
+
+	files := writer.getFiles()
+	require.Len(t, files, 1)
+	require.Equal(t, "msg.eml", files[0].Path)
+	require.Equal(t, "98b42374e5309a671c5190aae2f8fc5d2913d9b0c79c01ac3e62368fb53db8e5", files[0].SHA256)
+}
+
+func TestVerifyTask_Manifest(t *testing.T) {
+	exportDir := filepath.Join(t.TempDir(), "mail_20240101_000000")
+	tmpDir := filepath.Join(exportDir, "temp")
+	require.NoError(t, os.MkdirAll(tmpDir, 0o700))
+
+	writer := newManifestFileWriter(&utils.DiskFileWriter{}, exportDir)
+
+	labels, err := utils.GenerateVersionedJSON(LabelMetadataVersion, []proton.Label{})
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteFile(tmpDir, filepath.Join(exportDir, getLabelFileName()), labels, nil))
+
+	for _, id := range []string{"msg1", "msg2"} {
+		metadata := MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: id}}
+		metadataBytes, err := metadata.toBytes()
+		require.NoError(t, err)
+
+		require.NoError(t, writer.WriteFile(tmpDir, filepath.Join(exportDir, getMetadataFileName(id)), metadataBytes, nil))
+		require.NoError(t, writer.WriteFile(tmpDir, filepath.Join(exportDir, getEMLFileName(id)), []byte("Subject: "+id+"\r\n\r\n"), nil))
+	}
+
+	manifest, err := utils.GenerateVersionedJSON(ExportManifestVersion, ExportManifest{MessageCount: 2, Files: writer.getFiles()})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, getManifestFileName()), manifest, 0o600))
+
+	verify := func() VerifyReport {
+		task, err := NewVerifyTask(context.Background(), exportDir)
+		require.NoError(t, err)
+		require.NoError(t, task.Run(&NullProgressReporter{}))
+
+		return task.GetReport()
+	}
+
+	report := verify()
+	require.True(t, report.IsValid())
+	require.True(t, report.ChecksumsVerified)
+	require.Equal(t, 2, report.BuiltMessageCount)
+
+	// A bit flip which leaves the EML parseable is only caught by the manifest.
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, getEMLFileName("msg2")), []byte("Subject: msg3\r\n\r\n"), 0o600))
+
+	report = verify()
+	require.Equal(t, []VerifyIssue{{MessageID: "msg2", Path: "msg2.eml", Problem: "checksum does not match the manifest"}}, report.Issues)
+	require.Equal(t, 1, report.BuiltMessageCount)
+	require.Equal(t, 1, report.InvalidMessageCount)
+}
+
+func manifestFilePaths(files []ExportManifestFile) []string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+
+	return paths
+}
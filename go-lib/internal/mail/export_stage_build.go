@@ -22,6 +22,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ProtonMail/export-tool/internal/apiclient"
 	"github.com/ProtonMail/export-tool/internal/reporter"
@@ -29,6 +30,7 @@ import (
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/proton-bridge/v3/pkg/message"
 	"github.com/bradenaw/juniper/parallel"
+	"github.com/bradenaw/juniper/xslices"
 	"github.com/sirupsen/logrus"
 )
 
@@ -37,14 +39,25 @@ type BuildStageOutput struct {
 	messages      []MessageWriter
 }
 
+// BuiltMessageWriterFactory creates the writer for a message which was successfully decrypted and built.
+type BuiltMessageWriterFactory func(msg proton.FullMessage, eml bytes.Buffer) MessageWriter
+
+func newDecryptedAndBuiltMessageWriter(msg proton.FullMessage, eml bytes.Buffer) MessageWriter {
+	return &DecryptedAndBuiltMessageWriter{msg: msg, eml: eml}
+}
+
 type BuildStage struct {
 	panicHandler     async.PanicHandler
 	log              *logrus.Entry
 	outputCh         chan BuildStageOutput
-	parallelBuilders int
+	parallelBuilders WorkerLimit
 	maxBuildMemMB    uint64
 	reporter         reporter.Reporter
 	userID           string
+	newBuiltWriter   BuiltMessageWriterFactory
+	streamingDir     string
+	events           EventReporter
+	messageErrors    MessageErrorReporter
 }
 
 var ErrBuildNoAddrKey = errors.New("no key found for address")
@@ -61,13 +74,45 @@ func NewBuildStage(
 		panicHandler:     panicHandler,
 		log:              log.WithField("stage", "build"),
 		outputCh:         make(chan BuildStageOutput),
-		parallelBuilders: parallelBuilders,
+		parallelBuilders: fixedWorkerLimit(parallelBuilders),
 		maxBuildMemMB:    maxBuildMemMB,
 		reporter:         reporter,
 		userID:           userID,
+		newBuiltWriter:   newDecryptedAndBuiltMessageWriter,
+		events:           NullEventReporter{},
+		messageErrors:    NullMessageErrorReporter{},
 	}
 }
 
+// SetBuiltMessageWriterFactory replaces the writer used for messages which were successfully built. Must be called before Run.
+func (b *BuildStage) SetBuiltMessageWriterFactory(factory BuiltMessageWriterFactory) {
+	b.newBuiltWriter = factory
+}
+
+// SetWorkerLimit replaces the fixed number of parallel builders given to the constructor. The limit is notified of the
+// throughput of each batch if it implements throughputObserver. Must be called before Run.
+func (b *BuildStage) SetWorkerLimit(limit WorkerLimit) {
+	b.parallelBuilders = limit
+}
+
+// SetStreamingDir makes the stage build the messages with attachments downloaded to disk into temp files in dir, see
+// DownloadStage.SetStreamingDir. They are written with a StreamedMessageWriter, the BuiltMessageWriterFactory is only
+// used if a message has to be built in memory. Must be called before Run.
+func (b *BuildStage) SetStreamingDir(dir string) {
+	b.streamingDir = dir
+}
+
+// SetEventReporter reports the messages which could not be built. Must be called before Run.
+func (b *BuildStage) SetEventReporter(events EventReporter) {
+	b.events = events
+}
+
+// SetMessageErrorReporter lets the stage skip the messages which fail to build instead of failing. Must be called
+// before Run.
+func (b *BuildStage) SetMessageErrorReporter(messageErrors MessageErrorReporter) {
+	b.messageErrors = messageErrors
+}
+
 func (b *BuildStage) Run(
 	ctx context.Context,
 	inputs <-chan DownloadStageOutput,
@@ -85,36 +130,24 @@ func (b *BuildStage) Run(
 			}
 
 			results := make([]MessageWriter, len(chunk))
+			start := time.Now()
 
-			if err := parallel.DoContext(ctx, b.parallelBuilders, len(results), func(_ context.Context, i int) error {
-				addrID := chunk[i].AddressID
+			if err := parallel.DoContext(ctx, b.parallelBuilders.GetWorkerCount(), len(results), func(_ context.Context, i int) error {
+				files := input.attachmentFiles[chunk[i].ID]
+				defer removeAttachmentFiles(files)
 
-				kr, ok := keys.GetAddrKeyRing(addrID)
-				if !ok {
-					b.log.WithField("addrID", addrID).Warn("Address has no key ring")
-					results[i] = &AddrKeyRingMissingMessageWriter{msg: chunk[i]}
-					return nil
-				}
+				writer, err := b.buildMessage(keys, chunk[i], files)
+				if err != nil {
+					reportMessageFailed(b.events, "build", FailureBuild, chunk[i].MessageMetadata, err)
 
-				var buffer bytes.Buffer
-				buffer.Grow(chunk[i].Size)
+					if b.messageErrors.ReportMessageError(chunk[i].MessageMetadata, err) {
+						return nil
+					}
 
-				decrypted := message.DecryptMessage(kr, chunk[i].Message, chunk[i].AttData)
-
-				if err := message.BuildRFC822Into(kr, &decrypted, defaultMessageJobOpts(), &buffer); err != nil {
-					b.log.WithError(err).WithField("addrID", addrID).Warn("Failed to build message")
-					b.reporter.ReportError(fmt.Errorf("failed to build message: %w", err), reporter.Context{
-						"msgID":  chunk[i].Message.ID,
-						"userID": b.userID,
-					})
-					results[i] = &AssembleFailedMessageWriter{decrypted: decrypted}
-					return nil
+					return err
 				}
 
-				results[i] = &DecryptedAndBuiltMessageWriter{
-					msg: chunk[i],
-					eml: buffer,
-				}
+				results[i] = writer
 
 				return nil
 			}); err != nil {
@@ -122,6 +155,20 @@ func (b *BuildStage) Run(
 				return
 			}
 
+			if observer, ok := b.parallelBuilders.(throughputObserver); ok {
+				var size uint64
+				for _, msg := range chunk {
+					size += uint64(msg.Size) //nolint:gosec // we won't overflow.
+				}
+
+				observer.OnBatchProcessed(size, time.Since(start))
+			}
+
+			// Remove the messages which were skipped.
+			results = xslices.Filter(results, func(writer MessageWriter) bool {
+				return writer != nil
+			})
+
 			select {
 			case <-ctx.Done():
 				return
@@ -134,6 +181,51 @@ func (b *BuildStage) Run(
 	}
 }
 
+func (b *BuildStage) buildMessage(keys *apiclient.UnlockedKeyRing, msg proton.FullMessage, attachmentFiles []string) (MessageWriter, error) {
+	addrID := msg.AddressID
+
+	kr, ok := keys.GetAddrKeyRing(addrID)
+	if !ok {
+		b.log.WithField("addrID", addrID).Warn("Address has no key ring")
+
+		if err := loadAttachmentFiles(&msg, attachmentFiles); err != nil {
+			return nil, err
+		}
+
+		return &AddrKeyRingMissingMessageWriter{msg: msg}, nil
+	}
+
+	if len(attachmentFiles) != 0 {
+		writer, err := buildStreamedMessage(kr, msg, attachmentFiles, b.streamingDir)
+		if err == nil {
+			return writer, nil
+		}
+
+		b.log.WithError(err).WithField("msgID", msg.ID).Warn("Failed to build message from disk, building it in memory")
+
+		if err := loadAttachmentFiles(&msg, attachmentFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(msg.Size)
+
+	decrypted := message.DecryptMessage(kr, msg.Message, msg.AttData)
+
+	if err := message.BuildRFC822Into(kr, &decrypted, defaultMessageJobOpts(), &buffer); err != nil {
+		b.log.WithError(err).WithField("addrID", addrID).Warn("Failed to build message")
+		b.reporter.ReportError(fmt.Errorf("failed to build message: %w", err), reporter.Context{
+			"msgID":  msg.Message.ID,
+			"userID": b.userID,
+		})
+
+		return &AssembleFailedMessageWriter{decrypted: decrypted}, nil
+	}
+
+	return b.newBuiltWriter(msg, buffer), nil
+}
+
 func defaultMessageJobOpts() message.JobOptions {
 	return message.JobOptions{
 		IgnoreDecryptionErrors: true, // Whether to ignore decryption errors and create a "custom message" instead.
@@ -151,7 +243,12 @@ func chunkMemLimitFullMessage(batch []proton.FullMessage, maxMemory uint64) [][]
 
 	return chunkMemLimit(batch, maxMemory, stageMultiplier, func(message proton.FullMessage) uint64 {
 		var dataSize uint64
-		for _, a := range message.Attachments {
+		for i, a := range message.Attachments {
+			// Attachments downloaded to disk have no data in memory.
+			if i < len(message.AttData) && message.AttData[i] == nil {
+				continue
+			}
+
 			dataSize += uint64(a.Size) //nolint:gosec // we won't overflow.
 		}
 		dataSize += uint64(len(message.Body))
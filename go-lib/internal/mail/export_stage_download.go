@@ -32,15 +32,21 @@ import (
 
 type DownloadStageOutput struct {
 	messages []proton.FullMessage
+	// attachmentFiles holds the attachments of each message which were downloaded to disk, by message ID. The paths are
+	// indexed as the attachments of the message and empty for those kept in memory, whose AttData entry is set.
+	attachmentFiles map[string][]string
 }
 
 type DownloadStage struct {
 	client           apiclient.Client
 	log              *logrus.Entry
 	outputCh         chan DownloadStageOutput
-	parallelWorkers  int
+	parallelWorkers  WorkerLimit
 	maxDownloadMemMB uint64
 	panicHandler     async.PanicHandler
+	streamingDir     string
+	events           EventReporter
+	messageErrors    MessageErrorReporter
 }
 
 func NewDownloadStage(
@@ -54,17 +60,41 @@ func NewDownloadStage(
 		client:           client,
 		log:              log.WithField("stage", "download"),
 		outputCh:         make(chan DownloadStageOutput),
-		parallelWorkers:  parallelWorkers,
+		parallelWorkers:  fixedWorkerLimit(parallelWorkers),
 		panicHandler:     panicHandler,
 		maxDownloadMemMB: maxDownloadMemMB,
+		events:           NullEventReporter{},
+		messageErrors:    NullMessageErrorReporter{},
 	}
 }
 
+// SetWorkerLimit replaces the fixed number of parallel downloads given to the constructor. Must be called before Run.
+func (d *DownloadStage) SetWorkerLimit(limit WorkerLimit) {
+	d.parallelWorkers = limit
+}
+
+// SetStreamingDir makes the stage download the attachments of StreamedAttachmentMinSize or more to temp files in dir
+// rather than in memory. Must be called before Run.
+func (d *DownloadStage) SetStreamingDir(dir string) {
+	d.streamingDir = dir
+}
+
+// SetEventReporter reports the messages which could not be downloaded. Must be called before Run.
+func (d *DownloadStage) SetEventReporter(events EventReporter) {
+	d.events = events
+}
+
+// SetMessageErrorReporter lets the stage skip the messages which fail to download instead of failing. Must be called
+// before Run.
+func (d *DownloadStage) SetMessageErrorReporter(messageErrors MessageErrorReporter) {
+	d.messageErrors = messageErrors
+}
+
 func (d *DownloadStage) Run(ctx context.Context, input <-chan []proton.MessageMetadata, errReporter StageErrorReporter) {
 	d.log.Debug("Starting")
 	defer d.log.Debug("Exiting")
 
-	const Failed422ID = "MsgFailed422"
+	const SkippedID = "MsgSkipped"
 
 	defer close(d.outputCh)
 	for metadata := range input {
@@ -78,33 +108,63 @@ func (d *DownloadStage) Run(ctx context.Context, input <-chan []proton.MessageMe
 				messages: make([]proton.FullMessage, len(chunk)),
 			}
 
-			if err := parallel.DoContext(ctx, d.parallelWorkers, len(chunk), func(ctx context.Context, i int) error {
+			attachmentFiles := make([][]string, len(chunk))
+
+			if err := parallel.DoContext(ctx, d.parallelWorkers.GetWorkerCount(), len(chunk), func(ctx context.Context, i int) error {
 				defer async.HandlePanic(d.panicHandler)
 
-				msg, err := downloadMessageAndAttachments(ctx, d.client, chunk[i])
+				msg, files, err := downloadMessageAndAttachments(ctx, d.client, chunk[i], d.streamingDir)
 				if err != nil {
 					var apiErr *proton.APIError
 					if errors.As(err, &apiErr) && apiErr.Status == 422 {
 						d.log.WithField("msgID", chunk[i].ID).Warn("Failed to download message due to 422")
-						result.messages[i].ID = Failed422ID
+						reportMessageFailed(d.events, "download", FailureDownloadRejected, chunk[i], err)
+						result.messages[i].ID = SkippedID
 						return nil
 					}
 
 					d.log.WithError(err).WithField("msgID", chunk[i].ID).Error("Failed to download message or attachment")
+
+					if ctx.Err() == nil {
+						reportMessageFailed(d.events, "download", FailureDownload, chunk[i], err)
+
+						if d.messageErrors.ReportMessageError(chunk[i], err) {
+							result.messages[i].ID = SkippedID
+							return nil
+						}
+					}
+
 					return err
 				}
 
 				result.messages[i] = msg
+				attachmentFiles[i] = files
 
 				return nil
 			}); err != nil {
+				for _, files := range attachmentFiles {
+					removeAttachmentFiles(files)
+				}
+
 				errReporter.ReportStageError(err)
 				return
 			}
 
-			// Remove any failed 422 downloads.
+			for i, files := range attachmentFiles {
+				if len(files) == 0 {
+					continue
+				}
+
+				if result.attachmentFiles == nil {
+					result.attachmentFiles = make(map[string][]string)
+				}
+
+				result.attachmentFiles[result.messages[i].ID] = files
+			}
+
+			// Remove any failed 422 downloads, and the messages which were skipped.
 			result.messages = xslices.Filter(result.messages, func(t proton.FullMessage) bool {
-				return t.ID != Failed422ID
+				return t.ID != SkippedID
 			})
 
 			select {
@@ -116,10 +176,17 @@ func (d *DownloadStage) Run(ctx context.Context, input <-chan []proton.MessageMe
 	}
 }
 
-func downloadMessageAndAttachments(ctx context.Context, client apiclient.Client, metadata proton.MessageMetadata) (proton.FullMessage, error) {
+// downloadMessageAndAttachments downloads the message and its attachments. If streamingDir is not empty, the attachments
+// which should be streamed are downloaded to files in it, whose paths are returned.
+func downloadMessageAndAttachments(
+	ctx context.Context,
+	client apiclient.Client,
+	metadata proton.MessageMetadata,
+	streamingDir string,
+) (proton.FullMessage, []string, error) {
 	msg, err := client.GetMessage(ctx, metadata.ID)
 	if err != nil {
-		return proton.FullMessage{}, err
+		return proton.FullMessage{}, nil, err
 	}
 
 	full := proton.FullMessage{
@@ -127,16 +194,35 @@ func downloadMessageAndAttachments(ctx context.Context, client apiclient.Client,
 		AttData: nil,
 	}
 
+	var files []string
+
 	if len(msg.Attachments) != 0 {
 		attData := make([][]byte, len(msg.Attachments))
 
 		for i, a := range msg.Attachments {
+			if len(streamingDir) != 0 && isStreamedAttachment(a) {
+				path, err := downloadAttachmentToFile(ctx, client, a.ID, streamingDir)
+				if err != nil {
+					removeAttachmentFiles(files)
+					return proton.FullMessage{}, nil, err
+				}
+
+				if files == nil {
+					files = make([]string, len(msg.Attachments))
+				}
+
+				files[i] = path
+
+				continue
+			}
+
 			buffer := bytes.Buffer{}
 
 			buffer.Grow(int(a.Size))
 
 			if err := client.GetAttachmentInto(ctx, a.ID, &buffer); err != nil {
-				return proton.FullMessage{}, err
+				removeAttachmentFiles(files)
+				return proton.FullMessage{}, nil, err
 			}
 
 			attData[i] = buffer.Bytes()
@@ -145,7 +231,7 @@ func downloadMessageAndAttachments(ctx context.Context, client apiclient.Client,
 		full.AttData = attData
 	}
 
-	return full, nil
+	return full, files, nil
 }
 
 func chunkMemLimitMetadata(batch []proton.MessageMetadata, maxMemory uint64) [][]proton.MessageMetadata {
@@ -21,6 +21,8 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
+	"os"
 	"testing"
 
 	"github.com/ProtonMail/export-tool/internal/apiclient"
@@ -55,7 +57,7 @@ func TestDownloadMessageAndAttachments_NoAttachments(t *testing.T) {
 
 	client.EXPECT().GetMessage(gomock.Any(), gomock.Eq(msgID)).Return(msgData, nil)
 
-	fullMsg, err := downloadMessageAndAttachments(context.Background(), client, metaData)
+	fullMsg, _, err := downloadMessageAndAttachments(context.Background(), client, metaData, "")
 	require.NoError(t, err)
 	require.Equal(t, expected, fullMsg)
 }
@@ -108,11 +110,65 @@ func TestDownloadMessageAndAttachments_WithAttachments(t *testing.T) {
 		return nil
 	})
 
-	fullMsg, err := downloadMessageAndAttachments(context.Background(), client, metaData)
+	fullMsg, _, err := downloadMessageAndAttachments(context.Background(), client, metaData, "")
 	require.NoError(t, err)
 	require.Equal(t, expected, fullMsg)
 }
 
+func TestDownloadMessageAndAttachments_StreamedAttachment(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	client := apiclient.NewMockClient(mockCtrl)
+
+	const msgID = "msgID"
+	const attID1 = "att1"
+	const attID2 = "att2"
+	attData1 := []byte("hello")
+	attData2 := []byte("large attachment")
+
+	metaData := proton.MessageMetadata{
+		ID: msgID,
+	}
+
+	msgData := proton.Message{
+		MessageMetadata: metaData,
+		Attachments: []proton.Attachment{
+			{
+				ID:   attID1,
+				Size: int64(len(attData1)),
+			},
+			{
+				ID:   attID2,
+				Size: StreamedAttachmentMinSize,
+			},
+		},
+	}
+
+	client.EXPECT().GetMessage(gomock.Any(), gomock.Eq(msgID)).Return(msgData, nil)
+	client.EXPECT().GetAttachmentInto(gomock.Any(), gomock.Eq(attID1), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, b *bytes.Buffer) error {
+		_, err := b.Write(attData1)
+		require.NoError(t, err)
+		return nil
+	})
+	client.EXPECT().GetAttachmentInto(gomock.Any(), gomock.Eq(attID2), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, r io.ReaderFrom) error {
+		// A retried download starts over.
+		_, err := r.ReadFrom(bytes.NewReader([]byte("partial")))
+		require.NoError(t, err)
+		_, err = r.ReadFrom(bytes.NewReader(attData2))
+		require.NoError(t, err)
+		return nil
+	})
+
+	fullMsg, files, err := downloadMessageAndAttachments(context.Background(), client, metaData, t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{attData1, nil}, fullMsg.AttData)
+	require.Len(t, files, 2)
+	require.Empty(t, files[0])
+
+	data, err := os.ReadFile(files[1])
+	require.NoError(t, err)
+	require.Equal(t, attData2, data)
+}
+
 func TestDownloadStage_Run(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	client := apiclient.NewMockClient(mockCtrl)
@@ -224,3 +280,45 @@ func TestDownloadStage_RunOtherErrorsReported(t *testing.T) {
 
 	<-stage.outputCh
 }
+
+func TestDownloadStage_RunSkipsMessageErrors(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	client := apiclient.NewMockClient(mockCtrl)
+	errReporter := NewMockStageErrorReporter(mockCtrl)
+	messageErrors := newExportErrReporter(&ExportTask{log: logrus.WithField("test", "test")}, 1)
+	stage := NewDownloadStage(client, 2, logrus.WithField("test", "test"), MinDownloadMemMB, &async.NoopPanicHandler{})
+	stage.SetMessageErrorReporter(messageErrors)
+
+	input := make(chan []proton.MessageMetadata)
+
+	const msgID1 = "msgID1"
+	const msgID2 = "msgID2"
+
+	msgError := errors.New("unexpected error")
+
+	inputMetadata := []proton.MessageMetadata{
+		{
+			ID: msgID1,
+		},
+		{
+			ID: msgID2,
+		},
+	}
+
+	msg2 := proton.Message{MessageMetadata: proton.MessageMetadata{ID: msgID2}}
+
+	client.EXPECT().GetMessage(gomock.Any(), gomock.Eq(msgID1)).Return(proton.Message{}, msgError)
+	client.EXPECT().GetMessage(gomock.Any(), gomock.Eq(msgID2)).Return(msg2, nil)
+
+	go func() {
+		stage.Run(context.Background(), input, errReporter)
+	}()
+
+	input <- inputMetadata
+	close(input)
+
+	result := <-stage.outputCh
+
+	require.Equal(t, []proton.FullMessage{{Message: msg2}}, result.messages)
+	require.Equal(t, inputMetadata[:1], messageErrors.getFailedMessages())
+}
@@ -30,12 +30,19 @@ type MetadataFileChecker interface {
 	HasMessage(msgID string) (bool, error)
 }
 
+// MetadataStateChecker can optionally be implemented by a MetadataFileChecker which needs the full
+// message metadata to decide whether a message has to be exported again.
+type MetadataStateChecker interface {
+	HasMessageState(metadata proton.MessageMetadata) (bool, error)
+}
+
 type MetadataStage struct {
 	client    apiclient.Client
 	log       *logrus.Entry
 	outputCh  chan []proton.MessageMetadata
 	pageSize  int
 	splitSize int
+	filter    *messageFilter
 }
 
 func NewMetadataStage(
@@ -53,6 +60,11 @@ func NewMetadataStage(
 	}
 }
 
+// SetFilter restricts the messages sent to the next stage. Messages which are filtered out are reported as processed.
+func (m *MetadataStage) SetFilter(filter *messageFilter) {
+	m.filter = filter
+}
+
 func (m *MetadataStage) Run(
 	ctx context.Context,
 	errReporter StageErrorReporter,
@@ -67,6 +79,13 @@ func (m *MetadataStage) Run(
 
 	var lastMessageID string
 
+	var apiFilter proton.MessageFilter
+	if m.filter != nil {
+		apiFilter = m.filter.apiFilter()
+	}
+
+	apiFilter.Desc = true
+
 	for {
 		if ctx.Err() != nil {
 			return
@@ -75,10 +94,10 @@ func (m *MetadataStage) Run(
 		var metadata []proton.MessageMetadata
 
 		if lastMessageID != "" {
-			meta, err := client.GetMessageMetadataPage(ctx, 0, m.pageSize, proton.MessageFilter{
-				EndID: lastMessageID,
-				Desc:  true,
-			})
+			filter := apiFilter
+			filter.EndID = lastMessageID
+
+			meta, err := client.GetMessageMetadataPage(ctx, 0, m.pageSize, filter)
 
 			if err != nil {
 				errReporter.ReportStageError(err)
@@ -92,9 +111,7 @@ func (m *MetadataStage) Run(
 
 			metadata = meta
 		} else {
-			meta, err := client.GetMessageMetadataPage(ctx, 0, m.pageSize, proton.MessageFilter{
-				Desc: true,
-			})
+			meta, err := client.GetMessageMetadataPage(ctx, 0, m.pageSize, apiFilter)
 			if err != nil {
 				errReporter.ReportStageError(err)
 				return
@@ -109,9 +126,23 @@ func (m *MetadataStage) Run(
 
 		lastMessageID = metadata[len(metadata)-1].ID
 
+		stateChecker, hasStateChecker := mfc.(MetadataStateChecker)
+
 		initialLen := len(metadata)
 		metadata = xslices.Filter(metadata, func(t proton.MessageMetadata) bool {
-			isPresent, err := mfc.HasMessage(t.ID)
+			if m.filter != nil && !m.filter.matches(t) {
+				return false
+			}
+
+			var isPresent bool
+			var err error
+
+			if hasStateChecker {
+				isPresent, err = stateChecker.HasMessageState(t)
+			} else {
+				isPresent, err = mfc.HasMessage(t.ID)
+			}
+
 			if err != nil {
 				errReporter.ReportStageError(err)
 				return false
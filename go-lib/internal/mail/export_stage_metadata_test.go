@@ -85,6 +85,40 @@ func TestMetadataStage_RunWithCached(t *testing.T) {
 	require.Equal(t, expectedFiltered, result)
 }
 
+func TestMetadataStage_RunWithFilter(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	client := apiclient.NewMockClient(mockCtrl)
+	errReporter := NewMockStageErrorReporter(mockCtrl)
+	fileChecker := NewMockMetadataFileChecker(mockCtrl)
+	reporter := NewMockReporter(mockCtrl)
+
+	const pageSize = 2
+
+	expected := testMetadata(20)
+	for idx := range expected {
+		expected[idx].Unread = idx%2 == 0
+	}
+
+	encodeMetadataExpectations(client, expected, pageSize)
+
+	reporter.EXPECT().OnProgress(1).Times(10)
+	fileChecker.EXPECT().HasMessage(gomock.Any()).Times(10).Return(false, nil)
+
+	metadata := NewMetadataStage(client, logrus.WithField("test", "test"), pageSize, 1)
+	metadata.SetFilter(&messageFilter{unreadOnly: true})
+
+	go func() {
+		metadata.Run(context.Background(), errReporter, fileChecker, reporter)
+	}()
+
+	result := make([]proton.MessageMetadata, 0, 10)
+	for out := range metadata.outputCh {
+		result = append(result, out...)
+	}
+
+	require.Equal(t, xslices.Filter(expected, func(t proton.MessageMetadata) bool { return bool(t.Unread) }), result)
+}
+
 func testMetadata(count int) []proton.MessageMetadata {
 	result := make([]proton.MessageMetadata, count)
 
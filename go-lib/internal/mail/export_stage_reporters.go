@@ -17,6 +17,8 @@
 
 package mail
 
+import "github.com/ProtonMail/go-proton-api"
+
 type StageErrorReporter interface {
 	ReportStageError(err error)
 }
@@ -25,6 +27,18 @@ type NullErrorReporter struct{}
 
 func (n NullErrorReporter) ReportStageError(_ error) {}
 
+// MessageErrorReporter decides whether a stage can leave out a message it failed to process, rather than failing.
+type MessageErrorReporter interface {
+	// ReportMessageError returns true if the stage should carry on without the message.
+	ReportMessageError(metadata proton.MessageMetadata, err error) bool
+}
+
+type NullMessageErrorReporter struct{}
+
+func (n NullMessageErrorReporter) ReportMessageError(proton.MessageMetadata, error) bool {
+	return false
+}
+
 type StageProgressReporter interface {
 	SetMessageProcessed(total uint64)
 	SetMessageTotal(total uint64)
@@ -24,6 +24,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ProtonMail/export-tool/internal/utils"
 	"github.com/ProtonMail/gluon/async"
@@ -40,7 +43,13 @@ type WriteStage struct {
 	panicHandler     async.PanicHandler
 	log              *logrus.Entry
 	progressReporter StageProgressReporter
-	parallelWriters  int
+	parallelWriters  WorkerLimit
+	fileWriter       utils.FileWriter
+	writtenCount     atomic.Int64
+	events           EventReporter
+	messageErrors    MessageErrorReporter
+	layout           *labelFolderLayout
+	createdDirs      sync.Map
 }
 
 func NewWriteStage(
@@ -55,12 +64,43 @@ func NewWriteStage(
 		tempPath:         tempPath,
 		dirPath:          dirPath,
 		panicHandler:     panicHandler,
-		parallelWriters:  parallelWriters,
+		parallelWriters:  fixedWorkerLimit(parallelWriters),
 		progressReporter: progressReporter,
 		log:              log.WithField("stage", "write"),
+		fileWriter:       &utils.DiskFileWriter{},
+		events:           NullEventReporter{},
+		messageErrors:    NullMessageErrorReporter{},
 	}
 }
 
+// SetFileWriter replaces the writer storing the files of the messages, which writes them to disk by default. Must be
+// called before Run.
+func (w *WriteStage) SetFileWriter(fileWriter utils.FileWriter) {
+	w.fileWriter = fileWriter
+}
+
+// SetWorkerLimit replaces the fixed number of parallel writers given to the constructor. The limit is notified of the
+// throughput of each batch if it implements throughputObserver. Must be called before Run.
+func (w *WriteStage) SetWorkerLimit(limit WorkerLimit) {
+	w.parallelWriters = limit
+}
+
+// SetEventReporter reports each message which is written, or which could not be written. Must be called before Run.
+func (w *WriteStage) SetEventReporter(events EventReporter) {
+	w.events = events
+}
+
+// SetMessageErrorReporter lets the stage skip the messages which fail to be written instead of failing. Must be called
+// before Run.
+func (w *WriteStage) SetMessageErrorReporter(messageErrors MessageErrorReporter) {
+	w.messageErrors = messageErrors
+}
+
+// SetLabelLayout stores each message in the folder of its folder label, see ExportLayoutLabels. Must be called before Run.
+func (w *WriteStage) SetLabelLayout(labels []proton.Label) {
+	w.layout = newLabelFolderLayout(labels)
+}
+
 func (w *WriteStage) Run(ctx context.Context, inputs <-chan BuildStageOutput, errReporter StageErrorReporter) {
 	w.log.Debug("Starting")
 	defer w.log.Debug("Exiting")
@@ -70,31 +110,144 @@ func (w *WriteStage) Run(ctx context.Context, inputs <-chan BuildStageOutput, er
 			return
 		}
 
-		if err := parallel.DoContext(ctx, w.parallelWriters, len(input.messages), func(_ context.Context, i int) error {
+		start := time.Now()
+
+		var skipped atomic.Int64
+
+		if err := parallel.DoContext(ctx, w.parallelWriters.GetWorkerCount(), len(input.messages), func(_ context.Context, i int) error {
 			metadata := input.messages[i].GetMetadata()
-			metadataPath := filepath.Join(w.dirPath, getMetadataFileName(metadata.ID))
 
-			integrityChecker := &utils.Sha256IntegrityChecker{}
+			if err := w.writeMessage(input.messages[i], metadata); err != nil {
+				reportMessageFailed(w.events, "write", FailureWrite, metadata.MessageMetadata, err)
+
+				if w.messageErrors.ReportMessageError(metadata.MessageMetadata, err) {
+					skipped.Add(1)
+					return nil
+				}
 
-			metadataBytes, err := metadata.toBytes()
-			if err != nil {
-				w.log.WithField("msg-id", metadata.ID).WithError(err).Error("Failed to generate metadata")
-				return fmt.Errorf("failed to generate message metadata: %w", err)
+				return err
 			}
 
-			if err := utils.WriteFileSafe(w.tempPath, metadataPath, metadataBytes, integrityChecker); err != nil {
-				w.log.WithField("msg-id", metadata.ID).WithError(err).Errorf("Failed to write %v", metadataPath)
-				return fmt.Errorf("failed to write '%v': %w", metadata, err)
+			event := Event{Type: EventMessageExported, Stage: "write", MessageID: metadata.ID}
+			if failure, reason := metadata.WriterType.getFailure(); len(failure) != 0 {
+				event = newFailureEvent(EventMessageExported, "write", failure, metadata.MessageMetadata, errors.New(reason))
 			}
 
-			return input.messages[i].WriteMessage(w.dirPath, w.tempPath, w.log, integrityChecker)
+			w.events.ReportEvent(event)
+
+			return nil
 		}); err != nil {
 			errReporter.ReportStageError(err)
 			return
 		}
 
-		w.progressReporter.OnProgress(len(input.messages))
+		if observer, ok := w.parallelWriters.(throughputObserver); ok {
+			var size uint64
+			for _, msg := range input.messages {
+				size += uint64(msg.GetMetadata().Size) //nolint:gosec // we won't overflow.
+			}
+
+			observer.OnBatchProcessed(size, time.Since(start))
+		}
+
+		written := len(input.messages) - int(skipped.Load())
+
+		w.writtenCount.Add(int64(written))
+		w.progressReporter.OnProgress(written)
+	}
+}
+
+func (w *WriteStage) writeMessage(msg MessageWriter, metadata MessageMetadata) error {
+	integrityChecker := &utils.Sha256IntegrityChecker{}
+
+	if embedded, ok := msg.(embeddedMetadataMessageWriter); ok && embedded.HasEmbeddedMetadata() {
+		return msg.WriteMessage(w.dirPath, w.tempPath, w.log, w.fileWriter, integrityChecker)
+	}
+
+	dir := w.dirPath
+	name := metadata.ID
+
+	if w.layout != nil {
+		dir = w.layout.getFolderDir(w.dirPath, metadata.LabelIDs)
+		name = labelLayoutFileName(metadata.MessageMetadata)
+
+		if err := w.createDir(dir); err != nil {
+			return err
+		}
+
+		if named, ok := msg.(namedMessageWriter); ok {
+			named.setFileName(name)
+		}
+	}
+
+	metadataPath := filepath.Join(dir, getMetadataFileName(name))
+
+	metadataBytes, err := metadata.toBytes()
+	if err != nil {
+		w.log.WithField("msg-id", metadata.ID).WithError(err).Error("Failed to generate metadata")
+		return fmt.Errorf("failed to generate message metadata: %w", err)
+	}
+
+	if err := w.fileWriter.WriteFile(w.tempPath, metadataPath, metadataBytes, integrityChecker); err != nil {
+		w.log.WithField("msg-id", metadata.ID).WithError(err).Errorf("Failed to write %v", metadataPath)
+		return fmt.Errorf("failed to write '%v': %w", metadata, err)
+	}
+
+	if err := msg.WriteMessage(dir, w.tempPath, w.log, w.fileWriter, integrityChecker); err != nil {
+		// Resumed exports and restores would take the metadata without its message for an exported message.
+		if err := utils.RemoveFile(w.fileWriter, metadataPath); err != nil {
+			w.log.WithField("msg-id", metadata.ID).WithError(err).Warn("Failed to remove metadata of message which failed")
+		}
+
+		return err
+	}
+
+	if w.layout != nil {
+		return w.linkLabels(metadata.MessageMetadata, filepath.Join(dir, getEMLFileName(name)))
+	}
+
+	return nil
+}
+
+// linkLabels adds the EML file of a message to the folders of its labels as hard links. Nothing is added if the file
+// system doesn't support them, or if the message was written as a folder, the labels are still in its metadata.
+func (w *WriteStage) linkLabels(metadata proton.MessageMetadata, emlPath string) error {
+	if exists, err := fileExists(emlPath); err != nil || !exists {
+		return nil //nolint:nilerr // the message was written as a folder.
+	}
+
+	for _, path := range w.layout.getLabelPaths(metadata.LabelIDs) {
+		dir := filepath.Join(append([]string{w.dirPath}, path...)...)
+		if err := w.createDir(dir); err != nil {
+			return err
+		}
+
+		if err := os.Link(emlPath, filepath.Join(dir, filepath.Base(emlPath))); err != nil && !os.IsExist(err) {
+			w.log.WithError(err).WithField("msg-id", metadata.ID).Warn("Failed to link message in label folder")
+			return nil
+		}
 	}
+
+	return nil
+}
+
+func (w *WriteStage) createDir(dir string) error {
+	if _, ok := w.createdDirs.Load(dir); ok {
+		return nil
+	}
+
+	if err := w.fileWriter.MkdirAll(dir); err != nil {
+		return fmt.Errorf("failed to create '%v': %w", dir, err)
+	}
+
+	w.createdDirs.Store(dir, struct{}{})
+
+	return nil
+}
+
+// getWrittenCount returns the number of messages written so far.
+func (w *WriteStage) getWrittenCount() int64 {
+	return w.writtenCount.Load()
 }
 
 type MessageMetadata struct {
@@ -129,21 +282,62 @@ const (
 	MessageWriterTypeNoAddrKey
 )
 
+// getFailure explains why a message was exported in parts rather than as a single EML file, if it was.
+func (m MessageWriterType) getFailure() (MessageFailure, string) {
+	switch m {
+	case MessageWriterTypeFailedToAssemble:
+		return FailureAssemble, "the message could not be assembled, its body and attachments were written separately"
+	case MessageWriterTypeNoAddrKey:
+		return FailureAddressKeyMissing, "the key of the address is not available, the message was written encrypted"
+	case MessageWriterTypeDecryptedAndBuilt:
+	}
+
+	return "", ""
+}
+
 type MessageWriter interface {
-	WriteMessage(dir string, tempDir string, log *logrus.Entry, checker utils.IntegrityChecker) error
+	WriteMessage(dir string, tempDir string, log *logrus.Entry, fileWriter utils.FileWriter, checker utils.IntegrityChecker) error
 	GetMetadata() MessageMetadata
 }
 
+// namedMessageWriter is implemented by the writers storing a message in an EML file or a folder, which are named after
+// the ID of the message unless another name is set.
+type namedMessageWriter interface {
+	setFileName(name string)
+}
+
+type messageFileName struct {
+	name string
+}
+
+func (m *messageFileName) setFileName(name string) {
+	m.name = name
+}
+
+func (m *messageFileName) getFileName(msgID string) string {
+	if len(m.name) == 0 {
+		return msgID
+	}
+
+	return m.name
+}
+
+// embeddedMetadataMessageWriter is implemented by the writers whose output format already carries the message metadata.
+// No metadata file is written for them.
+type embeddedMetadataMessageWriter interface {
+	HasEmbeddedMetadata() bool
+}
+
 type DecryptedAndBuiltMessageWriter struct {
+	messageFileName
 	msg proton.FullMessage
 	eml bytes.Buffer
 }
 
-func (d *DecryptedAndBuiltMessageWriter) WriteMessage(dir string, tempDir string, log *logrus.Entry, integrityChecker utils.IntegrityChecker) error {
-	filePath := filepath.Join(dir, d.msg.ID)
-	filePath += emlExtension
+func (d *DecryptedAndBuiltMessageWriter) WriteMessage(dir string, tempDir string, log *logrus.Entry, fileWriter utils.FileWriter, integrityChecker utils.IntegrityChecker) error {
+	filePath := filepath.Join(dir, getEMLFileName(d.getFileName(d.msg.ID)))
 
-	if err := utils.WriteFileSafe(tempDir, filePath, d.eml.Bytes(), integrityChecker); err != nil {
+	if err := fileWriter.WriteFile(tempDir, filePath, d.eml.Bytes(), integrityChecker); err != nil {
 		log.WithField("msg-id", d.msg.ID).WithError(err).Errorf("Failed to write file %v", filePath)
 		return fmt.Errorf("failed to write metadata '%v': %w", filePath, err)
 	}
@@ -156,15 +350,16 @@ func (d *DecryptedAndBuiltMessageWriter) GetMetadata() MessageMetadata {
 }
 
 type AssembleFailedMessageWriter struct {
+	messageFileName
 	decrypted message.DecryptedMessage
 }
 
-func (a *AssembleFailedMessageWriter) WriteMessage(dir string, tempDir string, log *logrus.Entry, integrityChecker utils.IntegrityChecker) error {
+func (a *AssembleFailedMessageWriter) WriteMessage(dir string, tempDir string, log *logrus.Entry, fileWriter utils.FileWriter, integrityChecker utils.IntegrityChecker) error {
 	// Failed to assemble message, write body and attachments in a folder with the message id.
-	exportDir := filepath.Join(dir, a.decrypted.Msg.ID)
+	exportDir := filepath.Join(dir, a.getFileName(a.decrypted.Msg.ID))
 	var bodyPath string
 
-	if err := os.MkdirAll(exportDir, 0o700); err != nil {
+	if err := fileWriter.MkdirAll(exportDir); err != nil {
 		return fmt.Errorf("failed to create '%v': %w", exportDir, err)
 	}
 
@@ -178,7 +373,7 @@ func (a *AssembleFailedMessageWriter) WriteMessage(dir string, tempDir string, l
 		bodyPath = filepath.Join(exportDir, bodyFileNameEncrypted())
 	}
 
-	if err := utils.WriteFileSafe(tempDir, bodyPath, bodyBytes, integrityChecker); err != nil {
+	if err := fileWriter.WriteFile(tempDir, bodyPath, bodyBytes, integrityChecker); err != nil {
 		log.WithField("msg-id", a.decrypted.Msg.ID).WithError(err).Errorf("Failed to write %v", bodyPath)
 		return fmt.Errorf("failed to write '%v': %w", bodyPath, err)
 	}
@@ -197,7 +392,7 @@ func (a *AssembleFailedMessageWriter) WriteMessage(dir string, tempDir string, l
 			attachmentPath = filepath.Join(exportDir, attachmentFileNameEncrypted(attachmentInfo.ID, attachmentInfo.Name))
 		}
 
-		if err := utils.WriteFileSafe(tempDir, attachmentPath, attBytes, integrityChecker); err != nil {
+		if err := fileWriter.WriteFile(tempDir, attachmentPath, attBytes, integrityChecker); err != nil {
 			log.WithField("msg-id", a.decrypted.Msg.ID).WithField("attID", attachmentInfo.ID).WithError(err).Errorf("Failed to write %v", attachmentPath)
 			return fmt.Errorf("failed to write '%v': %w", attachmentPath, err)
 		}
@@ -211,6 +406,7 @@ func (a *AssembleFailedMessageWriter) GetMetadata() MessageMetadata {
 }
 
 type AddrKeyRingMissingMessageWriter struct {
+	messageFileName
 	msg proton.FullMessage
 }
 
@@ -218,18 +414,18 @@ func (a *AddrKeyRingMissingMessageWriter) GetMetadata() MessageMetadata {
 	return NewMessageMetadata(MessageWriterTypeNoAddrKey, &a.msg.Message)
 }
 
-func (a *AddrKeyRingMissingMessageWriter) WriteMessage(dir string, tempDir string, log *logrus.Entry, integrityChecker utils.IntegrityChecker) error {
+func (a *AddrKeyRingMissingMessageWriter) WriteMessage(dir string, tempDir string, log *logrus.Entry, fileWriter utils.FileWriter, integrityChecker utils.IntegrityChecker) error {
 	// Failed decrypt due to lack of addr keyring. Write everything as pgp files to disk.
-	exportDir := filepath.Join(dir, a.msg.ID)
+	exportDir := filepath.Join(dir, a.getFileName(a.msg.ID))
 
-	if err := os.MkdirAll(exportDir, 0o700); err != nil {
+	if err := fileWriter.MkdirAll(exportDir); err != nil {
 		return fmt.Errorf("failed to create '%v': %w", exportDir, err)
 	}
 
 	// write body.
 	bodyPath := filepath.Join(exportDir, bodyFileNameEncrypted())
 
-	if err := utils.WriteFileSafe(tempDir, bodyPath, []byte(a.msg.Body), integrityChecker); err != nil {
+	if err := fileWriter.WriteFile(tempDir, bodyPath, []byte(a.msg.Body), integrityChecker); err != nil {
 		log.WithField("msg-id", a.msg.ID).WithError(err).Errorf("Failed to write %v", bodyPath)
 		return fmt.Errorf("failed to write '%v': %w", bodyPath, err)
 	}
@@ -238,7 +434,7 @@ func (a *AddrKeyRingMissingMessageWriter) WriteMessage(dir string, tempDir strin
 	for idx, attachment := range a.msg.Attachments {
 		attachmentPath := filepath.Join(exportDir, attachmentFileNameEncrypted(attachment.ID, attachment.Name))
 
-		if err := utils.WriteFileSafe(tempDir, attachmentPath, a.msg.AttData[idx], integrityChecker); err != nil {
+		if err := fileWriter.WriteFile(tempDir, attachmentPath, a.msg.AttData[idx], integrityChecker); err != nil {
 			log.WithField("msg-id", a.msg.ID).WithField("attID", attachment.ID).WithError(err).Errorf("Failed to write %v", attachmentPath)
 			return fmt.Errorf("failed to write '%v': %w", attachmentPath, err)
 		}
@@ -279,8 +475,8 @@ func NewFileMetadataFileChecker(exportDir string) *FileMetadataFileChecker {
 	return &FileMetadataFileChecker{exportDir: exportDir}
 }
 
-func loadMetadataFile(metadataFilePath string) (MessageMetadata, error) {
-	b, err := os.ReadFile(metadataFilePath) //nolint:gosec
+func loadMetadataFile(metadataFilePath string, decrypter utils.FileDecrypter) (MessageMetadata, error) {
+	b, err := utils.ReadFileDecrypted(metadataFilePath, decrypter)
 	if err != nil {
 		return MessageMetadata{}, fmt.Errorf("failed to read metada file: %w", err)
 	}
@@ -333,12 +529,17 @@ func (f FileMetadataFileChecker) HasMessage(msgID string) (bool, error) {
 	dirPath := filepath.Join(f.exportDir, msgID)
 
 	// check if metadata file exists.
-	metadata, err := loadMetadataFile(metadataPath)
+	metadata, err := loadMetadataFile(metadataPath, nil)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return false, nil
 		}
 
+		if errors.Is(err, utils.ErrFileEncrypted) {
+			// The export is encrypted to a key we can't decrypt with, the message parts can't be listed.
+			return fileExists(messagePath)
+		}
+
 		if errors.Is(err, utils.ErrVersionDoesNotMatch) {
 			// Version doesn't match, need to re-fetch.
 			return false, nil
@@ -19,16 +19,20 @@ package mail
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/gluon/async"
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/proton-bridge/v3/pkg/message"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
 )
 
 func TestAddrKeyRingMissingMessageWriter(t *testing.T) {
@@ -65,7 +69,7 @@ func TestAddrKeyRingMissingMessageWriter(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	checker := &utils.Sha256IntegrityChecker{}
-	require.NoError(t, writer.WriteMessage(writeDir, tmpDir, logrus.WithField("t", "t"), checker))
+	require.NoError(t, writer.WriteMessage(writeDir, tmpDir, logrus.WithField("t", "t"), &utils.DiskFileWriter{}, checker))
 
 	{
 		data, err := os.ReadFile(filepath.Join(writeDir, msg.ID, attachmentFileNameEncrypted(attID, "foo")))
@@ -129,7 +133,7 @@ func TestAssembleFailedMessageWriter_NotDecrypted(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	checker := &utils.Sha256IntegrityChecker{}
-	require.NoError(t, writer.WriteMessage(writeDir, tmpDir, logrus.WithField("t", "t"), checker))
+	require.NoError(t, writer.WriteMessage(writeDir, tmpDir, logrus.WithField("t", "t"), &utils.DiskFileWriter{}, checker))
 
 	{
 		data, err := os.ReadFile(filepath.Join(writeDir, msg.ID, attachmentFileNameEncrypted(attID, "foo")))
@@ -193,7 +197,7 @@ func TestAssembleFailedMessageWriter_AllDecrypted(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	checker := &utils.Sha256IntegrityChecker{}
-	require.NoError(t, writer.WriteMessage(writeDir, tmpDir, logrus.WithField("t", "t"), checker))
+	require.NoError(t, writer.WriteMessage(writeDir, tmpDir, logrus.WithField("t", "t"), &utils.DiskFileWriter{}, checker))
 
 	{
 		data, err := os.ReadFile(filepath.Join(writeDir, msg.ID, attachmentFileName(attID, "foo")))
@@ -377,3 +381,142 @@ func getTestMessageMetadata() MessageMetadata {
 		WriterType: 0,
 	}
 }
+
+func TestFileMetadataFileChecker_HasMessage_EncryptedMetadata(t *testing.T) {
+	const messageID = "msg-1"
+	dir := t.TempDir()
+	checker := NewFileMetadataFileChecker(dir)
+	cipher := utils.NewPGPPasswordFileCipher([]byte("secret"))
+
+	metadata, err := utils.GenerateVersionedJSON(MessageMetadataVersion, getTestMessageMetadata())
+	require.NoError(t, err)
+
+	metadataPath := filepath.Join(dir, getMetadataFileName(messageID))
+	require.NoError(t, utils.WriteEncryptedFileSafe(dir, metadataPath, metadata, cipher, nil))
+
+	// The attachments of the message can't be listed, the message needs to be exported again.
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, messageID), 0o700))
+
+	hasMessage, err := checker.HasMessage(messageID)
+	require.NoError(t, err)
+	require.False(t, hasMessage)
+
+	emlFile := filepath.Join(dir, getEMLFileName(messageID))
+	require.NoError(t, utils.WriteEncryptedFileSafe(dir, emlFile, []byte("Subject: hello\r\n\r\n"), cipher, nil))
+
+	hasMessage, err = checker.HasMessage(messageID)
+	require.NoError(t, err)
+	require.True(t, hasMessage)
+}
+
+func TestWriteStage_LabelLayout(t *testing.T) {
+	exportDir := t.TempDir()
+	messages := writeTestLabelLayoutExport(t, exportDir)
+
+	inbox := filepath.Join(exportDir, "Inbox", getEMLFileName(labelLayoutFileName(messages[0])))
+	require.FileExists(t, inbox)
+	require.FileExists(t, emlToMetadataFilename(inbox))
+
+	// The ID of the message is kept in its metadata.
+	metadata, err := loadMetadataFile(emlToMetadataFilename(inbox), nil)
+	require.NoError(t, err)
+	require.Equal(t, messages[0].ID, metadata.ID)
+
+	// Labels are hard links to the file of the message.
+	link := filepath.Join(exportDir, labelLayoutLabelsDir, "Important", getEMLFileName(labelLayoutFileName(messages[0])))
+	inboxInfo, err := os.Stat(inbox)
+	require.NoError(t, err)
+	linkInfo, err := os.Stat(link)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(inboxInfo, linkInfo))
+
+	require.FileExists(t, filepath.Join(exportDir, labelLayoutFoldersDir, "Work", "Projects", getEMLFileName(labelLayoutFileName(messages[1]))))
+	require.FileExists(t, filepath.Join(exportDir, "Inbox", labelLayoutFileName(messages[2]), "body.pgp"))
+	require.FileExists(t, filepath.Join(exportDir, "All Mail", getEMLFileName(labelLayoutFileName(messages[3]))))
+
+	// Nothing is written at the root of the export but the labels.
+	entries, err := os.ReadDir(exportDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+}
+
+func TestWriteStage_FailedMessageHasNoMetadata(t *testing.T) {
+	exportDir := t.TempDir()
+	messageErrors := newExportErrReporter(&ExportTask{log: logrus.WithField("test", "test")}, 1)
+
+	stage := NewWriteStage(t.TempDir(), exportDir, 1, logrus.WithField("test", "test"), NullProgressReporter{}, &async.NoopPanicHandler{})
+	stage.SetMessageErrorReporter(messageErrors)
+
+	failing := &failingMessageWriter{metadata: MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: "failing"}}}
+
+	inputs := make(chan BuildStageOutput, 1)
+	inputs <- BuildStageOutput{messages: []MessageWriter{failing}}
+	close(inputs)
+
+	stage.Run(context.Background(), inputs, NewMockStageErrorReporter(gomock.NewController(t)))
+
+	require.Equal(t, []proton.MessageMetadata{failing.metadata.MessageMetadata}, messageErrors.getFailedMessages())
+	require.NoFileExists(t, filepath.Join(exportDir, getMetadataFileName("failing")))
+}
+
+type failingMessageWriter struct {
+	metadata MessageMetadata
+}
+
+func (f *failingMessageWriter) WriteMessage(string, string, *logrus.Entry, utils.FileWriter, utils.IntegrityChecker) error {
+	return errors.New("disk full")
+}
+
+func (f *failingMessageWriter) GetMetadata() MessageMetadata {
+	return f.metadata
+}
+
+// writeTestLabelLayoutExport writes an export with the label layout: a message in the inbox with a label, one in a
+// sub-folder, one which could not be decrypted and one in no folder.
+func writeTestLabelLayoutExport(t *testing.T, exportDir string) []proton.MessageMetadata {
+	labels := []proton.Label{
+		{ID: proton.InboxLabel, Name: "Inbox", Type: proton.LabelTypeSystem},
+		{ID: proton.AllMailLabel, Name: "All Mail", Type: proton.LabelTypeSystem},
+		{ID: "work", Name: "Work", Type: proton.LabelTypeFolder},
+		{ID: "projects", Name: "Projects", ParentID: "work", Type: proton.LabelTypeFolder},
+		{ID: "important", Name: "Important", Type: proton.LabelTypeLabel},
+	}
+
+	labelData, err := utils.GenerateVersionedJSON(LabelMetadataVersion, labels)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, getLabelFileName()), labelData, 0o600))
+
+	messages := []proton.MessageMetadata{
+		{ID: "inbox", Subject: "Hello", Time: 1700000000, LabelIDs: []string{proton.InboxLabel, proton.AllMailLabel, "important"}},
+		{ID: "projects", Subject: "Plans", Time: 1700000100, LabelIDs: []string{"projects", proton.AllMailLabel}},
+		{ID: "nokey", Subject: "Hello", Time: 1700000000, LabelIDs: []string{proton.InboxLabel}},
+		{ID: "all-mail", Time: 1700000200, LabelIDs: []string{proton.AllMailLabel}},
+	}
+
+	var writers []MessageWriter
+
+	for i, metadata := range messages {
+		msg := proton.FullMessage{Message: proton.Message{MessageMetadata: metadata, Body: "body"}}
+
+		if metadata.ID == "nokey" {
+			writers = append(writers, &AddrKeyRingMissingMessageWriter{msg: msg})
+			continue
+		}
+
+		writer := &DecryptedAndBuiltMessageWriter{msg: msg}
+		writer.eml.WriteString(fmt.Sprintf("Subject: %v\r\n\r\nbody %v\r\n", metadata.Subject, i))
+		writers = append(writers, writer)
+	}
+
+	stage := NewWriteStage(t.TempDir(), exportDir, 1, logrus.WithField("test", "test"), NullProgressReporter{}, &async.NoopPanicHandler{})
+	stage.SetLabelLayout(labels)
+
+	inputs := make(chan BuildStageOutput, 1)
+	inputs <- BuildStageOutput{messages: writers}
+	close(inputs)
+
+	// No stage error is expected.
+	stage.Run(context.Background(), inputs, NewMockStageErrorReporter(gomock.NewController(t)))
+
+	return messages
+}
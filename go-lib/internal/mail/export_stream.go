@@ -0,0 +1,312 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/gluon/rfc822"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/proton-bridge/v3/pkg/message"
+	"github.com/emersion/go-textwrapper"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// StreamedAttachmentMinSize is the size from which attachments are downloaded to disk and decrypted into the message
+// while it is written, so that the memory used by the export doesn't depend on the size of the messages.
+const StreamedAttachmentMinSize = 4 * MB
+
+// Attachments are stored in base64 with lines of 76 characters, the size of the placeholder is chosen so that its
+// encoding takes a single line.
+const streamedPlaceholderSize = 48
+
+var errStreamedPlaceholderNotFound = errors.New("attachment placeholder not found in built message")
+
+// Embedded messages are not base64 encoded by the message builder, they are always kept in memory.
+func isStreamedAttachment(att proton.Attachment) bool {
+	return att.Size >= StreamedAttachmentMinSize && att.MIMEType != rfc822.MessageRFC822
+}
+
+// attachmentFile restarts from the beginning of the file every time an attachment is downloaded into it, so that the
+// data is not duplicated when the request is retried.
+type attachmentFile struct {
+	*os.File
+}
+
+func (f attachmentFile) ReadFrom(r io.Reader) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(f.File, r)
+}
+
+func downloadAttachmentToFile(ctx context.Context, client apiclient.Client, attachmentID, dir string) (string, error) {
+	file, err := os.CreateTemp(dir, "attachment-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create attachment file: %w", err)
+	}
+
+	if err := client.GetAttachmentInto(ctx, attachmentID, attachmentFile{File: file}); err != nil {
+		removeAttachmentFiles([]string{file.Name()})
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		removeAttachmentFiles([]string{file.Name()})
+		return "", fmt.Errorf("failed to close attachment file: %w", err)
+	}
+
+	return file.Name(), nil
+}
+
+func removeAttachmentFiles(files []string) {
+	for _, path := range files {
+		if len(path) == 0 {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.WithError(err).WithField("path", path).Warn("Failed to remove attachment file")
+		}
+	}
+}
+
+// loadAttachmentFiles reads the attachments which were downloaded to disk into the AttData of the message, for the
+// messages which can't be built from disk.
+func loadAttachmentFiles(msg *proton.FullMessage, files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	attData := slices.Clone(msg.AttData)
+
+	for i, path := range files {
+		if len(path) == 0 {
+			continue
+		}
+
+		data, err := os.ReadFile(path) //nolint:gosec
+		if err != nil {
+			return fmt.Errorf("failed to read attachment file: %w", err)
+		}
+
+		attData[i] = data
+	}
+
+	msg.AttData = attData
+
+	return nil
+}
+
+type streamedAttachment struct {
+	index       int
+	placeholder []byte // base64 encoded.
+	offset      int    // in the built message.
+}
+
+// buildStreamedMessage builds the message into a file in dir, decrypting the attachments which were downloaded to disk
+// as it is written. The message is first built in memory with a random placeholder for each of these attachments, which
+// is then replaced with the attachment encoded as the message builder would have done, so that the result is identical
+// to a message built in memory.
+func buildStreamedMessage(kr *crypto.KeyRing, msg proton.FullMessage, files []string, dir string) (*StreamedMessageWriter, error) {
+	decrypted := message.DecryptMessage(kr, msg.Message, msg.AttData)
+	if decrypted.BodyErr != nil {
+		return nil, decrypted.BodyErr
+	}
+
+	var attachments []streamedAttachment
+
+	for i, path := range files {
+		if len(path) == 0 {
+			continue
+		}
+
+		placeholder := make([]byte, streamedPlaceholderSize)
+		if _, err := rand.Read(placeholder); err != nil {
+			return nil, fmt.Errorf("failed to generate attachment placeholder: %w", err)
+		}
+
+		decrypted.Attachments[i].Err = nil
+		decrypted.Attachments[i].Data.Reset()
+		decrypted.Attachments[i].Data.Write(placeholder)
+
+		attachments = append(attachments, streamedAttachment{
+			index:       i,
+			placeholder: []byte(base64.StdEncoding.EncodeToString(placeholder)),
+		})
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(len(msg.Body))
+
+	if err := message.BuildRFC822Into(kr, &decrypted, defaultMessageJobOpts(), &buffer); err != nil {
+		return nil, err
+	}
+
+	eml := buffer.Bytes()
+
+	for i := range attachments {
+		attachments[i].offset = bytes.Index(eml, attachments[i].placeholder)
+		if attachments[i].offset < 0 {
+			return nil, errStreamedPlaceholderNotFound
+		}
+	}
+
+	slices.SortFunc(attachments, func(a, b streamedAttachment) bool {
+		return a.offset < b.offset
+	})
+
+	file, err := os.CreateTemp(dir, "export-tool-*"+emlExtension)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message file: %w", err)
+	}
+
+	hasher := sha256.New()
+	fileBuffer := bufio.NewWriter(file)
+
+	if err := writeStreamedMessage(io.MultiWriter(fileBuffer, hasher), kr, msg.Attachments, files, eml, attachments); err != nil {
+		_ = file.Close()
+		removeAttachmentFiles([]string{file.Name()})
+
+		return nil, err
+	}
+
+	if err := fileBuffer.Flush(); err != nil {
+		_ = file.Close()
+		removeAttachmentFiles([]string{file.Name()})
+
+		return nil, fmt.Errorf("failed to write message: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		removeAttachmentFiles([]string{file.Name()})
+		return nil, fmt.Errorf("failed to close message file: %w", err)
+	}
+
+	msg.AttData = nil
+
+	return &StreamedMessageWriter{msg: msg, emlPath: file.Name(), hash: hasher.Sum(nil)}, nil
+}
+
+func writeStreamedMessage(
+	w io.Writer,
+	kr *crypto.KeyRing,
+	attachmentInfo []proton.Attachment,
+	files []string,
+	eml []byte,
+	attachments []streamedAttachment,
+) error {
+	var written int
+
+	for _, attachment := range attachments {
+		if _, err := w.Write(eml[written:attachment.offset]); err != nil {
+			return fmt.Errorf("failed to write message: %w", err)
+		}
+
+		if err := writeStreamedAttachment(w, kr, attachmentInfo[attachment.index], files[attachment.index]); err != nil {
+			return fmt.Errorf("failed to write attachment %v: %w", attachmentInfo[attachment.index].ID, err)
+		}
+
+		written = attachment.offset + len(attachment.placeholder)
+	}
+
+	if _, err := w.Write(eml[written:]); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+
+	return nil
+}
+
+// writeStreamedAttachment decrypts the attachment file into w, in base64 with the line length of the message writer.
+func writeStreamedAttachment(w io.Writer, kr *crypto.KeyRing, att proton.Attachment, path string) error {
+	keyPackets, err := base64.StdEncoding.DecodeString(att.KeyPackets)
+	if err != nil {
+		return fmt.Errorf("failed to decode key packets: %w", err)
+	}
+
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck
+
+	stream, err := kr.DecryptStream(io.MultiReader(bytes.NewReader(keyPackets), file), nil, crypto.GetUnixTime())
+	if err != nil {
+		return err
+	}
+
+	encoder := base64.NewEncoder(base64.StdEncoding, textwrapper.NewRFC822(w))
+
+	if _, err := io.Copy(encoder, stream); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// StreamedMessageWriter writes a message which was built into a temp file, see buildStreamedMessage.
+type StreamedMessageWriter struct {
+	messageFileName
+	msg     proton.FullMessage
+	emlPath string
+	hash    []byte
+}
+
+func (s *StreamedMessageWriter) WriteMessage(dir string, _ string, log *logrus.Entry, fileWriter utils.FileWriter, integrityChecker utils.IntegrityChecker) error {
+	filePath := filepath.Join(dir, getEMLFileName(s.getFileName(s.msg.ID)))
+
+	// The checker can only be initialized with the hash computed while the message was built.
+	var checker utils.IntegrityChecker
+
+	if sha256Checker, ok := integrityChecker.(*utils.Sha256IntegrityChecker); ok {
+		sha256Checker.InitializeHash(s.hash)
+		checker = sha256Checker
+	}
+
+	if err := utils.MoveFile(fileWriter, s.emlPath, filePath, checker); err != nil {
+		removeAttachmentFiles([]string{s.emlPath})
+
+		log.WithField("msg-id", s.msg.ID).WithError(err).Errorf("Failed to write file %v", filePath)
+		return fmt.Errorf("failed to write message '%v': %w", filePath, err)
+	}
+
+	return nil
+}
+
+func (s *StreamedMessageWriter) GetMetadata() MessageMetadata {
+	return NewMessageMetadata(MessageWriterTypeDecryptedAndBuilt, &s.msg.Message)
+}
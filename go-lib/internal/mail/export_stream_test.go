@@ -0,0 +1,139 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/ProtonMail/proton-bridge/v3/pkg/message"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildStreamedMessage(t *testing.T) {
+	key, err := crypto.GenerateKey("test", "test@proton.me", "x25519", 0)
+	require.NoError(t, err)
+
+	kr, err := crypto.NewKeyRing(key)
+	require.NoError(t, err)
+
+	body, err := kr.Encrypt(crypto.NewPlainMessageFromString("Hello"), nil)
+	require.NoError(t, err)
+
+	armoredBody, err := body.GetArmored()
+	require.NoError(t, err)
+
+	msg := proton.FullMessage{
+		Message: proton.Message{
+			MessageMetadata: proton.MessageMetadata{ID: "msg", Subject: "Streamed", Time: 1700000000},
+			Body:            armoredBody,
+			MIMEType:        "text/plain",
+		},
+	}
+
+	dir := t.TempDir()
+	files := make([]string, 3)
+
+	// The sizes end on a full base64 line, in the middle of one and below the streaming threshold.
+	for i, size := range []int{57 * 100, 10000, 100} {
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		split, err := kr.EncryptAttachment(crypto.NewPlainMessage(data), "file.bin")
+		require.NoError(t, err)
+
+		disposition := proton.AttachmentDisposition
+		if i == 1 {
+			disposition = proton.InlineDisposition
+		}
+
+		msg.Attachments = append(msg.Attachments, proton.Attachment{
+			ID:          "att" + string(rune('0'+i)),
+			Name:        "file.bin",
+			Size:        int64(size),
+			MIMEType:    "application/octet-stream",
+			Disposition: disposition,
+			KeyPackets:  base64.StdEncoding.EncodeToString(split.KeyPacket),
+		})
+		msg.AttData = append(msg.AttData, split.DataPacket)
+
+		if i < 2 {
+			files[i] = filepath.Join(dir, msg.Attachments[i].ID)
+			require.NoError(t, os.WriteFile(files[i], split.DataPacket, 0o600))
+		}
+	}
+
+	var expected bytes.Buffer
+
+	decrypted := message.DecryptMessage(kr, msg.Message, msg.AttData)
+	require.NoError(t, message.BuildRFC822Into(kr, &decrypted, defaultMessageJobOpts(), &expected))
+
+	streamedMsg := msg
+	streamedMsg.AttData = [][]byte{nil, nil, msg.AttData[2]}
+
+	writer, err := buildStreamedMessage(kr, streamedMsg, files, dir)
+	require.NoError(t, err)
+
+	eml, err := os.ReadFile(writer.emlPath)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), string(eml))
+
+	exportDir := filepath.Join(dir, "export")
+	require.NoError(t, os.Mkdir(exportDir, 0o700))
+
+	fileWriter := newManifestFileWriter(&utils.DiskFileWriter{}, exportDir)
+	require.NoError(t, writer.WriteMessage(exportDir, dir, logrus.WithField("test", "test"), fileWriter, &utils.Sha256IntegrityChecker{}))
+	require.NoFileExists(t, writer.emlPath)
+
+	written, err := os.ReadFile(filepath.Join(exportDir, getEMLFileName("msg")))
+	require.NoError(t, err)
+	require.Equal(t, expected.Bytes(), written)
+
+	hash, err := hashFile(filepath.Join(exportDir, getEMLFileName("msg")))
+	require.NoError(t, err)
+	require.Equal(t, []ExportManifestFile{{Path: "msg.eml", SHA256: hex.EncodeToString(hash)}}, fileWriter.getFiles())
+}
+
+func TestLoadAttachmentFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attachment")
+	require.NoError(t, os.WriteFile(path, []byte("streamed"), 0o600))
+
+	msg := proton.FullMessage{AttData: [][]byte{[]byte("memory"), nil}}
+	require.NoError(t, loadAttachmentFiles(&msg, []string{"", path}))
+	require.Equal(t, [][]byte{[]byte("memory"), []byte("streamed")}, msg.AttData)
+}
+
+func TestChunkMemLimitFullMessage_StreamedAttachments(t *testing.T) {
+	msg := proton.FullMessage{
+		Message: proton.Message{Attachments: []proton.Attachment{{Size: 2 * MB}, {Size: 2 * MB}}},
+		AttData: [][]byte{nil, nil},
+	}
+
+	// Both messages fit in a single chunk since their attachments are on disk.
+	require.Len(t, chunkMemLimitFullMessage([]proton.FullMessage{msg, msg}, 4*MB), 1)
+}
@@ -16,3 +16,120 @@
 // along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
 
 package mail
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindResumableExportDir(t *testing.T) {
+	userDir := t.TempDir()
+
+	_, err := findResumableExportDir(userDir)
+	require.ErrorIs(t, err, ErrNoResumableExport)
+
+	_, err = findResumableExportDir(filepath.Join(userDir, "missing"))
+	require.ErrorIs(t, err, ErrNoResumableExport)
+
+	older := filepath.Join(userDir, "mail_20240101_120000")
+	newer := filepath.Join(userDir, "mail_20240102_080000")
+	require.NoError(t, os.MkdirAll(older, 0o700))
+	require.NoError(t, os.MkdirAll(newer, 0o700))
+	require.NoError(t, os.MkdirAll(filepath.Join(userDir, "not_an_export"), 0o700))
+
+	dir, err := findResumableExportDir(userDir)
+	require.NoError(t, err)
+	require.Equal(t, newer, dir)
+
+	dir, err = findResumableExportDir(older)
+	require.NoError(t, err)
+	require.Equal(t, older, dir)
+
+	// A renamed export directory is still accepted as long as it contains the label file.
+	renamed := filepath.Join(userDir, "my_export")
+	require.NoError(t, os.MkdirAll(renamed, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(renamed, getLabelFileName()), []byte("{}"), 0o600))
+
+	dir, err = findResumableExportDir(renamed)
+	require.NoError(t, err)
+	require.Equal(t, renamed, dir)
+}
+
+func TestExportErrReporter_ErrorBudget(t *testing.T) {
+	export := &ExportTask{log: logrus.WithField("test", "test")}
+
+	strict := newExportErrReporter(export, 0)
+	require.False(t, strict.ReportMessageError(proton.MessageMetadata{ID: "a"}, errors.New("failed")))
+	require.Empty(t, strict.getFailedMessages())
+
+	tolerant := newExportErrReporter(export, 2)
+	require.True(t, tolerant.ReportMessageError(proton.MessageMetadata{ID: "a"}, errors.New("failed")))
+	require.True(t, tolerant.ReportMessageError(proton.MessageMetadata{ID: "b"}, errors.New("failed")))
+	require.False(t, tolerant.ReportMessageError(proton.MessageMetadata{ID: "c"}, errors.New("failed")))
+	require.Equal(t, []proton.MessageMetadata{{ID: "a"}, {ID: "b"}}, tolerant.getFailedMessages())
+
+	require.Error(t, export.SetErrorBudget(-1))
+	require.NoError(t, export.SetErrorBudget(10))
+}
+
+func TestExportTask_LabelLayoutRejectsEncryption(t *testing.T) {
+	encrypter := utils.NewPGPPasswordFileCipher([]byte("passphrase"))
+
+	encrypted := &ExportTask{log: logrus.WithField("test", "test")}
+	require.NoError(t, encrypted.SetEncrypter(encrypter))
+	require.ErrorIs(t, encrypted.SetLayout(ExportLayoutLabels), ErrUnsupportedExportFormat)
+	require.NoError(t, encrypted.SetLayout(ExportLayoutFlat))
+
+	labels := &ExportTask{log: logrus.WithField("test", "test")}
+	require.NoError(t, labels.SetLayout(ExportLayoutLabels))
+	require.ErrorIs(t, labels.SetEncrypter(encrypter), ErrUnsupportedExportFormat)
+}
+
+func TestExportTask_IncrementalRejectsFilter(t *testing.T) {
+	incremental := &ExportTask{
+		log:         logrus.WithField("test", "test"),
+		incremental: NewIncrementalMetadataFileChecker("", nil, "generation"),
+	}
+
+	require.ErrorIs(t, incremental.SetFilter(ExportFilter{UnreadOnly: true}), ErrUnsupportedExportFormat)
+	require.NoError(t, incremental.SetFilter(ExportFilter{}))
+
+	full := &ExportTask{log: logrus.WithField("test", "test")}
+	require.NoError(t, full.SetFilter(ExportFilter{UnreadOnly: true}))
+}
+
+func TestExportTask_EncryptedFailuresHaveNoSubject(t *testing.T) {
+	dir := t.TempDir()
+	const subject = "Confidential subject"
+
+	events, err := NewJSONEventFile(filepath.Join(dir, "events.json"))
+	require.NoError(t, err)
+
+	export := &ExportTask{log: logrus.WithField("test", "test"), exportDir: filepath.Join(dir, "mail"), events: events}
+	require.NoError(t, export.SetEncrypter(utils.NewPGPPasswordFileCipher([]byte("passphrase"))))
+
+	report := &FailureReport{}
+	export.collectFailures(report)
+
+	reportMessageFailed(export.events, "download", FailureDownload, proton.MessageMetadata{ID: "msg", Subject: subject}, errors.New("failed"))
+	require.NoError(t, events.Close())
+	require.NotEmpty(t, writeFailureReport(report, export.getFailureReportPath()))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		require.Contains(t, string(data), "msg")
+		require.NotContains(t, string(data), subject, entry.Name())
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/sirupsen/logrus"
+)
+
+// FailureReportEntry is a message which did not make it into the backup or the account, or only partially.
+type FailureReportEntry struct {
+	MessageID string
+	Subject   string
+	Stage     string
+	Failure   MessageFailure
+	Code      int `json:",omitempty"` // API error code, if the API rejected the message.
+	Reason    string
+}
+
+// FailureReport collects the failed messages from the events of a task. The report is written as CSV if its path
+// ends with .csv and as JSON otherwise. Only the last outcome of the messages which were retried is kept.
+type FailureReport struct {
+	lock    sync.Mutex
+	entries []FailureReportEntry
+	failed  map[string]struct{}
+}
+
+func (f *FailureReport) ReportEvent(event Event) {
+	if len(event.MessageID) == 0 {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.failed[event.MessageID]; ok {
+		f.entries = xslices.Filter(f.entries, func(entry FailureReportEntry) bool {
+			return entry.MessageID != event.MessageID
+		})
+
+		delete(f.failed, event.MessageID)
+	}
+
+	if len(event.Failure) == 0 {
+		return
+	}
+
+	if f.failed == nil {
+		f.failed = make(map[string]struct{})
+	}
+
+	f.failed[event.MessageID] = struct{}{}
+
+	f.entries = append(f.entries, FailureReportEntry{
+		MessageID: event.MessageID,
+		Subject:   event.Subject,
+		Stage:     event.Stage,
+		Failure:   event.Failure,
+		Code:      event.Code,
+		Reason:    event.Reason,
+	})
+}
+
+func (f *FailureReport) GetEntries() []FailureReportEntry {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]FailureReportEntry(nil), f.entries...)
+}
+
+// Write writes the report at path, replacing any previous report.
+func (f *FailureReport) Write(path string) error {
+	entries := f.GetEntries()
+
+	var data []byte
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		var builder strings.Builder
+
+		writer := csv.NewWriter(&builder)
+
+		records := [][]string{{"MessageID", "Subject", "Stage", "Failure", "Code", "Reason"}}
+		for _, entry := range entries {
+			code := ""
+			if entry.Code != 0 {
+				code = strconv.Itoa(entry.Code)
+			}
+
+			records = append(records, []string{entry.MessageID, entry.Subject, entry.Stage, string(entry.Failure), code, entry.Reason})
+		}
+
+		if err := writer.WriteAll(records); err != nil {
+			return fmt.Errorf("failed to encode failure report: %w", err)
+		}
+
+		data = []byte(builder.String())
+	} else {
+		var err error
+		if data, err = json.MarshalIndent(entries, "", "  "); err != nil {
+			return fmt.Errorf("failed to encode failure report: %w", err)
+		}
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write failure report: %w", err)
+	}
+
+	return nil
+}
+
+// withFailureReport makes the events reported to the returned reporter be collected in report too.
+func withFailureReport(events EventReporter, report *FailureReport) EventReporter {
+	if _, ok := events.(NullEventReporter); ok {
+		return report
+	}
+
+	return teeEventReporter{events, report}
+}
+
+// teeEventReporter forwards the events to several reporters.
+type teeEventReporter []EventReporter
+
+func (t teeEventReporter) ReportEvent(event Event) {
+	for _, events := range t {
+		events.ReportEvent(event)
+	}
+}
+
+// writeFailureReport writes the report at path if it has any entry and returns the path, or an empty string if
+// nothing was written.
+func writeFailureReport(report *FailureReport, path string) string {
+	if len(report.GetEntries()) == 0 {
+		return ""
+	}
+
+	if err := report.Write(path); err != nil {
+		logrus.WithError(err).WithField("path", path).Error("Failed to write failure report")
+		return ""
+	}
+
+	logrus.WithField("path", path).Info("Failure report written")
+
+	return path
+}
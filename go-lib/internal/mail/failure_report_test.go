@@ -0,0 +1,112 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureReport_CollectsFailures(t *testing.T) {
+	report := &FailureReport{}
+	events := &testEventReporter{}
+
+	reporter := withFailureReport(events, report)
+
+	metadata := proton.MessageMetadata{ID: "rejected", Subject: "Hello"}
+	apiErr := &proton.APIError{Status: 422, Code: 2001, Message: "invalid"}
+
+	reporter.ReportEvent(Event{Type: EventMessageExported, Stage: "write", MessageID: "built"})
+	reporter.ReportEvent(Event{Type: EventMessageSkipped, Stage: "import", MessageID: "present", Reason: "already present in the account"})
+	reportMessageFailed(reporter, "import", FailureImportRejected, metadata, fmt.Errorf("import: %w", apiErr))
+
+	require.Len(t, events.get(), 3)
+	require.Equal(t, []FailureReportEntry{{
+		MessageID: "rejected",
+		Subject:   "Hello",
+		Stage:     "import",
+		Failure:   FailureImportRejected,
+		Code:      2001,
+		Reason:    "import: " + apiErr.Error(),
+	}}, report.GetEntries())
+
+	require.Equal(t, report, withFailureReport(NullEventReporter{}, report))
+}
+
+func TestFailureReport_Write(t *testing.T) {
+	report := &FailureReport{}
+	report.ReportEvent(newFailureEvent(EventMessageExported, "write", FailureAssemble, proton.MessageMetadata{ID: "a", Subject: "Comma, \"quoted\""}, errors.New("bad mime")))
+	report.ReportEvent(newFailureEvent(EventMessageFailed, "download", FailureDownloadRejected, proton.MessageMetadata{ID: "b"}, &proton.APIError{Code: 2501, Status: 422, Message: "Message not found"}))
+
+	dir := t.TempDir()
+
+	csvPath := filepath.Join(dir, "failures.csv")
+	require.NoError(t, report.Write(csvPath))
+
+	data, err := os.ReadFile(csvPath)
+	require.NoError(t, err)
+	require.Equal(t, "MessageID,Subject,Stage,Failure,Code,Reason\n"+
+		"a,\"Comma, \"\"quoted\"\"\",write,AssembleFailed,,bad mime\n"+
+		"b,,download,DownloadRejected,2501,\"Message not found (Code=2501, Status=422)\"\n", string(data))
+
+	jsonPath := filepath.Join(dir, "failures.json")
+	require.NoError(t, report.Write(jsonPath))
+
+	data, err = os.ReadFile(jsonPath)
+	require.NoError(t, err)
+
+	var entries []FailureReportEntry
+	require.NoError(t, json.Unmarshal(data, &entries))
+	require.Equal(t, report.GetEntries(), entries)
+}
+
+func TestFailureReport_KeepsLastOutcome(t *testing.T) {
+	report := &FailureReport{}
+
+	report.ReportEvent(Event{Type: EventMessageFailed, Stage: "download", MessageID: "a", Failure: FailureDownload, Reason: "timeout"})
+	report.ReportEvent(Event{Type: EventMessageFailed, Stage: "download", MessageID: "b", Failure: FailureDownload, Reason: "timeout"})
+	report.ReportEvent(Event{Type: EventMessageFailed, Stage: "write", MessageID: "c", Failure: FailureWrite, Reason: "disk full"})
+
+	// The retried messages either succeed or fail again.
+	report.ReportEvent(Event{Type: EventMessageExported, Stage: "write", MessageID: "a"})
+	report.ReportEvent(Event{Type: EventMessageFailed, Stage: "build", MessageID: "b", Failure: FailureBuild, Reason: "missing file"})
+
+	require.Equal(t, []FailureReportEntry{
+		{MessageID: "c", Stage: "write", Failure: FailureWrite, Reason: "disk full"},
+		{MessageID: "b", Stage: "build", Failure: FailureBuild, Reason: "missing file"},
+	}, report.GetEntries())
+}
+
+func TestFailureReport_OnlyWrittenWithFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures.json")
+
+	report := &FailureReport{}
+	require.Empty(t, writeFailureReport(report, path))
+	require.NoFileExists(t, path)
+
+	report.ReportEvent(Event{Type: EventMessageFailed, MessageID: "a", Failure: FailureWrite, Reason: "disk full"})
+	require.Equal(t, path, writeFailureReport(report, path))
+	require.FileExists(t, path)
+}
@@ -21,12 +21,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
 	"regexp"
 	"time"
 
-	"github.com/ProtonMail/export-tool/internal/apiclient"
 	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/task"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
 	"github.com/sirupsen/logrus"
 )
@@ -40,12 +43,27 @@ type RestoreTask struct {
 	backupDir       string
 	session         *session.Session
 	log             *logrus.Entry
+	source          restoreSource
+	decrypter       utils.FileDecrypter
+	filter          RestoreFilter
 	labelMapping    map[string]string // map of [backup labelIDs] to remoteLabelIDs
 	importLabelID   string
 	importableCount int64
 	importedCount   int64
 	failedCount     int64
+	filteredCount   int64
+	addressMapping  map[string]string
+	addressFallback string
+	presentCount    int64
+	remoteIndex     *remoteMessageIndex
+	journal         *restoreJournal
+	resumedCount    int64
 	cancelledByUser bool
+	events          EventReporter
+	reportPath      string
+	writtenReport   string
+	restoreSettings bool
+	skipExisting    bool
 }
 
 func NewRestoreTask(ctx context.Context, backupDir string, session *session.Session) (*RestoreTask, error) {
@@ -65,6 +83,8 @@ func NewRestoreTask(ctx context.Context, backupDir string, session *session.Sess
 		session:      session,
 		log:          log,
 		labelMapping: make(map[string]string),
+		events:       NullEventReporter{},
+		skipExisting: true,
 	}, nil
 }
 
@@ -73,39 +93,160 @@ func (r *RestoreTask) Run(reporter Reporter) error {
 	defer func() { r.log.WithField("duration", time.Since(r.startTime)).Info("Finished") }()
 	r.log.WithField("backupDir", r.backupDir).Info("Starting")
 
-	messageInfoList, err := r.validateBackupDir(reporter)
-	if err != nil {
+	report := &FailureReport{}
+	r.events = withFailureReport(r.events, report)
+
+	// The subjects of an encrypted backup are not written in plain text.
+	if r.decrypter != nil {
+		r.events = withoutSubjects(r.events)
+	}
+
+	defer func() {
+		r.writtenReport = writeFailureReport(report, r.getFailureReportPath())
+	}()
+
+	r.ctx = withRetryEvents(r.ctx, r.events)
+	reporter = withProgressEvents(reporter, r.events)
+
+	var messageInfoList []messageInfo
+
+	if err := r.runStage("validation", func() error {
+		var err error
+		if messageInfoList, err = r.validateBackupDir(reporter); err != nil {
+			return err
+		}
+
+		messageInfoList, err = r.filterMessages(messageInfoList, reporter)
+
+		return err
+	}); err != nil {
 		return err
 	}
+
 	r.log.WithField("messageCount", len(messageInfoList)).Info("Found messages to import")
 
-	if err := r.restoreLabels(); err != nil {
+	if err := r.loadJournal(); err != nil {
 		return err
 	}
 
-	if err := r.createImportLabel(); err != nil {
+	if err := r.runStage("labels", func() error {
+		if err := r.restoreLabels(); err != nil {
+			return err
+		}
+
+		if len(r.importLabelID) == 0 {
+			return r.createImportLabel()
+		}
+
+		return nil
+	}); err != nil {
 		return err
 	}
 
-	err = r.importMails(messageInfoList, reporter)
+	if r.restoreSettings {
+		if err := r.runStage("settings", r.restoreMailSettings); err != nil {
+			return err
+		}
+	}
+
+	r.startJournal()
+	defer r.closeJournal()
+
+	if r.skipExisting {
+		if err := r.runStage("index", func() error {
+			var err error
+			r.remoteIndex, err = r.buildRemoteMessageIndex()
+
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	err := r.runStage("import", func() error {
+		return r.importMails(messageInfoList, reporter)
+	})
+	if err == nil && r.failedCount == 0 && r.GetSkippedCount() == 0 {
+		if err := r.journal.remove(); err != nil {
+			r.log.WithError(err).Warn("Failed to remove restore journal")
+		}
+	}
 
 	r.log.WithFields(logrus.Fields{
 		"importable": r.GetImportableCount(),
 		"imported":   r.GetImportedCount(),
 		"failed":     r.GetFailedCount(),
 		"skipped":    r.GetSkippedCount(),
+		"filtered":   r.GetFilteredCount(),
+		"present":    r.GetAlreadyPresentCount(),
+		"resumed":    r.GetResumedCount(),
 	}).Info("Report")
 
 	return err
 }
 
+// SetDecrypter sets the key or passphrase used to read a backup created with export encryption. Must be called before Run.
+func (r *RestoreTask) SetDecrypter(decrypter utils.FileDecrypter) {
+	r.decrypter = decrypter
+}
+
+// SetSkipExisting sets whether the messages already present in the account are skipped instead of being imported
+// again. A message is present if a remote message has the same Message-ID, time and labels. Enabled by default. Must
+// be called before Run.
+func (r *RestoreTask) SetSkipExisting(skipExisting bool) {
+	r.skipExisting = skipExisting
+}
+
+// SetEventReporter makes the restore report its stages, the outcome of each message, the retried requests and its
+// progress as events. Must be called before Run.
+func (r *RestoreTask) SetEventReporter(events EventReporter) {
+	r.events = events
+}
+
+// SetFailureReportPath sets where the report of the messages which failed to import is written. The report is CSV if
+// the path ends with .csv and JSON otherwise. By default, it is written as JSON next to the backup. Must be called
+// before Run.
+func (r *RestoreTask) SetFailureReportPath(path string) {
+	r.reportPath = path
+}
+
+// GetFailureReportPath returns the path of the failure report written by Run, or an empty string if no message failed.
+func (r *RestoreTask) GetFailureReportPath() string {
+	return r.writtenReport
+}
+
+func (r *RestoreTask) getFailureReportPath() string {
+	if len(r.reportPath) != 0 {
+		return r.reportPath
+	}
+
+	backupPath := r.backupDir
+	if format, ok := archiveFormatFromPath(backupPath); ok {
+		backupPath = backupPath[:len(backupPath)-len(format.extension())]
+	}
+
+	return backupPath + "_restore_failures.json"
+}
+
+// runStage runs a stage of the restore and reports when it starts and finishes.
+func (r *RestoreTask) runStage(name string, run func() error) error {
+	reportStageStarted(r.events, name)
+	defer reportStageFinished(r.events, name)
+
+	return run()
+}
+
 func (r *RestoreTask) Cancel() {
 	r.cancelledByUser = true
 	r.ctxCancel()
 }
 
 func (r *RestoreTask) Close() {
-	// Nothing to do so far.
+	if closer, ok := r.source.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			r.log.WithError(err).Warn("Failed to close backup")
+		}
+	}
 }
 
 func (r *RestoreTask) GetBackupPath() string {
@@ -125,14 +266,34 @@ func (r *RestoreTask) GetFailedCount() int64 {
 }
 
 func (r *RestoreTask) GetSkippedCount() int64 {
-	return r.importableCount - r.importedCount - r.failedCount
+	return r.importableCount - r.importedCount - r.failedCount - r.presentCount
+}
+
+// GetAlreadyPresentCount returns the number of messages which were not imported because they are already in the account.
+// They are not included in the skipped count. Always 0 if existing messages are not skipped.
+func (r *RestoreTask) GetAlreadyPresentCount() int64 {
+	return r.presentCount
+}
+
+// GetFilteredCount returns the number of messages of the backup which did not match the filter. They are not included
+// in the other counts.
+func (r *RestoreTask) GetFilteredCount() int64 {
+	return r.filteredCount
+}
+
+// GetResumedCount returns the number of messages which were imported by a previous interrupted restore of the same
+// backup. They are included in the imported count.
+func (r *RestoreTask) GetResumedCount() int64 {
+	return r.resumedCount
 }
 
 func (r *RestoreTask) GetOperationCancelledByUser() bool {
 	return r.cancelledByUser
 }
 
-func (r *RestoreTask) withAddrKR(fn func(addrID string, addrKR *crypto.KeyRing) error) error {
+// withAddrKRs calls fn with the addresses of the account whose keys could be unlocked, the primary address first, and
+// the primary key of each of them.
+func (r *RestoreTask) withAddrKRs(fn func(addresses []proton.Address, addrKRs map[string]*crypto.KeyRing) error) error {
 	client := r.session.GetClient()
 	addresses, err := client.GetAddresses(r.ctx)
 	if err != nil {
@@ -143,36 +304,35 @@ func (r *RestoreTask) withAddrKR(fn func(addrID string, addrKR *crypto.KeyRing)
 		return errors.New("address list is empty")
 	}
 
-	addrID := addresses[0].ID
-	user := r.session.GetUser()
-	salts := r.session.GetUserSalts()
-
-	saltedKeyPass, err := salts.SaltForKey(r.session.GetMailboxPassword(), user.Keys.Primary().ID)
+	unlockedKR, err := task.UnlockKeyRing(r.session, addresses)
 	if err != nil {
-		return fmt.Errorf("failed to salt key password: %w", err)
+		return err
 	}
+	defer unlockedKR.Close()
 
-	if userKR, err := user.Keys.Unlock(saltedKeyPass, nil); err != nil {
-		return fmt.Errorf("failed to unlock user keys: %w", err)
-	} else if userKR.CountDecryptionEntities() == 0 {
-		return fmt.Errorf("failed to unlock user keys")
-	}
+	unlockedAddresses := make([]proton.Address, 0, len(addresses))
+	addrKRs := make(map[string]*crypto.KeyRing, len(addresses))
 
-	unlockedKR, err := apiclient.NewUnlockedKeyRing(user, addresses, saltedKeyPass)
-	if err != nil {
-		return fmt.Errorf("failed to unlock user keyring:%w", err)
-	}
-	defer unlockedKR.Close()
+	for _, address := range addresses {
+		addrKR, ok := unlockedKR.GetAddrKeyRing(address.ID)
+		if !ok {
+			if len(unlockedAddresses) == 0 {
+				return fmt.Errorf("failed to get primary address keyring")
+			}
 
-	addrKR, ok := unlockedKR.GetAddrKeyRing(addresses[0].ID)
-	if !ok {
-		return fmt.Errorf("failed to get primary address keyring")
-	}
+			r.log.WithField("addressID", address.ID).Warn("Address keyring is not available, messages can't be restored into it")
 
-	primaryKR, err := addrKR.FirstKey()
-	if err != nil {
-		return fmt.Errorf("failed to get primary key: %w", err)
+			continue
+		}
+
+		primaryKR, err := addrKR.FirstKey()
+		if err != nil {
+			return fmt.Errorf("failed to get primary key: %w", err)
+		}
+
+		unlockedAddresses = append(unlockedAddresses, address)
+		addrKRs[address.ID] = primaryKR
 	}
 
-	return fn(addrID, primaryKR)
+	return fn(unlockedAddresses, addrKRs)
 }
@@ -0,0 +1,163 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-proton-api"
+	"golang.org/x/exp/slices"
+)
+
+// AddressFallbackPrimary imports the messages which can't be matched to an address of the account into the primary address.
+const AddressFallbackPrimary = "primary"
+
+// LoadAddressMappingFile reads a JSON object mapping backup addresses to the addresses of the account, e.g.
+//
+//	{ "old@example.com": "new@example.com", "<backup address ID>": "alias@example.com" }
+//
+// Keys and values are either address IDs or emails.
+func LoadAddressMappingFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address mapping file: %w", err)
+	}
+
+	var mapping map[string]string
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse address mapping file: %w", err)
+	}
+
+	return mapping, nil
+}
+
+// SetAddressMapping sets the explicit mapping of backup addresses (IDs or emails) to addresses of the account (IDs or emails).
+func (r *RestoreTask) SetAddressMapping(mapping map[string]string) {
+	r.addressMapping = mapping
+}
+
+// SetAddressFallback sets the address (ID or email) receiving the messages which can't be matched to an address of the
+// account. Defaults to the primary address.
+func (r *RestoreTask) SetAddressFallback(address string) {
+	r.addressFallback = address
+}
+
+// addressMapper picks the address of the account a backed up message is imported into:
+//  1. the explicit mapping of its address ID;
+//  2. the address with the same ID, when restoring into the original account;
+//  3. the explicit mapping of, or the address matching, the email of one of its participants;
+//  4. the fallback address.
+type addressMapper struct {
+	addresses  []proton.Address
+	mapping    map[string]string
+	fallbackID string
+}
+
+func newAddressMapper(addresses []proton.Address, mapping map[string]string, fallback string) (*addressMapper, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("address list is empty")
+	}
+
+	mapper := &addressMapper{
+		addresses: addresses,
+		mapping:   make(map[string]string, len(mapping)),
+	}
+
+	for from, to := range mapping {
+		addrID, ok := mapper.findAddress(to)
+		if !ok {
+			return nil, fmt.Errorf("address mapping target '%v' is not an address of the account", to)
+		}
+
+		mapper.mapping[from] = addrID
+		mapper.mapping[strings.ToLower(from)] = addrID
+	}
+
+	if len(fallback) == 0 || strings.EqualFold(fallback, AddressFallbackPrimary) {
+		mapper.fallbackID = addresses[0].ID
+	} else if addrID, ok := mapper.findAddress(fallback); ok {
+		mapper.fallbackID = addrID
+	} else {
+		return nil, fmt.Errorf("fallback address '%v' is not an address of the account", fallback)
+	}
+
+	return mapper, nil
+}
+
+func (m *addressMapper) findAddress(value string) (string, bool) {
+	index := slices.IndexFunc(m.addresses, func(address proton.Address) bool {
+		return address.ID == value || strings.EqualFold(address.Email, value)
+	})
+	if index < 0 {
+		return "", false
+	}
+
+	return m.addresses[index].ID, true
+}
+
+func (m *addressMapper) getAddressID(metadata proton.MessageMetadata) string {
+	if len(metadata.AddressID) != 0 {
+		if addrID, ok := m.mapping[metadata.AddressID]; ok {
+			return addrID
+		}
+
+		if slices.ContainsFunc(m.addresses, func(address proton.Address) bool { return address.ID == metadata.AddressID }) {
+			return metadata.AddressID
+		}
+	}
+
+	for _, email := range messageParticipantEmails(metadata) {
+		if addrID, ok := m.mapping[strings.ToLower(email)]; ok {
+			return addrID
+		}
+
+		if addrID, ok := m.findAddress(email); ok {
+			return addrID
+		}
+	}
+
+	return m.fallbackID
+}
+
+// messageParticipantEmails returns the emails which may be the address of the account owning the message: the sender
+// first for sent messages, the recipients first otherwise.
+func messageParticipantEmails(metadata proton.MessageMetadata) []string {
+	var sender, recipients []string
+
+	if metadata.Sender != nil {
+		sender = append(sender, metadata.Sender.Address)
+	}
+
+	for _, list := range [][]*mail.Address{metadata.ToList, metadata.CCList, metadata.BCCList} {
+		for _, address := range list {
+			if address != nil {
+				recipients = append(recipients, address.Address)
+			}
+		}
+	}
+
+	if metadata.Flags.Has(proton.MessageFlagSent) {
+		return append(sender, recipients...)
+	}
+
+	return append(recipients, sender...)
+}
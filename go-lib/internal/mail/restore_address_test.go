@@ -0,0 +1,92 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressMapper(t *testing.T) {
+	addresses := []proton.Address{
+		{ID: "primary", Email: "primary@proton.me"},
+		{ID: "alias", Email: "alias@proton.me"},
+		{ID: "custom", Email: "me@custom.com"},
+	}
+
+	mapper, err := newAddressMapper(addresses, map[string]string{
+		"old-id":          "custom",
+		"Old@example.com": "alias@proton.me",
+	}, "")
+	require.NoError(t, err)
+
+	// Explicit mapping of the backup address ID.
+	require.Equal(t, "custom", mapper.getAddressID(proton.MessageMetadata{AddressID: "old-id"}))
+
+	// Same address ID in the account.
+	require.Equal(t, "alias", mapper.getAddressID(proton.MessageMetadata{AddressID: "alias"}))
+
+	// Explicit mapping of a participant email.
+	require.Equal(t, "alias", mapper.getAddressID(proton.MessageMetadata{
+		AddressID: "unknown",
+		ToList:    []*mail.Address{{Address: "old@example.com"}},
+	}))
+
+	// Participant email matching an address of the account, the sender first for sent messages.
+	require.Equal(t, "custom", mapper.getAddressID(proton.MessageMetadata{
+		Sender: &mail.Address{Address: "ME@custom.com"},
+		ToList: []*mail.Address{{Address: "alias@proton.me"}},
+		Flags:  proton.MessageFlagSent,
+	}))
+	require.Equal(t, "alias", mapper.getAddressID(proton.MessageMetadata{
+		Sender: &mail.Address{Address: "me@custom.com"},
+		ToList: []*mail.Address{{Address: "alias@proton.me"}},
+		Flags:  proton.MessageFlagReceived,
+	}))
+
+	// Fallback to the primary address.
+	require.Equal(t, "primary", mapper.getAddressID(proton.MessageMetadata{AddressID: "unknown"}))
+
+	mapper, err = newAddressMapper(addresses, nil, "me@custom.com")
+	require.NoError(t, err)
+	require.Equal(t, "custom", mapper.getAddressID(proton.MessageMetadata{AddressID: "unknown"}))
+
+	_, err = newAddressMapper(addresses, nil, "missing@proton.me")
+	require.Error(t, err)
+
+	_, err = newAddressMapper(addresses, map[string]string{"old-id": "missing"}, "")
+	require.Error(t, err)
+}
+
+func TestLoadAddressMappingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"old@example.com": "new@proton.me"}`), 0o600))
+
+	mapping, err := LoadAddressMappingFile(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"old@example.com": "new@proton.me"}, mapping)
+
+	require.NoError(t, os.WriteFile(path, []byte(`[]`), 0o600))
+	_, err = LoadAddressMappingFile(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+	"golang.org/x/exp/slices"
+)
+
+// RestoreFilter selects the messages imported by a restore from their backed up metadata. The zero value of each field
+// disables the corresponding criteria.
+type RestoreFilter struct {
+	After         time.Time // Only messages received at or after this time.
+	Before        time.Time // Only messages received before this time.
+	IncludeLabels []string  // Backup label IDs or names, messages must have at least one of them.
+	ExcludeLabels []string  // Backup label IDs or names, messages must have none of them.
+	Addresses     []string  // Address IDs or emails, messages must belong to one of them.
+	Participants  []string  // Email addresses, messages must have been sent by or to one of them.
+}
+
+func (f RestoreFilter) IsEmpty() bool {
+	return f.After.IsZero() &&
+		f.Before.IsZero() &&
+		len(f.IncludeLabels) == 0 &&
+		len(f.ExcludeLabels) == 0 &&
+		len(f.Addresses) == 0 &&
+		len(f.Participants) == 0
+}
+
+// resolve maps the label names to the labels of the backup. Addresses are matched against the addresses of the
+// account, values which do not match any are used as is as the backup may come from another account.
+func (f RestoreFilter) resolve(labels []proton.Label, addresses []proton.Address) (*messageFilter, error) {
+	filter, err := ExportFilter{
+		After:         f.After,
+		Before:        f.Before,
+		IncludeLabels: f.IncludeLabels,
+		ExcludeLabels: f.ExcludeLabels,
+	}.resolve(labels, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range f.Addresses {
+		index := slices.IndexFunc(addresses, func(a proton.Address) bool {
+			return a.ID == address || strings.EqualFold(a.Email, address)
+		})
+		if index < 0 {
+			filter.addressIDs = append(filter.addressIDs, address)
+			continue
+		}
+
+		filter.addressIDs = append(filter.addressIDs, addresses[index].ID)
+	}
+
+	filter.participants = f.Participants
+
+	return filter, nil
+}
+
+// SetFilter restricts the messages imported by the restore. Must be called before Run.
+func (r *RestoreTask) SetFilter(filter RestoreFilter) {
+	r.filter = filter
+}
+
+// filterMessages removes the messages which do not match the filter from the list.
+func (r *RestoreTask) filterMessages(messageInfoList []messageInfo, reporter Reporter) ([]messageInfo, error) {
+	if r.filter.IsEmpty() {
+		return messageInfoList, nil
+	}
+
+	labels, err := r.source.getLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := r.session.GetClient().GetAddresses(r.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := r.filter.resolve(labels, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]messageInfo, 0, len(messageInfoList))
+
+	for _, info := range messageInfoList {
+		if r.ctx.Err() != nil {
+			return nil, r.ctx.Err()
+		}
+
+		metadata, err := r.source.readMetadata(info)
+		if err != nil {
+			// The message is kept so that the failure is reported by the import.
+			r.log.WithError(err).WithField("messageID", info.messageID).Warn("Could not read message metadata for filtering")
+			result = append(result, info)
+
+			continue
+		}
+
+		if filter.matches(metadata) {
+			result = append(result, info)
+		}
+	}
+
+	r.filteredCount = int64(len(messageInfoList) - len(result))
+	r.importableCount = int64(len(result))
+	reporter.SetMessageTotal(uint64(len(result)))
+
+	r.log.WithField("filteredCount", r.filteredCount).Info("Filtered messages to import")
+
+	return result, nil
+}
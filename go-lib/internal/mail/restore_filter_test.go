@@ -0,0 +1,84 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreFilter_Resolve(t *testing.T) {
+	labels := []proton.Label{
+		{ID: "folder", Name: "Work", Type: proton.LabelTypeFolder},
+	}
+
+	addresses := []proton.Address{
+		{ID: "addr-1", Email: "one@proton.me"},
+	}
+
+	filter, err := RestoreFilter{
+		IncludeLabels: []string{"work", "Inbox"},
+		Addresses:     []string{"one@proton.me", "backup-addr"},
+		Participants:  []string{"friend@example.com"},
+	}.resolve(labels, addresses)
+	require.NoError(t, err)
+	require.Equal(t, []string{"folder", proton.InboxLabel}, filter.includeLabelIDs)
+	require.Equal(t, []string{"addr-1", "backup-addr"}, filter.addressIDs)
+	require.Equal(t, []string{"friend@example.com"}, filter.participants)
+
+	_, err = RestoreFilter{IncludeLabels: []string{"missing"}}.resolve(labels, addresses)
+	require.Error(t, err)
+}
+
+func TestMessageFilter_MatchesParticipants(t *testing.T) {
+	metadata := proton.MessageMetadata{
+		Sender: &mail.Address{Address: "sender@example.com"},
+		ToList: []*mail.Address{{Address: "to@example.com"}},
+		CCList: []*mail.Address{{Address: "cc@example.com"}},
+	}
+
+	require.True(t, (&messageFilter{participants: []string{"SENDER@example.com"}}).matches(metadata))
+	require.True(t, (&messageFilter{participants: []string{"other@example.com", "cc@example.com"}}).matches(metadata))
+	require.False(t, (&messageFilter{participants: []string{"other@example.com"}}).matches(metadata))
+}
+
+func TestMailboxSource_ReadMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "msg")
+
+	require.NoError(t, os.WriteFile(path, []byte("From: Sender <sender@example.com>\r\nTo: to@example.com\r\nCc: cc@example.com\r\n\r\nbody\r\n"), 0o600))
+
+	source := newMailboxSource(func(msg *mailboxMessage) ([]byte, error) { return os.ReadFile(msg.path) })
+	source.addMessage("msg", []string{"Inbox"}, mailboxMessage{path: path, length: -1, time: 10}, mailboxFlags{seen: true})
+
+	infos := source.getMessageInfoList()
+	require.Len(t, infos, 1)
+
+	metadata, err := source.readMetadata(infos[0])
+	require.NoError(t, err)
+	require.Equal(t, int64(10), metadata.Time)
+	require.Equal(t, []string{proton.InboxLabel}, metadata.LabelIDs)
+	require.Equal(t, "sender@example.com", metadata.Sender.Address)
+	require.Equal(t, "to@example.com", metadata.ToList[0].Address)
+	require.Equal(t, "cc@example.com", metadata.CCList[0].Address)
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
+)
+
+// A restore journal is kept next to the backup while messages are being imported, so that an interrupted restore can
+// be resumed instead of starting over:
+// - the first line holds the versioned journal header: the import label and the mapping of the backup labels.
+// - every following line records a message which was successfully imported, along with its remote ID.
+// The journal is removed once every message has been imported.
+
+const RestoreJournalVersion = 1
+
+const restoreJournalFileName = "restore_journal.jsonl"
+
+type RestoreJournalHeader struct {
+	ImportLabelID string
+	LabelMapping  map[string]string
+}
+
+type RestoreJournalEntry struct {
+	MessageID string
+	RemoteID  string
+}
+
+type restoreJournal struct {
+	path     string
+	file     *os.File
+	header   RestoreJournalHeader
+	imported map[string]string // map of [backup messageIDs] to remote messageIDs
+}
+
+// getRestoreJournalPath returns the path of the journal for the given backup, which is either a directory or a single
+// mbox file.
+func getRestoreJournalPath(backupPath string) string {
+	if info, err := os.Stat(backupPath); err == nil && !info.IsDir() {
+		return backupPath + "." + restoreJournalFileName
+	}
+
+	return filepath.Join(backupPath, restoreJournalFileName)
+}
+
+// loadJournal resumes the previous restore of the backup if it was interrupted, reusing its import label and the
+// labels it created.
+func (r *RestoreTask) loadJournal() error {
+	journal, err := loadRestoreJournal(getRestoreJournalPath(r.backupDir))
+	if err != nil {
+		return err
+	}
+
+	if journal == nil {
+		r.journal = newRestoreJournal(getRestoreJournalPath(r.backupDir))
+		return nil
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"importLabelID": journal.header.ImportLabelID,
+		"importedCount": journal.getImportedCount(),
+	}).Info("Resuming interrupted restore")
+
+	r.journal = journal
+	r.importLabelID = journal.header.ImportLabelID
+	maps.Copy(r.labelMapping, journal.header.LabelMapping)
+
+	return nil
+}
+
+// startJournal writes the journal header. The restore goes on without a journal if it can't be written, e.g. when the
+// backup is on read-only media.
+func (r *RestoreTask) startJournal() {
+	err := r.journal.start(RestoreJournalHeader{
+		ImportLabelID: r.importLabelID,
+		LabelMapping:  r.labelMapping,
+	})
+	if err != nil {
+		r.log.WithError(err).Warn("Could not write restore journal, an interrupted restore will not be resumable")
+	}
+}
+
+func (r *RestoreTask) closeJournal() {
+	if err := r.journal.close(); err != nil {
+		r.log.WithError(err).Warn("Failed to close restore journal")
+	}
+}
+
+func newRestoreJournal(path string) *restoreJournal {
+	return &restoreJournal{
+		path:     path,
+		imported: make(map[string]string),
+	}
+}
+
+// loadRestoreJournal reads the journal left by a previous restore. Nil is returned if there is none. The last line of
+// the journal may be incomplete if the previous restore was interrupted while writing it, it is then ignored.
+func loadRestoreJournal(path string) (*restoreJournal, error) {
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to open restore journal: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read restore journal: %w", err)
+		}
+
+		// The previous restore was interrupted before anything was imported.
+		return nil, nil
+	}
+
+	header, err := utils.NewVersionedJSON[RestoreJournalHeader](RestoreJournalVersion, scanner.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse restore journal: %w", err)
+	}
+
+	journal := newRestoreJournal(path)
+	journal.header = header.Payload
+
+	for scanner.Scan() {
+		var entry RestoreJournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || len(entry.MessageID) == 0 {
+			break
+		}
+
+		journal.imported[entry.MessageID] = entry.RemoteID
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read restore journal: %w", err)
+	}
+
+	return journal, nil
+}
+
+// start rewrites the journal with the given header and the messages imported so far, then opens it for recording.
+func (j *restoreJournal) start(header RestoreJournalHeader) error {
+	j.header = header
+
+	var buffer bytes.Buffer
+
+	data, err := json.Marshal(utils.VersionedJSON[RestoreJournalHeader]{Version: RestoreJournalVersion, Payload: header})
+	if err != nil {
+		return fmt.Errorf("failed to json encode restore journal header: %w", err)
+	}
+
+	buffer.Write(data)
+	buffer.WriteByte('\n')
+
+	for messageID, remoteID := range j.imported {
+		if err := writeRestoreJournalEntry(&buffer, messageID, remoteID); err != nil {
+			return err
+		}
+	}
+
+	if err := utils.WriteFileSafe(filepath.Dir(j.path), j.path, buffer.Bytes(), nil); err != nil {
+		return fmt.Errorf("failed to write restore journal: %w", err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o600) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to open restore journal: %w", err)
+	}
+
+	j.file = file
+
+	return nil
+}
+
+func (j *restoreJournal) isImported(messageID string) bool {
+	if j == nil {
+		return false
+	}
+
+	_, ok := j.imported[messageID]
+
+	return ok
+}
+
+func (j *restoreJournal) getImportedCount() int {
+	if j == nil {
+		return 0
+	}
+
+	return len(j.imported)
+}
+
+// recordImported appends a successfully imported message to the journal.
+func (j *restoreJournal) recordImported(messageID, remoteID string) error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+
+	j.imported[messageID] = remoteID
+
+	return writeRestoreJournalEntry(j.file, messageID, remoteID)
+}
+
+func (j *restoreJournal) close() error {
+	if j == nil || j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+// remove closes and deletes the journal, once the restore is complete.
+func (j *restoreJournal) remove() error {
+	if j == nil {
+		return nil
+	}
+
+	if err := j.close(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func writeRestoreJournalEntry(writer io.Writer, messageID, remoteID string) error {
+	data, err := json.Marshal(RestoreJournalEntry{MessageID: messageID, RemoteID: remoteID})
+	if err != nil {
+		return fmt.Errorf("failed to json encode restore journal entry: %w", err)
+	}
+
+	if _, err := writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write restore journal entry: %w", err)
+	}
+
+	return nil
+}
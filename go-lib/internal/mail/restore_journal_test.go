@@ -0,0 +1,90 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreJournal(t *testing.T) {
+	dir := t.TempDir()
+	path := getRestoreJournalPath(dir)
+	require.Equal(t, filepath.Join(dir, restoreJournalFileName), path)
+
+	journal, err := loadRestoreJournal(path)
+	require.NoError(t, err)
+	require.Nil(t, journal)
+
+	journal = newRestoreJournal(path)
+	require.NoError(t, journal.start(RestoreJournalHeader{
+		ImportLabelID: "importLabel",
+		LabelMapping:  map[string]string{"backupLabel": "remoteLabel"},
+	}))
+	require.NoError(t, journal.recordImported("msg1", "remote1"))
+	require.NoError(t, journal.recordImported("msg2", "remote2"))
+	require.NoError(t, journal.close())
+
+	// Simulate an interruption while writing an entry.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"MessageID":"msg3","Rem`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	journal, err = loadRestoreJournal(path)
+	require.NoError(t, err)
+	require.NotNil(t, journal)
+	require.Equal(t, "importLabel", journal.header.ImportLabelID)
+	require.Equal(t, map[string]string{"backupLabel": "remoteLabel"}, journal.header.LabelMapping)
+	require.Equal(t, map[string]string{"msg1": "remote1", "msg2": "remote2"}, journal.imported)
+	require.True(t, journal.isImported("msg1"))
+	require.False(t, journal.isImported("msg3"))
+
+	// Restarting the journal drops the incomplete entry.
+	require.NoError(t, journal.start(journal.header))
+	require.NoError(t, journal.recordImported("msg3", "remote3"))
+	require.NoError(t, journal.close())
+
+	journal, err = loadRestoreJournal(path)
+	require.NoError(t, err)
+	require.Equal(t, 3, journal.getImportedCount())
+	require.True(t, journal.isImported("msg3"))
+
+	require.NoError(t, journal.remove())
+	require.NoFileExists(t, path)
+}
+
+func TestRestoreJournal_MboxFile(t *testing.T) {
+	mboxPath := filepath.Join(t.TempDir(), "Inbox.mbox")
+	require.NoError(t, os.WriteFile(mboxPath, nil, 0o600))
+
+	require.Equal(t, mboxPath+"."+restoreJournalFileName, getRestoreJournalPath(mboxPath))
+}
+
+func TestRestoreJournal_NilJournal(t *testing.T) {
+	var journal *restoreJournal
+
+	require.False(t, journal.isImported("msg1"))
+	require.NoError(t, journal.recordImported("msg1", "remote1"))
+	require.NoError(t, journal.close())
+	require.NoError(t, journal.remove())
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+)
+
+// restoreSource provides the labels and messages imported by a RestoreTask. The messages are listed by
+// validateBackupDir and read back one by one during the import.
+type restoreSource interface {
+	getLabels() ([]proton.Label, error)
+	// getAccount returns the account file of the backup, or nil if it has none.
+	getAccount() (*utils.VersionedJSON[AccountMetadata], error)
+	readMessage(info messageInfo) (Message, error)
+	readMetadata(info messageInfo) (proton.MessageMetadata, error)
+}
+
+// sequentialRestoreSource is implemented by the sources which can only read their messages efficiently in a given order.
+type sequentialRestoreSource interface {
+	sortMessageInfoList(messageList []messageInfo)
+}
+
+// backupDirSource reads the backups created by the export tool: one .eml and one .metadata.json file per message,
+// and the labels.json and account.json files.
+type backupDirSource struct {
+	dir       string
+	decrypter utils.FileDecrypter
+}
+
+func newBackupDirSource(dir string, decrypter utils.FileDecrypter) *backupDirSource {
+	return &backupDirSource{dir: dir, decrypter: decrypter}
+}
+
+func (b *backupDirSource) getLabels() ([]proton.Label, error) {
+	data, err := utils.ReadFileDecrypted(filepath.Join(b.dir, getLabelFileName()), b.decrypter)
+	if err != nil {
+		return nil, err
+	}
+
+	versionedLabels, err := utils.NewVersionedJSON[[]proton.Label](LabelMetadataVersion, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return versionedLabels.Payload, nil
+}
+
+func (b *backupDirSource) getAccount() (*utils.VersionedJSON[AccountMetadata], error) {
+	return readAccountFile(b.dir, b.decrypter)
+}
+
+func (b *backupDirSource) readMetadata(info messageInfo) (proton.MessageMetadata, error) {
+	metadataPath := emlToMetadataFilename(info.getEMLPath())
+
+	metadata, err := loadMetadataFile(metadataPath, b.decrypter)
+	if err != nil {
+		return proton.MessageMetadata{}, fmt.Errorf("could not load metadata file '%v': %w", metadataPath, err)
+	}
+
+	return metadata.MessageMetadata, nil
+}
+
+func (b *backupDirSource) readMessage(info messageInfo) (Message, error) {
+	emlPath := info.getEMLPath()
+
+	literal, err := utils.ReadFileDecrypted(emlPath, b.decrypter)
+	if err != nil {
+		return Message{}, fmt.Errorf("could not read EML file '%v': %w", emlPath, err)
+	}
+
+	metadataPath := emlToMetadataFilename(emlPath)
+
+	metadata, err := loadMetadataFile(metadataPath, b.decrypter)
+	if err != nil {
+		return Message{}, fmt.Errorf("could not load metadata file '%v': %w", metadataPath, err)
+	}
+
+	return Message{literal: literal, metadata: metadata.MessageMetadata}, nil
+}
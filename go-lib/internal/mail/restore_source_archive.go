@@ -0,0 +1,365 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/exp/slices"
+)
+
+// archiveSource reads a backup stored in a tar.zst or zip archive by the export, without extracting it. The labels and
+// the metadata of the messages are read when the archive is opened, the messages when they are imported.
+type archiveSource struct {
+	decrypter utils.FileDecrypter
+	labels    []proton.Label
+	account   *utils.VersionedJSON[AccountMetadata]
+	messages  map[string]*archiveMessage
+	zip       *zip.ReadCloser
+	tar       *tarArchiveCursor
+}
+
+type archiveMessage struct {
+	metadata    proton.MessageMetadata
+	hasMetadata bool
+	emlName     string
+	position    int // index of the EML entry, tar archives can only be read sequentially.
+}
+
+func newArchiveSource(ctx context.Context, archivePath string, format ArchiveFormat, decrypter utils.FileDecrypter) (*archiveSource, error) {
+	source := &archiveSource{
+		decrypter: decrypter,
+		messages:  make(map[string]*archiveMessage),
+	}
+
+	var err error
+
+	switch format {
+	case ArchiveFormatTarZstd:
+		source.tar = &tarArchiveCursor{path: archivePath}
+		err = source.tar.forEachEntry(ctx, source.onEntry)
+	case ArchiveFormatZip:
+		if source.zip, err = zip.OpenReader(archivePath); err != nil {
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+
+		for position, file := range source.zip.File {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+
+			file := file
+			if err = source.onEntry(file.Name, position, func() ([]byte, error) { return readZipEntry(file) }); err != nil {
+				break
+			}
+		}
+	case ArchiveFormatNone:
+		return nil, errors.New("not an archive")
+	}
+
+	if err != nil {
+		_ = source.Close()
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// onEntry collects the labels and the messages of the archive. Only the files at the root of the export are considered,
+// the folders of the messages which could not be built are ignored.
+func (a *archiveSource) onEntry(name string, position int, read func() ([]byte, error)) error {
+	if strings.Count(strings.Trim(name, "/"), "/") > 1 {
+		return nil
+	}
+
+	fileName := path.Base(name)
+
+	switch {
+	case fileName == getLabelFileName():
+		data, err := a.readEntry(read)
+		if err != nil {
+			return fmt.Errorf("failed to read labels file: %w", err)
+		}
+
+		versionedLabels, err := utils.NewVersionedJSON[[]proton.Label](LabelMetadataVersion, data)
+		if err != nil {
+			return fmt.Errorf("failed to parse labels file: %w", err)
+		}
+
+		a.labels = versionedLabels.Payload
+
+	case fileName == getAccountFileName():
+		data, err := a.readEntry(read)
+		if err != nil {
+			return fmt.Errorf("failed to read account file: %w", err)
+		}
+
+		if a.account, err = utils.NewVersionedJSON[AccountMetadata](AccountMetadataVersion, data); err != nil {
+			return fmt.Errorf("failed to parse account file: %w", err)
+		}
+
+	case strings.HasSuffix(fileName, jsonMetadataExtension):
+		data, err := a.readEntry(read)
+		if err != nil {
+			return fmt.Errorf("failed to read metadata file '%v': %w", name, err)
+		}
+
+		metadata, err := utils.NewVersionedJSON[MessageMetadata](MessageMetadataVersion, data)
+		if err != nil {
+			return fmt.Errorf("failed to parse metadata file '%v': %w", name, err)
+		}
+
+		msg := a.getMessage(strings.TrimSuffix(fileName, jsonMetadataExtension))
+		msg.metadata = metadata.Payload.MessageMetadata
+		msg.hasMetadata = true
+
+	case strings.HasSuffix(fileName, emlExtension):
+		msg := a.getMessage(strings.TrimSuffix(fileName, emlExtension))
+		msg.emlName = name
+		msg.position = position
+	}
+
+	return nil
+}
+
+func (a *archiveSource) readEntry(read func() ([]byte, error)) ([]byte, error) {
+	data, err := read()
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.DecryptFileData(data, a.decrypter)
+}
+
+func (a *archiveSource) getMessage(id string) *archiveMessage {
+	msg, ok := a.messages[id]
+	if !ok {
+		msg = &archiveMessage{}
+		a.messages[id] = msg
+	}
+
+	return msg
+}
+
+func (a *archiveSource) hasLabels() bool {
+	return a.labels != nil
+}
+
+// getMessageInfoList returns the messages of the archive which have both an EML and a metadata file.
+func (a *archiveSource) getMessageInfoList() []messageInfo {
+	result := make([]messageInfo, 0, len(a.messages))
+
+	for id, msg := range a.messages {
+		if !msg.hasMetadata || len(msg.emlName) == 0 {
+			continue
+		}
+
+		result = append(result, messageInfo{messageID: id, timestamp: msg.metadata.Time})
+	}
+
+	return result
+}
+
+// sortMessageInfoList sorts the messages in the order of the archive, so that tar archives are read in a single pass.
+func (a *archiveSource) sortMessageInfoList(messageList []messageInfo) {
+	if a.tar == nil {
+		return
+	}
+
+	slices.SortStableFunc(messageList, func(lhs, rhs messageInfo) bool {
+		return a.messages[lhs.messageID].position < a.messages[rhs.messageID].position
+	})
+}
+
+func (a *archiveSource) getLabels() ([]proton.Label, error) {
+	return a.labels, nil
+}
+
+func (a *archiveSource) getAccount() (*utils.VersionedJSON[AccountMetadata], error) {
+	return a.account, nil
+}
+
+func (a *archiveSource) readMetadata(info messageInfo) (proton.MessageMetadata, error) {
+	msg, ok := a.messages[info.messageID]
+	if !ok {
+		return proton.MessageMetadata{}, fmt.Errorf("unknown message '%v'", info.messageID)
+	}
+
+	return msg.metadata, nil
+}
+
+func (a *archiveSource) readMessage(info messageInfo) (Message, error) {
+	msg, ok := a.messages[info.messageID]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown message '%v'", info.messageID)
+	}
+
+	var data []byte
+	var err error
+
+	if a.tar != nil {
+		data, err = a.tar.readEntry(msg.position)
+	} else {
+		data, err = readZipEntry(a.zip.File[msg.position])
+	}
+
+	if err != nil {
+		return Message{}, fmt.Errorf("could not read archive entry '%v': %w", msg.emlName, err)
+	}
+
+	if data, err = utils.DecryptFileData(data, a.decrypter); err != nil {
+		return Message{}, fmt.Errorf("could not read archive entry '%v': %w", msg.emlName, err)
+	}
+
+	return Message{literal: data, metadata: msg.metadata}, nil
+}
+
+func (a *archiveSource) Close() error {
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+
+	if a.tar != nil {
+		a.tar.close()
+	}
+
+	return nil
+}
+
+func readZipEntry(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close() //nolint:errcheck
+
+	return io.ReadAll(reader)
+}
+
+// tarArchiveCursor reads the entries of a tar.zst archive. Reading an entry which precedes the current position
+// re-opens the archive.
+type tarArchiveCursor struct {
+	path     string
+	file     *os.File
+	decoder  *zstd.Decoder
+	reader   *tar.Reader
+	position int // index of the next entry.
+}
+
+func (t *tarArchiveCursor) open() error {
+	t.close()
+
+	file, err := os.Open(t.path) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	t.file = file
+	t.decoder = decoder
+	t.reader = tar.NewReader(decoder)
+	t.position = 0
+
+	return nil
+}
+
+func (t *tarArchiveCursor) close() {
+	if t.decoder != nil {
+		t.decoder.Close()
+		t.decoder = nil
+	}
+
+	if t.file != nil {
+		_ = t.file.Close()
+		t.file = nil
+	}
+
+	t.reader = nil
+}
+
+// next moves to the next entry of the archive.
+func (t *tarArchiveCursor) next() (*tar.Header, error) {
+	header, err := t.reader.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	t.position++
+
+	return header, nil
+}
+
+func (t *tarArchiveCursor) forEachEntry(ctx context.Context, fn func(name string, position int, read func() ([]byte, error)) error) error {
+	if err := t.open(); err != nil {
+		return err
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		header, err := t.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := fn(header.Name, t.position-1, func() ([]byte, error) { return io.ReadAll(t.reader) }); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *tarArchiveCursor) readEntry(position int) ([]byte, error) {
+	if t.reader == nil || position < t.position {
+		if err := t.open(); err != nil {
+			return nil, err
+		}
+	}
+
+	for t.position <= position {
+		if _, err := t.next(); err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+	}
+
+	return io.ReadAll(t.reader)
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"golang.org/x/exp/slices"
+)
+
+// mailboxLabelColor is the color of the labels created for the folders of a mailbox, labels require one.
+const mailboxLabelColor = "#8080FF"
+
+// mailboxFlags is the state of a message as stored by mbox and Maildir mailboxes.
+type mailboxFlags struct {
+	seen      bool
+	flagged   bool
+	replied   bool
+	forwarded bool
+	draft     bool
+}
+
+// mailboxMessage locates a message in an mbox file or a Maildir tree.
+type mailboxMessage struct {
+	path     string
+	offset   int64
+	length   int64 // -1 when the message is the whole file.
+	time     int64
+	labelIDs []string
+	unread   bool
+	flags    proton.MessageFlag
+}
+
+// mailboxSource is the restoreSource of mailboxes created by other mail clients or by the mbox and Maildir export
+// formats. Labels are derived from the folder names: well known names are mapped to system folders, every other folder
+// becomes a user folder, nested following the folder hierarchy. The 'Folders' and 'Labels' top level folders created by
+// the export formats are mapped back to user folders and labels respectively.
+//
+// A message stored in several folders is only imported once, with the labels of all its folders.
+type mailboxSource struct {
+	labels   []proton.Label
+	messages map[string]*mailboxMessage
+	infos    []messageInfo
+	readFn   func(msg *mailboxMessage) ([]byte, error)
+}
+
+func newMailboxSource(readFn func(msg *mailboxMessage) ([]byte, error)) *mailboxSource {
+	return &mailboxSource{
+		messages: make(map[string]*mailboxMessage),
+		readFn:   readFn,
+	}
+}
+
+func (m *mailboxSource) getLabels() ([]proton.Label, error) {
+	return m.labels, nil
+}
+
+func (m *mailboxSource) getAccount() (*utils.VersionedJSON[AccountMetadata], error) {
+	return nil, nil
+}
+
+func (m *mailboxSource) readMessage(info messageInfo) (Message, error) {
+	msg, ok := m.messages[info.messageID]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown message '%v'", info.messageID)
+	}
+
+	literal, err := m.readFn(msg)
+	if err != nil {
+		return Message{}, fmt.Errorf("could not read message '%v' from '%v': %w", info.messageID, msg.path, err)
+	}
+
+	metadata := msg.getMetadata(info.messageID)
+
+	// The participants are read from the headers, they are optional.
+	if parsed, err := mail.ReadMessage(bytes.NewReader(literal)); err == nil {
+		if from, err := parsed.Header.AddressList("From"); err == nil && len(from) != 0 {
+			metadata.Sender = from[0]
+		}
+
+		metadata.ToList, _ = parsed.Header.AddressList("To")
+		metadata.CCList, _ = parsed.Header.AddressList("Cc")
+		metadata.BCCList, _ = parsed.Header.AddressList("Bcc")
+	}
+
+	return Message{literal: literal, metadata: metadata}, nil
+}
+
+// readMetadata returns the metadata of the message, including its participants which are read from its headers.
+func (m *mailboxSource) readMetadata(info messageInfo) (proton.MessageMetadata, error) {
+	message, err := m.readMessage(info)
+	if err != nil {
+		return proton.MessageMetadata{}, err
+	}
+
+	return message.metadata, nil
+}
+
+func (m *mailboxMessage) getMetadata(messageID string) proton.MessageMetadata {
+	return proton.MessageMetadata{
+		ID:       messageID,
+		LabelIDs: m.labelIDs,
+		Unread:   proton.Bool(m.unread),
+		Flags:    m.flags,
+		Time:     m.time,
+	}
+}
+
+func (m *mailboxSource) getMessageInfoList() []messageInfo {
+	return m.infos
+}
+
+// addMessage registers a message found in the given folder. Messages sharing the same non-empty key are merged.
+func (m *mailboxSource) addMessage(key string, folderPath []string, msg mailboxMessage, flags mailboxFlags) {
+	labelID := m.getLabelIDForPath(folderPath)
+
+	if existing, ok := m.messages[key]; ok && len(key) != 0 {
+		if len(labelID) != 0 && !slices.Contains(existing.labelIDs, labelID) && (!m.isFolder(labelID) || !slices.ContainsFunc(existing.labelIDs, m.isFolder)) {
+			existing.labelIDs = append(existing.labelIDs, labelID)
+		}
+
+		if flags.flagged && !slices.Contains(existing.labelIDs, proton.StarredLabel) {
+			existing.labelIDs = append(existing.labelIDs, proton.StarredLabel)
+		}
+
+		existing.unread = existing.unread && !flags.seen
+
+		return
+	}
+
+	if len(key) == 0 {
+		key = fmt.Sprintf("%v:%v", msg.path, msg.offset)
+	}
+
+	if len(labelID) != 0 {
+		msg.labelIDs = append(msg.labelIDs, labelID)
+	}
+
+	if flags.flagged && labelID != proton.StarredLabel {
+		msg.labelIDs = append(msg.labelIDs, proton.StarredLabel)
+	}
+
+	msg.unread = !flags.seen
+	msg.flags = mailboxMessageFlags(labelID, flags)
+
+	m.messages[key] = &msg
+	m.infos = append(m.infos, messageInfo{messageID: key, timestamp: msg.time})
+}
+
+func (m *mailboxSource) isFolder(labelID string) bool {
+	if isSystemLabel(labelID) {
+		return isFolderSystemLabel(labelID)
+	}
+
+	index := slices.IndexFunc(m.labels, func(label proton.Label) bool { return label.ID == labelID })
+
+	return index >= 0 && m.labels[index].Type == proton.LabelTypeFolder
+}
+
+// getLabelIDForPath returns the ID of the label matching the folder path, creating it and its parents if needed.
+// An empty ID is returned for the folders which do not map to any label, such as 'All Mail'.
+func (m *mailboxSource) getLabelIDForPath(path []string) string {
+	if len(path) == 0 {
+		return ""
+	}
+
+	if len(path) == 1 {
+		if labelID, ok := systemLabelFromFolderName(path[0]); ok {
+			if len(labelID) != 0 {
+				m.addLabel(proton.Label{ID: labelID, Name: path[0], Type: proton.LabelTypeSystem})
+			}
+
+			return labelID
+		}
+	}
+
+	labelType, idPrefix := proton.LabelTypeFolder, "folder"
+
+	if len(path) > 1 {
+		switch path[0] {
+		case labelLayoutFoldersDir:
+			path = path[1:]
+		case labelLayoutLabelsDir:
+			labelType, idPrefix = proton.LabelTypeLabel, "label"
+			path = path[1:]
+		}
+	}
+
+	var parentID string
+
+	for i := range path {
+		labelID := fmt.Sprintf("%v:%v", idPrefix, strings.Join(path[:i+1], "/"))
+		m.addLabel(proton.Label{ID: labelID, ParentID: parentID, Name: path[i], Color: mailboxLabelColor, Type: labelType})
+		parentID = labelID
+	}
+
+	return parentID
+}
+
+func (m *mailboxSource) addLabel(label proton.Label) {
+	if !slices.ContainsFunc(m.labels, func(l proton.Label) bool { return l.ID == label.ID }) {
+		m.labels = append(m.labels, label)
+	}
+}
+
+// systemLabelFromFolderName maps the usual names of special folders to system labels. The returned ID is empty for the
+// folders which aggregate others.
+func systemLabelFromFolderName(name string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "inbox":
+		return proton.InboxLabel, true
+	case "sent", "sent items", "sent messages", "sent mail":
+		return proton.SentLabel, true
+	case "drafts", "draft":
+		return proton.DraftsLabel, true
+	case "trash", "deleted items", "deleted messages", "bin":
+		return proton.TrashLabel, true
+	case "spam", "junk", "junk e-mail", "junk email", "bulk mail":
+		return proton.SpamLabel, true
+	case "archive", "archives":
+		return proton.ArchiveLabel, true
+	case "outbox":
+		return proton.OutboxLabel, true
+	case "starred", "flagged":
+		return proton.StarredLabel, true
+	case "all mail":
+		return "", true
+	}
+
+	return "", false
+}
+
+func mailboxMessageFlags(labelID string, flags mailboxFlags) proton.MessageFlag {
+	var result proton.MessageFlag
+
+	switch {
+	case labelID == proton.SentLabel:
+		result = proton.MessageFlagSent
+	case labelID == proton.DraftsLabel || flags.draft:
+	default:
+		result = proton.MessageFlagReceived
+	}
+
+	if flags.replied {
+		result |= proton.MessageFlagReplied
+	}
+
+	if flags.forwarded {
+		result |= proton.MessageFlagForwarded
+	}
+
+	return result
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"encoding/base64"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+)
+
+// newMaildirSource lists the messages of the Maildir tree rooted at dir. Both the Maildir++ layout, where sub-folders
+// are dot separated names, and the nested directory layout are supported. The messages of encrypted exports are
+// decrypted with the decrypter.
+func newMaildirSource(ctx context.Context, dir string, folders []string, decrypter utils.FileDecrypter) (*mailboxSource, error) {
+	source := newMailboxSource(func(msg *mailboxMessage) ([]byte, error) {
+		return utils.ReadFileDecrypted(msg.path, decrypter)
+	})
+
+	for _, folder := range folders {
+		rel, err := filepath.Rel(dir, folder)
+		if err != nil {
+			return nil, err
+		}
+
+		folderPath := maildirFolderPath(rel)
+
+		for _, sub := range []string{"cur", "new"} {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			entries, err := os.ReadDir(filepath.Join(folder, sub))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+					continue
+				}
+
+				key, flags := parseMaildirFileName(entry.Name())
+
+				timestamp, ok := maildirFileTime(key)
+				if !ok {
+					if info, err := entry.Info(); err == nil {
+						timestamp = info.ModTime().Unix()
+					}
+				}
+
+				source.addMessage(key, folderPath, mailboxMessage{
+					path:   filepath.Join(folder, sub, entry.Name()),
+					length: -1,
+					time:   timestamp,
+				}, flags)
+			}
+		}
+	}
+
+	return source, nil
+}
+
+// findMaildirFolders returns the Maildir folders found in dir and the root of the Maildir tree. The root is the top
+// most folder when all the others are nested in it, dir otherwise.
+func findMaildirFolders(ctx context.Context, dir string) (string, []string, error) {
+	var folders []string
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil || !entry.IsDir() {
+			return nil //nolint:nilerr // unreadable entries are skipped.
+		}
+
+		if path != dir && mailFolderRegExp.MatchString(entry.Name()) {
+			return fs.SkipDir
+		}
+
+		if isMaildirFolder(path) {
+			folders = append(folders, path)
+		}
+
+		switch entry.Name() {
+		case "cur", "new", "tmp":
+			if path != dir {
+				return fs.SkipDir
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	root := dir
+	if len(folders) != 0 && folders[0] != dir {
+		candidate := folders[0]
+		nested := true
+
+		for _, folder := range folders[1:] {
+			if !strings.HasPrefix(folder, candidate+string(filepath.Separator)) {
+				nested = false
+				break
+			}
+		}
+
+		if nested {
+			root = candidate
+		}
+	}
+
+	return root, folders, nil
+}
+
+func isMaildirFolder(dir string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// maildirFolderPath converts the path of a Maildir folder relative to the root of the tree to a folder path.
+// The root folder is the Inbox.
+func maildirFolderPath(rel string) []string {
+	var path []string
+
+	for _, component := range strings.Split(filepath.ToSlash(rel), "/") {
+		if component == "." || len(component) == 0 {
+			continue
+		}
+
+		names := []string{component}
+		if strings.HasPrefix(component, ".") {
+			names = strings.Split(component[1:], ".")
+		}
+
+		for _, name := range names {
+			if len(name) != 0 {
+				path = append(path, decodeModifiedUTF7(name))
+			}
+		}
+	}
+
+	if len(path) == 0 {
+		return []string{"Inbox"}
+	}
+
+	return path
+}
+
+// parseMaildirFileName splits a Maildir file name into its unique part and its info flags.
+func parseMaildirFileName(name string) (string, mailboxFlags) {
+	var flags mailboxFlags
+
+	index := strings.LastIndex(name, ":2,")
+	if index < 0 {
+		index = strings.LastIndex(name, "!2,")
+	}
+
+	if index < 0 {
+		return name, flags
+	}
+
+	for _, flag := range name[index+3:] {
+		switch flag {
+		case 'S':
+			flags.seen = true
+		case 'F':
+			flags.flagged = true
+		case 'R':
+			flags.replied = true
+		case 'P':
+			flags.forwarded = true
+		case 'D':
+			flags.draft = true
+		}
+	}
+
+	return name[:index], flags
+}
+
+// maildirFileTime returns the delivery time embedded at the start of unique Maildir file names.
+func maildirFileTime(key string) (int64, bool) {
+	prefix, _, _ := strings.Cut(key, ".")
+
+	timestamp, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return timestamp, true
+}
+
+// decodeModifiedUTF7 decodes a folder name encoded with encodeModifiedUTF7. Names which are not valid modified UTF-7
+// are returned as is.
+func decodeModifiedUTF7(name string) string {
+	if !strings.Contains(name, "&") {
+		return name
+	}
+
+	encoding := base64.StdEncoding.WithPadding(base64.NoPadding)
+
+	var builder strings.Builder
+
+	for remaining := name; len(remaining) != 0; {
+		start := strings.IndexByte(remaining, '&')
+		if start < 0 {
+			builder.WriteString(remaining)
+			break
+		}
+
+		builder.WriteString(remaining[:start])
+
+		end := strings.IndexByte(remaining[start:], '-')
+		if end < 0 {
+			return name
+		}
+
+		encoded := remaining[start+1 : start+end]
+		remaining = remaining[start+end+1:]
+
+		if len(encoded) == 0 {
+			builder.WriteByte('&')
+			continue
+		}
+
+		data, err := encoding.DecodeString(strings.ReplaceAll(encoded, ",", "/"))
+		if err != nil || len(data)%2 != 0 {
+			return name
+		}
+
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i < len(data); i += 2 {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		}
+
+		builder.WriteString(string(utf16.Decode(units)))
+	}
+
+	return builder.String()
+}
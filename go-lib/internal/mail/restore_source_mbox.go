@@ -0,0 +1,277 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// newMboxSource lists the messages of the given mbox files. The folder of each file is its path relative to dir,
+// without extension.
+func newMboxSource(ctx context.Context, dir string, files []string) (*mailboxSource, error) {
+	source := newMailboxSource(readMboxMessage)
+
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			rel = filepath.Base(file)
+		}
+
+		folderPath := strings.Split(filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))), "/")
+
+		if err := scanMboxFile(ctx, file, func(msg mailboxMessage, messageID string, flags mailboxFlags) {
+			source.addMessage(messageID, folderPath, msg, flags)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return source, nil
+}
+
+// findMboxFiles returns the mbox files found in dir.
+func findMboxFiles(ctx context.Context, dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			return nil //nolint:nilerr // unreadable entries are skipped.
+		}
+
+		if entry.IsDir() {
+			if path != dir && mailFolderRegExp.MatchString(entry.Name()) {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if strings.EqualFold(filepath.Ext(path), mboxExtension) {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// scanMboxFile calls fn for every message of the mbox file, along with its Message-ID and status flags.
+// Messages start with a 'From ' line at the beginning of the file or after an empty line.
+func scanMboxFile(ctx context.Context, path string, fn func(msg mailboxMessage, messageID string, flags mailboxFlags)) error {
+	file, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to open mbox file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	return scanMbox(ctx, file, path, fn)
+}
+
+// scanMbox is scanMboxFile for the content of the mbox file at path read from r.
+func scanMbox(ctx context.Context, r io.Reader, path string, fn func(msg mailboxMessage, messageID string, flags mailboxFlags)) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+
+	var (
+		offset        int64
+		current       *mailboxMessage
+		messageID     string
+		flags         mailboxFlags
+		inHeader      bool
+		atLineStart   = true
+		previousBlank = true
+		blankStart    int64
+	)
+
+	flush := func(end int64) {
+		if current == nil {
+			return
+		}
+
+		current.length = end - current.offset
+		fn(*current, messageID, flags)
+		current = nil
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		line, err := reader.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read mbox file: %w", err)
+		}
+
+		lineStart := offset
+		offset += int64(len(line))
+		isFullLine := !errors.Is(err, bufio.ErrBufferFull)
+
+		if atLineStart && len(line) != 0 {
+			isBlank := len(bytes.TrimRight(line, "\r\n")) == 0 && isFullLine
+
+			switch {
+			case previousBlank && bytes.HasPrefix(line, []byte("From ")):
+				// The empty line before the separator is not part of the previous message.
+				flush(blankStart)
+
+				current = &mailboxMessage{path: path, offset: offset, time: parseMboxFromLineTime(line)}
+				messageID, flags, inHeader = "", mailboxFlags{}, true
+			case current != nil && inHeader && isBlank:
+				inHeader = false
+			case current != nil && inHeader:
+				parseMboxHeader(line, current, &messageID, &flags)
+			}
+
+			if isBlank {
+				blankStart = lineStart
+			}
+
+			previousBlank = isBlank
+		}
+
+		atLineStart = isFullLine
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	end := offset
+	if previousBlank && current != nil && blankStart >= current.offset {
+		end = blankStart
+	}
+
+	flush(end)
+
+	return nil
+}
+
+func parseMboxHeader(line []byte, msg *mailboxMessage, messageID *string, flags *mailboxFlags) {
+	name, value, ok := strings.Cut(strings.TrimRight(string(line), "\r\n"), ":")
+	if !ok {
+		return
+	}
+
+	value = strings.TrimSpace(value)
+
+	switch strings.ToLower(name) {
+	case "message-id":
+		*messageID = value
+	case "status":
+		flags.seen = strings.ContainsRune(value, 'R')
+	case "x-status":
+		flags.replied = strings.ContainsRune(value, 'A')
+		flags.flagged = strings.ContainsRune(value, 'F')
+		flags.draft = strings.ContainsRune(value, 'T')
+	case "date":
+		if msg.time == 0 {
+			if date, err := mail.ParseDate(value); err == nil {
+				msg.time = date.Unix()
+			}
+		}
+	}
+}
+
+// parseMboxFromLineTime returns the time at the end of a 'From ' line, or 0 if it can't be parsed.
+func parseMboxFromLineTime(line []byte) int64 {
+	const asctime = "Mon Jan _2 15:04:05 2006"
+
+	text := strings.TrimRight(string(line), "\r\n")
+	if len(text) < len(asctime) {
+		return 0
+	}
+
+	date, err := time.Parse(asctime, text[len(text)-len(asctime):])
+	if err != nil {
+		return 0
+	}
+
+	return date.Unix()
+}
+
+// readMboxMessage reads a message from an mbox file, removing the quoting of 'From ' lines and the mbox status headers.
+func readMboxMessage(msg *mailboxMessage) ([]byte, error) {
+	file, err := os.Open(msg.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	data := make([]byte, msg.length)
+	if _, err := file.ReadAt(data, msg.offset); err != nil {
+		return nil, err
+	}
+
+	return unquoteMboxMessage(data), nil
+}
+
+// unquoteMboxMessage returns the message of an mbox entry, without the quoting of 'From ' lines and the mbox status
+// headers.
+func unquoteMboxMessage(data []byte) []byte {
+	var buffer bytes.Buffer
+	buffer.Grow(len(data))
+
+	inHeader := true
+
+	for remaining := data; len(remaining) != 0; {
+		line := remaining
+		if i := bytes.IndexByte(remaining, '\n'); i >= 0 {
+			line = remaining[:i+1]
+		}
+
+		remaining = remaining[len(line):]
+
+		if inHeader {
+			if len(bytes.TrimRight(line, "\r\n")) == 0 {
+				inHeader = false
+			} else if isMboxStatusHeader(line) {
+				continue
+			}
+		}
+
+		if bytes.HasPrefix(line, []byte(">")) && isMboxFromLine(line) {
+			line = line[1:]
+		}
+
+		buffer.Write(line)
+	}
+
+	return buffer.Bytes()
+}
+
+func isMboxStatusHeader(line []byte) bool {
+	lower := bytes.ToLower(line)
+
+	return bytes.HasPrefix(lower, []byte("status:")) || bytes.HasPrefix(lower, []byte("x-status:"))
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/bradenaw/juniper/xslices"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
+)
+
+func TestMboxSource(t *testing.T) {
+	dir := t.TempDir()
+
+	first := proton.MessageMetadata{ID: "1", Time: 1700000000, Unread: true, Flags: proton.MessageFlagReceived}
+	second := proton.MessageMetadata{ID: "2", Time: 1700000100, LabelIDs: []string{proton.StarredLabel}, IsReplied: true, Flags: proton.MessageFlagReceived}
+
+	firstEML := "Message-ID: <1@proton.me>\r\nSubject: first\r\n\r\nFrom the start\r\n>From quoted\r\n"
+	secondEML := "Message-ID: <2@proton.me>\nSubject: second\n\nbody\n\n"
+
+	var data []byte
+	data = append(data, newMboxEntry(first, []byte(firstEML))...)
+	data = append(data, newMboxEntry(second, []byte(secondEML))...)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Folders", "Work"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Folders", "Work", "Projects.mbox"), data, 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Labels"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Labels", "Important.mbox"), newMboxEntry(second, []byte(secondEML)), 0o600))
+
+	files, err := findMboxFiles(context.Background(), dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	source, err := newMboxSource(context.Background(), dir, files)
+	require.NoError(t, err)
+
+	infos := source.getMessageInfoList()
+	require.Len(t, infos, 2)
+
+	messages := make(map[int64]Message)
+
+	for _, info := range infos {
+		msg, err := source.readMessage(info)
+		require.NoError(t, err)
+
+		messages[msg.metadata.Time] = msg
+	}
+
+	require.Equal(t, firstEML, string(messages[first.Time].literal))
+	require.True(t, bool(messages[first.Time].metadata.Unread))
+	require.Equal(t, proton.MessageFlagReceived, messages[first.Time].metadata.Flags)
+
+	require.Equal(t, secondEML, string(messages[second.Time].literal))
+	require.False(t, bool(messages[second.Time].metadata.Unread))
+	require.Equal(t, proton.MessageFlagReceived|proton.MessageFlagReplied, messages[second.Time].metadata.Flags)
+	require.ElementsMatch(t, []string{"folder:Work/Projects", proton.StarredLabel, "label:Important"}, messages[second.Time].metadata.LabelIDs)
+
+	labels, err := source.getLabels()
+	require.NoError(t, err)
+	require.Equal(t, []proton.Label{
+		{ID: "folder:Work", Name: "Work", Color: mailboxLabelColor, Type: proton.LabelTypeFolder},
+		{ID: "folder:Work/Projects", ParentID: "folder:Work", Name: "Projects", Color: mailboxLabelColor, Type: proton.LabelTypeFolder},
+		{ID: "label:Important", Name: "Important", Color: mailboxLabelColor, Type: proton.LabelTypeLabel},
+	}, labels)
+}
+
+func TestMaildirSource(t *testing.T) {
+	dir := t.TempDir()
+
+	labels := []proton.Label{
+		{ID: proton.InboxLabel, Name: "Inbox", Type: proton.LabelTypeSystem},
+		{ID: proton.SentLabel, Name: "Sent", Type: proton.LabelTypeSystem},
+		{ID: "label", Name: "Über", Type: proton.LabelTypeLabel},
+	}
+
+	store := NewMaildirStore(dir, labels)
+
+	require.NoError(t, store.StoreMessage(proton.MessageMetadata{
+		ID:       "received",
+		Time:     1700000000,
+		LabelIDs: []string{proton.InboxLabel, proton.StarredLabel, "label"},
+		Flags:    proton.MessageFlagReceived,
+	}, []byte("Subject: received\r\n\r\n"), &utils.DiskFileWriter{}, nil))
+
+	require.NoError(t, store.StoreMessage(proton.MessageMetadata{
+		ID:       "sent",
+		Time:     1700000100,
+		Unread:   true,
+		LabelIDs: []string{proton.SentLabel},
+		Flags:    proton.MessageFlagSent,
+	}, []byte("Subject: sent\r\n\r\n"), &utils.DiskFileWriter{}, nil))
+
+	// Messages delivered to new/ have no flags.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, maildirRootDir, "new", "1700000200.new.host"), []byte("Subject: new\r\n\r\n"), 0o600))
+
+	root, folders, err := findMaildirFolders(context.Background(), dir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, maildirRootDir), root)
+	require.Len(t, folders, 3)
+
+	source, err := newMaildirSource(context.Background(), root, folders, nil)
+	require.NoError(t, err)
+
+	infos := source.getMessageInfoList()
+	slices.SortFunc(infos, func(lhs, rhs messageInfo) bool { return lhs.timestamp < rhs.timestamp })
+	require.Len(t, infos, 3)
+
+	received, err := source.readMessage(infos[0])
+	require.NoError(t, err)
+	require.Equal(t, "Subject: received\r\n\r\n", string(received.literal))
+	require.False(t, bool(received.metadata.Unread))
+	require.ElementsMatch(t, []string{proton.InboxLabel, proton.StarredLabel, "label:Über"}, received.metadata.LabelIDs)
+
+	sent, err := source.readMessage(infos[1])
+	require.NoError(t, err)
+	require.True(t, bool(sent.metadata.Unread))
+	require.Equal(t, proton.MessageFlagSent, sent.metadata.Flags)
+	require.Equal(t, []string{proton.SentLabel}, sent.metadata.LabelIDs)
+
+	fresh, err := source.readMessage(infos[2])
+	require.NoError(t, err)
+	require.True(t, bool(fresh.metadata.Unread))
+	require.Equal(t, []string{proton.InboxLabel}, fresh.metadata.LabelIDs)
+}
+
+func TestMaildirFolderPath(t *testing.T) {
+	require.Equal(t, []string{"Inbox"}, maildirFolderPath("."))
+	require.Equal(t, []string{"Folders", "Work", "Projects"}, maildirFolderPath(".Folders.Work.Projects"))
+	require.Equal(t, []string{"Work", "Entwürfe"}, maildirFolderPath(filepath.Join("Work", "Entw&APw-rfe")))
+}
+
+func TestParseMaildirFileName(t *testing.T) {
+	key, flags := parseMaildirFileName("1700000000.id.host:2,FRS")
+	require.Equal(t, "1700000000.id.host", key)
+	require.Equal(t, mailboxFlags{seen: true, flagged: true, replied: true}, flags)
+
+	key, flags = parseMaildirFileName("1700000000.id.host")
+	require.Equal(t, "1700000000.id.host", key)
+	require.Equal(t, mailboxFlags{}, flags)
+
+	timestamp, ok := maildirFileTime(key)
+	require.True(t, ok)
+	require.Equal(t, int64(1700000000), timestamp)
+}
+
+func TestDecodeModifiedUTF7(t *testing.T) {
+	for _, name := range []string{"Work", "R&D", "日本語", "Entwürfe", "a&b&c"} {
+		require.Equal(t, name, decodeModifiedUTF7(encodeModifiedUTF7(name)))
+	}
+
+	require.Equal(t, "&invalid", decodeModifiedUTF7("&invalid"))
+}
+
+func TestSystemLabelFromFolderName(t *testing.T) {
+	labelID, ok := systemLabelFromFolderName("Sent Items")
+	require.True(t, ok)
+	require.Equal(t, proton.SentLabel, labelID)
+
+	labelID, ok = systemLabelFromFolderName("All Mail")
+	require.True(t, ok)
+	require.Empty(t, labelID)
+
+	_, ok = systemLabelFromFolderName("Work")
+	require.False(t, ok)
+}
+
+func TestBackupDirSource_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	cipher := utils.NewPGPPasswordFileCipher([]byte("secret"))
+	literal := []byte("Subject: hello\r\n\r\nworld")
+
+	labels, err := utils.GenerateVersionedJSON(LabelMetadataVersion, []proton.Label{{ID: "label", Name: "Label", Type: proton.LabelTypeLabel}})
+	require.NoError(t, err)
+	require.NoError(t, utils.WriteEncryptedFileSafe(dir, filepath.Join(dir, getLabelFileName()), labels, cipher, nil))
+
+	metadata := MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: "msg", Time: 1700000000, LabelIDs: []string{"label"}}}
+	metadataBytes, err := metadata.toBytes()
+	require.NoError(t, err)
+	require.NoError(t, utils.WriteEncryptedFileSafe(dir, filepath.Join(dir, getMetadataFileName("msg")), metadataBytes, cipher, nil))
+	require.NoError(t, utils.WriteEncryptedFileSafe(dir, filepath.Join(dir, getEMLFileName("msg")), literal, cipher, nil))
+
+	restore := &RestoreTask{ctx: context.Background(), backupDir: dir, log: logrus.WithField("test", "test")}
+
+	_, err = restore.collectBackupMessages()
+	require.ErrorIs(t, err, utils.ErrFileEncrypted)
+
+	restore.SetDecrypter(utils.NewPGPPasswordFileCipher([]byte("wrong")))
+	_, err = restore.collectBackupMessages()
+	require.ErrorIs(t, err, utils.ErrDecryptionFailed)
+
+	restore.SetDecrypter(cipher)
+	messageList, err := restore.collectBackupMessages()
+	require.NoError(t, err)
+	require.Len(t, messageList, 1)
+
+	source := newBackupDirSource(dir, cipher)
+
+	backupLabels, err := source.getLabels()
+	require.NoError(t, err)
+	require.Len(t, backupLabels, 1)
+	require.Equal(t, "Label", backupLabels[0].Name)
+
+	message, err := source.readMessage(messageList[0])
+	require.NoError(t, err)
+	require.Equal(t, literal, message.literal)
+	require.Equal(t, []string{"label"}, message.metadata.LabelIDs)
+}
+
+func TestBackupDirSource_LabelLayout(t *testing.T) {
+	dir := t.TempDir()
+	messages := writeTestLabelLayoutExport(t, dir)
+
+	// A flat message next to those of the label layout.
+	metadataBytes, err := (&MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: "flat", Time: 1700000300}}).toBytes()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, getMetadataFileName("flat")), metadataBytes, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, getEMLFileName("flat")), []byte("Subject: flat\r\n\r\n"), 0o600))
+
+	restore := &RestoreTask{ctx: context.Background(), backupDir: dir, log: logrus.WithField("test", "test")}
+
+	messageList, err := restore.collectBackupMessages()
+	require.NoError(t, err)
+
+	// The messages which could not be built are not restored, the hard links of the labels are not listed twice.
+	ids := xslices.Map(messageList, func(info messageInfo) string { return info.messageID })
+	slices.Sort(ids)
+	require.Equal(t, []string{"all-mail", "flat", "inbox", "projects"}, ids)
+
+	source := newBackupDirSource(dir, nil)
+
+	for _, info := range messageList {
+		message, err := source.readMessage(info)
+		require.NoError(t, err)
+		require.Equal(t, info.messageID, message.metadata.ID)
+
+		if info.messageID == messages[0].ID {
+			require.Equal(t, messages[0].LabelIDs, message.metadata.LabelIDs)
+			require.Equal(t, "Subject: Hello\r\n\r\nbody 0\r\n", string(message.literal))
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-proton-api"
+)
+
+// dedupTimeTolerance is the largest difference between the time of a backed up message and of a remote message for
+// them to be considered the same. It absorbs the difference between the delivery time recorded by other clients and the
+// time derived from the Date header on import.
+const dedupTimeTolerance = time.Hour
+
+// remoteMessageIndex holds the messages already present in the account, so that restoring the same backup twice does
+// not duplicate the mailbox.
+type remoteMessageIndex struct {
+	externalIDs map[string][]remoteMessage
+	messageIDs  map[string]struct{}
+}
+
+// remoteMessage is what is compared, besides the Message-ID, to decide whether a message of the backup is already
+// present in the account.
+type remoteMessage struct {
+	time     int64
+	labelIDs []string
+}
+
+func (r *RestoreTask) buildRemoteMessageIndex() (*remoteMessageIndex, error) {
+	r.log.Info("Listing remote messages for duplicate detection")
+
+	index := &remoteMessageIndex{
+		externalIDs: make(map[string][]remoteMessage),
+		messageIDs:  make(map[string]struct{}),
+	}
+
+	filter := proton.MessageFilter{Desc: true}
+
+	for {
+		if r.ctx.Err() != nil {
+			return nil, r.ctx.Err()
+		}
+
+		metadata, err := r.session.GetClient().GetMessageMetadataPage(r.ctx, 0, MetadataPageSize, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		// The message matching the EndID of the query is returned again.
+		if len(metadata) != 0 && metadata[0].ID == filter.EndID {
+			metadata = metadata[1:]
+		}
+
+		if len(metadata) == 0 {
+			break
+		}
+
+		for _, m := range metadata {
+			index.add(m)
+		}
+
+		filter.EndID = metadata[len(metadata)-1].ID
+	}
+
+	r.log.WithField("remoteMessageCount", len(index.messageIDs)).Info("Listed remote messages")
+
+	return index, nil
+}
+
+func (i *remoteMessageIndex) add(metadata proton.MessageMetadata) {
+	i.messageIDs[metadata.ID] = struct{}{}
+
+	if externalID := normalizeExternalID(metadata.ExternalID); len(externalID) != 0 {
+		i.externalIDs[externalID] = append(i.externalIDs[externalID], remoteMessage{
+			time:     metadata.Time,
+			labelIDs: metadata.LabelIDs,
+		})
+	}
+}
+
+// contains returns true if the message is already present in the account, either because the Proton message it was
+// exported from still exists or because a remote message has the same Message-ID, a close enough time and all the
+// remote labels the message would be imported with.
+func (i *remoteMessageIndex) contains(message Message, labelIDs []string) bool {
+	externalID := normalizeExternalID(message.metadata.ExternalID)
+	timestamp := message.metadata.Time
+	var internalID string
+
+	if header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(message.literal))).ReadMIMEHeader(); err == nil {
+		if len(externalID) == 0 {
+			externalID = normalizeExternalID(header.Get("Message-Id"))
+		}
+
+		if timestamp == 0 {
+			if date, err := mail.ParseDate(header.Get("Date")); err == nil {
+				timestamp = date.Unix()
+			}
+		}
+
+		internalID = strings.TrimSpace(header.Get("X-Pm-Internal-Id"))
+	}
+
+	if _, ok := i.messageIDs[internalID]; ok && len(internalID) != 0 {
+		return true
+	}
+
+	if len(externalID) == 0 {
+		return false
+	}
+
+	return slices.ContainsFunc(i.externalIDs[externalID], func(remote remoteMessage) bool {
+		return remote.matches(timestamp, labelIDs)
+	})
+}
+
+func (m remoteMessage) matches(timestamp int64, labelIDs []string) bool {
+	if diff := time.Duration(m.time-timestamp) * time.Second; diff > dedupTimeTolerance || diff < -dedupTimeTolerance {
+		return false
+	}
+
+	for _, labelID := range labelIDs {
+		if !slices.Contains(m.labelIDs, labelID) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizeExternalID(id string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">")
+}
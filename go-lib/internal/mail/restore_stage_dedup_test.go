@@ -0,0 +1,53 @@
+// Copyright (c) 2024 Proton AG
+//
+// This file is part of Proton Mail Bridge.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Mail Bridge. If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"testing"
+
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteMessageIndex_Contains(t *testing.T) {
+	index := &remoteMessageIndex{
+		externalIDs: make(map[string][]remoteMessage),
+		messageIDs:  make(map[string]struct{}),
+	}
+
+	index.add(proton.MessageMetadata{ID: "remote-1", ExternalID: "<present@example.com>", Time: 1700000000, LabelIDs: []string{proton.InboxLabel, "import"}})
+	index.add(proton.MessageMetadata{ID: "remote-2"})
+
+	inbox := []string{proton.InboxLabel}
+
+	// Matched on the external ID of the backed up metadata.
+	require.True(t, index.contains(Message{metadata: proton.MessageMetadata{ExternalID: "present@example.com", Time: 1700000000}}, inbox))
+
+	// Matched on the Message-ID and Date headers.
+	require.True(t, index.contains(Message{literal: []byte("Message-Id: <present@example.com>\r\nDate: Tue, 14 Nov 2023 22:13:20 +0000\r\n\r\nbody")}, inbox))
+
+	// Matched on the internal ID of a message exported from the same account.
+	require.True(t, index.contains(Message{literal: []byte("Message-Id: <other@example.com>\r\nX-Pm-Internal-Id: remote-2\r\n\r\nbody")}, inbox))
+
+	// The same Message-ID in another folder or at another time is a different message.
+	require.False(t, index.contains(Message{metadata: proton.MessageMetadata{ExternalID: "present@example.com", Time: 1700000000}}, []string{proton.SentLabel}))
+	require.False(t, index.contains(Message{metadata: proton.MessageMetadata{ExternalID: "present@example.com", Time: 1600000000}}, inbox))
+
+	require.False(t, index.contains(Message{literal: []byte("Message-Id: <other@example.com>\r\n\r\nbody")}, inbox))
+	require.False(t, index.contains(Message{literal: []byte("Subject: no identifiers\r\n\r\nbody")}, inbox))
+}
@@ -20,8 +20,6 @@ package mail
 import (
 	"bytes"
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/ProtonMail/gopenpgp/v2/crypto"
@@ -39,38 +37,76 @@ type Message struct {
 const messageBatchSize = 10 // max batch size supported by go-proton-api (larger batches will be split).
 
 func (r *RestoreTask) importMails(messageInfoList []messageInfo, reporter Reporter) error {
-	return r.withAddrKR(func(addrID string, addrKR *crypto.KeyRing) error {
-		messages := make([]Message, 0, messageBatchSize)
+	return r.withAddrKRs(func(addresses []proton.Address, addrKRs map[string]*crypto.KeyRing) error {
+		mapper, err := newAddressMapper(addresses, r.addressMapping, r.addressFallback)
+		if err != nil {
+			return err
+		}
+
+		// Messages are imported in batches per address as each batch is encrypted with the key of its address.
+		batches := make(map[string][]Message, len(addresses))
+		flush := func(addrID string) error {
+			if len(batches[addrID]) == 0 {
+				return nil
+			}
+
+			err := r.importMailBatch(addrID, addrKRs[addrID], batches[addrID], reporter)
+			batches[addrID] = batches[addrID][:0]
+
+			return err
+		}
+
 		for _, info := range messageInfoList {
-			emlPath := filepath.Join(r.backupDir, info.messageID+emlExtension)
-			literal, err := os.ReadFile(emlPath) //nolint:gosec
-			if err != nil {
-				logrus.WithField("path", emlPath).Error("Could not read EML file. Skipping.")
+			if r.journal.isImported(info.messageID) {
+				r.importedCount++
+				r.resumedCount++
+				r.events.ReportEvent(Event{
+					Type:      EventMessageImported,
+					Stage:     "import",
+					MessageID: info.messageID,
+					Reason:    "imported by a previous restore",
+				})
 				reporter.OnProgress(1)
 				continue
 			}
 
-			metadataPath := emlToMetadataFilename(emlPath)
-			metadata, err := loadMetadataFile(metadataPath)
+			message, err := r.source.readMessage(info)
 			if err != nil {
-				logrus.WithField("path", metadataPath).Error("Could not load metadata file. Skipping.")
+				logrus.WithError(err).Error("Could not read message. Skipping.")
+				r.onMessageUnreadable(proton.MessageMetadata{ID: info.messageID}, err)
+				reporter.OnProgress(1)
+				continue
+			}
+
+			if r.isAlreadyPresent(message) {
+				r.log.WithField("messageID", message.metadata.ID).Debug("Message is already present. Skipping.")
+				r.presentCount++
+				r.events.ReportEvent(Event{
+					Type:      EventMessageSkipped,
+					Stage:     "import",
+					MessageID: message.metadata.ID,
+					Reason:    "already present in the account",
+				})
 				reporter.OnProgress(1)
+				continue
 			}
 
-			messages = append(messages, Message{literal: literal, metadata: metadata.MessageMetadata})
-			if len(messages) >= messageBatchSize {
-				if err := r.importMailBatch(addrID, addrKR, messages, reporter); err != nil {
+			addrID := mapper.getAddressID(message.metadata)
+			batches[addrID] = append(batches[addrID], message)
+
+			if len(batches[addrID]) >= messageBatchSize {
+				if err := flush(addrID); err != nil {
 					return err
 				}
-				messages = messages[:0]
 			}
 		}
 
-		if len(messages) > 0 {
-			if err := r.importMailBatch(addrID, addrKR, messages, reporter); err != nil {
+		for _, address := range addresses {
+			if err := flush(address.ID); err != nil {
 				return err
 			}
 		}
+
 		return nil
 	})
 }
@@ -79,19 +115,20 @@ func (r *RestoreTask) importMailBatch(addrID string, addrKR *crypto.KeyRing, mes
 	defer reporter.OnProgress(len(messages))
 
 	reqs := make([]proton.ImportReq, 0, len(messages))
+	reqMessages := make([]Message, 0, len(messages)) // the messages matching each request.
 	for _, message := range messages {
 		log := r.log.WithField("messageID", message.metadata.AddressID)
 		labelIDs, err := r.getLabelList(message.metadata.LabelIDs)
 		if err != nil {
 			log.WithField("messageID", message.metadata.ID).WithError(err).Error("Could not map label to remote labels.")
-			r.failedCount++
+			r.onMessageFailed(message.metadata, FailureImport, err)
 			continue
 		}
 
 		msgParser, err := parser.New(bytes.NewReader(message.literal))
 		if err != nil {
 			log.WithField(message.metadata.ID, message.metadata).WithError(err).Error("Failed to parse literal for message.")
-			r.failedCount++
+			r.onMessageFailed(message.metadata, FailureRead, err)
 			continue
 		}
 
@@ -100,6 +137,7 @@ func (r *RestoreTask) importMailBatch(addrID string, addrKR *crypto.KeyRing, mes
 			buf := new(bytes.Buffer)
 			if err := msgParser.NewWriter().Write(buf); err != nil {
 				log.WithError(err).Error("failed to add an empty text body.")
+				r.onMessageUnreadable(message.metadata, err)
 				continue
 			}
 			message.literal = buf.Bytes()
@@ -114,6 +152,7 @@ func (r *RestoreTask) importMailBatch(addrID string, addrKR *crypto.KeyRing, mes
 			},
 			Message: message.literal,
 		})
+		reqMessages = append(reqMessages, message)
 	}
 
 	if len(reqs) == 0 {
@@ -123,23 +162,23 @@ func (r *RestoreTask) importMailBatch(addrID string, addrKR *crypto.KeyRing, mes
 	str, err := r.session.GetClient().ImportMessages(r.ctx, addrKR, -1, -1, reqs...)
 	if err != nil {
 		r.log.WithError(err).Error("Failed to prepare message batch for import. Retrying one by one.")
-		r.importOneByOne(reqs, messages, addrKR)
+		r.importOneByOne(reqs, reqMessages, addrKR)
 		return nil
 	}
 
 	results, err := stream.Collect(r.ctx, stream.Stream[proton.ImportRes](str))
 	if err != nil {
 		r.log.WithError(err).Error("An error occurred while importing a batch of messages. Retrying one by one.")
-		r.importOneByOne(reqs, messages, addrKR)
+		r.importOneByOne(reqs, reqMessages, addrKR)
 		return nil
 	}
 
 	for i, result := range results {
 		if result.Code != 1000 {
-			r.log.WithField("messageID", messages[i].metadata.ID).WithError(result.APIError).Error("Failed to import message")
-			r.failedCount++
+			r.log.WithField("messageID", reqMessages[i].metadata.ID).WithError(result.APIError).Error("Failed to import message")
+			r.onMessageFailed(reqMessages[i].metadata, FailureImportRejected, &result.APIError)
 		} else {
-			r.importedCount++
+			r.onMessageImported(reqMessages[i], result.MessageID)
 		}
 	}
 
@@ -151,26 +190,61 @@ func (r *RestoreTask) importOneByOne(requests []proton.ImportReq, messages []Mes
 		resultStream, err := r.session.GetClient().ImportMessages(r.ctx, addrKR, -1, -1, request)
 		if err != nil {
 			r.log.WithError(err).WithField("messageID", messages[i].metadata.ID).Error("Failed to import message")
-			r.failedCount++
+			r.onMessageFailed(messages[i].metadata, FailureImport, err)
 			continue
 		}
 
 		results, err := stream.Collect(r.ctx, stream.Stream[proton.ImportRes](resultStream))
 		if err != nil {
 			r.log.WithError(err).WithField("messageID", messages[i].metadata.ID).Error("Failed to import message")
-			r.failedCount++
+			r.onMessageFailed(messages[i].metadata, FailureImport, err)
 			continue
 		}
 
 		if results[0].Code != 1000 {
 			r.log.WithField("messageID", messages[i].metadata.ID).WithError(results[0].APIError).Error("Failed to import message")
-			r.failedCount++
+			r.onMessageFailed(messages[i].metadata, FailureImportRejected, &results[0].APIError)
 		} else {
-			r.importedCount++
+			r.onMessageImported(messages[i], results[0].MessageID)
 		}
 	}
 }
 
+func (r *RestoreTask) onMessageImported(message Message, remoteID string) {
+	r.importedCount++
+	r.events.ReportEvent(Event{Type: EventMessageImported, Stage: "import", MessageID: message.metadata.ID})
+
+	if err := r.journal.recordImported(message.metadata.ID, remoteID); err != nil {
+		r.log.WithError(err).WithField("messageID", message.metadata.ID).Warn("Failed to record imported message in restore journal")
+	}
+}
+
+func (r *RestoreTask) onMessageFailed(metadata proton.MessageMetadata, failure MessageFailure, err error) {
+	r.failedCount++
+	reportMessageFailed(r.events, "import", failure, metadata, err)
+}
+
+// onMessageUnreadable reports a message of the backup which could not be read. It is counted as skipped.
+func (r *RestoreTask) onMessageUnreadable(metadata proton.MessageMetadata, err error) {
+	r.events.ReportEvent(newFailureEvent(EventMessageSkipped, "import", FailureRead, metadata, err))
+}
+
+// isAlreadyPresent returns true if existing messages are skipped and the message is already in the account with the
+// labels it would be imported with.
+func (r *RestoreTask) isAlreadyPresent(message Message) bool {
+	if r.remoteIndex == nil {
+		return false
+	}
+
+	labelIDs, err := r.getLabelList(message.metadata.LabelIDs)
+	if err != nil {
+		// The import reports the failure.
+		return false
+	}
+
+	return r.remoteIndex.contains(message, labelIDs[1:])
+}
+
 func (r *RestoreTask) getLabelList(labels []string) ([]string, error) {
 	var result = make([]string, 0, len(labels)+1)
 	result = append(result, r.importLabelID)
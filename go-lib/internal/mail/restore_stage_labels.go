@@ -20,12 +20,9 @@ package mail
 import (
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/ProtonMail/export-tool/internal/utils"
 	"github.com/ProtonMail/go-proton-api"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/exp/slices"
@@ -34,7 +31,7 @@ import (
 var errCircularLabelReference = errors.New("unable to sort labels because of a circular reference")
 
 func (r *RestoreTask) restoreLabels() error {
-	backupLabels, err := r.readLabelFile()
+	backupLabels, err := r.source.getLabels()
 	if err != nil {
 		return err
 	}
@@ -56,9 +53,19 @@ func (r *RestoreTask) restoreLabels() error {
 		default:
 		}
 
+		// Labels created by an interrupted restore of the same backup are reused, they may have been renamed.
+		if _, ok := r.labelMapping[label.ID]; ok {
+			continue
+		}
+
 		labelID, name := matchLocalLabelWithRemote(label, remoteLabels)
 		if len(labelID) > 0 {
 			r.labelMapping[label.ID] = labelID
+
+			if r.restoreSettings {
+				r.restoreLabelSettings(label, remoteLabels)
+			}
+
 			continue
 		}
 
@@ -98,20 +105,6 @@ func matchLocalLabelWithRemote(label proton.Label, remoteLabels []proton.Label)
 	return "", findFirstAvailableLabelIncrementalName(label.Name, remoteLabels)
 }
 
-func (r *RestoreTask) readLabelFile() ([]proton.Label, error) {
-	data, err := os.ReadFile(filepath.Join(r.backupDir, getLabelFileName()))
-	if err != nil {
-		return nil, err
-	}
-
-	versionedLabels, err := utils.NewVersionedJSON[[]proton.Label](LabelMetadataVersion, data)
-	if err != nil {
-		return nil, err
-	}
-
-	return versionedLabels.Payload, nil
-}
-
 // createAndMapLabel create the given label and adds the mapping of its remote ID to r.labelMappings.
 func (r *RestoreTask) createAndMapLabel(label proton.Label) error {
 	var remoteParentID string
@@ -22,48 +22,74 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/exp/slices"
 )
 
 type messageInfo struct {
 	messageID string
 	timestamp int64
+	dir       string // the directory containing the message files.
+	fileName  string // the name of the message files without extension, the message ID if empty.
+}
+
+// getEMLPath returns the path of the EML file of a message of a backup directory.
+func (m messageInfo) getEMLPath() string {
+	if len(m.fileName) == 0 {
+		return filepath.Join(m.dir, getEMLFileName(m.messageID))
+	}
+
+	return filepath.Join(m.dir, getEMLFileName(m.fileName))
 }
 
 func (r *RestoreTask) validateBackupDir(reporter Reporter) ([]messageInfo, error) {
 	r.log.Info("Verifying backup folder")
 
-	messageList := make([]messageInfo, 0)
-	err := r.walkBackupDir(func(path string) {
-		metadata, err := loadMetadataFile(emlToMetadataFilename(path))
-		if err == nil {
-			messageList = append(messageList, messageInfo{
-				messageID: metadata.ID,
-				timestamp: metadata.Time,
-			})
+	var messageList []messageInfo
+	var err error
+
+	if format, ok := archiveFormatFromPath(r.backupDir); ok {
+		if info, err := os.Stat(r.backupDir); err == nil && !info.IsDir() {
+			return r.validateBackupArchive(format, reporter)
 		}
-	})
+	}
+
+	if hasCheckpointFile(r.backupDir) {
+		messageList, err = r.collectIncrementalBackupMessages()
+	} else {
+		messageList, err = r.collectBackupMessages()
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	messageCount := len(messageList)
-	if messageCount > 0 {
+	if len(messageList) > 0 {
 		labelsFilename := getLabelFileName()
 		if _, err := os.Stat(filepath.Join(r.backupDir, labelsFilename)); errors.Is(err, os.ErrNotExist) {
 			return nil, fmt.Errorf("the labels file '%v' could not be found", labelsFilename)
 		}
 
-		reporter.SetMessageTotal(uint64(messageCount))
-		reporter.SetMessageProcessed(0)
-		r.importableCount = int64(messageCount)
-		r.log.WithField("messageCount", messageCount).Info("Found importable messages")
+		r.source = newBackupDirSource(r.backupDir, r.decrypter)
 
-		slices.SortFunc(messageList, func(lhs, rhs messageInfo) bool { return lhs.timestamp < rhs.timestamp })
+		return r.setImportableMessages(messageList, reporter), nil
+	}
 
-		return messageList, nil
+	// The folder is not a backup created by the tool, it may contain mailboxes exported by another mail client.
+	source, err := r.detectMailboxSource()
+	if err != nil {
+		return nil, err
+	}
+
+	if source != nil {
+		if messageList := source.getMessageInfoList(); len(messageList) > 0 {
+			r.source = source
+
+			return r.setImportableMessages(messageList, reporter), nil
+		}
 	}
 
 	subDirs, err := r.getTimestampedBackupDirs()
@@ -76,7 +102,17 @@ func (r *RestoreTask) validateBackupDir(reporter Reporter) ([]messageInfo, error
 	}
 
 	if len(subDirs) > 1 {
-		return nil, errors.New("the specified folder contains more than one backup sub-folder")
+		// Incremental exports are a chain of sub-folders, the most recent one is able to restore the whole chain.
+		generation, checkpoint, err := findLatestCheckpoint(r.backupDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if checkpoint == nil {
+			return nil, errors.New("the specified folder contains more than one backup sub-folder")
+		}
+
+		subDirs = []string{filepath.Join(r.backupDir, generation)}
 	}
 
 	r.log.WithField("folderName", subDirs[0]).Info("A potential backup sub-folder has been found and will be inspected")
@@ -84,3 +120,152 @@ func (r *RestoreTask) validateBackupDir(reporter Reporter) ([]messageInfo, error
 
 	return r.validateBackupDir(reporter)
 }
+
+func (r *RestoreTask) setImportableMessages(messageList []messageInfo, reporter Reporter) []messageInfo {
+	messageCount := len(messageList)
+
+	reporter.SetMessageTotal(uint64(messageCount))
+	reporter.SetMessageProcessed(0)
+	r.importableCount = int64(messageCount)
+	r.log.WithField("messageCount", messageCount).Info("Found importable messages")
+
+	slices.SortFunc(messageList, func(lhs, rhs messageInfo) bool { return lhs.timestamp < rhs.timestamp })
+
+	if sequential, ok := r.source.(sequentialRestoreSource); ok {
+		sequential.sortMessageInfoList(messageList)
+	}
+
+	return messageList
+}
+
+// validateBackupArchive lists the messages of a backup stored in an archive by the export.
+func (r *RestoreTask) validateBackupArchive(format ArchiveFormat, reporter Reporter) ([]messageInfo, error) {
+	r.log.Info("Restoring from archive")
+
+	source, err := newArchiveSource(r.ctx, r.backupDir, format, r.decrypter)
+	if err != nil {
+		return nil, err
+	}
+
+	messageList := source.getMessageInfoList()
+	if len(messageList) == 0 {
+		_ = source.Close()
+		return nil, errors.New("no importable mail found")
+	}
+
+	if !source.hasLabels() {
+		_ = source.Close()
+		return nil, fmt.Errorf("the labels file '%v' could not be found", getLabelFileName())
+	}
+
+	r.source = source
+
+	return r.setImportableMessages(messageList, reporter), nil
+}
+
+// detectMailboxSource looks for a Maildir tree or mbox files in the backup path, which can also be a single mbox file.
+// Nil is returned if none is found.
+func (r *RestoreTask) detectMailboxSource() (*mailboxSource, error) {
+	info, err := os.Stat(r.backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		r.log.Info("Restoring from mbox file")
+		return newMboxSource(r.ctx, filepath.Dir(r.backupDir), []string{r.backupDir})
+	}
+
+	root, folders, err := findMaildirFolders(r.ctx, r.backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(folders) > 0 {
+		r.log.WithField("root", root).WithField("folderCount", len(folders)).Info("Restoring from Maildir")
+		return newMaildirSource(r.ctx, root, folders, r.decrypter)
+	}
+
+	files, err := findMboxFiles(r.ctx, r.backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) > 0 {
+		r.log.WithField("fileCount", len(files)).Info("Restoring from mbox files")
+		return newMboxSource(r.ctx, r.backupDir, files)
+	}
+
+	return nil, nil
+}
+
+func (r *RestoreTask) collectBackupMessages() ([]messageInfo, error) {
+	messageList := make([]messageInfo, 0)
+	var decryptionErr error
+	err := r.walkBackupDir(func(path string) {
+		metadata, err := loadMetadataFile(emlToMetadataFilename(path), r.decrypter)
+		if errors.Is(err, utils.ErrFileEncrypted) || errors.Is(err, utils.ErrDecryptionFailed) {
+			decryptionErr = err
+		}
+
+		if err == nil {
+			messageList = append(messageList, messageInfo{
+				messageID: metadata.ID,
+				timestamp: metadata.Time,
+				dir:       filepath.Dir(path),
+				fileName:  strings.TrimSuffix(filepath.Base(path), emlExtension),
+			})
+		}
+	})
+
+	// The backup is encrypted and can't be read with the given key or passphrase, if any.
+	if err == nil && len(messageList) == 0 && decryptionErr != nil {
+		return nil, decryptionErr
+	}
+
+	return messageList, err
+}
+
+// collectIncrementalBackupMessages lists the messages of an incremental backup chain using the checkpoint of its most recent
+// generation. Each message is read from the generation in which it was last exported.
+func (r *RestoreTask) collectIncrementalBackupMessages() ([]messageInfo, error) {
+	checkpoint, err := loadCheckpointFile(r.backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	chainDir := filepath.Dir(r.backupDir)
+	messageList := make([]messageInfo, 0, len(checkpoint.Messages))
+
+	for id, msg := range checkpoint.Messages {
+		if r.ctx.Err() != nil {
+			return nil, r.ctx.Err()
+		}
+
+		dir := filepath.Join(chainDir, msg.Generation)
+		emlPath := filepath.Join(dir, getEMLFileName(id))
+
+		if exists, err := fileExists(emlPath); err != nil || !exists {
+			r.log.WithField("path", emlPath).Warn("Skipping message with no EML file in the backup chain.")
+			continue
+		}
+
+		if exists, err := fileExists(emlToMetadataFilename(emlPath)); err != nil || !exists {
+			r.log.WithField("path", emlPath).Warn("Skipping EML file with no associated metadata file.")
+			continue
+		}
+
+		messageList = append(messageList, messageInfo{
+			messageID: id,
+			timestamp: msg.Time,
+			dir:       dir,
+		})
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"generation":       filepath.Base(r.backupDir),
+		"parentGeneration": checkpoint.ParentGeneration,
+	}).Info("Restoring incremental backup chain")
+
+	return messageList, nil
+}
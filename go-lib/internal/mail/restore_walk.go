@@ -10,8 +10,26 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// walkBackupDir calls fn with the path of every EML file of the backup which has a metadata file. The files are either
+// at the root of the backup, or in the folders of the label layout, see ExportLayoutLabels. The label folders only hold
+// hard links to the same files and are not walked.
 func (r *RestoreTask) walkBackupDir(fn func(emlPath string)) error {
-	return filepath.Walk(r.backupDir, func(path string, info fs.FileInfo, err error) error {
+	if err := r.walkMessageDir(r.backupDir, fn); err != nil {
+		return err
+	}
+
+	for _, dir := range r.getLabelLayoutDirs() {
+		if err := r.walkMessageDir(dir, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkMessageDir calls fn with the path of the EML files of dir which have a metadata file, its subdirectories are skipped.
+func (r *RestoreTask) walkMessageDir(dir string, fn func(emlPath string)) error {
+	return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
 		select {
 		case <-r.ctx.Done():
 			return r.ctx.Err()
@@ -23,11 +41,11 @@ func (r *RestoreTask) walkBackupDir(fn func(emlPath string)) error {
 			return nil
 		}
 
-		if info.IsDir() && (path != r.backupDir) { // we skip any dir that is not the root dir.
+		if info.IsDir() && (path != dir) { // we skip any dir that is not the root dir.
 			return filepath.SkipDir
 		}
 
-		emlPath := filepath.Join(r.backupDir, info.Name())
+		emlPath := filepath.Join(dir, info.Name())
 		if !strings.HasSuffix(emlPath, emlExtension) {
 			return nil
 		}
@@ -43,6 +61,27 @@ func (r *RestoreTask) walkBackupDir(fn func(emlPath string)) error {
 	})
 }
 
+// getLabelLayoutDirs returns the existing folders of the backup in which the label layout stores messages, derived from
+// its labels.json file. None is returned for backups without labels.
+func (r *RestoreTask) getLabelLayoutDirs() []string {
+	labels, err := newBackupDirSource(r.backupDir, r.decrypter).getLabels()
+	if err != nil {
+		return nil
+	}
+
+	var result []string
+
+	for _, path := range newLabelFolderLayout(labels).getFolderPaths() {
+		dir := filepath.Join(append([]string{r.backupDir}, path...)...)
+
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			result = append(result, dir)
+		}
+	}
+
+	return result
+}
+
 func (r *RestoreTask) getTimestampedBackupDirs() ([]string, error) {
 	var result []string
 	err := filepath.Walk(r.backupDir, func(path string, info fs.FileInfo, err error) error {
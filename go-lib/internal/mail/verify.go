@@ -0,0 +1,759 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/ProtonMail/proton-bridge/v3/pkg/message/parser"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// VerifyTask checks that an existing backup is complete and readable, without connecting to the account. It accepts
+// the same paths as the restore: an export folder, a folder containing export sub-folders or an export archive.
+type VerifyTask struct {
+	ctx             context.Context
+	ctxCancel       func()
+	backupPath      string
+	decrypter       utils.FileDecrypter
+	log             *logrus.Entry
+	exports         map[string]*verifiedExport
+	metadataFiles   map[string]struct{} // the metadata files of a verified folder, see isMessagePartDir.
+	messageDirs     map[string]struct{} // the folders of a verified folder holding metadata files.
+	report          VerifyReport
+	cancelledByUser bool
+}
+
+// VerifyIssue is a problem found in a file of the backup. Path is relative to the verified path. MessageID is the name of
+// the files of the message without extension, which is its ID unless the export uses ExportLayoutLabels.
+type VerifyIssue struct {
+	MessageID string `json:",omitempty"`
+	Path      string
+	Problem   string
+}
+
+// VerifyReport is the result of the verification of a backup.
+type VerifyReport struct {
+	BackupPath string
+	// ExportCount is the number of export folders found in the backup, incremental backups have one per run.
+	ExportCount int
+	// MessageCount is the number of messages found in the backup, whether they are valid or not.
+	MessageCount int
+	// BuiltMessageCount is the number of valid messages stored as an EML file, an mbox entry or a Maildir file.
+	BuiltMessageCount int
+	// PartsMessageCount is the number of valid messages stored as a folder with their body and attachments.
+	PartsMessageCount int
+	// InvalidMessageCount is the number of messages with at least one issue.
+	InvalidMessageCount int
+	// ChecksumsVerified is true when the content of the files was checked against the checksums of the archive or the
+	// manifests of the exports.
+	ChecksumsVerified bool
+	Issues            []VerifyIssue
+}
+
+// IsValid returns true if no issue was found in the backup.
+func (r *VerifyReport) IsValid() bool {
+	return len(r.Issues) == 0
+}
+
+type verifiedExport struct {
+	hasLabels bool
+	manifest  *ExportManifest
+	hashes    map[string]string           // hex encoded SHA-256 of the files, by path relative to the export.
+	messages  map[string]*verifiedMessage // by path of the message files relative to the export, without extension.
+	// mailboxMessages are the messages of the mbox and Maildir exports, which have no metadata file, and whether they are
+	// valid. A message is stored once per label, they are keyed by Maildir file name or by hash of the mbox entry.
+	mailboxMessages map[string]bool
+}
+
+func (e *verifiedExport) recordHash(rel string, data []byte) {
+	hash := sha256.Sum256(data)
+	e.hashes[rel] = hex.EncodeToString(hash[:])
+}
+
+type verifiedMessage struct {
+	metadata        *MessageMetadata
+	hasMetadataFile bool
+	hasEML          bool
+	parts           map[string]struct{}
+	hasFileIssue    bool
+}
+
+func NewVerifyTask(ctx context.Context, backupPath string) (*VerifyTask, error) {
+	absPath, err := filepath.Abs(backupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &VerifyTask{
+		ctx:        ctx,
+		ctxCancel:  cancel,
+		backupPath: absPath,
+		log:        logrus.WithField("verify", "mail"),
+		exports:    make(map[string]*verifiedExport),
+		report:     VerifyReport{BackupPath: absPath},
+	}, nil
+}
+
+// SetDecrypter sets the key or passphrase used to read a backup created with export encryption. Must be called before Run.
+func (v *VerifyTask) SetDecrypter(decrypter utils.FileDecrypter) {
+	v.decrypter = decrypter
+}
+
+func (v *VerifyTask) Cancel() {
+	v.cancelledByUser = true
+	v.ctxCancel()
+}
+
+func (v *VerifyTask) GetOperationCancelledByUser() bool {
+	return v.cancelledByUser
+}
+
+func (v *VerifyTask) GetBackupPath() string {
+	return v.backupPath
+}
+
+// GetReport returns the result of the verification. Only complete once Run has returned without error.
+func (v *VerifyTask) GetReport() VerifyReport {
+	return v.report
+}
+
+// Run reads every file of the backup. Problems found in the backup are collected in the report, an error is only
+// returned if the backup could not be read at all.
+func (v *VerifyTask) Run(reporter Reporter) error {
+	startTime := time.Now()
+	defer func() { v.log.WithField("duration", time.Since(startTime)).Info("Finished") }()
+	v.log.WithField("backupPath", v.backupPath).Info("Starting")
+
+	info, err := os.Stat(v.backupPath)
+	if err != nil {
+		return err
+	}
+
+	if format, ok := archiveFormatFromPath(v.backupPath); ok && !info.IsDir() {
+		err = v.verifyArchive(format, reporter)
+	} else if info.IsDir() {
+		err = v.verifyDir(reporter)
+	} else {
+		err = errors.New("the backup path is not a folder or an export archive")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	v.checkMessages()
+
+	if v.report.MessageCount == 0 {
+		return errors.New("no exported mail found")
+	}
+
+	v.log.WithFields(logrus.Fields{
+		"exports":  v.report.ExportCount,
+		"messages": v.report.MessageCount,
+		"built":    v.report.BuiltMessageCount,
+		"parts":    v.report.PartsMessageCount,
+		"invalid":  v.report.InvalidMessageCount,
+		"issues":   len(v.report.Issues),
+	}).Info("Report")
+
+	return nil
+}
+
+func (v *VerifyTask) verifyDir(reporter Reporter) error {
+	var files []string
+
+	v.metadataFiles = make(map[string]struct{})
+	v.messageDirs = make(map[string]struct{})
+
+	if err := filepath.WalkDir(v.backupPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if v.ctx.Err() != nil {
+			return v.ctx.Err()
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			files = append(files, filePath)
+		}
+
+		if strings.HasSuffix(filePath, jsonMetadataExtension) {
+			if rel, err := filepath.Rel(v.backupPath, filePath); err == nil {
+				v.metadataFiles[filepath.ToSlash(rel)] = struct{}{}
+				v.messageDirs[path.Dir(filepath.ToSlash(rel))] = struct{}{}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to list backup files: %w", err)
+	}
+
+	reporter.SetMessageTotal(uint64(len(files)))
+	reporter.SetMessageProcessed(0)
+
+	for _, filePath := range files {
+		if v.ctx.Err() != nil {
+			return v.ctx.Err()
+		}
+
+		rel, err := filepath.Rel(v.backupPath, filePath)
+		if err != nil {
+			return err
+		}
+
+		if err := v.onFile(filepath.ToSlash(rel), func() ([]byte, error) { return os.ReadFile(filePath) }); err != nil { //nolint:gosec
+			return err
+		}
+
+		reporter.OnProgress(1)
+	}
+
+	return nil
+}
+
+// verifyArchive reads every entry of the archive, which also checks them against the CRC of the zip entries or the
+// checksums of the zstd frames.
+func (v *VerifyTask) verifyArchive(format ArchiveFormat, reporter Reporter) error {
+	v.log.Info("Verifying archive")
+
+	switch format {
+	case ArchiveFormatTarZstd:
+		// The entry count of a tar archive is unknown until it has been read, no progress is reported.
+		cursor := &tarArchiveCursor{path: v.backupPath}
+		defer cursor.close()
+
+		if err := cursor.forEachEntry(v.ctx, func(name string, _ int, read func() ([]byte, error)) error {
+			return v.onFile(name, read)
+		}); err != nil {
+			return err
+		}
+
+	case ArchiveFormatZip:
+		reader, err := zip.OpenReader(v.backupPath)
+		if err != nil {
+			return fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer reader.Close() //nolint:errcheck
+
+		reporter.SetMessageTotal(uint64(len(reader.File)))
+		reporter.SetMessageProcessed(0)
+
+		for _, file := range reader.File {
+			if v.ctx.Err() != nil {
+				return v.ctx.Err()
+			}
+
+			if file.FileInfo().IsDir() {
+				continue
+			}
+
+			file := file
+			if err := v.onFile(file.Name, func() ([]byte, error) { return readZipEntry(file) }); err != nil {
+				return err
+			}
+
+			reporter.OnProgress(1)
+		}
+
+	case ArchiveFormatNone:
+		return errors.New("not an archive")
+	}
+
+	v.report.ChecksumsVerified = true
+
+	return nil
+}
+
+// onFile checks a file of the backup. name is the slash separated path of the file relative to the verified path.
+// Only a backup which can't be decrypted stops the verification, every other problem is added to the report.
+func (v *VerifyTask) onFile(name string, read func() ([]byte, error)) error {
+	exportDir, rel := splitExportPath(name)
+	if strings.HasPrefix(rel, "temp/") {
+		return nil
+	}
+
+	// Every file is read once and hashed, to be checked against the manifest of its export.
+	data, readErr := read()
+	if readErr == nil {
+		v.getExport(exportDir).recordHash(rel, data)
+	}
+
+	read = func() ([]byte, error) { return data, readErr }
+
+	if root, _, _ := strings.Cut(rel, "/"); root == maildirRootDir {
+		return v.checkMaildirFile(exportDir, name, rel, read)
+	}
+
+	if strings.HasSuffix(rel, mboxExtension) {
+		return v.checkMboxFile(exportDir, name, read)
+	}
+
+	if dir, fileName := path.Dir(rel), path.Base(rel); dir != "." {
+		root, _, _ := strings.Cut(rel, "/")
+
+		switch {
+		// The hard links of the label layout are hashed but not checked, they are the same files as the messages.
+		case root == labelLayoutLabelsDir:
+			return v.checkReadable(name, "", nil, read)
+
+		// The parts of the messages which could not be built.
+		case v.isMessagePartDir(exportDir, dir):
+			msg := v.getMessage(exportDir, dir)
+			if msg.parts == nil {
+				msg.parts = make(map[string]struct{})
+			}
+
+			msg.parts[fileName] = struct{}{}
+
+			return v.checkReadable(name, dir, msg, read)
+
+		// The messages stored in their folder by the label layout are checked as those at the root of the export.
+		case !strings.HasSuffix(fileName, emlExtension) && !strings.HasSuffix(fileName, jsonMetadataExtension):
+			return v.checkReadable(name, "", nil, read)
+		}
+	}
+
+	switch {
+	case rel == getLabelFileName():
+		export := v.getExport(exportDir)
+		export.hasLabels = true
+
+		data, err := v.readFile(read)
+		if err != nil {
+			return v.addFileIssue(name, "", nil, err)
+		}
+
+		if _, err := utils.NewVersionedJSON[[]proton.Label](LabelMetadataVersion, data); err != nil {
+			v.addIssue(name, "", fmt.Sprintf("invalid labels file: %v", err))
+		}
+
+	case rel == getAccountFileName():
+		data, err := v.readFile(read)
+		if err != nil {
+			return v.addFileIssue(name, "", nil, err)
+		}
+
+		if _, err := utils.NewVersionedJSON[AccountMetadata](AccountMetadataVersion, data); err != nil {
+			v.addIssue(name, "", fmt.Sprintf("invalid account file: %v", err))
+		}
+
+	case rel == getManifestFileName():
+		// The manifest is never encrypted.
+		data, err := read()
+		if err != nil {
+			return v.addFileIssue(name, "", nil, err)
+		}
+
+		manifest, err := utils.NewVersionedJSON[ExportManifest](ExportManifestVersion, data)
+		if err != nil {
+			v.addIssue(name, "", fmt.Sprintf("invalid manifest file: %v", err))
+			return nil
+		}
+
+		v.getExport(exportDir).manifest = &manifest.Payload
+
+	case strings.HasSuffix(rel, jsonMetadataExtension):
+		msgID := strings.TrimSuffix(rel, jsonMetadataExtension)
+		msg := v.getMessage(exportDir, msgID)
+		msg.hasMetadataFile = true
+
+		data, err := v.readFile(read)
+		if err != nil {
+			return v.addFileIssue(name, msgID, msg, err)
+		}
+
+		metadata, err := utils.NewVersionedJSON[MessageMetadata](MessageMetadataVersion, data)
+		if err != nil {
+			v.addIssue(name, msgID, fmt.Sprintf("invalid metadata file: %v", err))
+			msg.hasFileIssue = true
+
+			return nil
+		}
+
+		if metadata.Payload.ID != msgID && path.Base(msgID) != labelLayoutFileName(metadata.Payload.MessageMetadata) {
+			v.addIssue(name, msgID, fmt.Sprintf("metadata file describes message '%v'", metadata.Payload.ID))
+			msg.hasFileIssue = true
+		}
+
+		msg.metadata = &metadata.Payload
+
+	case strings.HasSuffix(rel, emlExtension):
+		msgID := strings.TrimSuffix(rel, emlExtension)
+		msg := v.getMessage(exportDir, msgID)
+		msg.hasEML = true
+
+		data, err := v.readFile(read)
+		if err != nil {
+			return v.addFileIssue(name, msgID, msg, err)
+		}
+
+		if _, err := parser.New(bytes.NewReader(data)); err != nil {
+			v.addIssue(name, msgID, fmt.Sprintf("invalid EML file: %v", err))
+			msg.hasFileIssue = true
+		}
+
+	default:
+		return v.checkReadable(name, "", nil, read)
+	}
+
+	return nil
+}
+
+// checkMboxFile checks that every message of an mbox file can be parsed. mbox files are never encrypted.
+func (v *VerifyTask) checkMboxFile(exportDir, name string, read func() ([]byte, error)) error {
+	data, err := read()
+	if err != nil {
+		return v.addFileIssue(name, "", nil, err)
+	}
+
+	return scanMbox(v.ctx, bytes.NewReader(data), name, func(msg mailboxMessage, _ string, _ mailboxFlags) {
+		literal := unquoteMboxMessage(data[msg.offset : msg.offset+msg.length])
+		hash := sha256.Sum256(literal)
+
+		valid := true
+
+		if _, err := parser.New(bytes.NewReader(literal)); err != nil {
+			v.addIssue(name, "", fmt.Sprintf("invalid message at offset %v: %v", msg.offset, err))
+			valid = false
+		}
+
+		v.recordMailboxMessage(exportDir, hex.EncodeToString(hash[:]), valid)
+	})
+}
+
+// checkMaildirFile checks that a message file of a Maildir export can be parsed. The other files of the Maildir tree,
+// such as the markers of the sub-folders, are only read.
+func (v *VerifyTask) checkMaildirFile(exportDir, name, rel string, read func() ([]byte, error)) error {
+	if dir := path.Base(path.Dir(rel)); dir != "cur" && dir != "new" {
+		return v.checkReadable(name, "", nil, read)
+	}
+
+	key := path.Base(rel)
+
+	data, err := v.readFile(read)
+	if err != nil {
+		v.recordMailboxMessage(exportDir, key, false)
+		return v.addFileIssue(name, "", nil, err)
+	}
+
+	valid := true
+
+	if _, err := parser.New(bytes.NewReader(data)); err != nil {
+		v.addIssue(name, "", fmt.Sprintf("invalid message file: %v", err))
+		valid = false
+	}
+
+	v.recordMailboxMessage(exportDir, key, valid)
+
+	return nil
+}
+
+// recordMailboxMessage records a message of an mbox or Maildir export, which is only valid if all its copies are.
+func (v *VerifyTask) recordMailboxMessage(exportDir, key string, valid bool) {
+	export := v.getExport(exportDir)
+
+	if previous, ok := export.mailboxMessages[key]; ok {
+		valid = valid && previous
+	}
+
+	export.mailboxMessages[key] = valid
+}
+
+// isMessagePartDir returns true if dir, relative to the export, is the folder of a message which could not be built rather
+// than a folder of the label layout. The folder of a message is next to its metadata file, and only the root of the export
+// and the folders of the label layout hold metadata files. Archives only use the flat layout, see ExportTask.SetLayout.
+func (v *VerifyTask) isMessagePartDir(exportDir, dir string) bool {
+	if _, ok := v.metadataFiles[path.Join(exportDir, dir)+jsonMetadataExtension]; ok {
+		return true
+	}
+
+	if strings.Contains(dir, "/") {
+		return false
+	}
+
+	_, ok := v.messageDirs[path.Join(exportDir, dir)]
+
+	return !ok
+}
+
+// checkReadable reports the read error of a file which content is not checked, e.g. an archive checksum mismatch.
+func (v *VerifyTask) checkReadable(name, msgID string, msg *verifiedMessage, read func() ([]byte, error)) error {
+	if _, err := read(); err != nil {
+		return v.addFileIssue(name, msgID, msg, err)
+	}
+
+	return nil
+}
+
+func (v *VerifyTask) readFile(read func() ([]byte, error)) ([]byte, error) {
+	data, err := read()
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.DecryptFileData(data, v.decrypter)
+}
+
+// addFileIssue reports a file which could not be read. A file encrypted to another key, or without a key, means none of
+// the backup can be verified.
+func (v *VerifyTask) addFileIssue(name, msgID string, msg *verifiedMessage, err error) error {
+	if errors.Is(err, utils.ErrFileEncrypted) {
+		return fmt.Errorf("the backup is encrypted, a decryption key or passphrase is required: %w", err)
+	}
+
+	if errors.Is(err, utils.ErrDecryptionFailed) {
+		return fmt.Errorf("the backup could not be decrypted: %w", err)
+	}
+
+	v.addIssue(name, msgID, fmt.Sprintf("failed to read file: %v", err))
+
+	if msg != nil {
+		msg.hasFileIssue = true
+	}
+
+	return nil
+}
+
+func (v *VerifyTask) addIssue(name, msgID, problem string) {
+	v.log.WithField("path", name).WithField("msg-id", msgID).Warn(problem)
+	v.report.Issues = append(v.report.Issues, VerifyIssue{MessageID: msgID, Path: name, Problem: problem})
+}
+
+func (v *VerifyTask) getExport(exportDir string) *verifiedExport {
+	export, ok := v.exports[exportDir]
+	if !ok {
+		export = &verifiedExport{
+			hashes:          make(map[string]string),
+			messages:        make(map[string]*verifiedMessage),
+			mailboxMessages: make(map[string]bool),
+		}
+		v.exports[exportDir] = export
+	}
+
+	return export
+}
+
+func (v *VerifyTask) getMessage(exportDir, msgID string) *verifiedMessage {
+	export := v.getExport(exportDir)
+
+	msg, ok := export.messages[msgID]
+	if !ok {
+		msg = &verifiedMessage{}
+		export.messages[msgID] = msg
+	}
+
+	return msg
+}
+
+// checkMessages checks that the files of each export match its manifest, and that every message found in the backup has
+// a metadata file and either an EML file or all of its parts, as expected by FileMetadataFileChecker. The messages of
+// mbox and Maildir exports were checked as their files were read.
+func (v *VerifyTask) checkMessages() {
+	exportDirs := maps.Keys(v.exports)
+	slices.Sort(exportDirs)
+
+	manifestCount := 0
+
+	for _, exportDir := range exportDirs {
+		export := v.exports[exportDir]
+
+		if len(export.messages) == 0 && len(export.mailboxMessages) == 0 {
+			continue
+		}
+
+		v.report.ExportCount++
+
+		if !export.hasLabels {
+			v.addIssue(path.Join(exportDir, getLabelFileName()), "", "labels file is missing")
+		}
+
+		if export.manifest != nil {
+			manifestCount++
+			v.checkManifest(exportDir, export)
+		}
+
+		msgIDs := maps.Keys(export.messages)
+		slices.Sort(msgIDs)
+
+		for _, msgID := range msgIDs {
+			v.checkMessage(exportDir, msgID, export.messages[msgID])
+		}
+
+		for _, valid := range export.mailboxMessages {
+			v.report.MessageCount++
+
+			if valid {
+				v.report.BuiltMessageCount++
+			} else {
+				v.report.InvalidMessageCount++
+			}
+		}
+	}
+
+	// Archives are covered by their own checksums, folders only if each of their exports has a manifest.
+	if manifestCount != 0 && manifestCount == v.report.ExportCount {
+		v.report.ChecksumsVerified = true
+	}
+}
+
+// checkManifest compares the hashes of the files of the export with those recorded in its manifest. Files which are not
+// listed are ignored, they may have been added by a later restore.
+func (v *VerifyTask) checkManifest(exportDir string, export *verifiedExport) {
+	for _, file := range export.manifest.Files {
+		var problem string
+
+		if hash, ok := export.hashes[file.Path]; !ok {
+			problem = "file listed in the manifest is missing"
+		} else if hash != file.SHA256 {
+			problem = "checksum does not match the manifest"
+		} else {
+			continue
+		}
+
+		msgID := getMessageIDFromPath(file.Path)
+		msg, ok := export.messages[msgID]
+		if !ok && strings.Contains(file.Path, "/") {
+			// An EML attachment of a message which could not be built.
+			msgID = path.Dir(file.Path)
+			msg, ok = export.messages[msgID]
+		}
+
+		if !ok {
+			msgID = ""
+		} else {
+			msg.hasFileIssue = true
+		}
+
+		v.addIssue(path.Join(exportDir, file.Path), msgID, problem)
+	}
+}
+
+// getMessageIDFromPath returns the key of the message a file of an export may belong to, see verifiedExport.messages.
+func getMessageIDFromPath(rel string) string {
+	if msgID, ok := strings.CutSuffix(rel, jsonMetadataExtension); ok {
+		return msgID
+	}
+
+	if msgID, ok := strings.CutSuffix(rel, emlExtension); ok {
+		return msgID
+	}
+
+	if dir := path.Dir(rel); dir != "." {
+		return dir
+	}
+
+	return ""
+}
+
+func (v *VerifyTask) checkMessage(exportDir, msgID string, msg *verifiedMessage) {
+	v.report.MessageCount++
+
+	valid := !msg.hasFileIssue
+
+	switch {
+	case msg.metadata == nil:
+		if !msg.hasMetadataFile {
+			v.addIssue(path.Join(exportDir, getMetadataFileName(msgID)), msgID, "metadata file is missing")
+		}
+
+		valid = false
+
+	case msg.hasEML:
+
+	case msg.parts != nil:
+		if missing := getMissingMessageParts(msg.metadata, msg.parts); len(missing) != 0 {
+			v.addIssue(path.Join(exportDir, msgID), msgID, fmt.Sprintf("missing message parts: %v", strings.Join(missing, ", ")))
+			valid = false
+		}
+
+	default:
+		v.addIssue(path.Join(exportDir, getEMLFileName(msgID)), msgID, "neither the EML file nor the message folder exist")
+		valid = false
+	}
+
+	switch {
+	case !valid:
+		v.report.InvalidMessageCount++
+	case msg.hasEML:
+		v.report.BuiltMessageCount++
+	default:
+		v.report.PartsMessageCount++
+	}
+}
+
+// getMissingMessageParts returns the body and the attachments of the message which were not found in its folder.
+func getMissingMessageParts(metadata *MessageMetadata, parts map[string]struct{}) []string {
+	hasPart := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := parts[name]; ok {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var missing []string
+
+	if !hasPart(bodyFileName(), bodyFileNameEncrypted()) {
+		missing = append(missing, bodyFileName())
+	}
+
+	for _, a := range metadata.Attachments {
+		if !hasPart(attachmentFileName(a.ID, a.Name), attachmentFileNameEncrypted(a.ID, a.Name)) {
+			missing = append(missing, attachmentFileName(a.ID, a.Name))
+		}
+	}
+
+	return missing
+}
+
+// splitExportPath returns the export folder containing a file, which is the closest 'mail_<date>_<time>' ancestor or the
+// verified path itself, and the path of the file relative to it.
+func splitExportPath(name string) (string, string) {
+	components := strings.Split(name, "/")
+
+	for i := len(components) - 2; i >= 0; i-- {
+		if mailFolderRegExp.MatchString(components[i]) {
+			return path.Join(components[:i+1]...), path.Join(components[i+1:]...)
+		}
+	}
+
+	return "", name
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package mail
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyTask_Dir(t *testing.T) {
+	rootDir := t.TempDir()
+	exportDir := filepath.Join(rootDir, "mail_20240101_000000")
+
+	writeTestVerifyExport(t, exportDir, func(path string, data []byte) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+	})
+
+	task, err := NewVerifyTask(context.Background(), rootDir)
+	require.NoError(t, err)
+	require.NoError(t, task.Run(&NullProgressReporter{}))
+
+	report := task.GetReport()
+	require.False(t, report.IsValid())
+	require.False(t, report.ChecksumsVerified)
+	requireTestVerifyReport(t, report)
+}
+
+func TestVerifyTask_Archive(t *testing.T) {
+	for _, format := range []ArchiveFormat{ArchiveFormatTarZstd, ArchiveFormatZip} {
+		format := format
+		t.Run(format.extension(), func(t *testing.T) {
+			rootDir := t.TempDir()
+			exportDir := filepath.Join(rootDir, "mail_20240101_000000")
+			archivePath := exportDir + format.extension()
+
+			cipher := utils.NewPGPPasswordFileCipher([]byte("secret"))
+
+			writer, err := newArchiveFileWriter(archivePath, rootDir, format, cipher)
+			require.NoError(t, err)
+
+			writeTestVerifyExport(t, exportDir, func(path string, data []byte) {
+				require.NoError(t, writer.WriteFile("", path, data, nil))
+			})
+			require.NoError(t, writer.finish())
+
+			task, err := NewVerifyTask(context.Background(), archivePath)
+			require.NoError(t, err)
+			require.ErrorIs(t, task.Run(&NullProgressReporter{}), utils.ErrFileEncrypted)
+
+			task, err = NewVerifyTask(context.Background(), archivePath)
+			require.NoError(t, err)
+			task.SetDecrypter(cipher)
+			require.NoError(t, task.Run(&NullProgressReporter{}))
+
+			report := task.GetReport()
+			require.True(t, report.ChecksumsVerified)
+			requireTestVerifyReport(t, report)
+		})
+	}
+}
+
+func TestVerifyTask_NoExport(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0o600))
+
+	task, err := NewVerifyTask(context.Background(), dir)
+	require.NoError(t, err)
+	require.Error(t, task.Run(&NullProgressReporter{}))
+}
+
+func TestSplitExportPath(t *testing.T) {
+	exportDir, rel := splitExportPath("mail_20240101_000000/msg/body.txt")
+	require.Equal(t, "mail_20240101_000000", exportDir)
+	require.Equal(t, "msg/body.txt", rel)
+
+	exportDir, rel = splitExportPath("msg.eml")
+	require.Empty(t, exportDir)
+	require.Equal(t, "msg.eml", rel)
+
+	// The name of a message folder never matches the export folder pattern.
+	exportDir, rel = splitExportPath("mail_20240101_000000")
+	require.Empty(t, exportDir)
+	require.Equal(t, "mail_20240101_000000", rel)
+}
+
+// writeTestVerifyExport writes an export with two valid messages and four broken ones.
+func writeTestVerifyExport(t *testing.T, exportDir string, write func(path string, data []byte)) {
+	labels, err := utils.GenerateVersionedJSON(LabelMetadataVersion, []proton.Label{{ID: "label", Name: "Label", Type: proton.LabelTypeLabel}})
+	require.NoError(t, err)
+	write(filepath.Join(exportDir, getLabelFileName()), labels)
+
+	writeMetadata := func(id string, attachments ...proton.Attachment) {
+		metadata := MessageMetadata{MessageMetadata: proton.MessageMetadata{ID: id}, Attachments: attachments}
+		b, err := metadata.toBytes()
+		require.NoError(t, err)
+		write(filepath.Join(exportDir, getMetadataFileName(id)), b)
+	}
+
+	// A message which was built.
+	writeMetadata("built")
+	write(filepath.Join(exportDir, getEMLFileName("built")), []byte("Subject: built\r\n\r\nbody\r\n"))
+
+	// A message which could not be built, with all its parts.
+	attachment := proton.Attachment{ID: "att", Name: "file.txt"}
+	writeMetadata("parts", attachment)
+	write(filepath.Join(exportDir, "parts", bodyFileName()), []byte("body"))
+	write(filepath.Join(exportDir, "parts", attachmentFileNameEncrypted(attachment.ID, attachment.Name)), []byte("attachment"))
+
+	// A message with a missing attachment.
+	writeMetadata("missing-part", attachment)
+	write(filepath.Join(exportDir, "missing-part", bodyFileNameEncrypted()), []byte("body"))
+
+	// A message without metadata.
+	write(filepath.Join(exportDir, getEMLFileName("no-metadata")), []byte("Subject: no metadata\r\n\r\n"))
+
+	// A message with a corrupted metadata file.
+	write(filepath.Join(exportDir, getMetadataFileName("bad-metadata")), []byte("{"))
+	write(filepath.Join(exportDir, getEMLFileName("bad-metadata")), []byte("Subject: bad metadata\r\n\r\n"))
+
+	// A message with metadata only.
+	writeMetadata("no-message")
+}
+
+func requireTestVerifyReport(t *testing.T, report VerifyReport) {
+	require.Equal(t, 1, report.ExportCount)
+	require.Equal(t, 6, report.MessageCount)
+	require.Equal(t, 1, report.BuiltMessageCount)
+	require.Equal(t, 1, report.PartsMessageCount)
+	require.Equal(t, 4, report.InvalidMessageCount)
+
+	issues := make(map[string]string)
+	for _, issue := range report.Issues {
+		issues[issue.MessageID] = issue.Path
+	}
+
+	require.Len(t, issues, 4)
+	require.Equal(t, "mail_20240101_000000/missing-part", issues["missing-part"])
+	require.Equal(t, "mail_20240101_000000/no-metadata.metadata.json", issues["no-metadata"])
+	require.Equal(t, "mail_20240101_000000/bad-metadata.metadata.json", issues["bad-metadata"])
+	require.Equal(t, "mail_20240101_000000/no-message.eml", issues["no-message"])
+}
+
+func TestVerifyTask_LabelLayout(t *testing.T) {
+	rootDir := t.TempDir()
+	exportDir := filepath.Join(rootDir, "mail_20240101_000000")
+	require.NoError(t, os.Mkdir(exportDir, 0o700))
+
+	messages := writeTestLabelLayoutExport(t, exportDir)
+
+	task, err := NewVerifyTask(context.Background(), rootDir)
+	require.NoError(t, err)
+	require.NoError(t, task.Run(&NullProgressReporter{}))
+
+	report := task.GetReport()
+	require.True(t, report.IsValid(), report.Issues)
+	require.Equal(t, 4, report.MessageCount)
+	require.Equal(t, 3, report.BuiltMessageCount)
+	require.Equal(t, 1, report.PartsMessageCount)
+
+	// A message of a sub-folder whose EML file is missing.
+	name := path.Join(labelLayoutFoldersDir, "Work", "Projects", labelLayoutFileName(messages[1]))
+	require.NoError(t, os.Remove(filepath.Join(exportDir, filepath.FromSlash(getEMLFileName(name)))))
+
+	task, err = NewVerifyTask(context.Background(), rootDir)
+	require.NoError(t, err)
+	require.NoError(t, task.Run(&NullProgressReporter{}))
+
+	report = task.GetReport()
+	require.Equal(t, 1, report.InvalidMessageCount)
+	require.Equal(t, []VerifyIssue{{
+		MessageID: name,
+		Path:      path.Join("mail_20240101_000000", getEMLFileName(name)),
+		Problem:   "neither the EML file nor the message folder exist",
+	}}, report.Issues)
+}
+
+func TestVerifyTask_Mbox(t *testing.T) {
+	rootDir := t.TempDir()
+	exportDir := filepath.Join(rootDir, "mail_20240101_000000")
+	labels := writeTestVerifyMailboxLabels(t, exportDir)
+
+	store := NewMboxStore(exportDir, labels)
+
+	// Messages stored in several mbox files are counted once.
+	require.NoError(t, store.AppendMessage(proton.MessageMetadata{
+		ID:       "both",
+		Time:     1700000000,
+		LabelIDs: []string{proton.InboxLabel, "label"},
+	}, []byte("Subject: both\r\n\r\nFrom the start\r\n")))
+	require.NoError(t, store.AppendMessage(proton.MessageMetadata{
+		ID:       "inbox",
+		Time:     1700000100,
+		LabelIDs: []string{proton.InboxLabel},
+	}, []byte("Subject: inbox\r\n\r\nbody\r\n")))
+	require.NoError(t, store.AppendMessage(proton.MessageMetadata{
+		ID:       "invalid",
+		Time:     1700000200,
+		LabelIDs: []string{"label"},
+	}, []byte("not a header\r\n\r\nbody\r\n")))
+
+	task, err := NewVerifyTask(context.Background(), rootDir)
+	require.NoError(t, err)
+	require.NoError(t, task.Run(&NullProgressReporter{}))
+
+	report := task.GetReport()
+	require.Equal(t, 1, report.ExportCount)
+	require.Equal(t, 3, report.MessageCount)
+	require.Equal(t, 2, report.BuiltMessageCount)
+	require.Equal(t, 1, report.InvalidMessageCount)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, "mail_20240101_000000/Labels/Label.mbox", report.Issues[0].Path)
+}
+
+func TestVerifyTask_Maildir(t *testing.T) {
+	rootDir := t.TempDir()
+	exportDir := filepath.Join(rootDir, "mail_20240101_000000")
+	labels := writeTestVerifyMailboxLabels(t, exportDir)
+
+	cipher := utils.NewPGPPasswordFileCipher([]byte("secret"))
+	fileWriter := &utils.DiskFileWriter{Encrypter: cipher}
+	store := NewMaildirStore(exportDir, labels)
+
+	// Messages linked in several folders are counted once.
+	require.NoError(t, store.StoreMessage(proton.MessageMetadata{
+		ID:       "both",
+		Time:     1700000000,
+		LabelIDs: []string{proton.InboxLabel, "label"},
+	}, []byte("Subject: both\r\n\r\n"), fileWriter, nil))
+	require.NoError(t, store.StoreMessage(proton.MessageMetadata{
+		ID:       "invalid",
+		Time:     1700000100,
+		LabelIDs: []string{proton.InboxLabel},
+	}, []byte("not a header\r\n\r\n"), fileWriter, nil))
+
+	task, err := NewVerifyTask(context.Background(), rootDir)
+	require.NoError(t, err)
+	require.ErrorIs(t, task.Run(&NullProgressReporter{}), utils.ErrFileEncrypted)
+
+	task, err = NewVerifyTask(context.Background(), rootDir)
+	require.NoError(t, err)
+	task.SetDecrypter(cipher)
+	require.NoError(t, task.Run(&NullProgressReporter{}))
+
+	report := task.GetReport()
+	require.Equal(t, 1, report.ExportCount)
+	require.Equal(t, 2, report.MessageCount)
+	require.Equal(t, 1, report.BuiltMessageCount)
+	require.Equal(t, 1, report.InvalidMessageCount)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, "mail_20240101_000000/Maildir/cur/"+maildirFileName(proton.MessageMetadata{
+		ID:       "invalid",
+		Time:     1700000100,
+		LabelIDs: []string{proton.InboxLabel},
+	}), report.Issues[0].Path)
+}
+
+// writeTestVerifyMailboxLabels writes the labels file of an mbox or Maildir export and returns its labels.
+func writeTestVerifyMailboxLabels(t *testing.T, exportDir string) []proton.Label {
+	labels := []proton.Label{
+		{ID: proton.InboxLabel, Name: "Inbox", Type: proton.LabelTypeSystem},
+		{ID: "label", Name: "Label", Type: proton.LabelTypeLabel},
+	}
+
+	data, err := utils.GenerateVersionedJSON(LabelMetadataVersion, labels)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(exportDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(exportDir, getLabelFileName()), data, 0o600))
+
+	return labels
+}
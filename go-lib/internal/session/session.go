@@ -113,7 +113,7 @@ func (s *Session) Login(ctx context.Context, email string, password []byte) erro
 		return err
 	}
 
-	client = apiclient.NewAutoRetryClient(client, &apiclient.SleepRetryStrategyBuilder{})
+	client = apiclient.NewAutoRetryClient(client, apiclient.NewRateLimitRetryStrategyBuilder())
 	s.client = client
 	s.setMailboxPassword(password)
 	s.passwordMode = auth.PasswordMode
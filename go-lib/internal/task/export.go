@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ProtonMail/export-tool/internal/apiclient"
+	"github.com/ProtonMail/export-tool/internal/session"
+	"github.com/ProtonMail/export-tool/internal/utils"
+	"github.com/ProtonMail/go-proton-api"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrPartialExport is returned by the exports which completed without some of the data, e.g. calendars whose keys
+// could not be unlocked. The files of everything else are written.
+var ErrPartialExport = errors.New("export is incomplete")
+
+// ExportBase holds the state shared by the exports which write their files to a new timestamped directory.
+type ExportBase struct {
+	Ctx       context.Context
+	ExportDir string
+	TmpDir    string
+	Session   *session.Session
+	Log       *logrus.Entry
+	Encrypter utils.FileEncrypter
+
+	ctxCancel       func()
+	cancelledByUser bool
+}
+
+// NewExportBase returns the state of an export to a <dirPrefix>_yyyymmdd_hhmmss directory of exportPath.
+func NewExportBase(ctx context.Context, exportPath, dirPrefix string, session *session.Session) ExportBase {
+	exportDir := filepath.Join(exportPath, GenerateUniqueExportDir(dirPrefix))
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	return ExportBase{
+		Ctx:       ctx,
+		ExportDir: exportDir,
+		TmpDir:    filepath.Join(exportDir, "temp"),
+		Session:   session,
+		Log:       logrus.WithField("export", dirPrefix).WithField("userID", session.GetUser().ID),
+		ctxCancel: cancel,
+	}
+}
+
+func (e *ExportBase) Cancel() {
+	e.cancelledByUser = true
+	e.ctxCancel()
+}
+
+func (e *ExportBase) GetOperationCancelledByUser() bool {
+	return e.cancelledByUser
+}
+
+func (e *ExportBase) GetExportPath() string {
+	return e.ExportDir
+}
+
+// SetEncrypter makes the export encrypt every file it writes. Must be called before Run.
+func (e *ExportBase) SetEncrypter(encrypter utils.FileEncrypter) {
+	e.Encrypter = encrypter
+}
+
+// CreateTmpDir creates the temporary directory the files are written to before being moved in the export directory.
+// The returned function removes it.
+func (e *ExportBase) CreateTmpDir() (func(), error) {
+	if err := os.MkdirAll(e.TmpDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create export tmp directory: %w", err)
+	}
+
+	return func() {
+		if err := os.RemoveAll(e.TmpDir); err != nil {
+			e.Log.WithError(err).Error("Failed to remove temp directory")
+		}
+	}, nil
+}
+
+// NewFileWriter returns the writer of the export files, which encrypts them if an encrypter was set.
+func (e *ExportBase) NewFileWriter() *utils.DiskFileWriter {
+	return &utils.DiskFileWriter{Encrypter: e.Encrypter}
+}
+
+// UnlockKeyRing unlocks the user keys and the keys of the given addresses with the mailbox password of the session.
+func UnlockKeyRing(session *session.Session, addresses []proton.Address) (*apiclient.UnlockedKeyRing, error) {
+	user := session.GetUser()
+
+	saltedKeyPass, err := session.GetUserSalts().SaltForKey(session.GetMailboxPassword(), user.Keys.Primary().ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to salt key password: %w", err)
+	}
+
+	keyRing, err := apiclient.NewUnlockedKeyRing(user, addresses, saltedKeyPass)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock user keyring: %w", err)
+	}
+
+	if keyRing.GetUserKeyRing().CountDecryptionEntities() == 0 {
+		keyRing.Close()
+		return nil, fmt.Errorf("failed to unlock user keys")
+	}
+
+	return keyRing, nil
+}
+
+// ListPages calls getPage with increasing page numbers until it returns less than pageSize items, and returns them all.
+func ListPages[T any](pageSize int, getPage func(page, pageSize int) ([]T, error)) ([]T, error) {
+	var result []T
+
+	for page := 0; ; page++ {
+		items, err := getPage(page, pageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, items...)
+
+		if len(items) < pageSize {
+			return result, nil
+		}
+	}
+}
+
+func GenerateUniqueExportDir(prefix string) string {
+	const format = "20060102_150405"
+	return prefix + "_" + time.Now().Format(format)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is Free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPages(t *testing.T) {
+	const pageSize = 3
+
+	var requested []int
+
+	items, err := ListPages(pageSize, func(page, size int) ([]int, error) {
+		require.Equal(t, pageSize, size)
+		requested = append(requested, page)
+
+		if page < 2 {
+			return []int{page, page, page}, nil
+		}
+
+		return []int{page}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2}, requested)
+	require.Equal(t, []int{0, 0, 0, 1, 1, 1, 2}, items)
+
+	// A full last page is followed by an empty one.
+	items, err = ListPages(pageSize, func(page, _ int) ([]int, error) {
+		if page == 0 {
+			return []int{1, 2, 3}, nil
+		}
+
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3}, items)
+
+	pageErr := errors.New("failed")
+
+	_, err = ListPages(pageSize, func(int, int) ([]int, error) {
+		return nil, pageErr
+	})
+	require.ErrorIs(t, err, pageErr)
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+)
+
+var (
+	ErrFileEncrypted    = errors.New("file is encrypted and no decryption key was provided")
+	ErrDecryptionFailed = errors.New("failed to decrypt file")
+)
+
+// FileEncrypter encrypts the contents of the files before they are written to disk.
+type FileEncrypter interface {
+	EncryptFile(data []byte) ([]byte, error)
+}
+
+// FileStreamEncrypter is implemented by the encrypters which can encrypt a file without holding it in memory. The
+// returned writer encrypts what is written to it into w and must be closed.
+type FileStreamEncrypter interface {
+	EncryptFileStream(w io.Writer) (io.WriteCloser, error)
+}
+
+// FileDecrypter decrypts the contents of files written with a FileEncrypter.
+type FileDecrypter interface {
+	DecryptFile(data []byte) ([]byte, error)
+}
+
+// PGPFileCipher encrypts files as binary OpenPGP messages, either to a key or with a passphrase. The files can be
+// decrypted with any OpenPGP implementation, e.g. 'gpg --decrypt'.
+type PGPFileCipher struct {
+	keyRing  *crypto.KeyRing
+	password []byte
+}
+
+func NewPGPPasswordFileCipher(password []byte) *PGPFileCipher {
+	return &PGPFileCipher{password: password}
+}
+
+// NewPGPKeyFileCipher creates a cipher from an armored OpenPGP key. A public key can only encrypt, a private key is
+// unlocked with the passphrase so that it can decrypt as well.
+func NewPGPKeyFileCipher(armoredKey string, passphrase []byte) (*PGPFileCipher, error) {
+	key, err := crypto.NewKeyFromArmored(armoredKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	if key.IsPrivate() {
+		locked, err := key.IsLocked()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check key lock: %w", err)
+		}
+
+		if locked && len(passphrase) != 0 {
+			if key, err = key.Unlock(passphrase); err != nil {
+				return nil, fmt.Errorf("failed to unlock key: %w", err)
+			}
+		} else if locked {
+			// Without passphrase the key can still be used for encryption.
+			if key, err = key.ToPublic(); err != nil {
+				return nil, fmt.Errorf("failed to get public key: %w", err)
+			}
+		}
+	}
+
+	if !key.CanEncrypt() {
+		return nil, fmt.Errorf("key %v can't be used for encryption", key.GetFingerprint())
+	}
+
+	keyRing, err := crypto.NewKeyRing(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keyring: %w", err)
+	}
+
+	return &PGPFileCipher{keyRing: keyRing}, nil
+}
+
+// LoadPGPKeyFileCipher creates a cipher from an armored OpenPGP key file, see NewPGPKeyFileCipher.
+func LoadPGPKeyFileCipher(path string, passphrase []byte) (*PGPFileCipher, error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return NewPGPKeyFileCipher(string(data), passphrase)
+}
+
+func (c *PGPFileCipher) EncryptFile(data []byte) ([]byte, error) {
+	var message *crypto.PGPMessage
+	var err error
+
+	if c.keyRing != nil {
+		message, err = c.keyRing.Encrypt(crypto.NewPlainMessage(data), nil)
+	} else {
+		message, err = crypto.EncryptMessageWithPassword(crypto.NewPlainMessage(data), c.password)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt file: %w", err)
+	}
+
+	return message.GetBinary(), nil
+}
+
+// EncryptFileStream encrypts to the same binary OpenPGP message as EncryptFile.
+func (c *PGPFileCipher) EncryptFileStream(w io.Writer) (io.WriteCloser, error) {
+	var writer io.WriteCloser
+	var err error
+
+	if c.keyRing != nil {
+		writer, err = c.keyRing.EncryptStream(w, crypto.NewPlainMessageMetadata(true, "", crypto.GetUnixTime()), nil)
+	} else {
+		writer, err = openpgp.SymmetricallyEncrypt(w, c.password, &openpgp.FileHints{IsBinary: true}, &packet.Config{DefaultCipher: packet.CipherAES256})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt file: %w", err)
+	}
+
+	return writer, nil
+}
+
+func (c *PGPFileCipher) DecryptFile(data []byte) ([]byte, error) {
+	var message *crypto.PlainMessage
+	var err error
+
+	if c.keyRing != nil {
+		message, err = c.keyRing.Decrypt(crypto.NewPGPMessage(data), nil, 0)
+	} else {
+		message, err = crypto.DecryptMessageWithPassword(crypto.NewPGPMessage(data), c.password)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	return message.GetBinary(), nil
+}
+
+// IsEncryptedFile returns whether the contents are a binary OpenPGP message, which starts with a public key or
+// a symmetric key encrypted session key packet. It is only meant for the text files written by the tool, which can
+// never start with a byte that has the high bit set.
+func IsEncryptedFile(data []byte) bool {
+	if len(data) == 0 || data[0]&0x80 == 0 {
+		return false
+	}
+
+	var tag byte
+	if data[0]&0x40 != 0 {
+		tag = data[0] & 0x3f
+	} else {
+		tag = (data[0] & 0x3c) >> 2
+	}
+
+	return tag == 1 || tag == 3
+}
+
+// WriteEncryptedFileSafe encrypts the contents before writing them with WriteFileSafe. The contents are written as is
+// if the encrypter is nil.
+func WriteEncryptedFileSafe(tempPath, dstPath string, data []byte, encrypter FileEncrypter, integrityChecker IntegrityChecker) error {
+	if encrypter != nil {
+		encrypted, err := encrypter.EncryptFile(data)
+		if err != nil {
+			return err
+		}
+
+		data = encrypted
+	}
+
+	return WriteFileSafe(tempPath, dstPath, data, integrityChecker)
+}
+
+// ReadFileDecrypted reads a file, decrypting it if it was written encrypted. ErrFileEncrypted is returned if the file
+// is encrypted and the decrypter is nil.
+func ReadFileDecrypted(path string, decrypter FileDecrypter) ([]byte, error) {
+	data, err := os.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptFileData(data, decrypter)
+}
+
+// DecryptFileData decrypts the contents of a file if they are encrypted, see ReadFileDecrypted.
+func DecryptFileData(data []byte, decrypter FileDecrypter) ([]byte, error) {
+	if !IsEncryptedFile(data) {
+		return data, nil
+	}
+
+	if decrypter == nil {
+		return nil, ErrFileEncrypted
+	}
+
+	return decrypter.DecryptFile(data)
+}
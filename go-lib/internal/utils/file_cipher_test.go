@@ -0,0 +1,155 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v2/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGPFileCipher_Key(t *testing.T) {
+	key, err := crypto.GenerateKey("test", "test@proton.me", "x25519", 0)
+	require.NoError(t, err)
+
+	lockedKey, err := key.Lock([]byte("secret"))
+	require.NoError(t, err)
+
+	armoredPrivate, err := lockedKey.Armor()
+	require.NoError(t, err)
+
+	armoredPublic, err := key.GetArmoredPublicKey()
+	require.NoError(t, err)
+
+	encrypter, err := NewPGPKeyFileCipher(armoredPublic, nil)
+	require.NoError(t, err)
+
+	data := []byte("Subject: hello\r\n\r\nworld")
+
+	encrypted, err := encrypter.EncryptFile(data)
+	require.NoError(t, err)
+	require.True(t, IsEncryptedFile(encrypted))
+	require.NotContains(t, string(encrypted), "world")
+
+	// A public key can't decrypt.
+	_, err = encrypter.DecryptFile(encrypted)
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+
+	// A locked private key without passphrase can only encrypt.
+	lockedEncrypter, err := NewPGPKeyFileCipher(armoredPrivate, nil)
+	require.NoError(t, err)
+	_, err = lockedEncrypter.DecryptFile(encrypted)
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+
+	_, err = NewPGPKeyFileCipher(armoredPrivate, []byte("wrong"))
+	require.Error(t, err)
+
+	decrypter, err := NewPGPKeyFileCipher(armoredPrivate, []byte("secret"))
+	require.NoError(t, err)
+
+	decrypted, err := decrypter.DecryptFile(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, data, decrypted)
+}
+
+func TestPGPFileCipher_Password(t *testing.T) {
+	data := []byte(`{"Version":1}`)
+
+	encrypted, err := NewPGPPasswordFileCipher([]byte("secret")).EncryptFile(data)
+	require.NoError(t, err)
+	require.True(t, IsEncryptedFile(encrypted))
+
+	_, err = NewPGPPasswordFileCipher([]byte("wrong")).DecryptFile(encrypted)
+	require.ErrorIs(t, err, ErrDecryptionFailed)
+
+	decrypted, err := NewPGPPasswordFileCipher([]byte("secret")).DecryptFile(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, data, decrypted)
+}
+
+func TestPGPFileCipher_EncryptFileStream(t *testing.T) {
+	key, err := crypto.GenerateKey("test", "test@proton.me", "x25519", 0)
+	require.NoError(t, err)
+
+	armoredPrivate, err := key.Armor()
+	require.NoError(t, err)
+
+	keyCipher, err := NewPGPKeyFileCipher(armoredPrivate, nil)
+	require.NoError(t, err)
+
+	data := []byte("Subject: hello\r\n\r\nworld")
+
+	for _, cipher := range []*PGPFileCipher{keyCipher, NewPGPPasswordFileCipher([]byte("secret"))} {
+		var encrypted bytes.Buffer
+
+		writer, err := cipher.EncryptFileStream(&encrypted)
+		require.NoError(t, err)
+		_, err = writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		require.True(t, IsEncryptedFile(encrypted.Bytes()))
+		require.NotContains(t, encrypted.String(), "world")
+
+		decrypted, err := cipher.DecryptFile(encrypted.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, data, decrypted)
+	}
+}
+
+func TestIsEncryptedFile(t *testing.T) {
+	require.False(t, IsEncryptedFile(nil))
+	require.False(t, IsEncryptedFile([]byte(`{"Version":1}`)))
+	require.False(t, IsEncryptedFile([]byte("From: foo@bar.com\r\n")))
+	require.False(t, IsEncryptedFile([]byte{0xff, 0xd8, 0xff}))
+	require.True(t, IsEncryptedFile([]byte{0xc1, 0x5e}))
+	require.True(t, IsEncryptedFile([]byte{0xc3, 0x0d}))
+	require.True(t, IsEncryptedFile([]byte{0x84, 0x5e}))
+	require.True(t, IsEncryptedFile([]byte{0x8c, 0x0d}))
+}
+
+func TestWriteEncryptedFileSafe(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "file.eml")
+	data := []byte("Subject: hello\r\n\r\nworld")
+	cipher := NewPGPPasswordFileCipher([]byte("secret"))
+
+	require.NoError(t, WriteEncryptedFileSafe(tmpDir, filePath, data, cipher, &Sha256IntegrityChecker{}))
+
+	onDisk, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	require.NotEqual(t, data, onDisk)
+
+	_, err = ReadFileDecrypted(filePath, nil)
+	require.ErrorIs(t, err, ErrFileEncrypted)
+
+	decrypted, err := ReadFileDecrypted(filePath, cipher)
+	require.NoError(t, err)
+	require.Equal(t, data, decrypted)
+
+	// Plain files are read as is.
+	require.NoError(t, WriteEncryptedFileSafe(tmpDir, filePath, data, nil, nil))
+
+	plain, err := ReadFileDecrypted(filePath, cipher)
+	require.NoError(t, err)
+	require.Equal(t, data, plain)
+}
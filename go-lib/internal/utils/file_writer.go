@@ -0,0 +1,196 @@
+// Copyright (c) 2023 Proton AG
+//
+// This file is part of Proton Export Tool.
+//
+// Proton Mail Bridge is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Proton Mail Bridge is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with Proton Export Tool.  If not, see <https://www.gnu.org/licenses/>.
+
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileWriter stores the files of an export, either on disk or in an archive.
+type FileWriter interface {
+	MkdirAll(path string) error
+	WriteFile(tempPath, dstPath string, data []byte, integrityChecker IntegrityChecker) error
+}
+
+// FileMover is implemented by the writers which can store a file written to a temporary location without reading it
+// into memory.
+type FileMover interface {
+	MoveFile(srcPath, dstPath string, integrityChecker IntegrityChecker) error
+}
+
+// MoveFile stores the file at srcPath with the writer and removes it. The file is read into memory when the writer is
+// not a FileMover. The integrity checker, if any, must already be initialized with the contents of the file, which are
+// checked against it before they are stored. The hash of a Sha256IntegrityChecker is kept, the hash of the file as it
+// was stored is recorded with SetStoredHash.
+func MoveFile(fileWriter FileWriter, srcPath, dstPath string, integrityChecker IntegrityChecker) error {
+	if mover, ok := fileWriter.(FileMover); ok {
+		return mover.MoveFile(srcPath, dstPath, integrityChecker)
+	}
+
+	return writeFileFrom(fileWriter, srcPath, dstPath, integrityChecker)
+}
+
+// FileRemover is implemented by the writers which can remove a file they stored.
+type FileRemover interface {
+	RemoveFile(path string) error
+}
+
+// RemoveFile removes the file at path stored with the writer. Nothing is done if the writer is not a FileRemover, such
+// as the writers of archives whose entries can't be removed, or if the file doesn't exist.
+func RemoveFile(fileWriter FileWriter, path string) error {
+	if remover, ok := fileWriter.(FileRemover); ok {
+		return remover.RemoveFile(path)
+	}
+
+	return nil
+}
+
+func writeFileFrom(fileWriter FileWriter, srcPath, dstPath string, integrityChecker IntegrityChecker) error {
+	if integrityChecker != nil {
+		if err := integrityChecker.Check(srcPath); err != nil {
+			return err
+		}
+	}
+
+	data, err := os.ReadFile(srcPath) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to read '%v': %w", srcPath, err)
+	}
+
+	stored := &Sha256IntegrityChecker{}
+
+	if err := fileWriter.WriteFile(filepath.Dir(srcPath), dstPath, data, stored); err != nil {
+		return err
+	}
+
+	setStoredHash(integrityChecker, stored.GetStoredHash())
+
+	return os.Remove(srcPath)
+}
+
+// encryptFileFrom encrypts the file at srcPath to dstPath as it is read and removes it. The file is checked against the
+// integrity checker as it is read, before it replaces dstPath.
+func encryptFileFrom(encrypter FileStreamEncrypter, srcPath, dstPath string, integrityChecker *Sha256IntegrityChecker) error {
+	src, err := os.Open(srcPath) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to open '%v': %w", srcPath, err)
+	}
+
+	storedHash, err := WriteFileSafeFrom(filepath.Dir(srcPath), dstPath, func(w io.Writer) error {
+		return encryptFrom(encrypter, w, src, integrityChecker)
+	})
+	if err != nil {
+		_ = src.Close()
+		return err
+	}
+
+	if err := src.Close(); err != nil {
+		return fmt.Errorf("failed to close '%v': %w", srcPath, err)
+	}
+
+	setStoredHash(integrityChecker, storedHash)
+
+	return os.Remove(srcPath)
+}
+
+// encryptFrom encrypts src to w and checks the data read from src against the integrity checker, if any.
+func encryptFrom(encrypter FileStreamEncrypter, w io.Writer, src io.Reader, integrityChecker *Sha256IntegrityChecker) error {
+	encrypted, err := encrypter.EncryptFileStream(w)
+	if err != nil {
+		return err
+	}
+
+	hasher := sha256.New()
+
+	if _, err := io.Copy(encrypted, io.TeeReader(src, hasher)); err != nil {
+		_ = encrypted.Close()
+		return fmt.Errorf("failed to encrypt file: %w", err)
+	}
+
+	if err := encrypted.Close(); err != nil {
+		return err
+	}
+
+	if integrityChecker != nil && !bytes.Equal(hasher.Sum(nil), integrityChecker.GetHash()) {
+		return ErrIntegrityCheckFailed
+	}
+
+	return nil
+}
+
+// setStoredHash records the hash of a file stored by MoveFile if the integrity checker is a Sha256IntegrityChecker.
+func setStoredHash(integrityChecker IntegrityChecker, hash []byte) {
+	if checker, ok := integrityChecker.(*Sha256IntegrityChecker); ok && checker != nil {
+		checker.SetStoredHash(hash)
+	}
+}
+
+// DiskFileWriter writes files to disk with WriteFileSafe, encrypting them first if it has an Encrypter.
+type DiskFileWriter struct {
+	Encrypter FileEncrypter
+}
+
+func (d *DiskFileWriter) MkdirAll(path string) error {
+	return os.MkdirAll(path, 0o700)
+}
+
+func (d *DiskFileWriter) WriteFile(tempPath, dstPath string, data []byte, integrityChecker IntegrityChecker) error {
+	return WriteEncryptedFileSafe(tempPath, dstPath, data, d.Encrypter, integrityChecker)
+}
+
+// MoveFile renames the file to its destination. If the writer has an Encrypter, the file is encrypted as it is read
+// when both the encrypter and the integrity checker support it, and read into memory otherwise.
+func (d *DiskFileWriter) MoveFile(srcPath, dstPath string, integrityChecker IntegrityChecker) error {
+	if d.Encrypter != nil {
+		streamEncrypter, ok := d.Encrypter.(FileStreamEncrypter)
+		checker, isSha256 := integrityChecker.(*Sha256IntegrityChecker)
+
+		if !ok || (integrityChecker != nil && !isSha256) {
+			return writeFileFrom(d, srcPath, dstPath, integrityChecker)
+		}
+
+		return encryptFileFrom(streamEncrypter, srcPath, dstPath, checker)
+	}
+
+	if integrityChecker != nil {
+		if err := integrityChecker.Check(srcPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		return fmt.Errorf("failed to move file to location: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DiskFileWriter) RemoveFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove '%v': %w", path, err)
+	}
+
+	return nil
+}
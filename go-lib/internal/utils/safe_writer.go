@@ -80,13 +80,81 @@ func WriteFileSafe(tempPath, dstPath string, data []byte, integrityChecker Integ
 	return nil
 }
 
+// WriteFileSafeFrom is WriteFileSafe for contents written by write, so that they are never held in memory. The written
+// file is checked against the hash of the contents as they are written, which is returned.
+func WriteFileSafeFrom(tempPath, dstPath string, write func(w io.Writer) error) ([]byte, error) {
+	file, err := os.CreateTemp(tempPath, "export-tool-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tmp file: %w", err)
+	}
+
+	filePath := file.Name()
+	hasher := sha256.New()
+
+	if err := write(io.MultiWriter(file, hasher)); err != nil {
+		if err := file.Close(); err != nil {
+			logrus.WithField("dstPath", filePath).WithError(err).Error("Failed to close tmp file after io error")
+		}
+
+		if err := os.Remove(filePath); err != nil {
+			logrus.WithField("dstPath", filePath).WithError(err).Error("Failed to remove tmp file after io error")
+		}
+
+		return nil, fmt.Errorf("failed to write contents: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tmp file: %w", err)
+	}
+
+	checker := &Sha256IntegrityChecker{hash: hasher.Sum(nil)}
+
+	if err := checker.Check(filePath); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(filePath, dstPath); err != nil {
+		return nil, fmt.Errorf("failed to move file to location: %w", err)
+	}
+
+	return checker.GetHash(), nil
+}
+
 type Sha256IntegrityChecker struct {
-	hash []byte
+	hash       []byte
+	storedHash []byte
 }
 
 func (s *Sha256IntegrityChecker) Initialize(i []byte) {
 	hash := sha256.Sum256(i)
 	s.hash = hash[:]
+	s.storedHash = nil
+}
+
+// InitializeHash initializes the checker with the SHA-256 hash of data which was hashed while it was written.
+func (s *Sha256IntegrityChecker) InitializeHash(hash []byte) {
+	s.hash = hash
+	s.storedHash = nil
+}
+
+// GetHash returns the SHA-256 hash of the data the checker was initialized with.
+func (s *Sha256IntegrityChecker) GetHash() []byte {
+	return s.hash
+}
+
+// SetStoredHash records the SHA-256 hash of a file which was stored differently from the data the checker was
+// initialized with, e.g. encrypted while it was moved, see MoveFile.
+func (s *Sha256IntegrityChecker) SetStoredHash(hash []byte) {
+	s.storedHash = hash
+}
+
+// GetStoredHash returns the SHA-256 hash of the file as it was stored, GetHash unless SetStoredHash was called.
+func (s *Sha256IntegrityChecker) GetStoredHash() []byte {
+	if s.storedHash != nil {
+		return s.storedHash
+	}
+
+	return s.hash
 }
 
 func (s *Sha256IntegrityChecker) Check(path string) error {
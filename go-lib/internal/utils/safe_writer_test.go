@@ -18,6 +18,7 @@
 package utils
 
 import (
+	"crypto/sha256"
 	"os"
 	"path/filepath"
 	"testing"
@@ -44,3 +45,74 @@ func TestSha256IntegrityChecker_Check(t *testing.T) {
 	require.NoError(t, os.WriteFile(filePath, dataCorrupt, 0o700))
 	require.ErrorIs(t, ErrIntegrityCheckFailed, checker.Check(filePath))
 }
+
+func TestMoveFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	data := []byte("Proton Mail Bridge is free software: you can redistribute it and/or modify")
+	cipher := NewPGPPasswordFileCipher([]byte("secret"))
+
+	for _, writer := range []*DiskFileWriter{{}, {Encrypter: cipher}} {
+		srcPath := filepath.Join(tmpDir, "src.txt")
+		dstPath := filepath.Join(tmpDir, "dst.txt")
+		require.NoError(t, os.WriteFile(srcPath, data, 0o600))
+
+		checker := &Sha256IntegrityChecker{}
+		checker.Initialize(data)
+
+		require.NoError(t, MoveFile(writer, srcPath, dstPath, checker))
+		require.NoFileExists(t, srcPath)
+
+		stored, err := ReadFileDecrypted(dstPath, cipher)
+		require.NoError(t, err)
+		require.Equal(t, data, stored)
+	}
+}
+
+func TestMoveFile_ChecksSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	data := []byte("Proton Mail Bridge is free software: you can redistribute it and/or modify")
+	cipher := NewPGPPasswordFileCipher([]byte("secret"))
+
+	for _, writer := range []*DiskFileWriter{{}, {Encrypter: cipher}} {
+		srcPath := filepath.Join(tmpDir, "src.txt")
+		dstPath := filepath.Join(tmpDir, "dst.txt")
+		require.NoError(t, os.WriteFile(srcPath, data[:10], 0o600))
+
+		checker := &Sha256IntegrityChecker{}
+		checker.Initialize(data)
+
+		require.ErrorIs(t, MoveFile(writer, srcPath, dstPath, checker), ErrIntegrityCheckFailed)
+		require.NoFileExists(t, dstPath)
+	}
+}
+
+func TestMoveFile_StoredHash(t *testing.T) {
+	tmpDir := t.TempDir()
+	data := []byte("Proton Mail Bridge is free software: you can redistribute it and/or modify")
+	cipher := NewPGPPasswordFileCipher([]byte("secret"))
+	plainHash := sha256.Sum256(data)
+
+	for _, writer := range []*DiskFileWriter{{}, {Encrypter: cipher}} {
+		srcPath := filepath.Join(tmpDir, "src.txt")
+		dstPath := filepath.Join(tmpDir, "dst.txt")
+		require.NoError(t, os.WriteFile(srcPath, data, 0o600))
+
+		checker := &Sha256IntegrityChecker{}
+		checker.Initialize(data)
+
+		require.NoError(t, MoveFile(writer, srcPath, dstPath, checker))
+
+		// The checker keeps the hash of the source, the stored hash is the one of the file, encrypted or not.
+		stored, err := os.ReadFile(dstPath)
+		require.NoError(t, err)
+
+		storedHash := sha256.Sum256(stored)
+		require.Equal(t, plainHash[:], checker.GetHash())
+		require.Equal(t, storedHash[:], checker.GetStoredHash())
+	}
+
+	// No checker is needed.
+	srcPath := filepath.Join(tmpDir, "src.txt")
+	require.NoError(t, os.WriteFile(srcPath, data, 0o600))
+	require.NoError(t, MoveFile(&DiskFileWriter{Encrypter: cipher}, srcPath, filepath.Join(tmpDir, "dst.txt"), nil))
+}
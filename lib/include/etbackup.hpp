@@ -20,6 +20,7 @@
 #include <exception>
 #include <filesystem>
 #include <string>
+#include <vector>
 
 #include "etexception.hpp"
 
@@ -47,6 +48,38 @@ public:
 class Backup final {
     friend class Session;
 
+public:
+    enum class Format { EML, Mbox, Maildir };
+
+    // Stores the backup in a single archive file instead of a directory. Only supported with Format::EML.
+    enum class ArchiveFormat { None, TarZstd, Zip };
+
+    // Stores the messages in the folders of their labels, named after their date and subject. Only supported with
+    // Format::EML and without archive.
+    enum class Layout { Flat, Labels };
+
+    // Outcome of start(). Partial when messages were left out within the error budget, see getFailedMessageCount().
+    enum class Result { Complete, Partial };
+
+    // Restricts the messages included in the backup. Empty values disable the corresponding criteria.
+    struct Filter {
+        std::int64_t after = 0;  // Unix timestamp, only messages received at or after this time.
+        std::int64_t before = 0; // Unix timestamp, only messages received before this time.
+        std::vector<std::string> includeLabels;
+        std::vector<std::string> excludeLabels;
+        std::vector<std::string> addresses;
+        bool unreadOnly = false;
+        bool withAttachments = false;
+    };
+
+    // Overrides the parallelism and memory of the backup. Zero values are adjusted automatically.
+    struct Concurrency {
+        int downloads = 0;
+        int builders = 0;
+        int writers = 0;
+        std::uint64_t memoryMB = 0;
+    };
+
 private:
     const Session& mSession;
     etBackup* mPtr;
@@ -61,14 +94,50 @@ public:
     Backup& operator=(const Backup&) = delete;
     Backup& operator=(Backup&& rhs) noexcept = delete;
 
-    void start(BackupCallback& cb);
+    void setFormat(Format format);
+
+    void setArchiveFormat(ArchiveFormat format);
+
+    // The labels layout is not available for encrypted backups, its folders are named after the labels.
+    void setLayout(Layout layout);
+
+    // Incremental backups can't be filtered, the messages left out would be missing from their checkpoint.
+    void setFilter(const Filter& filter);
+
+    void setConcurrency(const Concurrency& concurrency);
+
+    // Number of messages the backup may leave out when they keep failing, instead of failing on the first one.
+    void setErrorBudget(int64_t budget);
+
+    // Encrypts every file of the backup to the armored OpenPGP key. The passphrase is only needed for locked private keys.
+    void setEncryptionKeyFile(const std::filesystem::path& path, const std::string& keyPassphrase = {});
+
+    // Encrypts every file of the backup with the passphrase.
+    void setEncryptionPassphrase(const std::string& passphrase);
+
+    // Writes the events of the backup, and the network losses of the session, as JSON lines to the file, which is
+    // truncated.
+    void setEventFile(const std::filesystem::path& path);
+
+    // Where the failed messages are listed, as CSV if the path ends with .csv and JSON otherwise.
+    void setFailureReportPath(const std::filesystem::path& path);
+
+    // Completes without throwing when messages were left out within the error budget, and returns Result::Partial.
+    // The user settings, mail settings, addresses with their signatures and Sieve filters are saved next to the messages
+    // when they can be retrieved.
+    Result start(BackupCallback& cb);
 
     void cancel();
 
     std::filesystem::path getExportPath() const;
 
+    // Path of the report written by start(), empty if no message failed.
+    std::filesystem::path getFailureReportPath() const;
+
     std::uint64_t getExpectedDiskUsage() const;
 
+    int64_t getFailedMessageCount() const;
+
 private:
     template<class F>
     void wrapCCall(F func);
@@ -20,6 +20,7 @@
 #include <exception>
 #include <filesystem>
 #include <string>
+#include <vector>
 
 #include "etexception.hpp"
 
@@ -47,6 +48,17 @@ public:
 class Restore final {
     friend class Session;
 
+public:
+    // Selects the messages imported by the restore. Empty values disable the corresponding criteria.
+    struct Filter {
+        int64_t after = 0;  // Unix timestamp, only messages received at or after this time.
+        int64_t before = 0; // Unix timestamp, only messages received before this time.
+        std::vector<std::string> includeLabels;
+        std::vector<std::string> excludeLabels;
+        std::vector<std::string> addresses;
+        std::vector<std::string> participants;
+    };
+
 private:
     const Session& mSession;
     etRestore* mPtr;
@@ -61,15 +73,50 @@ public:
     Restore& operator=(const Restore&) = delete;
     Restore& operator=(Restore&& rhs) noexcept = delete;
 
+    void setFilter(const Filter& filter);
+
+    // JSON file mapping backup addresses to the addresses of the account.
+    void setAddressMappingFile(const std::filesystem::path& path);
+
+    // Address (email, ID or "primary") receiving the messages which do not match any address of the account.
+    void setAddressFallback(const std::string& address);
+
+    // Re-applies the display name and signatures stored in the backup and the colors of the labels which already exist,
+    // and creates the Sieve filters whose name is not used in the account.
+    void setRestoreSettings(bool restoreSettings);
+
+    // Skips the messages already in the account, with the same Message-ID, time and labels. Enabled by default.
+    void setSkipExisting(bool skipExisting);
+
+    // Private OpenPGP key decrypting a backup created with encryption. The passphrase unlocks the key.
+    void setDecryptionKeyFile(const std::filesystem::path& path, const std::string& keyPassphrase = {});
+
+    // Passphrase decrypting a backup created with passphrase encryption.
+    void setDecryptionPassphrase(const std::string& passphrase);
+
+    // Writes the events of the restore, and the network losses of the session, as JSON lines to the file, which is
+    // truncated.
+    void setEventFile(const std::filesystem::path& path);
+
+    // Where the failed messages are listed, as CSV if the path ends with .csv and JSON otherwise.
+    void setFailureReportPath(const std::filesystem::path& path);
+
     void start(RestoreCallback& cb);
 
     void cancel();
 
     std::filesystem::path getBackupPath() const;
+
+    // Path of the report written by start(), empty if no message failed.
+    std::filesystem::path getFailureReportPath() const;
     int64_t getImportableCount() const;
     int64_t getImportedCount() const;
     int64_t getFailedCount() const;
     int64_t getSkippedCount() const;
+    int64_t getFilteredCount() const;
+    // Messages skipped as already in the account, not included in getSkippedCount().
+    int64_t getAlreadyPresentCount() const;
+    int64_t getResumedCount() const;
 
 private:
     template<class F>
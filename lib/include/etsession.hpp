@@ -73,6 +73,8 @@ public:
     [[nodiscard]] LoginState markHVSolved();
 
     [[nodiscard]] Backup newBackup(const char* exportPath) const;
+    [[nodiscard]] Backup resumeBackup(const char* exportPath) const;
+    [[nodiscard]] Backup newIncrementalBackup(const char* exportPath) const;
     [[nodiscard]] Restore newRestore(const char* backupPath) const;
 
     void setUsingDefaultExportPath(const bool usingDefaultExportPath);
@@ -37,6 +37,7 @@ inline void mapETBackupStatusToException(etBackup* ptr, etBackupStatus status) {
     case ET_BACKUP_STATUS_CANCELLED:
         throw CancelledException();
     case ET_BACKUP_STATUS_OK:
+    case ET_BACKUP_STATUS_PARTIAL:
         break;
     }
 }
@@ -55,11 +56,132 @@ Backup::~Backup() {
     wrapCCall([](etBackup* ptr) { return etBackupDelete(ptr); });
 }
 
-void Backup::start(BackupCallback& cb) {
+void Backup::setFormat(Format format) {
+    etBackupFormat etFormat = ET_BACKUP_FORMAT_EML;
+    switch (format) {
+    case Format::EML:
+        etFormat = ET_BACKUP_FORMAT_EML;
+        break;
+    case Format::Mbox:
+        etFormat = ET_BACKUP_FORMAT_MBOX;
+        break;
+    case Format::Maildir:
+        etFormat = ET_BACKUP_FORMAT_MAILDIR;
+        break;
+    }
+
+    wrapCCall([&](etBackup* ptr) { return etBackupSetFormat(ptr, etFormat); });
+}
+
+void Backup::setArchiveFormat(ArchiveFormat format) {
+    etBackupArchiveFormat etFormat = ET_BACKUP_ARCHIVE_FORMAT_NONE;
+    switch (format) {
+    case ArchiveFormat::None:
+        etFormat = ET_BACKUP_ARCHIVE_FORMAT_NONE;
+        break;
+    case ArchiveFormat::TarZstd:
+        etFormat = ET_BACKUP_ARCHIVE_FORMAT_TAR_ZSTD;
+        break;
+    case ArchiveFormat::Zip:
+        etFormat = ET_BACKUP_ARCHIVE_FORMAT_ZIP;
+        break;
+    }
+
+    wrapCCall([&](etBackup* ptr) { return etBackupSetArchiveFormat(ptr, etFormat); });
+}
+
+void Backup::setLayout(Layout layout) {
+    etBackupLayout etLayout = ET_BACKUP_LAYOUT_FLAT;
+    switch (layout) {
+    case Layout::Flat:
+        etLayout = ET_BACKUP_LAYOUT_FLAT;
+        break;
+    case Layout::Labels:
+        etLayout = ET_BACKUP_LAYOUT_LABELS;
+        break;
+    }
+
+    wrapCCall([&](etBackup* ptr) { return etBackupSetLayout(ptr, etLayout); });
+}
+
+void Backup::setFilter(const Filter& filter) {
+    auto toCStrings = [](const std::vector<std::string>& values) {
+        std::vector<const char*> result;
+        result.reserve(values.size());
+        for (const auto& value : values) {
+            result.push_back(value.c_str());
+        }
+        return result;
+    };
+
+    const auto includeLabels = toCStrings(filter.includeLabels);
+    const auto excludeLabels = toCStrings(filter.excludeLabels);
+    const auto addresses = toCStrings(filter.addresses);
+
+    auto etFilter = etBackupFilter{};
+    etFilter.after = filter.after;
+    etFilter.before = filter.before;
+    etFilter.includeLabels = includeLabels.data();
+    etFilter.includeLabelsCount = includeLabels.size();
+    etFilter.excludeLabels = excludeLabels.data();
+    etFilter.excludeLabelsCount = excludeLabels.size();
+    etFilter.addresses = addresses.data();
+    etFilter.addressesCount = addresses.size();
+    etFilter.unreadOnly = filter.unreadOnly ? 1 : 0;
+    etFilter.withAttachments = filter.withAttachments ? 1 : 0;
+
+    wrapCCall([&](etBackup* ptr) { return etBackupSetFilter(ptr, &etFilter); });
+}
+
+void Backup::setConcurrency(const Concurrency& concurrency) {
+    auto etConcurrency = etBackupConcurrency{};
+    etConcurrency.downloads = concurrency.downloads;
+    etConcurrency.builders = concurrency.builders;
+    etConcurrency.writers = concurrency.writers;
+    etConcurrency.memoryMB = concurrency.memoryMB;
+
+    wrapCCall([&](etBackup* ptr) { return etBackupSetConcurrency(ptr, &etConcurrency); });
+}
+
+void Backup::setErrorBudget(int64_t budget) {
+    wrapCCall([&](etBackup* ptr) { return etBackupSetErrorBudget(ptr, budget); });
+}
+
+void Backup::setEncryptionKeyFile(const std::filesystem::path& path, const std::string& keyPassphrase) {
+    const auto pathStr = path.u8string();
+    wrapCCall([&](etBackup* ptr) {
+        return etBackupSetEncryptionKeyFile(ptr, pathStr.c_str(), keyPassphrase.c_str());
+    });
+}
+
+void Backup::setEncryptionPassphrase(const std::string& passphrase) {
+    wrapCCall([&](etBackup* ptr) { return etBackupSetEncryptionPassphrase(ptr, passphrase.c_str()); });
+}
+
+void Backup::setEventFile(const std::filesystem::path& path) {
+    const auto pathStr = path.u8string();
+    wrapCCall([&](etBackup* ptr) { return etBackupSetEventFile(ptr, pathStr.c_str()); });
+}
+
+void Backup::setFailureReportPath(const std::filesystem::path& path) {
+    const auto pathStr = path.u8string();
+    wrapCCall([&](etBackup* ptr) { return etBackupSetFailureReportPath(ptr, pathStr.c_str()); });
+}
+
+Backup::Result Backup::start(BackupCallback& cb) {
+    auto result = Result::Complete;
+
     wrapCCall([&](etBackup* ptr) {
         auto etCb = makeETCallback(cb);
-        return etBackupStart(ptr, &etCb);
+        const auto status = etBackupStart(ptr, &etCb);
+        if (status == ET_BACKUP_STATUS_PARTIAL) {
+            result = Result::Partial;
+        }
+
+        return status;
     });
+
+    return result;
 }
 
 void Backup::cancel() {
@@ -76,12 +198,29 @@ std::filesystem::path Backup::getExportPath() const {
     return result;
 }
 
+std::filesystem::path Backup::getFailureReportPath() const {
+    char* outPath = nullptr;
+    wrapCCall([&](etBackup* ptr) { return etBackupGetFailureReportPath(ptr, &outPath); });
+
+    auto result = std::filesystem::u8path(outPath);
+    etFree(outPath);
+
+    return result;
+}
+
 std::uint64_t Backup::getExpectedDiskUsage() const {
     std::uint64_t usage = 0;
     wrapCCall([&](etBackup* ptr) { return etBackupGetRequiredDiskSpaceEstimate(ptr, &usage); });
     return usage;
 }
 
+int64_t Backup::getFailedMessageCount() const {
+    int64_t result = 0;
+    wrapCCall([&](etBackup* ptr) { return etBackupGetFailedMessageCount(ptr, &result); });
+
+    return result;
+}
+
 template<class F>
 void Backup::wrapCCall(F func) {
     static_assert(std::is_invocable_r_v<etBackupStatus, F, etBackup*>, "invalid function/lambda signature");
@@ -75,6 +75,16 @@ std::filesystem::path Restore::getBackupPath() const {
     return result;
 }
 
+std::filesystem::path Restore::getFailureReportPath() const {
+    char* outPath = nullptr;
+    wrapCCall([&](etRestore* ptr) { return etRestoreGetFailureReportPath(ptr, &outPath); });
+
+    auto result = std::filesystem::u8path(outPath);
+    etFree(outPath);
+
+    return result;
+}
+
 int64_t Restore::getImportableCount() const {
     int64_t result = 0;
     wrapCCall([&](etRestore* ptr) { return etRestoreGetImportableCount(ptr, &result); });
@@ -103,6 +113,95 @@ int64_t Restore::getSkippedCount() const {
     return result;
 }
 
+int64_t Restore::getFilteredCount() const {
+    int64_t result = 0;
+    wrapCCall([&](etRestore* ptr) { return etRestoreGetFilteredCount(ptr, &result); });
+
+    return result;
+}
+
+int64_t Restore::getAlreadyPresentCount() const {
+    int64_t result = 0;
+    wrapCCall([&](etRestore* ptr) { return etRestoreGetAlreadyPresentCount(ptr, &result); });
+
+    return result;
+}
+
+int64_t Restore::getResumedCount() const {
+    int64_t result = 0;
+    wrapCCall([&](etRestore* ptr) { return etRestoreGetResumedCount(ptr, &result); });
+
+    return result;
+}
+
+void Restore::setAddressMappingFile(const std::filesystem::path& path) {
+    const auto pathStr = path.u8string();
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetAddressMappingFile(ptr, pathStr.c_str()); });
+}
+
+void Restore::setAddressFallback(const std::string& address) {
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetAddressFallback(ptr, address.c_str()); });
+}
+
+void Restore::setRestoreSettings(bool restoreSettings) {
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetRestoreSettings(ptr, restoreSettings ? 1 : 0); });
+}
+
+void Restore::setSkipExisting(bool skipExisting) {
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetSkipExisting(ptr, skipExisting ? 1 : 0); });
+}
+
+void Restore::setDecryptionKeyFile(const std::filesystem::path& path, const std::string& keyPassphrase) {
+    const auto pathStr = path.u8string();
+    wrapCCall([&](etRestore* ptr) {
+        return etRestoreSetDecryptionKeyFile(ptr, pathStr.c_str(), keyPassphrase.c_str());
+    });
+}
+
+void Restore::setDecryptionPassphrase(const std::string& passphrase) {
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetDecryptionPassphrase(ptr, passphrase.c_str()); });
+}
+
+void Restore::setEventFile(const std::filesystem::path& path) {
+    const auto pathStr = path.u8string();
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetEventFile(ptr, pathStr.c_str()); });
+}
+
+void Restore::setFailureReportPath(const std::filesystem::path& path) {
+    const auto pathStr = path.u8string();
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetFailureReportPath(ptr, pathStr.c_str()); });
+}
+
+void Restore::setFilter(const Filter& filter) {
+    auto toCStrings = [](const std::vector<std::string>& values) {
+        std::vector<const char*> result;
+        result.reserve(values.size());
+        for (const auto& value : values) {
+            result.push_back(value.c_str());
+        }
+        return result;
+    };
+
+    const auto includeLabels = toCStrings(filter.includeLabels);
+    const auto excludeLabels = toCStrings(filter.excludeLabels);
+    const auto addresses = toCStrings(filter.addresses);
+    const auto participants = toCStrings(filter.participants);
+
+    auto etFilter = etRestoreFilter{};
+    etFilter.after = filter.after;
+    etFilter.before = filter.before;
+    etFilter.includeLabels = includeLabels.data();
+    etFilter.includeLabelsCount = includeLabels.size();
+    etFilter.excludeLabels = excludeLabels.data();
+    etFilter.excludeLabelsCount = excludeLabels.size();
+    etFilter.addresses = addresses.data();
+    etFilter.addressesCount = addresses.size();
+    etFilter.participants = participants.data();
+    etFilter.participantsCount = participants.size();
+
+    wrapCCall([&](etRestore* ptr) { return etRestoreSetFilter(ptr, &etFilter); });
+}
+
 template<class F>
 void Restore::wrapCCall(F func) {
     static_assert(std::is_invocable_r_v<etRestoreStatus, F, etRestore*>, "invalid function/lambda signature");
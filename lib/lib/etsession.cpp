@@ -158,6 +158,20 @@ Backup Session::newBackup(const char* exportPath) const {
     return Backup(*this, exportPtr);
 }
 
+Backup Session::resumeBackup(const char* exportPath) const {
+    etBackup* exportPtr = nullptr;
+    wrapCCall([&](etSession* ptr) -> etSessionStatus { return etSessionResumeBackup(ptr, exportPath, &exportPtr); });
+
+    return Backup(*this, exportPtr);
+}
+
+Backup Session::newIncrementalBackup(const char* exportPath) const {
+    etBackup* exportPtr = nullptr;
+    wrapCCall([&](etSession* ptr) -> etSessionStatus { return etSessionNewIncrementalBackup(ptr, exportPath, &exportPtr); });
+
+    return Backup(*this, exportPtr);
+}
+
 Restore Session::newRestore(const char* backupPath) const {
     etRestore* restorePtr = nullptr;
     wrapCCall([&](etSession* ptr) -> etSessionStatus { return etSessionNewRestore(ptr, backupPath, &restorePtr); });
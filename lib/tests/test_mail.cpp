@@ -83,7 +83,9 @@ TEST_CASE("MailExport") {
         auto backup = session.newBackup(tmpDir.u8string().c_str());
         exportDir = backup.getExportPath();
         auto nullCallback = NullBackupCallback();
-        REQUIRE_NOTHROW(backup.start(nullCallback));
+        auto result = etcpp::Backup::Result::Partial;
+        REQUIRE_NOTHROW(result = backup.start(nullCallback));
+        REQUIRE(result == etcpp::Backup::Result::Complete);
     }
 
     for (const auto& msgID: messageIDs) {